@@ -51,17 +51,30 @@ def shell(name: str = "base"):
     """
 
 
-def run(commands: List[str], mount_host: bool = False):
+def run(
+    commands: List[str], mount_host: bool = False, user: str = "", test: bool = False
+):
     """Execute command
 
+    The commands with `test=True` are not run in the build. They are run by
+    `envd test` in the environment started from the image, e.g. to check that
+    the packages can be imported in CI.
+
     Args:
         commands (List[str]): command to run during the building process
         mount_host (bool): mount the host directory. Default is False.
             Enabling this will disable the build cache for this operation.
+        user (str): name or `uid[:gid]` of the user to run the commands, e.g.
+            `root` or `envd`. The build fails if the named user does not
+            exist at this point. Default is the runtime user (`envd` in the
+            dev environment).
+        test (bool): run the commands by `envd test` instead of the build.
+            `mount_host` and `user` are not supported for the test commands.
 
     Example:
     ```
     run(commands=["conda install -y -c conda-forge exa"])
+    run(commands=["python -c 'import torch'"], test=True)
     ```
     """
 
@@ -85,21 +98,37 @@ def git_config(
     """
 
 
-def include(git: str):
-    """Import from another git repo
+def include(git: str = "", ref: str = "", path: str = ""):
+    """Import from another git repo or a directory of the build context
 
     This will pull the git repo and execute all the `envd` files. The return value will be a module
     contains all the variables/functions defined (expect those has `_` prefix).
 
+    The files are executed in the lexical order of their paths, and the rules
+    called by the functions of the module apply to the environment in the call
+    order, thus the composed environment is deterministic. Pin the `ref` (a
+    branch, a tag or a commit) of the shared templates to not be changed by the
+    updates of the repo. The templates in the subdirectory of the repo are
+    included by the `//` after the repo, or the path after the repo on GitHub,
+    e.g. `github.com/org/envd-templates/pytorch`.
+
     Args:
         git (str): git URL
+        ref (Optional[str]): branch, tag or commit of the git repo,
+            the latest default branch if not set
+        path (Optional[str]): directory or `envd` file relative to the build
+            context, instead of the git repo. Only the `envd` files directly
+            in the directory are executed, except the one being built
 
     Example usage:
     ```
     envd = include("https://github.com/tensorchord/envdlib")
+    templates = include("github.com/org/envd-templates/pytorch", ref="v1.2.0")
+    local = include(path="./templates")
 
     def build():
         base(os="ubuntu20.04", language="python")
+        templates.cuda_base()
         envd.tensorboard(host_port=8000)
     ```
-    """
+    """
\ No newline at end of file
@@ -27,7 +27,7 @@ v1 is experimental and may change in the future. Make sure to freeze the envd ve
 :::
 """
 
-from typing import List, Optional
+from typing import Any, Dict, List, Optional
 
 
 def apt_source(source: Optional[str]):
@@ -133,12 +133,79 @@ def cran_mirror(url: str):
 def julia_pkg_server(url: str):
     """Configure the package server for Julia.
     Since Julia 1.5, https://pkg.julialang.org is the default pkg server.
+    It's used by the build and exported as `JULIA_PKG_SERVER` at runtime.
 
     Args:
         url (str): Julia pkg server URL
     """
 
 
+def julia(pkg_server: str = "", binary_mirror: str = ""):
+    """Configure the mirrors of Julia, e.g. behind the corporate proxies.
+
+    The pkg server is the same as `config.julia_pkg_server`. The binary mirror
+    has the same layout as https://julialang-s3.julialang.org, the Julia
+    release and its checksums are downloaded from it.
+
+    Example usage:
+    ```
+    config.julia(
+        pkg_server="https://mirrors.example.com/julia",
+        binary_mirror="https://mirrors.example.com/julia-releases",
+    )
+    ```
+
+    Args:
+        pkg_server (str): Julia pkg server URL
+        binary_mirror (str): mirror of the Julia releases
+    """
+
+
+def julia_registry(url: str, token_env: str = ""):
+    """Add a Julia registry before installing the Julia packages.
+
+    The token is only mounted as a build secret when accessing the registry,
+    it will not be persisted in the image.
+
+    Example usage:
+    ```
+    config.julia_registry(
+        url="https://gitlab.example.com/julia/registry.git",
+        token_env="JULIA_REGISTRY_TOKEN",
+    )
+    ```
+
+    Args:
+        url (str): Julia registry URL
+        token_env (str): environment variable in the host that holds the
+            access token of the private registry
+    """
+
+
+def julia_offline(release_mirror: str = ""):
+    """Build the Julia environment without contacting the public endpoints.
+
+    The Julia release is downloaded from the mirror of
+    https://julialang-s3.julialang.org with the same layout, and the packages
+    from the mirror configured by `config.julia_pkg_server`. The General
+    registry is only added from the pkg server if there are no registries
+    added by `config.julia_registry`, otherwise only those registries are
+    used. The build fails with the names of the packages missing in the
+    registries, instead of waiting for the public fallbacks.
+
+    Example usage:
+    ```
+    config.julia_pkg_server(url="https://julia-mirror.example.com")
+    config.julia_offline(release_mirror="https://julia-releases.example.com")
+    ```
+
+    Args:
+        release_mirror (str): mirror of the Julia releases, it's required
+            unless the Julia build is given by `install.julia(debug_url=...)`
+            or the mirror is set by `config.julia(binary_mirror=...)`
+    """
+
+
 def rstudio_server():
     """
     Enable the RStudio Server (only work for `base(os="ubuntu20.04", language="r")`)
@@ -163,3 +230,575 @@ def owner(uid: int, gid: int):
         uid (int): UID
         gid (int): GID
     """
+
+
+def stop_signal(signal: str = "SIGTERM", grace_period: int = 5):
+    """Configure the signal to stop the environment
+
+    The signal is set as the `STOPSIGNAL` of the image, and forwarded to all the
+    daemon processes (`runtime.daemon`, jupyter, etc.) by the supervisor.
+
+    Args:
+        signal (str): signal name, such as "SIGTERM", "SIGINT"
+        grace_period (int): seconds to wait for the processes to exit before
+            they are killed
+    """
+
+
+def cache_dir(path: str):
+    """Configure the common parent directory of the language package caches
+
+    The Julia depot, pip cache and conda pkgs will be placed under
+    `<path>/julia`, `<path>/pip` and `<path>/conda` at runtime. It's
+    recommended to mount a volume to the path with `runtime.mount` to share
+    the caches between environments.
+
+    Args:
+        path (str): absolute path in the container
+    """
+
+
+def shell_completion(pip: bool = False, starship: bool = False):
+    """Enable the shell completions of the installed CLIs
+
+    The completions are loaded in the rc file of the configured shell
+    (bash or zsh). Each tool can be enabled separately.
+
+    Example usage:
+    ```
+    config.shell_completion(pip=True)
+    ```
+
+    Args:
+        pip (bool): enable the completion of `pip`
+        starship (bool): enable the completion of `starship`
+    """
+
+
+def trusted_cert(language: str, cert: str, url: str = ""):
+    """Trust the cert of an internal package server for the language
+
+    A CA bundle with the system CAs and the declared certs is generated for
+    each language, thus the servers of different languages can use different
+    certs. It can be called multiple times.
+
+    Example usage:
+    ```
+    config.trusted_cert(
+        language="julia", cert="certs/pkg.pem", url="https://pkg.internal"
+    )
+    config.trusted_cert(
+        language="python", cert="certs/pypi.pem", url="https://pypi.internal"
+    )
+    ```
+
+    Args:
+        language (str): one of `python` (pip), `conda` and `julia`
+        cert (str): path to the PEM encoded cert file in the host
+        url (Optional[str]): the server that uses the cert, for reference only
+    """
+
+
+def ca_cert(path: str):
+    """Install the CA cert into the system trust store of the image
+
+    It's for the corporate network with the TLS interception. The cert is
+    trusted by apt-get, wget and curl in the build, and pip, conda, npm,
+    cargo and Julia are configured to use the system CA bundle in the build
+    and at runtime. It's also installed into the image of the download steps,
+    e.g. of the Julia and Node.js releases. It can be called multiple times.
+
+    Example usage:
+    ```
+    config.ca_cert(path="certs/corp-root-ca.pem")
+    ```
+
+    Args:
+        path (str): path to the PEM encoded cert file in the host
+    """
+
+
+def proxy(http_proxy: str = "", https_proxy: str = "", no_proxy: str = ""):
+    """Use the proxy in every step of the build
+
+    The empty one falls back to the environment variable of the host, e.g.
+    `HTTPS_PROXY` or `https_proxy`. The proxy is not kept in the image and
+    changing it does not invalidate the build cache.
+
+    Example usage:
+    ```
+    # use HTTP_PROXY, HTTPS_PROXY and NO_PROXY of the host
+    config.proxy()
+    config.proxy(https_proxy="http://proxy.corp:3128", no_proxy="localhost,.corp")
+    ```
+
+    Args:
+        http_proxy (Optional[str]): proxy of the HTTP requests
+        https_proxy (Optional[str]): proxy of the HTTPS requests
+        no_proxy (Optional[str]): comma separated hosts without the proxy
+    """
+
+
+def build_limits(step_timeout: str = "", memory: str = "", cgroup_parent: str = ""):
+    """Limit the resources of every step of the build
+
+    The build fails with the name of the step, e.g. `[internal] installing
+    Julia packages`, if it's not completed in `step_timeout`, which is
+    overridden by `envd build --step-timeout`. The `memory` is the max data
+    segment size (`ulimit -d`) of every process in the steps, which is not
+    a hard limit: it does not cap the resident memory, the `mmap` allocations
+    (e.g. the large blocks of malloc) or the sum of the processes. BuildKit has
+    no per-step memory cgroup, use `cgroup_parent` for the hard limit of the CPU
+    and memory of the steps, which must be created with the limits on the
+    builder host. Changing `memory` or `cgroup_parent` invalidates the build
+    cache.
+
+    Example usage:
+    ```
+    config.build_limits(step_timeout="30m", memory="8g")
+    ```
+
+    Args:
+        step_timeout (Optional[str]): timeout of every step, e.g. `30m` or `2h`
+        memory (Optional[str]): data segment limit of the processes, e.g. `8g`
+        cgroup_parent (Optional[str]): cgroup of the steps on the builder host
+    """
+
+
+def build_worker(constraints: List[str] = [], gpu_constraints: List[str] = []):
+    """Run the build on the BuildKit workers matching the constraints
+
+    The constraints are containerd filters on the worker labels, e.g.
+    `labels.gpu==true`. The `gpu_constraints` only apply to the CUDA related
+    stages (installing the language packages when `install.cuda` is used).
+
+    Example usage:
+    ```
+    config.build_worker(gpu_constraints=["labels.gpu==true"])
+    ```
+
+    Args:
+        constraints (List[str]): worker filters for the whole build
+        gpu_constraints (List[str]): worker filters for the CUDA related stages
+    """
+
+
+def platform(platform: str):
+    """Build the image for the platform
+
+    The image is built for `linux/amd64` by default. The releases of Julia,
+    Node.js and rustup are selected by the platform, e.g. `aarch64` for
+    `linux/arm64`. `envd build --platform` overrides it. The multi-platform
+    image is not supported, build the platforms one by one instead.
+
+    Example usage:
+    ```
+    config.platform(platform="linux/arm64")
+    ```
+
+    Args:
+        platform (str): `linux/amd64` or `linux/arm64`
+    """
+
+
+def metadata(author: str = "", maintainer: str = "", license: str = ""):
+    """Configure the metadata of the image
+
+    They are added to the image labels `org.opencontainers.image.authors`,
+    `maintainer` and `org.opencontainers.image.licenses`.
+
+    Example usage:
+    ```
+    config.metadata(
+        author="envd <envd@tensorchord.ai>",
+        license="Apache-2.0",
+    )
+    ```
+
+    Args:
+        author (str): author of the image
+        maintainer (str): maintainer of the image
+        license (str): SPDX license expression, e.g. `MIT OR Apache-2.0`
+    """
+
+
+def slim_runtime():
+    """Split the build into the builder and runtime stages
+
+    Only the base image, the system packages, and the Julia binary and depots
+    under `/opt/julia` are copied into the final image, the other changes made
+    by the language setup are left in the builder stage. It's only supported
+    for Julia in the non-dev environment (`base(dev=False)`).
+    """
+
+
+def feature(name: str, enabled: bool = True):
+    """Enable or disable the feature consulted by the installers
+
+    The known features are:
+    - `julia.lock_depot` (default `False`): same as `install.julia(lock_depot=True)`
+    - `julia.precompile` (default `True`): precompile the Julia packages when
+        they are installed
+    - `apt.cleanup_lists` (default `True`): keep the apt lists out of the image.
+        The lists are shared by the apt steps (including `runtime.run`) in a build
+        cache, so they are fetched once and not left in any layer
+    - `julia.shared_cache` (default `False`): share the compiled Julia artifacts
+        with the other envd builds on the host. The cache is keyed by the Julia
+        distribution, thus the environments of the same Julia version reuse the
+        precompiled files of the overlapping packages. Like `julia.download_cache`,
+        only the precompiled files of the used packages are copied into the image
+    - `julia.download_cache` (default `True`): reuse the Julia package sources
+        and artifacts downloaded by the other envd builds on the host. The
+        cache is mounted over the depot for the Pkg operations, and only the
+        files used by the environment are copied into the image afterwards
+    - `julia.grouped_install` (default `False`): add every group of the Julia
+        packages (i.e. every `install.julia_packages`) by its own Julia process,
+        thus the failure is attributed to the group. Otherwise all the packages
+        are added and precompiled together at once
+    - `julia.precompile_once` (default `False`): precompile the Julia packages
+        and projects by a single `Pkg.precompile()` after all the Pkg operations,
+        instead of after every one of them. It has no effect if
+        `julia.precompile` is disabled
+    - `julia.relocatable_depot` (default `False`): keep the absolute paths out of
+        the Julia depot, thus it can be tarred and unpacked elsewhere. The
+        artifacts are looked up by the hashes, the artifact overrides of the
+        paths are rejected, and the build fails if the depot path is baked into
+        any manifest or preferences. The dev packages and projects stay outside
+        of the depot
+    - `build.rootless` (default `False`): create the Julia depot, the sysimage
+        and the cache dirs owned by the user up front, and run the Pkg
+        operations as the user, instead of chowning the dirs as root after the
+        installation. It avoids the duplicated layers of the chown, and works
+        with the rootless builder (`envd context create --builder-rootless`).
+        It only takes effect in the dev environment of the non-root user
+
+    Unknown features are ignored with a warning.
+
+    Example usage:
+    ```
+    config.feature("julia.precompile", enabled=False)
+    ```
+
+    Args:
+        name (str): feature name
+        enabled (bool): enable or disable the feature
+    """
+
+
+def attestation(output: str = "attestation.intoto.json", signing_key: str = ""):
+    """Generate the SLSA provenance attestation of the build
+
+    The attestation is an in-toto statement, which records the output image
+    digest and the build inputs: the base image digest, the Julia tarball and
+    its checksum, the downloaded files and the declared packages. It's wrapped
+    in a signed DSSE envelope if the signing key is provided.
+
+    Example usage:
+    ```
+    config.attestation(signing_key="/home/user/.envd/attestation.key")
+    ```
+
+    Args:
+        output (str): path to write the attestation, relative to the build context
+        signing_key (Optional[str]): path to the PEM encoded PKCS8 private key
+            (ed25519, ECDSA or RSA) in the host
+    """
+
+
+def image_compression(algorithm: str, level: int = -1):
+    """Configure the layer compression of the output image
+
+    `zstd` makes the large layers (e.g. the Julia depot) smaller and faster to
+    push, at the cost of the build time. It requires the OCI media types,
+    which are enabled automatically. The target registry must support the
+    zstd layers, and loading them into Docker requires Docker Engine 23.0 or
+    later. The layers of the base image are kept as is. The options in
+    `envd build --output` take precedence.
+
+    Example usage:
+    ```
+    config.image_compression(algorithm="zstd", level=9)
+    ```
+
+    Args:
+        algorithm (str): one of `gzip`, `zstd`, `estargz` and `uncompressed`
+        level (int): compression level, 0-9 for `gzip` and `estargz`, 0-22 for
+            `zstd`. The default of the algorithm is used if it's negative
+    """
+
+
+def julia_runtime(
+    heap_size_hint: str = "", gc_threads: int = 0, threads: str = "", cpu_target: str = ""
+):
+    """Configure the default flags and environment variables of Julia at runtime
+
+    A wrapper of `julia` with the flags `--heap-size-hint` and `--gcthreads`
+    is generated. The heap size hint prevents the OOM kills in the memory
+    constrained containers. Note that `--heap-size-hint` requires Julia 1.9+
+    and `--gcthreads` requires Julia 1.10+, the build fails if the installed
+    Julia does not support them.
+
+    `threads` and `cpu_target` are exported as `JULIA_NUM_THREADS` and
+    `JULIA_CPU_TARGET` in the environment. The CPU target is also exported at
+    build time, thus the packages are precompiled for it.
+
+    Example usage:
+    ```
+    config.julia_runtime(heap_size_hint="4G", threads="auto")
+    ```
+
+    Args:
+        heap_size_hint (str): heap size hint, e.g. `512M`, `4G`, at least 64M. The
+            default of Julia is used if it's empty.
+        gc_threads (int): number of GC threads. The default of Julia is used if it's 0.
+        threads (str): number of threads, e.g. `auto`, `4`, or `4,1` with the
+            interactive threads. The default of Julia is used if it's empty.
+        cpu_target (str): CPU target of the compiled code, e.g. `generic`. The
+            default of Julia is used if it's empty.
+    """
+
+
+def julia_repl(banner: bool = True, color: str = "", display_rows: int = 0):
+    """Configure the interactive defaults of Julia
+
+    Every setting is optional and keeps the Julia default if it's not set.
+    `banner` and `color` are added to the flags of the `julia` wrapper (see
+    `config.julia_runtime`), and `display_rows` is set in the system wide
+    `startup.jl`, which respects `LINES` set by the user.
+
+    Example usage:
+    ```
+    config.julia_repl(banner=False, color="yes", display_rows=20)
+    ```
+
+    Args:
+        banner (bool): show the startup banner
+        color (str): `yes` or `no` to force the color output
+        display_rows (int): number of the rows to display the arrays
+    """
+
+
+def tmux(default_session: bool = True):
+    """Install tmux with a default config (`~/.tmux.conf`) in the dev environment
+
+    It's useful to keep the long-running sessions over SSH. If
+    `default_session` is enabled, run `envd-tmux` to attach to the default
+    session, which is created with a shell window (and a Julia REPL window for
+    the Julia environment) if it does not exist.
+
+    Example usage:
+    ```
+    config.tmux()
+    ```
+
+    Args:
+        default_session (bool): generate `envd-tmux` for the default session layout
+    """
+
+
+def init_process(name: str = "tini"):
+    """Run the init process as PID 1, with the entrypoint as its child
+
+    The init process reaps the zombies of the orphaned processes (e.g. the
+    ones started by `nohup cmd &`), and forwards the signals to the entrypoint.
+    It's enabled with `tini` by default if any `runtime.daemon` is declared.
+
+    Example usage:
+    ```
+    config.init_process(name="dumb-init")
+    ```
+
+    Args:
+        name (str): one of `tini`, `dumb-init` and `none` (disable it)
+    """
+
+
+def julia_failure_hook(command: str):
+    """Run the diagnostic command when a Julia package fails to install
+
+    The command runs by `sh` before the build aborts, and its output goes to
+    the build log. The packages of the failed Pkg operation are available in
+    `ENVD_JULIA_PACKAGES` (separated by spaces), and the depots in
+    `JULIA_DEPOT_PATH`.
+
+    Example usage:
+    ```
+    config.julia_failure_hook(
+        command="for p in $ENVD_JULIA_PACKAGES; do cat /opt/julia/user_packages/packages/$p/*/deps/build.log; done"
+    )
+    ```
+
+    Args:
+        command (str): the command to run on failure
+    """
+
+
+def julia_build_log(max_size: str = "64KiB"):
+    """Cap the build logs of the Julia packages baked into the image
+
+    The `deps/build.log` of the packages in the depot are truncated to the
+    max size before the image is committed, only the tail of the logs is kept.
+    They are capped to 64KiB by default even if this is not declared.
+
+    Example usage:
+    ```
+    config.julia_build_log(max_size="1MiB")
+    # keep the whole logs
+    config.julia_build_log(max_size="")
+    ```
+
+    Args:
+        max_size (str): the max size of each build log, empty for no limit
+    """
+
+
+def vscode_settings(settings: Dict[str, Any] = {}, file: str = ""):
+    """Bake the default settings of VS Code server
+
+    The settings are merged into the machine settings
+    (`~/.vscode-server/data/Machine/settings.json`) when the environment
+    starts, and the existing settings of the user take precedence. The
+    `julia.executablePath` is populated from the Julia install location if
+    it's not set.
+
+    Example usage:
+    ```
+    config.vscode_settings(
+        settings={"editor.formatOnSave": True},
+        file="settings.json",
+    )
+    ```
+
+    Args:
+        settings (Dict[str, Any]): the settings, which override the ones in the file
+        file (str): path of the `settings.json` in the build context, the
+            comments are not supported
+    """
+
+
+def julia_preferences(preferences: Dict[str, Dict[str, Any]] = {}, file: str = ""):
+    """Bake the Julia preferences of the packages, e.g. for MPI.jl or PythonCall
+
+    The preferences are written to the `LocalPreferences.toml` of the default
+    environment, where `install.julia_packages` adds the packages, before
+    they are installed, thus they are picked up during the precompilation.
+    The Julia projects of `install.julia_projects` keep their own
+    `LocalPreferences.toml`.
+
+    Example usage:
+    ```
+    config.julia_preferences(
+        preferences={"MPIPreferences": {"binary": "system", "abi": "MPICH"}},
+    )
+    ```
+
+    Args:
+        preferences (Dict[str, Dict[str, Any]]): the preferences keyed by the
+            package names, which override the ones in the file
+        file (str): path of the TOML file in the build context
+    """
+
+
+def user(
+    name: str,
+    uid: int,
+    group: str = "",
+    groups: List[str] = [],
+    directories: List[str] = [],
+):
+    """Create an additional user besides `envd` in the image
+
+    The users are created before the packages are installed, thus they can
+    be used in `run(user=)`. The uids and gids should not collide with each
+    other, nor with the host uid and gid taken by `envd` in the dev
+    environment.
+
+    Example usage:
+    ```
+    config.group(name="data", gid=2000)
+    config.user(name="worker", uid=2001, groups=["data"], directories=["/data"])
+    ```
+
+    Args:
+        name (str): name of the user
+        uid (int): uid of the user
+        group (str): primary group declared by `config.group`, the group
+            named after the user with the gid of `uid` is created if it's empty
+        groups (List[str]): supplementary groups, declared by `config.group`
+            or existing in the base image
+        directories (List[str]): absolute paths of the directories owned by
+            the user and its primary group
+    """
+
+
+def group(name: str, gid: int):
+    """Create an additional group in the image
+
+    Args:
+        name (str): name of the group
+        gid (int): gid of the group
+    """
+
+
+def output(
+    target: str,
+    name: str = "",
+    path: str = "",
+    insecure: bool = False,
+    docker_config: str = "",
+):
+    """Set the output target of the image, it's overridden by `envd build --output`
+
+    The targets are:
+    - `docker`: load the image to the docker host, by default
+    - `registry`: push the image to the registry
+    - `oci`: write the OCI image layout to the directory
+    - `tarball`: write the docker archive to the file, which can be loaded by
+        `docker load`
+
+    The target and its options are validated when the build starts.
+
+    Example usage:
+    ```
+    config.output(target="registry", name="ghcr.io/org/env:v1")
+    config.output(target="oci", path="build/oci")
+    ```
+
+    Args:
+        target (str): one of `docker`, `registry`, `oci` and `tarball`
+        name (str): the image reference, the tag of the build is used if it's
+            empty, required by `registry`
+        path (str): the directory of `oci` or the file of `tarball`, relative
+            to the build context
+        insecure (bool): allow pushing to the HTTP or self-signed registry
+        docker_config (str): the directory of the `config.json` with the
+            registry credentials, the default docker config is used if it's empty
+    """
+
+
+def julia_artifact_overrides(file: str, artifacts_dir: str = ""):
+    """Bake the Julia artifact overrides, e.g. for the air-gapped JLL binaries
+
+    The artifacts in `artifacts_dir` are copied to
+    `/opt/julia/artifact_overrides`, and the `Overrides.toml` is written to the
+    depot before any of the Julia packages is installed, thus the overridden
+    artifacts are never downloaded.
+
+    The keys of the `Overrides.toml` are the artifact hashes or the package
+    UUIDs, and the values are the artifact hashes or the paths of the baked
+    artifacts. The relative paths are resolved against `artifacts_dir`, and
+    every path must be in it.
+
+    Example usage:
+    ```
+    config.julia_artifact_overrides(
+        file="Overrides.toml",
+        artifacts_dir="artifacts",
+    )
+    ```
+
+    Args:
+        file (str): path of the `Overrides.toml` in the build context
+        artifacts_dir (str): path of the directory with the artifacts in the
+            build context
+    """
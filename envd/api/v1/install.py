@@ -27,17 +27,31 @@ v1 is experimental and may change in the future. Make sure to freeze the envd ve
 :::
 """
 
-from typing import List, Optional
+from typing import Dict, List, Optional
 
 
-def python(version: str = "3.9"):
+def python(
+    version: str = "3.9", pip: str = "", setuptools: str = "", wheel: str = ""
+):
     """Install python.
 
     If `install.conda` is not used, this will create a solo Python environment. Otherwise, it
     will be a conda environment.
 
+    The pip toolchain shipped with the environment is used by default. Pin the
+    versions of `pip`, `setuptools` and `wheel` for the reproducible installs,
+    they are installed before any Python package.
+
+    Example usage:
+    ```
+    install.python(version="3.10", pip="23.0.1", setuptools="67.4.0", wheel="0.38.4")
+    ```
+
     Args:
         version (str): Python version
+        pip (str): pinned pip version
+        setuptools (str): pinned setuptools version
+        wheel (str): pinned wheel version
     """
 
 
@@ -50,15 +64,65 @@ def conda(use_mamba: bool = False):
 
 
 def r_lang():
-    """Install R Lang."""
+    """Install R Lang.
+
+    The site library is owned by the user of the dev environment, and the
+    CRAN mirror (see `config.cran_mirror`) is set in the site profile, thus
+    `install.packages` works at runtime without sudo.
+    """
+
 
+def julia(
+    lock_depot: bool = False,
+    debug_url: str = "",
+    debug_sha256: str = "",
+    resolve_strategy: str = "all",
+    version: str = "",
+    precompile_seed: Optional[int] = None,
+):
+    """Install Julia.
+
+    The Julia release is linked against glibc, thus the base image must be
+    glibc based, and the build fails early on the musl based images such as
+    Alpine.
+
+    The stripped release of Julia 1.8.5 is installed by default. Set `version`
+    to install another release, whose checksum is verified against the
+    published checksums of the release, the build fails if it does not exist.
+    Set `debug_url` to install a Julia distribution built with the debug
+    symbols (e.g. by `make debug binary-dist`) instead, which provides both
+    `julia` and `julia-debug`. Note that the debug build increases the image
+    size significantly.
 
-def julia():
-    """Install Julia."""
+    Args:
+        lock_depot (bool): set the depot of the packages installed by
+            `install.julia_packages` read-only after the build, to prevent
+            users from mutating the shared installation. Packages added at
+            runtime go to a writable depot (`~/.julia` or the one under
+            `config.cache_dir`). Run `envd-unlock` in the container to unlock it.
+        debug_url (str): URL of the Julia debug build tarball
+        debug_sha256 (str): sha256 checksum of the Julia debug build tarball
+        resolve_strategy (str): how aggressively `Pkg.add` and `Pkg.develop`
+            move the versions of the packages installed by the previous steps,
+            one of `all`, `direct`, `semver`, `tiered` and `none`, which map to
+            the `Pkg.PRESERVE_*` levels. `all` keeps the versions unchanged and
+            fails if it's not resolvable, use `tiered` (the Pkg default) to fall
+            back to upgrades, or `none` to get the latest versions.
+        version (str): the full Julia release, e.g. `1.6.7` or `1.10.0`,
+            1.6 or later, can not be used with `debug_url`
+        precompile_seed (Optional[int]): fixed RNG seed of the precompilation,
+            for the bit-reproducible depots. The automatic precompilation of
+            the Pkg operations is disabled, and the packages are precompiled
+            one by one by a single `Pkg.precompile()` after them, seeded by
+            `Random.seed!`. Julia has no environment variable for the seed,
+            thus the precompile workers only get it as
+            `ENVD_JULIA_PRECOMPILE_SEED`, for the packages which seed their
+            randomized code paths from it. Unset by default.
+    """
 
 
 def apt_packages(name: List[str] = []):
-    """Install package by system-level package manager (apt on Ubuntu).
+    """Install package by system-level package manager (apt on Ubuntu, apk on Alpine).
 
     Args:
         name (List[str]): apt package name list
@@ -72,7 +136,10 @@ def python_packages(
 
     Args:
         name (List[str]): package name list
-        requirements (str): requirements file path
+        requirements (str): requirements file path. Only the file and the nested
+            requirements and constraints (`-r` and `-c`) are sent to the build,
+            thus the cache depends on their content. The whole build context is
+            sent if the local packages are referred, e.g. `-e .`
         local_wheels (List[str]): local wheels
             (wheel files should be placed under the current directory)
     """
@@ -89,6 +156,25 @@ def conda_packages(name: List[str] = [], channel: List[str] = [], env_file: str
     """
 
 
+def conda_env(path: str = "environment.yml"):
+    """Install python packages from the conda environment file
+
+    It requires `install.conda()`. The same as `conda_packages(env_file=path)`,
+    the pip requirements in the file are handled the same way as
+    `python_packages(requirements=...)`.
+
+    Args:
+        path (str): conda environment file path in the build context
+
+    Example:
+    ```python
+    install.conda()
+    install.python()
+    install.conda_env(path="environment.yml")
+    ```
+    """
+
+
 def r_packages(name: List[str]):
     """Install R packages by R package manager.
 
@@ -97,14 +183,277 @@ def r_packages(name: List[str]):
     """
 
 
-def julia_packages(name: List[str]):
+def julia_packages(
+    name: List[str] = [],
+    platform: str = "",
+    uuid: Dict[str, str] = {},
+    path: str = "",
+    precompile: bool = False,
+    sysimage: bool = False,
+):
     """Install Julia packages.
 
+    With `platform`, the packages replace the default ones (declared without
+    `platform`) when building for the platform, e.g. to skip the packages
+    without the arm64 binaries. The platforms without the overrides install
+    the default packages. The platform is `linux/amd64` unless it's set by
+    `config.platform`.
+
+    Example usage:
+    ```
+    install.julia_packages(name=["Flux", "CUDA"])
+    install.julia_packages(name=["Flux"], platform="linux/arm64")
+    ```
+
+    Pin the version by `name@version`, e.g. `DataFrames@1.5.0`, or
+    `Flux@0.13` for the latest `0.13.x`:
+    ```
+    install.julia_packages(name=["DataFrames@1.5.0", "Flux@0.13"])
+    ```
+
+    The unregistered packages are added from Git by the HTTPS or SSH URLs, or
+    `owner/repo` on GitHub, optionally with the branch, tag or commit after
+    `#`. The SSH remotes require the credentials available to the builder:
+    ```
+    install.julia_packages(
+        name=[
+            "https://gitlab.example.com/team/Internal.jl.git#v1.2.0",
+            "git@gitlab.example.com:team/Tools.jl.git",
+            "JuliaLang/Example.jl#main",
+        ]
+    )
+    ```
+
+    If the packages with the same name are in different registries, set the
+    UUID to disambiguate them:
+    ```
+    install.julia_packages(
+        name=["Example"], uuid={"Example": "7876af07-990d-54b4-ab0e-23690620f79a"}
+    )
+    ```
+
+    Import the exact dependencies of a project by `path`, which is the
+    `Project.toml` (or its dir) in the build context. The project is
+    instantiated from its `Manifest.toml`, the same as `install.julia_projects`:
+    ```
+    install.julia_packages(path="./Project.toml")
+    ```
+
+    Cut the first-use latency of the heavy packages by precompiling them and
+    baking them into the sysimage, the same as `install.julia_sysimage`:
+    ```
+    install.julia_packages(name=["Plots", "DataFrames"], precompile=True, sysimage=True)
+    ```
+
+    Args:
+        name (List[str]): List of Julia packages, `name@version` or Git `url#rev`
+        platform (str): the platform of the overrides, e.g. `linux/arm64`
+        uuid (Dict[str, str]): UUIDs of the packages by name
+        path (str): path of the `Project.toml` relative to the build context
+        precompile (bool): precompile the packages even if the auto
+            precompilation is disabled by `config.feature("julia.precompile", False)`
+        sysimage (bool): bake the packages into the sysimage by PackageCompiler,
+            merged with the packages of `install.julia_sysimage`
+    """
+
+
+def julia_dev_packages(path: List[str]):
+    """Develop the local Julia packages in the build context.
+
+    The packages are tracked by `Pkg.develop`, thus the edits are picked up
+    live in the dev environment.
+
+    Example usage:
+    ```
+    install.julia_dev_packages(path=["packages/MyPkg"])
+    ```
+
+    Args:
+        path (List[str]): List of package paths relative to the build context,
+            each must contain a `Project.toml`
+    """
+
+
+def julia_projects(path: List[str], parallel: bool = False, activate: bool = False):
+    """Instantiate the Julia projects in the build context.
+
+    The dependencies pinned by the `Manifest.toml` of every project are
+    installed by `Pkg.instantiate`. The project without the `Manifest.toml` is
+    resolved from its `Project.toml` with a warning, thus the versions are not
+    reproducible.
+
+    With `activate`, the project is the active one (`JULIA_PROJECT`) at
+    runtime, and the packages of `install.julia_packages` are still loadable.
+
+    With `parallel`, the projects are instantiated concurrently, each from the
+    same depot, and the results are merged into the final depot. It speeds up
+    the monorepo with independent projects, note that if the projects share
+    the same registry or package files, the copy of the last project wins.
+
+    Example usage:
+    ```
+    install.julia_projects(path=["services/api", "services/worker"], parallel=True)
+    ```
+
+    Args:
+        path (List[str]): List of project paths relative to the build context,
+            each must contain a `Project.toml`, and should contain a `Manifest.toml`
+        parallel (bool): instantiate the projects concurrently
+        activate (bool): activate the project at runtime, it requires a single path
+    """
+
+
+def julia_debugger(debuggers: List[str] = ["Debugger", "Infiltrator"]):
+    """Add the Julia debuggers in the dev environment.
+
+    The debuggers are added by the first `Pkg.add` of `install.julia_packages`,
+    and skipped if the environment is not for development. If the VS Code
+    extension `julialang.language-julia` is installed, the launch config to
+    debug the active Julia file is added to the VS Code settings.
+
+    Example usage:
+    ```
+    install.julia_debugger(debuggers=["Infiltrator"])
+    ```
+
+    Args:
+        debuggers (List[str]): `Debugger` and/or `Infiltrator`
+    """
+
+
+def julia_cuda(version: str = "", preload: bool = True):
+    """Add the GPU support of Julia by CUDA.jl.
+
+    CUDA.jl is added by the first `Pkg.add` of `install.julia_packages`, and
+    the runtime environment is set for the NVIDIA container runtime to mount
+    the driver and the GPUs of `runtime.gpu`, one GPU if it's not set. With
+    `preload`, CUDA.jl is loaded at build time to download and compile the
+    CUDA artifacts for the driver of the builder, thus the first `using CUDA`
+    in the container does not stall. If the builder has no GPU, the artifacts
+    are downloaded at the first use instead of failing the build. With
+    `install.cuda`, the GPU workers are selected by
+    `config.build_worker(gpu_constraints=...)`. For CUDA.jl 4.0 or later, set
+    the preference `local = "true"` of `CUDA_Runtime_jll` by
+    `config.julia_preferences` to use the toolkit of the CUDA base image
+    instead of the artifacts.
+
+    Example usage:
+    ```
+    install.julia_cuda(version="5.1")
+    ```
+
+    Args:
+        version (str): version of CUDA.jl, the latest one by default
+        preload (bool): load CUDA.jl at build time
+    """
+
+
+def julia_sysimage(packages: List[str] = []):
+    """Build the Julia sysimage with the packages by PackageCompiler.
+
+    The sysimage is built after the Julia packages are installed, and used by
+    the default `julia` by `--sysimage`, thus `using` the packages does not
+    load or compile them at runtime. PackageCompiler itself is not kept in the
+    image. The C compiler is required, e.g. by
+    `install.apt_packages(name=["build-essential"])`. Note that the build is
+    slow and the sysimage is large.
+
+    Example usage:
+    ```
+    install.julia_packages(name=["Plots", "DataFrames"])
+    install.julia_sysimage(packages=["Plots"])
+    ```
+
+    Args:
+        packages (List[str]): packages baked into the sysimage, all the Julia
+            packages by default
+    """
+
+
+def julia_cache_packages(name: List[str]):
+    """Fetch Julia packages at build time without installing them.
+
+    The packages are stored in a separate depot appended to `JULIA_DEPOT_PATH`,
+    thus `Pkg.add` of them at runtime is fast and works offline.
+
     Args:
         name (List[str]): List of Julia packages
     """
 
 
+def node(version: str = ""):
+    """Install Node.js, independent of the language of the environment.
+
+    The official release is verified by its checksums and unpacked under
+    `/opt/node`, which is owned by the user, thus `npm install -g` works in
+    the container without sudo. pnpm and yarn are enabled by corepack, and
+    fetched at the first use.
+
+    Example usage:
+    ```
+    install.node(version="18.19.1")
+    ```
+
+    Args:
+        version (str): full Node.js release, e.g. 20.11.1, the LTS one by default
+    """
+
+
+def npm_packages(name: List[str]):
+    """Install the global packages by npm.
+
+    The default Node.js is installed if `install.node` is not declared. The
+    npm cache is shared across the builds, and kept under `config.cache_dir`
+    at runtime if it is configured.
+
+    Example usage:
+    ```
+    install.npm_packages(name=["pnpm", "typescript@5.3.3"])
+    ```
+
+    Args:
+        name (List[str]): package name list
+    """
+
+
+def rust(toolchain: str = "", components: List[str] = []):
+    """Install the Rust toolchain by rustup, independent of the language of
+    the environment.
+
+    The toolchain is installed with the minimal profile under `/opt/rust`,
+    with `RUSTUP_HOME` and `CARGO_HOME` owned by the user, thus `rustup` and
+    `cargo install` work in the container without sudo. cargo is available to
+    the package installation, e.g. to build the PyPI wheels with the Rust
+    extensions like tokenizers. Linking requires a C compiler, e.g.
+    `install.apt_packages(name=["build-essential"])`.
+
+    Example usage:
+    ```
+    install.rust(toolchain="1.75.0", components=["clippy", "rustfmt"])
+    ```
+
+    Args:
+        toolchain (str): channel or release, e.g. nightly, 1.75.0, the stable one by default
+        components (List[str]): rustup components added to the minimal profile
+    """
+
+
+def cargo_packages(name: List[str]):
+    """Install the tools by `cargo install --locked`.
+
+    The stable Rust is installed if `install.rust` is not declared. The
+    registry of cargo is shared across the builds.
+
+    Example usage:
+    ```
+    install.cargo_packages(name=["ripgrep", "cargo-nextest@0.9.67"])
+    ```
+
+    Args:
+        name (List[str]): package name list
+    """
+
+
 def vscode_extensions(name: List[str]):
     """Install VS Code extensions
 
@@ -113,10 +462,32 @@ def vscode_extensions(name: List[str]):
     """
 
 
-def cuda(version: str, cudnn: Optional[str] = "8"):
+def cuda(
+    version: str,
+    cudnn: Optional[str] = None,
+    compute_capabilities: Optional[List[str]] = None,
+):
     """Install CUDA dependency
 
+    The compute capabilities are not specific to CUDA.jl, they apply to the
+    whole image: they are exported as `TORCH_CUDA_ARCH_LIST` and `CUDAARCHS`
+    in both the build and the environment, and every ahead-of-time CUDA build
+    that consults them targets these capabilities, e.g. the PyTorch
+    extensions and the CMake projects installed by any language. Multiple
+    capabilities make the image portable across GPUs. CUDA.jl selects its
+    artifacts by the CUDA version and compiles the kernels at runtime for the
+    device in use, thus it's not affected by the capabilities.
+
+    The pinned PyTorch or TensorFlow in `install.python_packages`, e.g.
+    `torch==2.1.0`, is checked against the CUDA versions of its official
+    builds, and the build fails early if they do not match.
+
     Args:
-        version (str): CUDA version, such as '11.6.2'
-        cudnn (optional, str): CUDNN version, such as '8'
+        version (str): CUDA version, such as '11.6.2' or '12.1', the latter is
+            expanded to the latest patch release
+        cudnn (optional, str): CUDNN version, such as '8'. It defaults to '8',
+            or '9' since CUDA 12.4
+        compute_capabilities (optional, List[str]): GPU compute capabilities
+            to build for, such as ['8.0', '8.6']. envd warns if one of them is
+            not supported by the CUDA version.
     """
@@ -47,3 +47,58 @@ def http(url: str, checksum: Optional[str], filename: Optional[str]):
         checksum (Optional[str]): checksum for the downloaded file
         filename (Optional[str]): rewrite the filename
     """
+
+
+def mount_secret(
+    id: str, target: str, env: Optional[str] = None, file: Optional[str] = None
+):
+    """Mount the secret in the install steps of the packages and `run` (build time)
+
+    The secret is only mounted during the build steps, it will not be persisted
+    in the image layers. It can be overridden by `envd build --secret id=file`.
+
+    Args:
+        id (str): ID of the secret
+        target (str): path of the secret in the build steps, e.g. `/root/.netrc`
+        env (Optional[str]): environment variable in the host that holds the secret
+        file (Optional[str]): file in the host that holds the secret, relative
+            to the build context if it's not absolute
+
+    Example:
+    ```python
+    # the credentials of the private PyPI index, git and Julia registries,
+    # built by `envd build --secret netrc=$HOME/.netrc`
+    io.mount_secret(id="netrc", target="/root/.netrc")
+    install.python_packages(name=["internal-package"])
+    ```
+    """
+
+
+def mount(
+    envd_path: str,
+    host_path: Optional[str] = None,
+    volume: Optional[str] = None,
+    readonly: bool = False,
+):
+    """Mount the host path or the named volume into the environment (runtime)
+
+    The mounts are set up by `envd up`. The named volume is created if it does
+    not exist, and it is populated by the content of the image at the first
+    mount, thus it is suitable for the caches shared by the environments.
+    Exactly one of `host_path` and `volume` must be specified. `~` is expanded
+    to the home of the host user in `host_path` and to the home of the envd
+    user in `envd_path`.
+
+    Args:
+        envd_path (str): destination path in the envd container
+        host_path (Optional[str]): source path in the host machine, relative to
+            the build context if it's not absolute
+        volume (Optional[str]): name of the volume, e.g. `huggingface-cache`
+        readonly (bool): mount as read-only
+
+    Example:
+    ```python
+    io.mount(host_path="/data/imagenet", envd_path="/data/imagenet", readonly=True)
+    io.mount(volume="huggingface-cache", envd_path="~/.cache/huggingface")
+    ```
+    """
@@ -27,7 +27,7 @@ v1 is experimental and may change in the future. Make sure to freeze the envd ve
 :::
 """
 
-from typing import List, Optional, Dict
+from typing import List, Optional, Dict, Union
 
 
 def command(commands: Dict[str, str]):
@@ -53,6 +53,7 @@ def expose(
     host_port: Optional[str],
     service: Optional[str],
     listen_addr: Optional[str],
+    publish: bool = True,
 ):
     """Expose port to host
     Proposal: https://github.com/tensorchord/envd/pull/780
@@ -63,10 +64,17 @@ def expose(
             `host_port=0`, `envd` will randomly choose a free port
         service (Optional[str]): service name
         listen_addr (Optional[str]): address to listen on
+        publish (bool): publish the port to the host, otherwise it's only
+            exposed in the image, and `host_port` must not be set
     """
 
 
-def daemon(commands: List[List[str]]):
+def daemon(
+    commands: List[List[str]],
+    probes: List[str] = [],
+    names: List[str] = [],
+    restart: str = "on-failure",
+):
     """Run daemon processes in the container
     Proposal: https://github.com/tensorchord/envd/pull/769
 
@@ -75,15 +83,32 @@ def daemon(commands: List[List[str]]):
     You can find the generated horust config files under `/etc/horust/services`
     and log files under `/var/log/horust` in the container.
 
+    The daemon is ready after its probe succeeds, which is checked by horust
+    without any tool in the image. The probe is either the HTTP endpoint to
+    GET, or the absolute path of the file created by the daemon when it's
+    ready. The built-in jupyter and rstudio have the HTTP probes by default.
+
+    The stdout and stderr of the daemon can be printed by `envd logs <name>`,
+    the name is `daemon_<index>` if it's not specified.
+
     Args:
         commands (List[List[str]]): run multiple commands in the background
+        probes (List[str]): the readiness probes of the daemons respectively,
+            the HTTP endpoint or the file path, an empty string for no probe
+        names (List[str]): the service names of the daemons respectively, an
+            empty string for the default name
+        restart (str): restart strategy of the daemons, one of "always",
+            "on-failure" and "never"
 
     Example usage:
     ```
     runtime.daemon(commands=[
         ["jupyter-lab", "--port", "8080"],
         ["python3", "serving.py", ">>serving.log", "2>&1"],
-    ])
+    ], probes=[
+        "http://127.0.0.1:8080/api",
+        "/tmp/serving.ready",
+    ], names=["jupyter-lab", "serving"], restart="always")
     ```
     """
 
@@ -111,6 +136,76 @@ def mount(host_path: str, envd_path: str):
     """
 
 
+def artifact(reference: str, envd_path: str):
+    """Bake a read-only artifact from the registry into the container path
+
+    Unlike `runtime.mount`, the artifact is immutable and versioned by the
+    digest. The artifact must be packaged as a container image, e.g. built
+    `FROM scratch` with the files copied to `/`. The other OCI artifacts (e.g.
+    pushed by `oras push`) are not supported. The content of the image is
+    copied under `envd_path` at build time, owned by root. It's a separate
+    layer of the image, thus it's shared by the environments with the same
+    artifact digest and the destination.
+
+    Example usage:
+    ```
+    runtime.artifact(
+        reference="ghcr.io/org/mnist@sha256:...",
+        envd_path="~/data/mnist",
+    )
+    ```
+
+    Args:
+        reference (str): artifact reference, must be pinned by digest
+        envd_path (str): destination path in the envd container
+    """
+
+
+def depends_on(host: str, port: int, name: str = "", timeout: int = 60):
+    """Declare an external service that the environment depends on
+
+    The entrypoint waits for the service to be reachable (TCP) before starting,
+    and fails if it's still not reachable after the timeout. It can be called
+    multiple times for multiple services.
+
+    Example usage:
+    ```
+    runtime.depends_on(host="postgres", port=5432, name="database")
+    ```
+
+    Args:
+        host (str): hostname or IP address of the service
+        port (int): port of the service
+        name (Optional[str]): name of the service, defaults to `host:port`
+        timeout (int): seconds to wait for the service, 0 means waiting forever
+    """
+
+
+def gpu(count: int = 0, devices: List[Union[int, str]] = [], capabilities: List[str] = []):
+    """Attach the GPUs to the environment
+
+    One GPU is attached by default if `install.cuda` is used. Set the `count`
+    (-1 for all the GPUs), or pin the `devices` by the indexes or the UUIDs of
+    the GPUs or the MIG devices, on the multi-GPU hosts. The capabilities of
+    the driver are `compute`, `compat32`, `graphics`, `utility`, `video` and
+    `display`, all of them by default. `envd up --gpu-devices` overrides the
+    devices, and `envd up --no-gpu` attaches none.
+
+    Example usage:
+    ```
+    runtime.gpu(count=2)
+    runtime.gpu(devices=[0, 2], capabilities=["compute", "utility", "video"])
+    runtime.gpu(devices=["MIG-4b3c2d1e-aaaa-bbbb-cccc-0123456789ab"])
+    ```
+
+    Args:
+        count (int): number of GPUs, 1 if neither the count nor the devices is set
+        devices (List[Union[int, str]]): indexes or UUIDs of the devices,
+            mutually exclusive with `count`
+        capabilities (List[str]): capabilities of the driver
+    """
+
+
 def init(commands: List[str]):
     """Commands to be executed when start the container
 
@@ -120,3 +215,60 @@ def init(commands: List[str]):
     Args:
         commands (List[str]): list of commands
     """
+
+
+def pre_destroy(commands: List[str]):
+    """Commands to be executed before the container is stopped or destroyed
+
+    The commands run when the environment receives the stop signal, e.g. to
+    flush the caches or release the remote resources. They must finish within
+    the grace period of `config.stop_signal`, the container is killed after that.
+    `run` and `runtime.init` are the build time and start time counterparts.
+
+    Example usage:
+    ```
+    runtime.pre_destroy(commands=["pkill -f tensorboard", "sync"])
+    ```
+
+    Args:
+        commands (List[str]): list of commands
+    """
+
+
+def entry_script(
+    path: Optional[str] = "/etc/profile.d/envd.sh", commands: List[str] = []
+):
+    """Generate a script that sources the runtime environments
+
+    The script exports the environments from `runtime.environ`, the extra PATH
+    and activates the conda environment if any. It will be sourced by the shell
+    rc files so that non-login shells also get the environments.
+
+    Args:
+        path (Optional[str]): location of the generated script in the container
+        commands (List[str]): extra commands appended to the script
+
+    Example usage:
+    ```
+    runtime.entry_script(commands=["ulimit -n 65536"])
+    ```
+    """
+
+
+def secret(source: str, envd_path: str):
+    """Declare the secret file mounted read-only at runtime
+
+    Unlike the build secrets, the secret is provided by the orchestrator (e.g.
+    envd-server) when the environment starts, it's never baked into the image.
+    Only the parent directory of `envd_path` is created in the image, and the
+    declarations are recorded in the image label for the orchestrator.
+
+    Example usage:
+    ```
+    runtime.secret(source="aws-credentials", envd_path="~/.aws/credentials")
+    ```
+
+    Args:
+        source (str): name of the secret in the orchestrator
+        envd_path (str): absolute path of the secret file in the container
+    """
@@ -91,10 +91,13 @@ func New() EnvdApp {
 		CommandImage,
 		CommandInit,
 		CommandLogin,
+		CommandLogs,
 		CommandPause,
 		CommandPrune,
+		CommandPush,
 		CommandRun,
 		CommandResume,
+		CommandTest,
 		CommandUp,
 		CommandDebug,
 		CommandVersion,
@@ -106,7 +109,7 @@ func New() EnvdApp {
  Usage:
     envd up --path <path>
 
-    envd build --path <path> --output type=image,name=<image>,push=true{{if .VisibleCommands}}
+    envd push --path <path> --tag <image>{{if .VisibleCommands}}
 
  Build and launch envd environments. Get more information at: https://envd.tensorchord.ai/.
  To get started with using envd, check out the getting started guide: https://envd.tensorchord.ai/guide/getting-started.html.
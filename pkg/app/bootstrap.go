@@ -215,7 +215,7 @@ func buildkit(clicontext *cli.Context) error {
 		}
 	} else {
 		bkClient, err = buildkitd.NewClient(clicontext.Context,
-			c.Builder, c.BuilderAddress, clicontext.String("dockerhub-mirror"))
+			c.Builder, c.BuilderAddress, clicontext.String("dockerhub-mirror"), c.BuilderRootless)
 		if err != nil {
 			return errors.Wrap(err, "failed to create buildkit client")
 		}
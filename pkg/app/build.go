@@ -15,16 +15,112 @@
 package app
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"time"
 
+	"github.com/cockroachdb/errors"
+	"github.com/docker/go-units"
+	"github.com/olekukonko/tablewriter"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
 	buildutil "github.com/tensorchord/envd/pkg/app/build"
 	"github.com/tensorchord/envd/pkg/app/telemetry"
+	envdbuilder "github.com/tensorchord/envd/pkg/builder"
 	sshconfig "github.com/tensorchord/envd/pkg/ssh/config"
 )
 
+// buildFlags are the flags of the build shared by `envd build` and `envd push`.
+var buildFlags = []cli.Flag{
+	&cli.PathFlag{
+		Name:    "from",
+		Usage:   "Function to execute, format `file:func`",
+		Aliases: []string{"f"},
+		Value:   "build.envd:build",
+	},
+	&cli.BoolFlag{
+		Name:    "use-proxy",
+		Usage:   "Use HTTPS_PROXY/HTTP_PROXY/NO_PROXY in the build process",
+		Aliases: []string{"proxy"},
+		Value:   false,
+	},
+	&cli.StringSliceFlag{
+		Name:  "secret",
+		Usage: "Build secret from the file in the host, format `id=file`, overrides `io.mount_secret` in build.envd",
+	},
+	&cli.PathFlag{
+		Name:    "path",
+		Usage:   "Path to the directory containing the build.envd",
+		Aliases: []string{"p"},
+		Value:   ".",
+	},
+	&cli.PathFlag{
+		Name:    "public-key",
+		Usage:   "Path to the public key",
+		Aliases: []string{"pubk"},
+		Value:   sshconfig.GetPublicKeyOrPanic(),
+		Hidden:  true,
+	},
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "Force rebuild the image",
+		Value: false,
+	},
+	// https://github.com/urfave/cli/issues/1134#issuecomment-1191407527
+	&cli.StringFlag{
+		Name:    "export-cache",
+		Usage:   "Export the cache (e.g. type=registry,ref=<image>,mode=max), mode=max caches the intermediate steps as well, e.g. the downloads of the language binaries",
+		Aliases: []string{"ec"},
+	},
+	&cli.StringFlag{
+		Name:    "import-cache",
+		Usage:   "Import the cache (e.g. type=registry,ref=<image> or type=local,src=<dir>)",
+		Aliases: []string{"ic"},
+	},
+	&cli.PathFlag{
+		Name:  "policy",
+		Usage: "Path to the JSON policy file to validate the environment against before the build",
+	},
+	&cli.StringFlag{
+		Name:  "validation-webhook",
+		Usage: "URL of the webhook to approve or reject the environment before the build",
+	},
+	&cli.DurationFlag{
+		Name:  "validation-webhook-timeout",
+		Usage: "Timeout of the validation webhook",
+		Value: 10 * time.Second,
+	},
+	&cli.BoolFlag{
+		Name:  "validation-webhook-fail-open",
+		Usage: "Continue the build if the validation webhook can not be reached",
+		Value: false,
+	},
+	&cli.StringFlag{
+		Name:  "platform",
+		Usage: "Platform of the image, e.g. linux/arm64, overrides `config.platform` in build.envd",
+	},
+	&cli.BoolFlag{
+		Name:  "lock",
+		Usage: "Write the base image digest and the versions of the packages installed in the image to envd.lock",
+		Value: false,
+	},
+	&cli.BoolFlag{
+		Name:  "frozen",
+		Usage: "Fail the build before the export if the versions of the packages installed in the image diverge from envd.lock",
+		Value: false,
+	},
+	&cli.StringFlag{
+		Name:  "progress",
+		Usage: "Format of the build progress, auto, tty, plain or json. The json format prints the events of the steps line by line to stderr",
+	},
+	&cli.DurationFlag{
+		Name:  "step-timeout",
+		Usage: "Fail the build if a step is not completed in the timeout, overrides `config.build_limits` in build.envd",
+	},
+}
+
 var CommandBuild = &cli.Command{
 	Name:     "build",
 	Category: CategoryBasic,
@@ -35,61 +131,61 @@ To build an image using build.envd:
 	$ envd build
 To build and push the image to a registry:
 	$ envd build --output type=image,name=docker.io/username/image,push=true
+To preview the resolved system, Julia or PyPI packages without building the image:
+	$ envd build --resolve-only
+To record the base image digest and the installed packages in envd.lock:
+	$ envd build --lock
+To fail the build if the installed packages diverge from envd.lock:
+	$ envd build --frozen
+To print the steps of the build and their estimated cache status without building:
+	$ envd build --dry-run --format dot | dot -Tsvg > build.svg
+To print the events of the steps in JSON lines, e.g. for the IDE plugins and CI:
+	$ envd build --progress json
+To print the sizes of the steps and the suggestions to reduce the image size:
+	$ envd build --analyze
+To open the environment in VS Code Dev Containers or GitHub Codespaces, with the image pushed:
+	$ envd build --export devcontainer --tag docker.io/username/image > .devcontainer/devcontainer.json
+To export the environment pinned to the resolved packages and the base image digest for a bug report:
+	$ envd build --export reproducer > reproducer.json
 `,
-	Flags: []cli.Flag{
+	Flags: append(append([]cli.Flag{
 		&cli.StringFlag{
 			Name:        "tag",
 			Usage:       "Name and optionally a tag in the 'name:tag' format",
 			Aliases:     []string{"t"},
 			DefaultText: "PROJECT:dev",
 		},
-		&cli.PathFlag{
-			Name:    "from",
-			Usage:   "Function to execute, format `file:func`",
-			Aliases: []string{"f"},
-			Value:   "build.envd:build",
-		},
-		&cli.BoolFlag{
-			Name:    "use-proxy",
-			Usage:   "Use HTTPS_PROXY/HTTP_PROXY/NO_PROXY in the build process",
-			Aliases: []string{"proxy"},
-			Value:   false,
-		},
-		&cli.PathFlag{
-			Name:    "path",
-			Usage:   "Path to the directory containing the build.envd",
-			Aliases: []string{"p"},
-			Value:   ".",
-		},
-		&cli.PathFlag{
-			Name:    "public-key",
-			Usage:   "Path to the public key",
-			Aliases: []string{"pubk"},
-			Value:   sshconfig.GetPublicKeyOrPanic(),
-			Hidden:  true,
-		},
 		&cli.StringFlag{
 			Name:    "output",
 			Usage:   "Output destination (e.g. type=tar,dest=path,push=true)",
 			Aliases: []string{"o"},
 		},
+	}, buildFlags...),
+		&cli.StringFlag{
+			Name:  "export",
+			Usage: "Print the environment in the format instead of building the image, `dockerfile`, `devcontainer` or `reproducer`",
+		},
 		&cli.BoolFlag{
-			Name:  "force",
-			Usage: "Force rebuild the image",
+			Name:  "resolve-only",
+			Usage: "Print the resolved versions of the packages without building the image",
 			Value: false,
 		},
-		// https://github.com/urfave/cli/issues/1134#issuecomment-1191407527
-		&cli.StringFlag{
-			Name:    "export-cache",
-			Usage:   "Export the cache (e.g. type=registry,ref=<image>)",
-			Aliases: []string{"ec"},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print the steps of the build and their cache status estimated by the last build, without building the image",
+			Value: false,
 		},
 		&cli.StringFlag{
-			Name:    "import-cache",
-			Usage:   "Import the cache (e.g. type=registry,ref=<image>)",
-			Aliases: []string{"ic"},
+			Name:  "format",
+			Usage: "Format of the dry run, json or dot",
+			Value: envdbuilder.DryRunFormatJSON,
 		},
-	},
+		&cli.BoolFlag{
+			Name:  "analyze",
+			Usage: "Print the sizes of the steps and the suggestions to reduce the image size after the build",
+			Value: false,
+		},
+	),
 	Action: build,
 }
 
@@ -113,5 +209,60 @@ func build(clicontext *cli.Context) error {
 	if err = buildutil.InterpretEnvdDef(builder); err != nil {
 		return err
 	}
-	return buildutil.BuildImage(clicontext, builder)
+	if clicontext.Bool("resolve-only") {
+		return builder.Resolve(clicontext.Context, os.Stdout)
+	}
+	if clicontext.Bool("dry-run") {
+		return builder.DryRun(clicontext.Context, os.Stdout, clicontext.String("format"))
+	}
+	switch export := clicontext.String("export"); export {
+	case "":
+	case "dockerfile":
+		return builder.ExportDockerfile(clicontext.Context, os.Stdout)
+	case "devcontainer":
+		return builder.ExportDevContainer(clicontext.Context, os.Stdout)
+	case "reproducer":
+		return builder.ExportReproducer(clicontext.Context, os.Stdout)
+	default:
+		return errors.Newf("unsupported export format %s, must be dockerfile, devcontainer or reproducer", export)
+	}
+	if err = buildutil.BuildImage(clicontext, builder); err != nil {
+		return err
+	}
+	if clicontext.Bool("analyze") {
+		analysis, err := builder.Analyze(clicontext.Context)
+		if err != nil {
+			return errors.Wrap(err, "failed to analyze the build")
+		}
+		renderAnalysis(os.Stdout, analysis)
+	}
+	return nil
+}
+
+func renderAnalysis(w io.Writer, analysis *envdbuilder.Analysis) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Step", "Size"})
+
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t") // pad with tabs
+	table.SetNoWhiteSpace(true)
+
+	var total int64
+	for _, s := range analysis.Steps {
+		table.Append([]string{s.Name, units.HumanSize(float64(s.Size))})
+		total += s.Size
+	}
+	table.Render()
+	fmt.Fprintln(w, "Total size of the measured steps:", units.HumanSize(float64(total)))
+	for _, s := range analysis.Suggestions {
+		fmt.Fprintln(w, "Suggestion:", s)
+	}
 }
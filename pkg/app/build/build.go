@@ -134,6 +134,17 @@ func ParseBuildOpt(clicontext *cli.Context) (builder.Options, error) {
 	exportCache := clicontext.String("export-cache")
 	importCache := clicontext.String("import-cache")
 	useProxy := clicontext.Bool("use-proxy")
+	if clicontext.Bool("lock") && clicontext.Bool("frozen") {
+		return builder.Options{}, errors.New("--lock and --frozen can not be used together")
+	}
+	secrets := map[string]string{}
+	for _, s := range clicontext.StringSlice("secret") {
+		id, file, ok := strings.Cut(s, "=")
+		if !ok || id == "" || file == "" {
+			return builder.Options{}, errors.Newf("invalid secret %s, the format is `id=file`", s)
+		}
+		secrets[id] = file
+	}
 
 	opt := builder.Options{
 		ManifestFilePath: manifest,
@@ -147,11 +158,24 @@ func ParseBuildOpt(clicontext *cli.Context) (builder.Options, error) {
 		ExportCache:      exportCache,
 		ImportCache:      importCache,
 		UseHTTPProxy:     useProxy,
+		PolicyFilePath:   clicontext.Path("policy"),
+
+		ValidationWebhook:         clicontext.String("validation-webhook"),
+		ValidationWebhookTimeout:  clicontext.Duration("validation-webhook-timeout"),
+		ValidationWebhookFailOpen: clicontext.Bool("validation-webhook-fail-open"),
+		Platform:                  clicontext.String("platform"),
+		Secrets:                   secrets,
+		Lock:                      clicontext.Bool("lock"),
+		Frozen:                    clicontext.Bool("frozen"),
+		StepTimeout:               clicontext.Duration("step-timeout"),
 	}
 
 	debug := clicontext.Bool("debug")
 	if debug {
 		opt.ProgressMode = "plain"
 	}
+	if mode := clicontext.String("progress"); mode != "" {
+		opt.ProgressMode = mode
+	}
 	return opt, nil
 }
@@ -40,17 +40,21 @@ var CommandContextCreate = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:  "builder-address",
-			Usage: "Builder address",
+			Usage: "Builder address, e.g. tcp://<host>:<port> of the remote buildkitd for the builder tcp",
 			Value: "envd_buildkitd",
 		},
+		&cli.BoolFlag{
+			Name:  "builder-rootless",
+			Usage: "Start the rootless buildkitd without the privileges for the builder docker-container or nerdctl-container, use a builder address different from the privileged one",
+		},
 		&cli.StringFlag{
 			Name:  "runner",
-			Usage: "Runner to use(docker, envd-server)",
+			Usage: "Runner to use(docker, envd-server, k8s)",
 			Value: string(types.RunnerTypeDocker),
 		},
 		&cli.StringFlag{
 			Name:  "runner-address",
-			Usage: "Runner address",
+			Usage: "Runner address, it's the namespace for the runner k8s",
 		},
 		&cli.BoolFlag{
 			Name:  "use",
@@ -69,10 +73,11 @@ func contextCreate(clicontext *cli.Context) error {
 	use := clicontext.Bool("use")
 
 	c := types.Context{
-		Name:           name,
-		Builder:        types.BuilderType(builder),
-		BuilderAddress: builderAddress,
-		Runner:         types.RunnerType(runner),
+		Name:            name,
+		Builder:         types.BuilderType(builder),
+		BuilderAddress:  builderAddress,
+		BuilderRootless: clicontext.Bool("builder-rootless"),
+		Runner:          types.RunnerType(runner),
 	}
 	if runnerAddress != "" {
 		c.RunnerAddress = &runnerAddress
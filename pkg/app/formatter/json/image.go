@@ -52,3 +52,7 @@ func PrintImages(imgs []types.EnvdImage) error {
 	}
 	return printJSON(output)
 }
+
+func PrintDependencyDiff(diff types.DependencyDiff) error {
+	return printJSON(diff)
+}
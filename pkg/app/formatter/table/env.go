@@ -93,6 +93,26 @@ func RenderDependencies(w io.Writer, dep *types.Dependency) {
 		envRow[1] = "APT"
 		table.Append(envRow)
 	}
+	for _, p := range dep.JuliaPackages {
+		envRow := make([]string, 2)
+		envRow[0] = p
+		envRow[1] = "Julia"
+		table.Append(envRow)
+	}
+	table.Render()
+}
+
+func RenderDependencyDiff(w io.Writer, diff types.DependencyDiff) {
+	table := createTable(w, []string{"Name", "Type", "Change", "From", "To"})
+	appendChanges := func(change string, pkgs []types.PackageChange) {
+		for _, p := range pkgs {
+			table.Append([]string{p.Name, p.Type, change,
+				formatter.StringOrNone(p.From), formatter.StringOrNone(p.To)})
+		}
+	}
+	appendChanges("added", diff.Added)
+	appendChanges("removed", diff.Removed)
+	appendChanges("changed", diff.Changed)
 	table.Render()
 }
 
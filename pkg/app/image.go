@@ -35,6 +35,7 @@ var CommandImage = &cli.Command{
 
 	Subcommands: []*cli.Command{
 		CommandDescribeImage,
+		CommandDiffImage,
 		CommandListImage,
 		CommandPruneImages,
 		CommandRemoveImage,
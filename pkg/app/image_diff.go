@@ -0,0 +1,74 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/tensorchord/envd/pkg/app/formatter"
+	"github.com/tensorchord/envd/pkg/app/formatter/json"
+	"github.com/tensorchord/envd/pkg/app/formatter/table"
+	envdbuilder "github.com/tensorchord/envd/pkg/builder"
+	"github.com/tensorchord/envd/pkg/types"
+)
+
+var CommandDiffImage = &cli.Command{
+	Name:  "diff",
+	Usage: "Show the changes of the resolved packages between two builds",
+	Description: `The packages are compared by the lockfiles generated by envd build --lock,
+thus the versions resolved in the builds are diffed instead of the declared ones.
+
+	$ envd images diff --from envd.lock.old --to envd.lock`,
+	Flags: []cli.Flag{
+		&cli.PathFlag{
+			Name:     "from",
+			Usage:    "Path to the lockfile of the previous build",
+			Required: true,
+		},
+		&cli.PathFlag{
+			Name:  "to",
+			Usage: "Path to the lockfile of the new build",
+			Value: envdbuilder.LockFile,
+		},
+		&formatter.FormatFlag,
+	},
+	Action: diffImage,
+}
+
+func diffImage(clicontext *cli.Context) error {
+	from, err := envdbuilder.LoadLock(clicontext.Path("from"))
+	if err != nil {
+		return err
+	}
+	to, err := envdbuilder.LoadLock(clicontext.Path("to"))
+	if err != nil {
+		return err
+	}
+	diff := types.DiffResolvedPackages(from.Packages, to.Packages)
+	if from.Image != to.Image {
+		diff.Changed = append(diff.Changed, types.PackageChange{
+			Type: types.PackageTypeImage, Name: "base", From: from.Image, To: to.Image})
+	}
+	format := clicontext.String("format")
+	switch format {
+	case "table":
+		table.RenderDependencyDiff(os.Stdout, diff)
+	case "json":
+		return json.PrintDependencyDiff(diff)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/tensorchord/envd/pkg/envd"
+	"github.com/tensorchord/envd/pkg/home"
+	"github.com/tensorchord/envd/pkg/ssh"
+	"github.com/tensorchord/envd/pkg/types"
+)
+
+var CommandLogs = &cli.Command{
+	Name:      "logs",
+	Category:  CategoryManagement,
+	Usage:     "Print the logs of the service in the environment",
+	ArgsUsage: "[service]",
+	Description: `
+To list the services, e.g. sshd, jupyter and the daemons of runtime.daemon:
+	$ envd logs
+To follow the logs of the daemon:
+	$ envd logs --follow daemon_0
+`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "name",
+			Usage:   "Name of the environment",
+			Aliases: []string{"n"},
+		},
+		&cli.PathFlag{
+			Name:    "path",
+			Usage:   "Path to the directory containing the build.envd",
+			Aliases: []string{"p"},
+			Value:   ".",
+		},
+		&cli.BoolFlag{
+			Name:    "follow",
+			Usage:   "Follow the log output",
+			Aliases: []string{"f"},
+		},
+		&cli.IntFlag{
+			Name:  "tail",
+			Usage: "Number of lines to show from the end of the logs",
+			Value: 100,
+		},
+	},
+	Action: logs,
+}
+
+func logs(clicontext *cli.Context) error {
+	name := clicontext.String("name")
+	if name == "" {
+		path, err := filepath.Abs(clicontext.Path("path"))
+		if err != nil {
+			return errors.Wrap(err, "failed to get the absolute path")
+		}
+		name = filepath.Base(path)
+	}
+	service := clicontext.Args().First()
+	if service != "" && !types.ServiceNameRegex.MatchString(service) {
+		return errors.Newf("invalid service name %s", service)
+	}
+
+	context, err := home.GetManager().ContextGetCurrent()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the current context")
+	}
+	engine, err := envd.New(clicontext.Context, envd.Options{Context: context})
+	if err != nil {
+		return errors.Wrap(err, "failed to create the envd engine")
+	}
+	if isRunning, err := engine.IsRunning(clicontext.Context, name); err != nil {
+		return errors.Wrapf(err, "failed to check if the environment %s is running", name)
+	} else if !isRunning {
+		return errors.Newf("the environment %s is not running", name)
+	}
+
+	opt, err := ssh.GetOptions(name)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the ssh options")
+	}
+	sshClient, err := ssh.NewClient(*opt)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the ssh client")
+	}
+
+	var command string
+	if service == "" {
+		command = fmt.Sprintf("ls %s | sed 's/\\.toml$//'", types.HorustServiceDir)
+	} else {
+		follow := ""
+		if clicontext.Bool("follow") {
+			follow = "-F "
+		}
+		command = fmt.Sprintf("tail -n %d %s%s/%s_stdout.log %s/%s_stderr.log",
+			clicontext.Int("tail"), follow, types.HorustLogDir, service, types.HorustLogDir, service)
+	}
+	if err := sshClient.ExecWithWriter(command,
+		clicontext.App.Writer, clicontext.App.ErrWriter); err != nil {
+		return errors.Wrapf(err, "failed to execute the command `%s`", command)
+	}
+	return nil
+}
@@ -15,13 +15,16 @@
 package app
 
 import (
+	"os"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
 	"github.com/tensorchord/envd/pkg/app/telemetry"
 	"github.com/tensorchord/envd/pkg/buildkitd"
+	"github.com/tensorchord/envd/pkg/driver/docker"
 	"github.com/tensorchord/envd/pkg/home"
 	"github.com/tensorchord/envd/pkg/types"
 )
@@ -30,6 +33,10 @@ var CommandPrune = &cli.Command{
 	Name:     "prune",
 	Category: CategorySettings,
 	Usage:    "Clean up the build cache",
+	Description: `
+To clean up the build cache and the envd images not used by any environment:
+	$ envd prune --images
+`,
 	Flags: []cli.Flag{
 		&cli.DurationFlag{
 			Name:  "keep-duration",
@@ -48,6 +55,10 @@ var CommandPrune = &cli.Command{
 			Name:  "all",
 			Usage: "Include internal caches (oh-my-zsh, vscode extensions and other envd caches)",
 		},
+		&cli.BoolFlag{
+			Name:  "images",
+			Usage: "Remove the envd images not used by any container",
+		},
 		&cli.BoolFlag{
 			Name:  "verbose, v",
 			Usage: "Verbose output",
@@ -87,7 +98,7 @@ func prune(clicontext *cli.Context) error {
 		}
 	} else {
 		bkClient, err = buildkitd.NewClient(clicontext.Context,
-			c.Builder, c.BuilderAddress, "")
+			c.Builder, c.BuilderAddress, "", c.BuilderRootless)
 		if err != nil {
 			return errors.Wrap(err, "failed to create buildkit client")
 		}
@@ -96,5 +107,22 @@ func prune(clicontext *cli.Context) error {
 		keepDuration, keepStorage, filter, verbose, cleanAll); err != nil {
 		return errors.Wrap(err, "failed to prune buildkit cache")
 	}
+	if clicontext.Bool("images") {
+		if c.Runner != types.RunnerTypeDocker {
+			logrus.Warnf("the images are only pruned with the docker runner, skipped for %s", c.Runner)
+			return nil
+		}
+		cli, err := docker.NewClient(clicontext.Context)
+		if err != nil {
+			return err
+		}
+		report, err := cli.PruneEnvdImages(clicontext.Context)
+		if err != nil {
+			return err
+		}
+		if len(report.ImagesDeleted) > 0 {
+			renderPruneReport(os.Stdout, report)
+		}
+	}
 	return nil
 }
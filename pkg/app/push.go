@@ -0,0 +1,77 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	buildutil "github.com/tensorchord/envd/pkg/app/build"
+	"github.com/tensorchord/envd/pkg/app/telemetry"
+)
+
+var CommandPush = &cli.Command{
+	Name:     "push",
+	Category: CategoryBasic,
+	Usage:    "Build the envd environment and push the image to the registry",
+	Description: `
+The image is pushed by the builder of the current context without loading it
+into the docker host, e.g. with the remote buildkitd:
+	$ envd context create --name remote --builder tcp --builder-address tcp://<host>:<port> --use
+	$ envd push --tag docker.io/username/image:tag
+It's the same as:
+	$ envd build --output type=image,name=docker.io/username/image:tag,push=true
+`,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "tag",
+			Usage:    "Name of the image in the registry and optionally a tag in the 'name:tag' format",
+			Aliases:  []string{"t"},
+			Required: true,
+		},
+	}, buildFlags...),
+	Action: push,
+}
+
+func push(clicontext *cli.Context) error {
+	opt, err := buildutil.ParseBuildOpt(clicontext)
+	if err != nil {
+		return err
+	}
+	opt.OutputOpts = fmt.Sprintf("type=image,name=%s,push=true", opt.Tag)
+	defer func(start time.Time) {
+		telemetry.GetReporter().Telemetry(
+			"push", telemetry.AddField("duration", time.Since(start).Seconds()))
+	}(time.Now())
+
+	logger := logrus.WithField("builder-options", opt)
+	logger.Debug("starting push command")
+
+	builder, err := buildutil.GetBuilder(clicontext, opt)
+	if err != nil {
+		return err
+	}
+	if err = buildutil.InterpretEnvdDef(builder); err != nil {
+		return err
+	}
+	if err = buildutil.BuildImage(clicontext, builder); err != nil {
+		return err
+	}
+	logrus.Infof("the image %s is pushed", opt.Tag)
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import "testing"
+
+func TestPushBuildFlags(t *testing.T) {
+	pushFlags := map[string]bool{}
+	for _, f := range CommandPush.Flags {
+		pushFlags[f.Names()[0]] = true
+	}
+	for _, f := range buildFlags {
+		if !pushFlags[f.Names()[0]] {
+			t.Errorf("the build flag --%s is missing in envd push", f.Names()[0])
+		}
+	}
+	for _, name := range []string{"policy", "validation-webhook", "lock", "frozen", "progress", "step-timeout"} {
+		if !pushFlags[name] {
+			t.Errorf("the flag --%s is missing in envd push", name)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	gossh "golang.org/x/crypto/ssh"
+
+	buildutil "github.com/tensorchord/envd/pkg/app/build"
+	"github.com/tensorchord/envd/pkg/app/telemetry"
+	"github.com/tensorchord/envd/pkg/envd"
+	"github.com/tensorchord/envd/pkg/home"
+	"github.com/tensorchord/envd/pkg/ssh"
+	sshconfig "github.com/tensorchord/envd/pkg/ssh/config"
+	"github.com/tensorchord/envd/pkg/types"
+	"github.com/tensorchord/envd/pkg/util/fileutil"
+)
+
+var CommandTest = &cli.Command{
+	Name:      "test",
+	Category:  CategoryBasic,
+	Usage:     "Build the envd environment and run the tests in it",
+	ArgsUsage: "[command]",
+	Description: `
+The environment is started without attaching, the test commands declared by
+run(commands=[...], test=True) in build.envd are run in the working directory,
+and the environment is destroyed afterwards. The exit status is the one of the
+first failed command, e.g. to gate the CI:
+	$ envd test
+To run the command instead of the declared tests:
+	$ envd test -- python -c "import torch"
+`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "tag",
+			Usage:       "Name and optionally a tag in the 'name:tag' format",
+			Aliases:     []string{"t"},
+			DefaultText: "PROJECT:dev",
+		},
+		&cli.PathFlag{
+			Name:    "path",
+			Usage:   "Path to the directory containing the build.envd",
+			Aliases: []string{"p"},
+			Value:   ".",
+		},
+		&cli.PathFlag{
+			Name:    "from",
+			Usage:   "Function to execute, format `file:func`",
+			Aliases: []string{"f"},
+			Value:   "build.envd:build",
+		},
+		&cli.BoolFlag{
+			Name:    "use-proxy",
+			Usage:   "Use HTTPS_PROXY/HTTP_PROXY/NO_PROXY in the build process",
+			Aliases: []string{"proxy"},
+			Value:   false,
+		},
+		&cli.StringSliceFlag{
+			Name:  "secret",
+			Usage: "Build secret from the file in the host, format `id=file`, overrides `io.mount_secret` in build.envd",
+		},
+		&cli.PathFlag{
+			Name:    "private-key",
+			Usage:   "Path to the private key",
+			Aliases: []string{"k"},
+			Value:   sshconfig.GetPrivateKeyOrPanic(),
+			Hidden:  true,
+		},
+		&cli.PathFlag{
+			Name:    "public-key",
+			Usage:   "Path to the public key",
+			Aliases: []string{"pubk"},
+			Value:   sshconfig.GetPublicKeyOrPanic(),
+			Hidden:  true,
+		},
+		&cli.StringFlag{
+			Name:    "import-cache",
+			Usage:   "Import the cache (e.g. type=registry,ref=<image>)",
+			Aliases: []string{"ic"},
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Timeout of container creation",
+			Value: time.Second * 30,
+		},
+		&cli.IntFlag{
+			Name:  "shm-size",
+			Usage: "Configure the shared memory size (megabyte)",
+			Value: 2048,
+		},
+		&cli.BoolFlag{
+			Name:  "no-gpu",
+			Usage: "Launch the CPU container even if it's a GPU image",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "keep",
+			Usage: "Keep the environment after the tests for debugging",
+			Value: false,
+		},
+	},
+	Action: test,
+}
+
+func test(clicontext *cli.Context) error {
+	c, err := home.GetManager().ContextGetCurrent()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the current context")
+	}
+	if c.Runner != types.RunnerTypeDocker {
+		return errors.Newf("`envd test` is not supported for the runner %s", c.Runner)
+	}
+	opt, err := buildutil.ParseBuildOpt(clicontext)
+	if err != nil {
+		return err
+	}
+	defer func(start time.Time) {
+		telemetry.GetReporter().Telemetry(
+			"test", telemetry.AddField("duration", time.Since(start).Seconds()))
+	}(time.Now())
+
+	builder, err := buildutil.GetBuilder(clicontext, opt)
+	if err != nil {
+		return err
+	}
+	if err = buildutil.InterpretEnvdDef(builder); err != nil {
+		return err
+	}
+	var commands []string
+	if clicontext.Args().Present() {
+		commands = []string{quoteCommand(clicontext.Args().Slice())}
+	} else {
+		for _, t := range builder.GetGraph().GetRuntimeTests() {
+			commands = append(commands, strings.Join(t, " && "))
+		}
+	}
+	if len(commands) == 0 {
+		return errors.New("no test is declared by `run(commands=[...], test=True)` in build.envd")
+	}
+	if err = buildutil.BuildImage(clicontext, builder); err != nil {
+		return err
+	}
+
+	engine, err := envd.New(clicontext.Context, envd.Options{Context: c})
+	if err != nil {
+		return errors.Wrap(err, "failed to create the docker client")
+	}
+	name, err := buildutil.CreateEnvNameFromDir(opt.BuildContextDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create the env name from %s", opt.BuildContextDir)
+	}
+	// do not replace the environment of `envd up`
+	name += "-test"
+	so := envd.StartOptions{
+		EnvironmentName: name,
+		BuildContext:    opt.BuildContextDir,
+		Image:           builder.ImageName(),
+		Forced:          true,
+		Timeout:         clicontext.Duration("timeout"),
+		SshdHost:        envd.Localhost,
+		ShmSize:         clicontext.Int("shm-size"),
+		EngineSource: envd.EngineSource{
+			DockerSource: &envd.DockerSource{Graph: builder.GetGraph()},
+		},
+	}
+	if !clicontext.Bool("no-gpu") {
+		setGPUOptions(&so, builder.GetGraph())
+	}
+	res, err := engine.StartEnvd(clicontext.Context, so)
+	if err != nil {
+		return errors.Wrap(err, "failed to start the envd environment")
+	}
+	if !clicontext.Bool("keep") {
+		defer func() {
+			// destroy even if the tests are interrupted
+			if _, err := engine.Destroy(context.Background(), name); err != nil {
+				logrus.Warnf("failed to destroy the environment %s: %s", name, err)
+			}
+		}()
+	}
+
+	hostname, err := c.GetSSHHostname(envd.Localhost)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the ssh hostname")
+	}
+	eo, err := engine.GenerateSSHConfig(name, hostname, clicontext.Path("private-key"), res)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the ssh entry")
+	}
+	sshOpt := ssh.DefaultOptions()
+	sshOpt.Server = eo.IFace
+	sshOpt.Port = eo.Port
+	sshOpt.PrivateKeyPath = eo.PrivateKeyPath
+	sshOpt.AgentForwarding = false
+
+	for i, command := range commands {
+		logrus.Infof("running the test %d/%d: %s", i+1, len(commands), command)
+		// the client is closed after every command
+		sshClient, err := ssh.NewClient(sshOpt)
+		if err != nil {
+			return errors.Wrap(err, "failed to create the ssh client")
+		}
+		err = sshClient.ExecWithWriter(fmt.Sprintf("cd %s && %s", fileutil.EnvdHomeDir(filepath.Base(opt.BuildContextDir)), command),
+			clicontext.App.Writer, clicontext.App.ErrWriter)
+		var exitErr *gossh.ExitError
+		if errors.As(err, &exitErr) {
+			return cli.Exit(fmt.Sprintf("the test `%s` failed with the exit status %d",
+				command, exitErr.ExitStatus()), exitErr.ExitStatus())
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to execute the command `%s`", command)
+		}
+	}
+	logrus.Infof("all the %d tests passed", len(commands))
+	return nil
+}
+
+// shellSafeArg matches the arguments which are kept as is by the shell.
+var shellSafeArg = regexp.MustCompile(`^[A-Za-z0-9@%_+=:,./-]+$`)
+
+// quoteCommand joins the arguments into a shell command, the arguments with
+// the special characters are single quoted, thus the quoting on the command
+// line is kept, e.g. `python -c "import torch"`.
+func quoteCommand(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if shellSafeArg.MatchString(arg) {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import "testing"
+
+func TestQuoteCommand(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"pytest", "-x", "tests/"}, "pytest -x tests/"},
+		{[]string{"python", "-c", "import torch"}, "python -c 'import torch'"},
+		{[]string{"echo", "it's", ""}, `echo 'it'\''s' ''`},
+		{[]string{"sh", "-c", "echo $HOME && ls"}, "sh -c 'echo $HOME && ls'"},
+	} {
+		if got := quoteCommand(tc.args); got != tc.expected {
+			t.Errorf("quoteCommand(%q) = %s, expected %s", tc.args, got, tc.expected)
+		}
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/tensorchord/envd/pkg/app/telemetry"
 	"github.com/tensorchord/envd/pkg/envd"
 	"github.com/tensorchord/envd/pkg/home"
+	"github.com/tensorchord/envd/pkg/lang/ir"
 	sshconfig "github.com/tensorchord/envd/pkg/ssh/config"
 	"github.com/tensorchord/envd/pkg/types"
 )
@@ -66,6 +67,10 @@ var CommandUp = &cli.Command{
 			Aliases: []string{"proxy"},
 			Value:   false,
 		},
+		&cli.StringSliceFlag{
+			Name:  "secret",
+			Usage: "Build secret from the file in the host, format `id=file`, overrides `io.mount_secret` in build.envd",
+		},
 		&cli.PathFlag{
 			Name:    "private-key",
 			Usage:   "Path to the private key",
@@ -115,6 +120,15 @@ var CommandUp = &cli.Command{
 			Usage: "Launch the CPU container",
 			Value: false,
 		},
+		&cli.StringSliceFlag{
+			Name:  "gpu-devices",
+			Usage: "Indexes or UUIDs of the GPUs or MIG devices to attach, e.g. 0,2, overrides `runtime.gpu` in build.envd",
+		},
+		&cli.BoolFlag{
+			Name:  "user-ns",
+			Usage: "Run the environment in the user namespace without the privilege escalation, e.g. sudo, it requires the rootless or userns-remap docker daemon",
+			Value: false,
+		},
 		&cli.BoolFlag{
 			Name:  "force",
 			Usage: "Force rebuild and run the container although the previous container is running",
@@ -125,6 +139,14 @@ var CommandUp = &cli.Command{
 			Usage: "Assign the host address for environment ssh acesss server listening",
 			Value: envd.Localhost,
 		},
+		&cli.StringFlag{
+			Name:  "runner",
+			Usage: "Runner to use instead of the runner of the current context (docker, envd-server, k8s)",
+		},
+		&cli.StringFlag{
+			Name:  "namespace",
+			Usage: "Namespace of the environment pod for the runner k8s",
+		},
 		// https://github.com/urfave/cli/issues/1134#issuecomment-1191407527
 		&cli.StringFlag{
 			Name:    "export-cache",
@@ -136,6 +158,24 @@ var CommandUp = &cli.Command{
 			Usage:   "Import the cache (e.g. type=registry,ref=<image>)",
 			Aliases: []string{"ic"},
 		},
+		&cli.PathFlag{
+			Name:  "policy",
+			Usage: "Path to the JSON policy file to validate the environment against before the build",
+		},
+		&cli.StringFlag{
+			Name:  "validation-webhook",
+			Usage: "URL of the webhook to approve or reject the environment before the build",
+		},
+		&cli.DurationFlag{
+			Name:  "validation-webhook-timeout",
+			Usage: "Timeout of the validation webhook",
+			Value: 10 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:  "validation-webhook-fail-open",
+			Usage: "Continue the build if the validation webhook can not be reached",
+			Value: false,
+		},
 	},
 
 	Action: up,
@@ -146,18 +186,34 @@ func up(clicontext *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to get the current context")
 	}
+	if runner := types.RunnerType(clicontext.String("runner")); runner != "" && runner != c.Runner {
+		switch runner {
+		case types.RunnerTypeDocker, types.RunnerTypeEnvdServer, types.RunnerTypeKubernetes:
+		default:
+			return errors.Newf("unknown runner type %s", runner)
+		}
+		// The runner address of the context is for its own runner.
+		c.Runner = runner
+		c.RunnerAddress = nil
+	}
+	if namespace := clicontext.String("namespace"); namespace != "" {
+		if c.Runner != types.RunnerTypeKubernetes {
+			return errors.New("`--namespace` is only supported for the runner k8s")
+		}
+		c.RunnerAddress = &namespace
+	}
 
 	buildOpt, err := buildutil.ParseBuildOpt(clicontext)
 	if err != nil {
 		return errors.Wrap(err, "failed to parse the build options")
 	}
 
-	// Always push image to registry when envd-server is the runner.
-	if c.Runner == types.RunnerTypeEnvdServer {
+	// Always push image to registry when envd-server or k8s is the runner.
+	if c.Runner == types.RunnerTypeEnvdServer || c.Runner == types.RunnerTypeKubernetes {
 		buildOpt.OutputOpts = fmt.Sprintf("type=image,name=%s,push=true", buildOpt.Tag)
 
 		// Unable to modify sshd host when runner is envd-server.
-		if clicontext.String("host") != envd.Localhost {
+		if c.Runner == types.RunnerTypeEnvdServer && clicontext.String("host") != envd.Localhost {
 			return errors.New("Failed to modify the sshd host when runner is envd-server.")
 		}
 	}
@@ -187,19 +243,6 @@ func up(clicontext *cli.Context) error {
 	}
 
 	logrus.Debug("start running the environment")
-	// Do not attach GPU if the flag is set.
-	gpuEnable := clicontext.Bool("no-gpu")
-	var gpu bool
-	if gpuEnable {
-		gpu = false
-	} else {
-		gpu = builder.GPUEnabled()
-	}
-	numGPU := 0
-	if gpu {
-		numGPU = 1
-	}
-
 	opt := envd.Options{
 		Context: c,
 	}
@@ -214,8 +257,7 @@ func up(clicontext *cli.Context) error {
 	startOptions := envd.StartOptions{
 		EnvironmentName: name,
 		BuildContext:    buildOpt.BuildContextDir,
-		Image:           buildOpt.Tag,
-		NumGPU:          numGPU,
+		Image:           builder.ImageName(),
 		Forced:          clicontext.Bool("force"),
 		Timeout:         clicontext.Duration("timeout"),
 		SshdHost:        clicontext.String("host"),
@@ -223,6 +265,14 @@ func up(clicontext *cli.Context) error {
 		NumCPU:          clicontext.String("cpus"),
 		NumMem:          clicontext.String("memory"),
 		CPUSet:          clicontext.String("cpu-set"),
+		UserNS:          clicontext.Bool("user-ns"),
+	}
+	// Do not attach GPU if the flag is set.
+	if !clicontext.Bool("no-gpu") {
+		setGPUOptions(&startOptions, builder.GetGraph())
+		if devices := clicontext.StringSlice("gpu-devices"); len(devices) > 0 {
+			startOptions.NumGPU, startOptions.GPUDevices = 0, devices
+		}
 	}
 	if len(startOptions.NumCPU) > 0 && len(startOptions.CPUSet) > 0 {
 		return errors.New("`--cpus` and `--cpu-set` are mutually exclusive")
@@ -276,3 +326,17 @@ func up(clicontext *cli.Context) error {
 
 	return nil
 }
+
+// setGPUOptions attaches the GPUs declared by runtime.gpu, or one GPU if CUDA
+// is installed.
+func setGPUOptions(so *envd.StartOptions, g ir.Graph) {
+	if gpu := g.GetRuntimeGPU(); gpu != nil {
+		so.NumGPU = gpu.Count
+		so.GPUDevices = gpu.Devices
+		so.GPUCapabilities = gpu.Capabilities
+		return
+	}
+	if g.GPUEnabled() {
+		so.NumGPU = 1
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/docker/go-units"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+)
+
+// largeStepSize is the size of the step suggested to be checked.
+const largeStepSize = 1 << 30
+
+// StepSize is the size of the layer of the step, estimated by the records of
+// the build cache.
+type StepSize struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Analysis is the sizes of the steps of the last build, and the suggestions
+// to reduce the size of the image.
+type Analysis struct {
+	Steps       []StepSize `json:"steps"`
+	Suggestions []string   `json:"suggestions,omitempty"`
+}
+
+// Analyze reports the sizes of the steps of the build, which must be built
+// before. The steps without the records in the build cache, e.g. the file
+// operations, are not reported.
+func (b generalBuilder) Analyze(ctx context.Context) (*Analysis, error) {
+	def, err := b.Compile(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile")
+	}
+	records, err := b.Client.DiskUsage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the disk usage of the build cache")
+	}
+	return analyzeDefinition(def, records)
+}
+
+// analyzeDefinition matches the steps of the definition with the records of
+// the build cache by the descriptions, which are the names of the merge and
+// diff steps, or `mount / from exec <args>` of the exec steps. The latest
+// record is used if there are many.
+func analyzeDefinition(def *llb.Definition, records []*client.UsageInfo) (*Analysis, error) {
+	latest := map[string]*client.UsageInfo{}
+	for _, r := range records {
+		if r.RecordType == client.UsageRecordTypeCacheMount {
+			continue
+		}
+		if l, ok := latest[r.Description]; !ok || lastUsed(r).After(lastUsed(l)) {
+			latest[r.Description] = r
+		}
+	}
+
+	var execs []*pb.ExecOp
+	seen := map[string]bool{}
+	analysis := &Analysis{}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, errors.Wrap(err, "failed to parse the op")
+		}
+		name := vertexName(op, def.Metadata[digest.FromBytes(dt)])
+		var description string
+		switch o := op.Op.(type) {
+		case *pb.Op_Exec:
+			execs = append(execs, o.Exec)
+			description = "mount / from exec " + strings.Join(o.Exec.Meta.Args, " ")
+		case *pb.Op_Merge, *pb.Op_Diff:
+			description = name
+		default:
+			continue
+		}
+		r, ok := latest[description]
+		if !ok || seen[description] {
+			continue
+		}
+		seen[description] = true
+		analysis.Steps = append(analysis.Steps, StepSize{Name: name, Size: r.Size})
+	}
+	sort.SliceStable(analysis.Steps, func(i, j int) bool {
+		return analysis.Steps[i].Size > analysis.Steps[j].Size
+	})
+
+	for _, s := range analysis.Steps {
+		if s.Size >= largeStepSize {
+			analysis.Suggestions = append(analysis.Suggestions, fmt.Sprintf(
+				"the step `%s` is %s, check if the caches or the build dependencies are kept in the image",
+				s.Name, units.HumanSize(float64(s.Size))))
+		}
+	}
+	analysis.Suggestions = append(analysis.Suggestions, execSuggestions(execs)...)
+	return analysis, nil
+}
+
+// execSuggestions suggests the cleanup of the package caches and the squash
+// of the install steps.
+func execSuggestions(execs []*pb.ExecOp) []string {
+	var suggestions []string
+	aptInstalls, pipInstalls := 0, 0
+	aptLists, pipCache := false, false
+	for _, e := range execs {
+		command := strings.Join(e.Meta.Args, " ")
+		if strings.Contains(command, "apt-get install") {
+			aptInstalls++
+			if !strings.Contains(command, "/var/lib/apt/lists") && !hasMount(e, "/var/lib/apt") {
+				aptLists = true
+			}
+		}
+		if strings.Contains(command, "pip install") && !strings.Contains(command, "--dry-run") {
+			pipInstalls++
+			if !strings.Contains(command, "--no-cache-dir") && !hasMount(e, ".cache/pip") {
+				pipCache = true
+			}
+		}
+	}
+	if aptLists {
+		suggestions = append(suggestions, "the apt lists are kept in the image, "+
+			"enable config.feature(\"apt.cleanup_lists\") or `rm -rf /var/lib/apt/lists/*` in the same step")
+	}
+	if aptInstalls > 1 {
+		suggestions = append(suggestions, fmt.Sprintf("apt-get install is called by %d steps, "+
+			"declare the packages by one install.apt_packages to squash them", aptInstalls))
+	}
+	if pipCache {
+		suggestions = append(suggestions, "the pip cache is kept in the image, install the packages with `--no-cache-dir`")
+	}
+	if pipInstalls > 1 {
+		suggestions = append(suggestions, fmt.Sprintf("pip install is called by %d steps, "+
+			"declare the packages by one install.python_packages to squash them", pipInstalls))
+	}
+	return suggestions
+}
+
+func hasMount(e *pb.ExecOp, dest string) bool {
+	for _, m := range e.Mounts {
+		if strings.Contains(m.Dest, dest) {
+			return true
+		}
+	}
+	return false
+}
+
+func lastUsed(r *client.UsageInfo) time.Time {
+	if r.LastUsedAt != nil {
+		return *r.LastUsedAt
+	}
+	return r.CreatedAt
+}
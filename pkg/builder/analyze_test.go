@@ -0,0 +1,52 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeDefinition(t *testing.T) {
+	base := llb.Image("docker.io/library/ubuntu:22.04")
+	apt := base.Run(llb.Shlex("apt-get update"), llb.WithCustomName("update apt")).Root()
+	system := apt.Run(llb.Shlex("apt-get install -y git"), llb.WithCustomName("install git")).Root()
+	pip := system.Run(llb.Shlex("pip install --no-cache-dir numpy")).Root()
+	def, err := pip.Marshal(context.Background())
+	require.NoError(t, err)
+
+	old, now := time.Now().Add(-time.Hour), time.Now()
+	records := []*client.UsageInfo{
+		{Description: "mount / from exec apt-get update", Size: 10, LastUsedAt: &now},
+		{Description: "mount / from exec apt-get install -y git", Size: 1, LastUsedAt: &old},
+		{Description: "mount / from exec apt-get install -y git", Size: 200, LastUsedAt: &now},
+		{Description: "mount / from exec pip install --no-cache-dir numpy", Size: 30, CreatedAt: now},
+		{Description: "cached mount /root/.cache/pip", Size: 1000, RecordType: client.UsageRecordTypeCacheMount},
+	}
+	analysis, err := analyzeDefinition(def, records)
+	require.NoError(t, err)
+	require.Equal(t, []StepSize{
+		{Name: "install git", Size: 200},
+		{Name: "pip install --no-cache-dir numpy", Size: 30},
+		{Name: "update apt", Size: 10},
+	}, analysis.Steps)
+	require.Len(t, analysis.Suggestions, 1)
+	require.Contains(t, analysis.Suggestions[0], "apt.cleanup_lists")
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+	"github.com/tensorchord/envd/pkg/version"
+)
+
+const (
+	inTotoStatementType  = "https://in-toto.io/Statement/v0.1"
+	inTotoPayloadType    = "application/vnd.in-toto+json"
+	slsaProvenanceType   = "https://slsa.dev/provenance/v0.2"
+	envdBuilderID        = "https://github.com/tensorchord/envd"
+	envdAttestationBuild = "https://github.com/tensorchord/envd/build@v1"
+)
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Subject       []inTotoSubject `json:"subject"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type slsaProvenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType string                   `json:"buildType"`
+	Metadata  map[string]string        `json:"metadata,omitempty"`
+	Materials []ir.AttestationMaterial `json:"materials"`
+}
+
+type dsseSignature struct {
+	Sig string `json:"sig"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+// writeAttestation generates the SLSA provenance of the output image as an
+// in-toto statement. It's wrapped in a signed DSSE envelope if the signing
+// key is configured.
+func (b generalBuilder) writeAttestation(res *client.SolveResponse) error {
+	if b.graph == nil || res == nil {
+		return nil
+	}
+	cfg := b.graph.GetAttestationConfig()
+	if cfg == nil {
+		return nil
+	}
+	imageDigest, err := digest.Parse(res.ExporterResponse[exptypes.ExporterImageDigestKey])
+	if err != nil {
+		return errors.Wrap(err, "failed to get the digest of the output image")
+	}
+
+	statement := inTotoStatement{
+		Type:          inTotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Subject: []inTotoSubject{{
+			Name: b.Tag,
+			Digest: map[string]string{
+				imageDigest.Algorithm().String(): imageDigest.Encoded(),
+			},
+		}},
+	}
+	statement.Predicate.Builder.ID = envdBuilderID
+	statement.Predicate.BuildType = envdAttestationBuild
+	statement.Predicate.Metadata = map[string]string{
+		"envdVersion": version.GetVersion().String(),
+	}
+	statement.Predicate.Materials = b.graph.GetAttestationMaterials()
+
+	data, err := json.Marshal(statement)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the attestation")
+	}
+	if cfg.SigningKey != "" {
+		if data, err = signAttestation(data, cfg.SigningKey); err != nil {
+			return err
+		}
+	}
+
+	output := cfg.Output
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(b.BuildContextDir, output)
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write the attestation to %s", output)
+	}
+	b.logger.WithField("output", output).Debug("attestation is generated")
+	return nil
+}
+
+// signAttestation signs the statement with the PKCS8 private key, and
+// returns the DSSE envelope.
+func signAttestation(statement []byte, keyFile string) ([]byte, error) {
+	pemData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the signing key %s", keyFile)
+	}
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.Newf("no PEM encoded key found in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the signing key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported signing key")
+	}
+
+	// Refer to https://github.com/secure-systems-lab/dsse/blob/master/protocol.md
+	pae := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s",
+		len(inTotoPayloadType), inTotoPayloadType, len(statement), statement))
+	var sig []byte
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		// ed25519 signs the message itself
+		sig, err = signer.Sign(rand.Reader, pae, crypto.Hash(0))
+	} else {
+		hashed := sha256.Sum256(pae)
+		sig, err = signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign the attestation")
+	}
+
+	return json.Marshal(dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []dsseSignature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAttestation(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	statement := []byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`)
+	data, err := signAttestation(statement, keyFile)
+	require.NoError(t, err)
+
+	var envelope dsseEnvelope
+	require.NoError(t, json.Unmarshal(data, &envelope))
+	require.Equal(t, inTotoPayloadType, envelope.PayloadType)
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	require.NoError(t, err)
+	require.Equal(t, statement, payload)
+	require.Len(t, envelope.Signatures, 1)
+	sig, err := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	require.NoError(t, err)
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s",
+		len(inTotoPayloadType), inTotoPayloadType, len(statement), statement)
+	require.True(t, ed25519.Verify(pub, []byte(pae), sig))
+
+	_, err = signAttestation(statement, filepath.Join(t.TempDir(), "missing.pem"))
+	require.Error(t, err)
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/session"
@@ -94,7 +95,7 @@ func New(ctx context.Context, opt Options) (Builder, error) {
 		}
 	} else {
 		cli, err = buildkitd.NewClient(ctx,
-			c.Builder, c.BuilderAddress, "")
+			c.Builder, c.BuilderAddress, "", c.BuilderRootless)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create buildkit client")
 		}
@@ -119,7 +120,43 @@ func (b generalBuilder) NumGPUs() int {
 }
 
 func (b generalBuilder) Build(ctx context.Context, force bool) error {
-	if !force && !b.checkIfNeedBuild(ctx) {
+	if err := b.checkPolicy(); err != nil {
+		return err
+	}
+	if err := b.checkWebhook(ctx); err != nil {
+		return err
+	}
+	b.Tag = b.ImageName()
+	if oc := b.outputConfig(); oc != nil {
+		if oc.Target != "docker" {
+			entry, err := outputEntry(*oc, b.Tag, b.BuildContextDir)
+			if err != nil {
+				return errors.Wrap(err, "invalid output")
+			}
+			b.entries = []client.ExportEntry{entry}
+		}
+	}
+	// the cache check only applies to the images in the docker host, e.g. the
+	// image pushed by `envd push` is always built
+	for _, entry := range b.entries {
+		if entry.Type != client.ExporterDocker && entry.Type != "moby" {
+			force = true
+		}
+	}
+	if err := b.checkCompression(); err != nil {
+		return err
+	}
+	if err := b.checkCacheExport(); err != nil {
+		return err
+	}
+	// the cache is exported even if the image in the docker host is up to date,
+	// e.g. the CI runner with the image from the previous job
+	if b.ExportCache != "" {
+		force = true
+	}
+	// the lock is read from the built image, the lockfile is kept if the
+	// image is up to date
+	if !force && !b.lockRequired() && !b.checkIfNeedBuild(ctx) {
 		return nil
 	}
 
@@ -128,6 +165,12 @@ func (b generalBuilder) Build(ctx context.Context, force bool) error {
 		return errors.Wrap(err, "failed to compile")
 	}
 	b.definition = def
+	if b.Lock || b.Frozen {
+		if b.lockDefinition, err = b.graph.CompileLock(ctx); err != nil {
+			return errors.Wrap(err, "failed to compile the lock")
+		}
+		b.builtLock = &builtLock{}
+	}
 
 	pw, err := progresswriter.NewPrinter(ctx, os.Stdout, b.ProgressMode)
 	if err != nil {
@@ -137,6 +180,103 @@ func (b generalBuilder) Build(ctx context.Context, force bool) error {
 	if err = b.build(ctx, pw); err != nil {
 		return errors.Wrap(err, "failed to build")
 	}
+	if err := recordBuiltDigests(b.BuildContextDir, def); err != nil {
+		b.logger.Debugf("failed to record the digests of the build: %s", err)
+	}
+	return b.writeLock(b.builtLock.get())
+}
+
+// outputConfig returns the output declared by `config.output`, it's
+// overridden by the output of the flag.
+func (b generalBuilder) outputConfig() *ir.OutputConfig {
+	if b.graph == nil || b.OutputOpts != "" {
+		return nil
+	}
+	return b.graph.GetOutputConfig()
+}
+
+// ImageName returns the name of the image built, which is declared by
+// `config.output` or the tag.
+func (b generalBuilder) ImageName() string {
+	if oc := b.outputConfig(); oc != nil && oc.Name != "" {
+		return oc.Name
+	}
+	return b.Tag
+}
+
+// dockerConfig loads the registry credentials of the output target, or the
+// default docker config.
+func (b generalBuilder) dockerConfig() *configfile.ConfigFile {
+	if oc := b.outputConfig(); oc != nil && oc.DockerConfigDir != "" {
+		cfg, err := config.Load(oc.DockerConfigDir)
+		if err == nil {
+			return cfg
+		}
+		b.logger.Warnf("failed to load the docker config in %s, fallback to the default: %s", oc.DockerConfigDir, err)
+	}
+	return config.LoadDefaultConfigFile(os.Stderr)
+}
+
+// checkPolicy rejects the environment violating the policy, even if the
+// image is cached.
+func (b generalBuilder) checkPolicy() error {
+	if b.PolicyFilePath == "" {
+		return nil
+	}
+	policy, err := ir.LoadPolicy(b.PolicyFilePath)
+	if err != nil {
+		return err
+	}
+	if b.graph == nil {
+		return errors.New("failed to validate the environment against the policy: no graph")
+	}
+	b.logger.Debugf("validating the environment against the policy %s", b.PolicyFilePath)
+	return b.graph.CheckPolicy(*policy)
+}
+
+// checkCacheExport validates the cache export before the build, instead of
+// failing after all the steps are built.
+func (b generalBuilder) checkCacheExport() error {
+	if b.ExportCache == "" {
+		return nil
+	}
+	ce, err := ParseExportCache([]string{b.ExportCache}, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse export cache")
+	}
+	for _, entry := range b.entries {
+		if entry.Type != "moby" {
+			continue
+		}
+		for _, c := range ce {
+			if c.Type != "inline" {
+				return errors.Newf("cache export type %s is not supported by the moby builder, "+
+					"use the inline cache or the docker-container builder instead", c.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// checkCompression validates the layer compression against the exporter.
+func (b generalBuilder) checkCompression() error {
+	cc := b.compressionConfig()
+	if cc == nil {
+		return nil
+	}
+	for _, entry := range b.entries {
+		switch entry.Type {
+		case "moby":
+			return errors.Newf("compression %s is not supported by the moby builder, "+
+				"the image is stored in dockerd as is", cc.Algorithm)
+		case client.ExporterLocal:
+			return errors.Newf("compression %s is not supported by the local exporter", cc.Algorithm)
+		case client.ExporterDocker:
+			if cc.Algorithm == "zstd" || cc.Algorithm == "estargz" {
+				b.logger.Warnf("loading the %s layers requires Docker Engine 23.0 or later", cc.Algorithm)
+			}
+		}
+	}
 	return nil
 }
 
@@ -152,6 +292,11 @@ func (b generalBuilder) Interpret() error {
 	if _, err := b.ExecFile(b.ManifestFilePath, b.BuildFuncName); err != nil {
 		return errors.Wrapf(err, "failed to exec starlark file %s", b.ManifestFilePath)
 	}
+	if b.Platform != "" {
+		if err := b.graph.SetPlatform(b.Platform); err != nil {
+			return errors.Wrap(err, "failed to set the platform")
+		}
+	}
 	return nil
 }
 
@@ -191,8 +336,12 @@ func (b generalBuilder) imageConfig(ctx context.Context) (string, error) {
 
 	env := b.graph.GetEnviron()
 	user := b.graph.GetUser()
+	var stopSignal string
+	if sc := b.graph.GetStopConfig(); sc != nil {
+		stopSignal = sc.Signal
+	}
 
-	data, err := ImageConfigStr(labels, ports, ep, env, user)
+	data, err := ImageConfigStr(labels, ports, ep, env, user, stopSignal, b.graph.GetPlatform())
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get image config")
 	}
@@ -206,6 +355,20 @@ func (b generalBuilder) defaultCacheImporter() (*string, error) {
 	return nil, nil
 }
 
+func (b generalBuilder) compressionConfig() *ir.CompressionConfig {
+	if b.graph != nil {
+		return b.graph.GetCompressionConfig()
+	}
+	return nil
+}
+
+func (b generalBuilder) buildSecrets() []ir.BuildSecret {
+	if b.graph != nil {
+		return b.graph.GetBuildSecrets()
+	}
+	return nil
+}
+
 func (b generalBuilder) build(ctx context.Context, pw progresswriter.Writer) error {
 	b.logger.Debug("building envd image")
 	ce, err := ParseExportCache([]string{b.ExportCache}, nil)
@@ -215,21 +378,46 @@ func (b generalBuilder) build(ctx context.Context, pw progresswriter.Writer) err
 	// k := platforms.Format(platforms.DefaultSpec())
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	watcher := newStepWatcher(b.stepTimeout())
+	statusCh := make(chan *client.SolveStatus)
+	pw = progresswriter.Tee(pw, statusCh)
+	go watcher.watch(statusCh, cancel)
 	eg, ctx := errgroup.WithContext(ctx)
 
 	// Create a pipe to load the image into the docker host.
 	pipeR, pipeW := io.Pipe()
 
+	secrets, err := secretsProvider(b.buildSecrets(), b.Secrets)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the build secrets")
+	}
+
 	for _, entry := range b.entries {
 		// Set up docker config auth.
-		dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
+		dockerConfig := b.dockerConfig()
 		attachable := []session.Attachable{authprovider.NewDockerAuthProvider(dockerConfig)}
+		if secrets != nil {
+			attachable = append(attachable, secrets)
+		}
 		b.logger.WithFields(logrus.Fields{
 			"type": entry.Type,
 		}).Debug("build image with buildkit")
 		switch entry.Type {
 		// Create default build.
 		case client.ExporterDocker:
+			// the docker archive tarball is not loaded to the docker host
+			if entry.Output != nil {
+				eg.Go(func() error {
+					solveOpt := constructSolveOpt(ce, entry, b, attachable)
+					res, err := b.Client.Build(ctx, solveOpt, "envd", b.BuildFunc(), pw.Status())
+					if err != nil {
+						return errors.Wrap(&BuildkitdErr{err: err}, "Buildkit error")
+					}
+					b.logger.Debug("llb def is solved successfully")
+					return b.writeAttestation(res)
+				})
+				break
+			}
 			eg.Go(func() error {
 				if entry.Attrs == nil {
 					entry = client.ExportEntry{
@@ -244,14 +432,14 @@ func (b generalBuilder) build(ctx context.Context, pw progresswriter.Writer) err
 				}
 				defer pipeW.Close()
 				solveOpt := constructSolveOpt(ce, entry, b, attachable)
-				_, err := b.Client.Build(ctx, solveOpt, "envd", b.BuildFunc(), pw.Status())
+				res, err := b.Client.Build(ctx, solveOpt, "envd", b.BuildFunc(), pw.Status())
 				if err != nil {
 					err = errors.Wrap(&BuildkitdErr{err: err}, "Buildkit error")
 					logrus.Errorf("%+v", err)
 					return err
 				}
 				b.logger.Debug("llb def is solved successfully")
-				return nil
+				return b.writeAttestation(res)
 			})
 			// Load the image to docker host.
 			eg.Go(func() error {
@@ -273,13 +461,13 @@ func (b generalBuilder) build(ctx context.Context, pw progresswriter.Writer) err
 			func(entry client.ExportEntry) {
 				eg.Go(func() error {
 					solveOpt := constructSolveOpt(ce, entry, b, attachable)
-					_, err := b.Client.Build(ctx, solveOpt, "envd", b.BuildFunc(), pw.Status())
+					res, err := b.Client.Build(ctx, solveOpt, "envd", b.BuildFunc(), pw.Status())
 					if err != nil {
 						err = errors.Wrap(err, "failed to solve LLB")
 						return err
 					}
 					b.logger.Debug("llb def is solved successfully")
-					return nil
+					return b.writeAttestation(res)
 				})
 			}(entry)
 		}
@@ -294,6 +482,12 @@ func (b generalBuilder) build(ctx context.Context, pw progresswriter.Writer) err
 
 	err = eg.Wait()
 	if err != nil {
+		err = watcher.wrap(err)
+		var timeoutErr *StepTimeoutErr
+		if errors.As(err, &timeoutErr) {
+			pipeR.Close()
+			return err
+		}
 		if errors.Is(err, context.Canceled) {
 			b.logger.Debug("cancelling the error group")
 			// Close the pipe on cancels, otherwise the whole thing hangs.
@@ -317,6 +511,9 @@ func constructSolveOpt(ce []client.CacheOptionsEntry, entry client.ExportEntry,
 			},
 		}
 	}
+	if cc := b.compressionConfig(); cc != nil {
+		entry = withCompression(entry, *cc)
+	}
 	opt := client.SolveOpt{
 		CacheExports: ce,
 		Exports:      []client.ExportEntry{entry},
@@ -56,6 +56,9 @@ func (b generalBuilder) BuildFunc() func(ctx context.Context, c client.Client) (
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to solve")
 		}
+		if err := b.recordLock(ctx, c, sreq.CacheImports); err != nil {
+			return nil, err
+		}
 
 		imageConfig, err := b.imageConfig(ctx)
 		if err != nil {
@@ -16,6 +16,7 @@ package builder
 
 import (
 	"context"
+	"io"
 
 	"github.com/moby/buildkit/client/llb"
 
@@ -24,9 +25,23 @@ import (
 
 type Builder interface {
 	Build(ctx context.Context, force bool) error
+	// Resolve writes the resolved language packages without building the image.
+	Resolve(ctx context.Context, w io.Writer) error
+	// ExportDockerfile writes the Dockerfile equivalent to the environment.
+	ExportDockerfile(ctx context.Context, w io.Writer) error
+	// ExportDevContainer writes the devcontainer.json of the environment.
+	ExportDevContainer(ctx context.Context, w io.Writer) error
+	// ExportReproducer writes the environment pinned to the resolved versions.
+	ExportReproducer(ctx context.Context, w io.Writer) error
+	// DryRun writes the steps of the build in the format without executing them.
+	DryRun(ctx context.Context, w io.Writer, format string) error
+	// Analyze reports the sizes of the steps of the last build.
+	Analyze(ctx context.Context) (*Analysis, error)
 	Interpret() error
 	// Compile compiles envd IR to LLB.
 	Compile(ctx context.Context) (*llb.Definition, error)
+	// ImageName returns the name of the image built.
+	ImageName() string
 	GPUEnabled() bool
 	NumGPUs() int
 	GetGraph() ir.Graph
@@ -0,0 +1,163 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/tensorchord/envd/pkg/config"
+	"github.com/tensorchord/envd/pkg/lang/ir"
+	"github.com/tensorchord/envd/pkg/util/fileutil"
+)
+
+// devContainer is the subset of the Dev Container spec used by the export,
+// see https://containers.dev/implementors/json_reference.
+type devContainer struct {
+	Name             string                        `json:"name"`
+	Image            string                        `json:"image"`
+	RemoteUser       string                        `json:"remoteUser,omitempty"`
+	ContainerEnv     map[string]string             `json:"containerEnv,omitempty"`
+	ForwardPorts     []int                         `json:"forwardPorts,omitempty"`
+	WorkspaceFolder  string                        `json:"workspaceFolder"`
+	WorkspaceMount   string                        `json:"workspaceMount"`
+	Mounts           []string                      `json:"mounts,omitempty"`
+	RunArgs          []string                      `json:"runArgs,omitempty"`
+	OverrideCommand  bool                          `json:"overrideCommand"`
+	HostRequirements *devContainerHostRequirements `json:"hostRequirements,omitempty"`
+	Customizations   devContainerCustomizations    `json:"customizations"`
+}
+
+type devContainerHostRequirements struct {
+	GPU bool `json:"gpu"`
+}
+
+type devContainerCustomizations struct {
+	VSCode devContainerVSCode `json:"vscode"`
+}
+
+type devContainerVSCode struct {
+	Extensions []string               `json:"extensions,omitempty"`
+	Settings   map[string]interface{} `json:"settings,omitempty"`
+}
+
+// ExportDevContainer writes the devcontainer.json of the environment, which
+// runs the image of the tag, thus the image must be built or pushed before
+// the container is created by VS Code or GitHub Codespaces.
+func (b generalBuilder) ExportDevContainer(ctx context.Context, w io.Writer) error {
+	if _, err := b.Compile(ctx); err != nil {
+		return errors.Wrap(err, "failed to compile")
+	}
+	ep, err := b.graph.GetEntrypoint(b.BuildContextDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to get entrypoint")
+	}
+	dc := newDevContainer(b.graph, filepath.Base(b.BuildContextDir), b.Tag, ep)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dc)
+}
+
+func newDevContainer(g ir.Graph, name, image string, entrypoint []string) devContainer {
+	workDir := fileutil.EnvdHomeDir(name)
+	dc := devContainer{
+		Name:            name,
+		Image:           image,
+		RemoteUser:      g.GetUser(),
+		ContainerEnv:    map[string]string{},
+		WorkspaceFolder: workDir,
+		WorkspaceMount:  fmt.Sprintf("source=${localWorkspaceFolder},target=%s,type=bind", workDir),
+		// keep the entrypoint of the image, e.g. horust for sshd, jupyter and
+		// the daemons, or the devcontainer CLI replaces it with a sleep loop
+		OverrideCommand: len(entrypoint) == 0,
+		Customizations: devContainerCustomizations{
+			VSCode: devContainerVSCode{
+				Extensions: g.GetVSCodeExtensions(),
+				Settings:   g.GetVSCodeSettings(),
+			},
+		},
+	}
+	for _, e := range g.GetEnviron() {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			dc.ContainerEnv[kv[0]] = kv[1]
+		}
+	}
+
+	if g.GetJupyterConfig() != nil {
+		dc.ForwardPorts = append(dc.ForwardPorts, config.JupyterPortInContainer)
+	}
+	if g.GetRStudioServerConfig() != nil {
+		dc.ForwardPorts = append(dc.ForwardPorts, config.RStudioServerPortInContainer)
+	}
+	for _, p := range g.GetExposedPorts() {
+		dc.ForwardPorts = append(dc.ForwardPorts, p.EnvdPort)
+	}
+
+	for _, m := range g.GetMount() {
+		mount := fmt.Sprintf("source=%s,target=%s,type=bind", m.Source, m.Destination)
+		if m.Volume != "" {
+			mount = fmt.Sprintf("source=%s,target=%s,type=volume", m.Volume, m.Destination)
+		}
+		if m.ReadOnly {
+			mount += ",readonly"
+		}
+		dc.Mounts = append(dc.Mounts, mount)
+	}
+
+	if gpu := g.GetRuntimeGPU(); gpu != nil {
+		dc.RunArgs = append(dc.RunArgs, "--gpus", dockerGPUs(*gpu))
+		dc.HostRequirements = &devContainerHostRequirements{GPU: true}
+	} else if g.GPUEnabled() {
+		gpus := "all"
+		if n := g.GetNumGPUs(); n > 0 {
+			gpus = fmt.Sprint(n)
+		}
+		dc.RunArgs = append(dc.RunArgs, "--gpus", gpus)
+		dc.HostRequirements = &devContainerHostRequirements{GPU: true}
+	}
+	return dc
+}
+
+// dockerGPUs returns the value of `docker run --gpus`, e.g.
+// `"device=0,2","capabilities=compute,utility"`.
+func dockerGPUs(gpu ir.GPUConfig) string {
+	var opts []string
+	switch {
+	case len(gpu.Devices) > 0:
+		opts = append(opts, "device="+strings.Join(gpu.Devices, ","))
+	case gpu.Count < 0:
+		opts = append(opts, "all")
+	default:
+		opts = append(opts, fmt.Sprintf("count=%d", gpu.Count))
+	}
+	if len(gpu.Capabilities) > 0 {
+		opts = append(opts, "capabilities="+strings.Join(gpu.Capabilities, ","))
+	}
+	if len(opts) == 1 && !strings.Contains(opts[0], ",") {
+		return opts[0]
+	}
+	quoted := make([]string, 0, len(opts))
+	for _, o := range opts {
+		quoted = append(quoted, fmt.Sprintf("%q", o))
+	}
+	return strings.Join(quoted, ",")
+}
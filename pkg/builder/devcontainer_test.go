@@ -0,0 +1,59 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+	v1 "github.com/tensorchord/envd/pkg/lang/ir/v1"
+)
+
+func TestNewDevContainer(t *testing.T) {
+	defer func() { v1.DefaultGraph = v1.NewGraph() }()
+
+	v1.DefaultGraph = v1.NewGraph()
+	require.NoError(t, v1.VSCodePlugins([]string{"ms-python.python", "julialang.language-julia-1.38.2"}))
+	require.NoError(t, v1.Jupyter("", 8888))
+	require.NoError(t, v1.RuntimeExpose(8080, 0, "app", "", true))
+	v1.Mount("/data/imagenet", "/data/imagenet", true)
+	require.NoError(t, v1.Volume("huggingface-cache", "/home/envd/.cache/huggingface", false))
+
+	dc := newDevContainer(v1.DefaultGraph, "mnist", "docker.io/org/mnist:dev", []string{"horust"})
+	require.Equal(t, "docker.io/org/mnist:dev", dc.Image)
+	require.Equal(t, "/home/envd/mnist", dc.WorkspaceFolder)
+	require.Equal(t, "source=${localWorkspaceFolder},target=/home/envd/mnist,type=bind", dc.WorkspaceMount)
+	require.False(t, dc.OverrideCommand)
+	require.Equal(t, []int{8888, 8080}, dc.ForwardPorts)
+	require.Equal(t, []string{
+		"source=/data/imagenet,target=/data/imagenet,type=bind,readonly",
+		"source=huggingface-cache,target=/home/envd/.cache/huggingface,type=volume",
+	}, dc.Mounts)
+	require.Equal(t, []string{"ms-python.python", "julialang.language-julia@1.38.2"},
+		dc.Customizations.VSCode.Extensions)
+	require.Nil(t, dc.HostRequirements)
+
+	dc = newDevContainer(v1.DefaultGraph, "mnist", "mnist:dev", nil)
+	require.True(t, dc.OverrideCommand)
+}
+
+func TestDockerGPUs(t *testing.T) {
+	require.Equal(t, "count=1", dockerGPUs(ir.GPUConfig{Count: 1}))
+	require.Equal(t, "all", dockerGPUs(ir.GPUConfig{Count: -1}))
+	require.Equal(t, `"device=0,2","capabilities=compute,video"`,
+		dockerGPUs(ir.GPUConfig{Devices: []string{"0", "2"}, Capabilities: []string{"compute", "video"}}))
+}
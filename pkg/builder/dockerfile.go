@@ -0,0 +1,590 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/tensorchord/envd/pkg/flag"
+	"github.com/tensorchord/envd/pkg/home"
+	"github.com/tensorchord/envd/pkg/lang/ir"
+	"github.com/tensorchord/envd/pkg/types"
+)
+
+const (
+	dockerfileSyntax = "docker/dockerfile:1.5"
+	// dockerfileEmptyStage is the stage copied to create the directories,
+	// which works in the stages without a shell.
+	dockerfileEmptyStage = "envd-empty"
+	localPrefix          = "local://"
+)
+
+// ExportDockerfile writes the Dockerfile equivalent to the compiled
+// environment, for the pipelines which only build by docker.
+func (b generalBuilder) ExportDockerfile(ctx context.Context, w io.Writer) error {
+	def, err := b.Compile(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to compile")
+	}
+	data, err := b.imageConfig(ctx)
+	if err != nil {
+		return err
+	}
+	var img v1.Image
+	if err := json.Unmarshal([]byte(data), &img); err != nil {
+		return errors.Wrap(err, "failed to parse the image config")
+	}
+	dockerfile, err := convertDockerfile(def, img.Config, map[string]string{
+		flag.FlagCacheDir: home.GetManager().CacheDir(),
+	}, b.buildSecrets())
+	if err != nil {
+		return errors.Wrap(err, "failed to convert the LLB to Dockerfile")
+	}
+	_, err = w.Write(dockerfile)
+	return err
+}
+
+// dockerfileConverter walks the LLB from the output, every vertex is a stage
+// built from the stage of its input. The Diff of the Merge is replayed on top
+// of the merged stage, since Dockerfile has no equivalent of the Diff.
+type dockerfileConverter struct {
+	ops map[digest.Digest]pb.Op
+	// stages are the converted stages by the input and the rebase
+	stages map[string]string
+	buf    bytes.Buffer
+	n      int
+	// contexts are the named build contexts besides the build context
+	contexts map[string]bool
+	empty    bool
+}
+
+// convertDockerfile converts the LLB definition to the Dockerfile, with the
+// image config in the final stage. The local sources other than the build
+// context are the named build contexts, e.g. `--build-context cache-dir=...`,
+// the paths are from localDirs. The secrets are passed by `--secret` in the
+// build command.
+func convertDockerfile(def *llb.Definition, config v1.ImageConfig, localDirs map[string]string, secrets []ir.BuildSecret) ([]byte, error) {
+	c := &dockerfileConverter{
+		ops:      map[digest.Digest]pb.Op{},
+		stages:   map[string]string{},
+		contexts: map[string]bool{},
+	}
+	var terminal pb.Op
+	for i, dt := range def.Def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, errors.Wrap(err, "failed to parse op")
+		}
+		c.ops[digest.FromBytes(dt)] = op
+		if i == len(def.Def)-1 {
+			terminal = op
+		}
+	}
+	if terminal.Op != nil || len(terminal.Inputs) != 1 {
+		return nil, errors.New("the definition does not end with the output")
+	}
+	final, err := c.stage(terminal.Inputs[0], nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(final, localPrefix) {
+		return nil, errors.New("the output is a local source")
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# syntax=%s\n", dockerfileSyntax)
+	out.WriteString("# Generated by `envd build --export dockerfile`, build it in the build context")
+	if len(c.contexts) > 0 || len(secrets) > 0 {
+		out.WriteString(" with:\n#   docker buildx build")
+		names := make([]string, 0, len(c.contexts))
+		for name := range c.contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&out, " --build-context %s=%s", name, localDirs[name])
+		}
+		for _, s := range secrets {
+			switch {
+			case s.Env != "":
+				fmt.Fprintf(&out, " --secret id=%s,env=%s", s.ID, s.Env)
+			case s.File != "":
+				fmt.Fprintf(&out, " --secret id=%s,src=%s", s.ID, s.File)
+			default:
+				fmt.Fprintf(&out, " --secret id=%s,src=<file>", s.ID)
+			}
+		}
+		out.WriteString(" .")
+	}
+	out.WriteString("\n")
+	if c.empty {
+		fmt.Fprintf(&out, "\nFROM scratch AS %s\n", dockerfileEmptyStage)
+	}
+	out.Write(c.buf.Bytes())
+
+	fmt.Fprintf(&out, "\nFROM %s\n", final)
+	for _, env := range config.Env {
+		if k, v, ok := strings.Cut(env, "="); ok {
+			fmt.Fprintf(&out, "ENV %s=%q\n", k, v)
+		}
+	}
+	for _, k := range sortedKeys(config.Labels) {
+		fmt.Fprintf(&out, "LABEL %q=%q\n", k, config.Labels[k])
+	}
+	ports := make([]string, 0, len(config.ExposedPorts))
+	for p := range config.ExposedPorts {
+		ports = append(ports, p)
+	}
+	sort.Strings(ports)
+	for _, p := range ports {
+		fmt.Fprintf(&out, "EXPOSE %s\n", p)
+	}
+	if config.WorkingDir != "" {
+		fmt.Fprintf(&out, "WORKDIR %s\n", config.WorkingDir)
+	}
+	if config.User != "" {
+		fmt.Fprintf(&out, "USER %s\n", config.User)
+	}
+	if config.StopSignal != "" {
+		fmt.Fprintf(&out, "STOPSIGNAL %s\n", config.StopSignal)
+	}
+	if len(config.Entrypoint) > 0 {
+		fmt.Fprintf(&out, "ENTRYPOINT %s\n", jsonArray(config.Entrypoint))
+	}
+	return out.Bytes(), nil
+}
+
+// stage returns the stage of the input. The lower input of the Diff is
+// replaced by the rebase stage.
+func (c *dockerfileConverter) stage(input *pb.Input, lower *pb.Input, rebase string) (string, error) {
+	if lower != nil && input.Digest == lower.Digest && input.Index == lower.Index {
+		return rebase, nil
+	}
+	key := fmt.Sprintf("%s:%d", input.Digest, input.Index)
+	if lower != nil {
+		key += fmt.Sprintf("|%s:%d>%s", lower.Digest, lower.Index, rebase)
+	}
+	if s, ok := c.stages[key]; ok {
+		return s, nil
+	}
+	op, ok := c.ops[input.Digest]
+	if !ok {
+		return "", errors.Newf("op %s is not in the definition", input.Digest)
+	}
+
+	var s string
+	var err error
+	switch o := op.Op.(type) {
+	case *pb.Op_Source:
+		s, err = c.source(op, o.Source)
+	case *pb.Op_Exec:
+		s, err = c.exec(op, o.Exec, input.Index, lower, rebase)
+	case *pb.Op_File:
+		s, err = c.file(op, o.File, input.Index, lower, rebase)
+	case *pb.Op_Merge:
+		s, err = c.merge(op, o.Merge, lower, rebase)
+	case *pb.Op_Diff:
+		// only the Diff in the Merge is replayed, the others keep the upper
+		s, err = c.inputStage(op, o.Diff.Upper.Input, lower, rebase)
+	default:
+		err = errors.Newf("op %s is not supported in Dockerfile", input.Digest)
+	}
+	if err != nil {
+		return "", err
+	}
+	c.stages[key] = s
+	return s, nil
+}
+
+// inputStage returns the stage of the input of the op by the index.
+func (c *dockerfileConverter) inputStage(op pb.Op, index pb.InputIndex, lower *pb.Input, rebase string) (string, error) {
+	if index == pb.Empty {
+		return "scratch", nil
+	}
+	if int(index) >= len(op.Inputs) {
+		return "", errors.Newf("invalid input index %d", index)
+	}
+	return c.stage(op.Inputs[index], lower, rebase)
+}
+
+// newStage writes the stage from the base with the instructions.
+func (c *dockerfileConverter) newStage(from string, instructions ...string) string {
+	name := fmt.Sprintf("s%d", c.n)
+	c.n++
+	fmt.Fprintf(&c.buf, "\nFROM %s AS %s\n", from, name)
+	for _, i := range instructions {
+		c.buf.WriteString(i + "\n")
+	}
+	return name
+}
+
+func (c *dockerfileConverter) source(op pb.Op, src *pb.SourceOp) (string, error) {
+	id := src.Identifier
+	switch {
+	case strings.HasPrefix(id, "docker-image://"):
+		from := strings.TrimPrefix(id, "docker-image://")
+		if op.Platform != nil {
+			from = fmt.Sprintf("--platform=%s/%s %s", op.Platform.OS, op.Platform.Architecture, from)
+		}
+		return c.newStage(from), nil
+	case strings.HasPrefix(id, localPrefix):
+		name := strings.TrimPrefix(id, localPrefix)
+		if src.Attrs[pb.AttrIncludePatterns] != "" || src.Attrs[pb.AttrExcludePatterns] != "" {
+			return "", errors.Newf("the include or exclude patterns of the local source %s are not supported in Dockerfile", name)
+		}
+		if name != flag.FlagBuildContext {
+			c.contexts[name] = true
+		}
+		followPaths := src.Attrs[pb.AttrFollowPaths]
+		if followPaths == "" {
+			return localPrefix + name, nil
+		}
+		// only the follow paths are transferred, they are copied to a stage
+		// to keep the cache of the steps depending on them
+		var paths []string
+		if err := json.Unmarshal([]byte(followPaths), &paths); err != nil {
+			return "", errors.Wrapf(err, "failed to parse the follow paths of the local source %s", name)
+		}
+		from := ""
+		if name != flag.FlagBuildContext {
+			from = " --from=" + name
+		}
+		instructions := make([]string, 0, len(paths))
+		for _, p := range paths {
+			if strings.ContainsAny(p, "*?[") {
+				return "", errors.Newf("the follow path %s with the wildcard is not supported in Dockerfile", p)
+			}
+			p = path.Clean("/" + p)
+			instructions = append(instructions, fmt.Sprintf("COPY%s %s %s", from, strings.TrimPrefix(p, "/"), p))
+		}
+		return c.newStage("scratch", instructions...), nil
+	case strings.HasPrefix(id, "https://"), strings.HasPrefix(id, "http://"):
+		filename := src.Attrs[pb.AttrHTTPFilename]
+		if filename == "" {
+			filename = path.Base(id)
+		}
+		add := "ADD"
+		if perm := src.Attrs[pb.AttrHTTPPerm]; perm != "" {
+			add += " --chmod=" + perm
+		}
+		if checksum := src.Attrs[pb.AttrHTTPChecksum]; checksum != "" {
+			add = fmt.Sprintf("# checksum %s\n%s", checksum, add)
+		}
+		return c.newStage("scratch", fmt.Sprintf("%s %s /%s", add, id, filename)), nil
+	}
+	return "", errors.Newf("source %s is not supported in Dockerfile", id)
+}
+
+func (c *dockerfileConverter) exec(op pb.Op, exec *pb.ExecOp, index pb.OutputIndex, lower *pb.Input, rebase string) (string, error) {
+	var root *pb.Mount
+	for _, m := range exec.Mounts {
+		if m.Dest == "/" {
+			root = m
+		}
+	}
+	if root == nil || root.Output != index {
+		return "", errors.Newf("only the root output of the exec %v is supported in Dockerfile", exec.Meta.Args)
+	}
+	base, err := c.inputStage(op, root.Input, lower, rebase)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(base, localPrefix) {
+		return "", errors.Newf("exec %v on the local source is not supported in Dockerfile", exec.Meta.Args)
+	}
+
+	if len(exec.Secretenv) > 0 {
+		return "", errors.Newf("the secret env of the exec %v is not supported in Dockerfile", exec.Meta.Args)
+	}
+	run := []string{"RUN"}
+	for _, m := range exec.Mounts {
+		if m == root {
+			continue
+		}
+		mount, err := c.mount(op, m)
+		if err != nil {
+			return "", err
+		}
+		run = append(run, "--mount="+mount)
+	}
+	// the environment is exactly the one of the exec, with the default PATH
+	// of buildkit if it's not set
+	env := exec.Meta.Env
+	hasPath := false
+	for _, e := range env {
+		hasPath = hasPath || strings.HasPrefix(e, "PATH=")
+	}
+	if !hasPath {
+		env = append([]string{"PATH=" + types.DefaultSystemPath}, env...)
+	}
+	args := append(append([]string{"env", "-i"}, env...), exec.Meta.Args...)
+	run = append(run, jsonArray(args))
+
+	user := exec.Meta.User
+	if user == "" {
+		user = "root"
+	}
+	cwd := exec.Meta.Cwd
+	if cwd == "" {
+		cwd = "/"
+	}
+	return c.newStage(base, "WORKDIR "+cwd, "USER "+user, strings.Join(run, " ")), nil
+}
+
+// mount returns the `--mount` flag of the non-root mount of the exec.
+func (c *dockerfileConverter) mount(op pb.Op, m *pb.Mount) (string, error) {
+	from := func() (string, error) {
+		if m.Input == pb.Empty {
+			return "", nil
+		}
+		s, err := c.inputStage(op, m.Input, nil, "")
+		if err != nil {
+			return "", err
+		}
+		source := m.Selector
+		if source == "" {
+			source = "/"
+		}
+		if name := strings.TrimPrefix(s, localPrefix); name != s {
+			if name == flag.FlagBuildContext {
+				return ",source=" + source, nil
+			}
+			s = name
+		}
+		return fmt.Sprintf(",from=%s,source=%s", s, source), nil
+	}
+
+	switch m.MountType {
+	case pb.MountType_BIND:
+		if m.Input == pb.Empty {
+			return "type=tmpfs,target=" + m.Dest, nil
+		}
+		f, err := from()
+		if err != nil {
+			return "", err
+		}
+		mount := "type=bind,target=" + m.Dest + f
+		if !m.Readonly {
+			mount += ",rw"
+		}
+		return mount, nil
+	case pb.MountType_CACHE:
+		f, err := from()
+		if err != nil {
+			return "", err
+		}
+		sharing := map[pb.CacheSharingOpt]string{
+			pb.CacheSharingOpt_SHARED:  "shared",
+			pb.CacheSharingOpt_PRIVATE: "private",
+			pb.CacheSharingOpt_LOCKED:  "locked",
+		}[m.CacheOpt.Sharing]
+		return fmt.Sprintf("type=cache,id=%s,target=%s,sharing=%s%s", m.CacheOpt.ID, m.Dest, sharing, f), nil
+	case pb.MountType_SECRET:
+		return fmt.Sprintf("type=secret,id=%s,target=%s,required=%t,uid=%d,gid=%d,mode=%04o",
+			m.SecretOpt.ID, m.Dest, !m.SecretOpt.Optional, m.SecretOpt.Uid, m.SecretOpt.Gid, m.SecretOpt.Mode), nil
+	case pb.MountType_SSH:
+		return fmt.Sprintf("type=ssh,id=%s,target=%s,required=%t", m.SSHOpt.ID, m.Dest, !m.SSHOpt.Optional), nil
+	case pb.MountType_TMPFS:
+		return "type=tmpfs,target=" + m.Dest, nil
+	}
+	return "", errors.Newf("mount %s of type %s is not supported in Dockerfile", m.Dest, m.MountType)
+}
+
+func (c *dockerfileConverter) file(op pb.Op, file *pb.FileOp, index pb.OutputIndex, lower *pb.Input, rebase string) (string, error) {
+	outputs := make([]string, len(file.Actions))
+	stageOf := func(i pb.InputIndex) (string, error) {
+		if int(i) >= len(op.Inputs) {
+			return outputs[int(i)-len(op.Inputs)], nil
+		}
+		return c.inputStage(op, i, lower, rebase)
+	}
+
+	for i, action := range file.Actions {
+		base, err := stageOf(action.Input)
+		if err != nil {
+			return "", err
+		}
+		var instruction string
+		switch a := action.Action.(type) {
+		case *pb.FileAction_Copy:
+			src, err := stageOf(action.SecondaryInput)
+			if err != nil {
+				return "", err
+			}
+			dest, err := copyDest(a.Copy)
+			if err != nil {
+				return "", err
+			}
+			flags := chownFlags(a.Copy.Owner, a.Copy.Mode)
+			if name := strings.TrimPrefix(src, localPrefix); name == src {
+				flags = " --from=" + src + flags
+			} else if name != flag.FlagBuildContext {
+				flags = " --from=" + name + flags
+			}
+			instruction = fmt.Sprintf("COPY%s %s %s", flags, a.Copy.Src, dest)
+		case *pb.FileAction_Mkfile:
+			delimiter := "EOF"
+			for bytes.Contains(a.Mkfile.Data, []byte(delimiter)) {
+				delimiter += "_"
+			}
+			data := strings.TrimSuffix(string(a.Mkfile.Data), "\n")
+			instruction = fmt.Sprintf("COPY%s <<'%s' %s\n%s\n%s",
+				chownFlags(a.Mkfile.Owner, a.Mkfile.Mode), delimiter, a.Mkfile.Path, data, delimiter)
+		case *pb.FileAction_Mkdir:
+			c.empty = true
+			instruction = fmt.Sprintf("COPY --from=%s%s / %s/",
+				dockerfileEmptyStage, chownFlags(a.Mkdir.Owner, a.Mkdir.Mode), strings.TrimSuffix(a.Mkdir.Path, "/"))
+		case *pb.FileAction_Rm:
+			instruction = "RUN " + jsonArray([]string{"rm", "-rf", a.Rm.Path})
+		default:
+			return "", errors.New("file action is not supported in Dockerfile")
+		}
+		if strings.HasPrefix(base, localPrefix) {
+			return "", errors.New("file action on the local source is not supported in Dockerfile")
+		}
+		outputs[i] = c.newStage(base, instruction)
+		if action.Output == index {
+			return outputs[i], nil
+		}
+	}
+	return "", errors.Newf("output %d is not in the file op", index)
+}
+
+func (c *dockerfileConverter) merge(op pb.Op, merge *pb.MergeOp, lower *pb.Input, rebase string) (string, error) {
+	acc, err := c.inputStage(op, merge.Inputs[0].Input, lower, rebase)
+	if err != nil {
+		return "", err
+	}
+	for _, input := range merge.Inputs[1:] {
+		in := op.Inputs[input.Input]
+		if diff, ok := c.ops[in.Digest].Op.(*pb.Op_Diff); ok && diff.Diff.Lower.Input != pb.Empty {
+			diffOp := c.ops[in.Digest]
+			diffLower := diffOp.Inputs[diff.Diff.Lower.Input]
+			upper := diffOp.Inputs[diff.Diff.Upper.Input]
+			if c.ancestor(upper, diffLower) {
+				if acc, err = c.stage(upper, diffLower, acc); err != nil {
+					return "", err
+				}
+				continue
+			}
+		}
+		s, err := c.stage(in, nil, "")
+		if err != nil {
+			return "", err
+		}
+		from := s
+		if name := strings.TrimPrefix(s, localPrefix); name != s {
+			from = name
+		}
+		acc = c.newStage(acc, fmt.Sprintf("COPY --link --from=%s / /", from))
+	}
+	return acc, nil
+}
+
+// ancestor returns true if the lower is the input of the upper, directly or
+// not.
+func (c *dockerfileConverter) ancestor(upper, lower *pb.Input) bool {
+	if upper.Digest == lower.Digest && upper.Index == lower.Index {
+		return true
+	}
+	for _, input := range c.ops[upper.Digest].Inputs {
+		if c.ancestor(input, lower) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyDest returns the dest of COPY for the copy action, or the error if the
+// flags of the copy could not be expressed by COPY.
+//
+// COPY always follows the symlinks, creates the dest path and allows the
+// empty wildcard, which is the superset of the copy unless the src is a
+// symlink. The directory src without CopyDirContentsOnly is copied as the
+// dest, or into it if the dest ends with "/", while COPY copies the contents
+// of the directory, thus the base of the src is appended to the latter.
+func copyDest(cp *pb.FileActionCopy) (string, error) {
+	switch {
+	case cp.AttemptUnpackDockerCompatibility:
+		return "", errors.Newf("the unpack of %s is not supported in Dockerfile", cp.Src)
+	case len(cp.IncludePatterns) > 0 || len(cp.ExcludePatterns) > 0:
+		return "", errors.Newf("the include or exclude patterns of %s are not supported in Dockerfile", cp.Src)
+	case cp.Timestamp >= 0:
+		return "", errors.Newf("the timestamp of %s is not supported in Dockerfile", cp.Src)
+	case !cp.AllowWildcard && strings.ContainsAny(cp.Src, "*?["):
+		return "", errors.Newf("the src %s would be expanded as the wildcard in Dockerfile", cp.Src)
+	}
+	if !cp.DirCopyContents && strings.HasSuffix(cp.Dest, "/") && path.Clean(cp.Src) != "/" {
+		return path.Join(cp.Dest, path.Base(cp.Src)), nil
+	}
+	return cp.Dest, nil
+}
+
+// chownFlags returns the `--chown` and `--chmod` flags of COPY.
+func chownFlags(owner *pb.ChownOpt, mode int32) string {
+	var flags string
+	if owner != nil && owner.User != nil {
+		flags = " --chown=" + userOpt(owner.User)
+		if owner.Group != nil {
+			flags += ":" + userOpt(owner.Group)
+		}
+	}
+	if mode > 0 {
+		flags += fmt.Sprintf(" --chmod=%04o", mode)
+	}
+	return flags
+}
+
+func userOpt(u *pb.UserOpt) string {
+	switch u := u.User.(type) {
+	case *pb.UserOpt_ByName:
+		return u.ByName.Name
+	case *pb.UserOpt_ByID:
+		return fmt.Sprint(u.ByID)
+	}
+	return ""
+}
+
+// jsonArray returns the exec form of the instruction, the shell form would
+// parse the args again.
+func jsonArray(args []string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(args)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tensorchord/envd/pkg/flag"
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestConvertDockerfile(t *testing.T) {
+	base := llb.Image("ubuntu:22.04").
+		Run(llb.Shlex("apt-get update"), llb.AddEnv("DEBIAN_FRONTEND", "noninteractive")).Root()
+	lang := base.File(llb.Mkfile("/etc/envd/lang", 0644, []byte("julia\n")))
+	run := base.Run(llb.Shlex("pip install -r requirements.txt"), llb.Dir("/workspace"))
+	run.AddMount("/workspace", llb.Local(flag.FlagBuildContext), llb.Readonly)
+	run.AddMount("/root/.cache/pip", llb.Scratch(), llb.AsPersistentCacheDir("pip", llb.CacheMountShared))
+	merge := llb.Merge([]llb.State{
+		base,
+		llb.Diff(base, lang),
+		llb.Diff(base, run.Root()),
+	})
+	final := merge.File(llb.Copy(llb.Local(flag.FlagCacheDir), "oh-my-zsh", "/opt/oh-my-zsh"))
+	def, err := final.Marshal(context.Background())
+	require.NoError(t, err)
+
+	dockerfile, err := convertDockerfile(def, v1.ImageConfig{
+		Env:        []string{"PATH=/usr/bin:/bin"},
+		Entrypoint: []string{"horust"},
+	}, map[string]string{flag.FlagCacheDir: "/home/envd/.cache/envd"}, nil)
+	require.NoError(t, err)
+	out := string(dockerfile)
+
+	for _, expected := range []string{
+		"# syntax=docker/dockerfile:",
+		"--build-context cache-dir=/home/envd/.cache/envd",
+		"FROM --platform=linux/amd64 docker.io/library/ubuntu:22.04 AS s0",
+		`RUN ["env","-i","PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin","DEBIAN_FRONTEND=noninteractive","apt-get","update"]`,
+		"COPY --chmod=0644 <<'EOF' /etc/envd/lang\njulia\nEOF",
+		"RUN --mount=type=cache,id=pip,target=/root/.cache/pip,sharing=shared --mount=type=bind,target=/workspace,source=/ ",
+		"COPY --from=cache-dir /oh-my-zsh /opt/oh-my-zsh",
+		`ENV PATH="/usr/bin:/bin"`,
+		`ENTRYPOINT ["horust"]`,
+	} {
+		require.Contains(t, out, expected)
+	}
+	// the pip install is replayed on top of the stage with the file, instead
+	// of copying the whole root of the upper
+	require.NotContains(t, out, "COPY --link")
+	require.Equal(t, 1, strings.Count(out, "apt-get"))
+}
+
+func TestConvertDockerfileFollowPaths(t *testing.T) {
+	base := llb.Image("ubuntu:22.04")
+	run := base.Run(llb.Shlex("pip install -r requirements.txt"), llb.Dir("/workspace"),
+		llb.AddSecret("/run/secrets/pip", llb.SecretID("pip"), llb.SecretFileOpt(1000, 1000, 0400)))
+	run.AddMount("/workspace", llb.Local(flag.FlagBuildContext, llb.FollowPaths([]string{"requirements.txt"})), llb.Readonly)
+	final := run.Root().File(llb.Copy(llb.Local(flag.FlagCacheDir), "oh-my-zsh", "/opt/", &llb.CopyInfo{CreateDestPath: true}))
+	def, err := final.Marshal(context.Background())
+	require.NoError(t, err)
+
+	dockerfile, err := convertDockerfile(def, v1.ImageConfig{},
+		map[string]string{flag.FlagCacheDir: "/home/envd/.cache/envd"},
+		[]ir.BuildSecret{{ID: "pip", Env: "PIP_TOKEN"}})
+	require.NoError(t, err)
+	out := string(dockerfile)
+
+	for _, expected := range []string{
+		"--build-context cache-dir=/home/envd/.cache/envd --secret id=pip,env=PIP_TOKEN .",
+		"FROM scratch AS s1\nCOPY requirements.txt /requirements.txt\n",
+		"--mount=type=bind,target=/workspace,from=s1,source=/ ",
+		"--mount=type=secret,id=pip,target=/run/secrets/pip,required=true,uid=1000,gid=1000,mode=0400",
+		"COPY --from=cache-dir /oh-my-zsh /opt/oh-my-zsh",
+	} {
+		require.Contains(t, out, expected)
+	}
+}
+
+func TestConvertDockerfileUnsupported(t *testing.T) {
+	base := llb.Image("ubuntu:22.04")
+	for name, state := range map[string]llb.State{
+		"unpack": base.File(llb.Copy(llb.Local(flag.FlagBuildContext), "data.tar", "/data",
+			&llb.CopyInfo{AttemptUnpack: true})),
+		"exclude": base.File(llb.Copy(llb.Local(flag.FlagBuildContext), "/", "/data",
+			&llb.CopyInfo{ExcludePatterns: []string{"*.pyc"}})),
+		"wildcard": base.File(llb.Copy(llb.Local(flag.FlagBuildContext), "data[0]", "/data")),
+		"local": base.File(llb.Copy(llb.Local(flag.FlagBuildContext,
+			llb.ExcludePatterns([]string{".git"})), "/", "/data")),
+	} {
+		def, err := state.Marshal(context.Background())
+		require.NoError(t, err)
+		_, err = convertDockerfile(def, v1.ImageConfig{}, nil, nil)
+		require.Error(t, err, name)
+	}
+}
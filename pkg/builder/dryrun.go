@@ -0,0 +1,221 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/tensorchord/envd/pkg/util/fileutil"
+)
+
+const (
+	DryRunFormatJSON = "json"
+	DryRunFormatDot  = "dot"
+
+	// The cache status is estimated by the digests of the last build, the
+	// changes of the files in the build context are not detected.
+	cacheStatusCached      = "cached"
+	cacheStatusChanged     = "changed"
+	cacheStatusInvalidated = "invalidated"
+	cacheStatusUnknown     = "unknown"
+)
+
+// Vertex is the step of the build printed by the dry run.
+type Vertex struct {
+	Digest digest.Digest   `json:"digest"`
+	Name   string          `json:"name"`
+	Inputs []digest.Digest `json:"inputs,omitempty"`
+	// Cache is the estimated cache status: cached, changed if the step itself
+	// is changed, invalidated if any of the inputs is changed, or unknown
+	// without the previous build
+	Cache string `json:"cache"`
+}
+
+// DryRun writes the steps of the build without executing them.
+func (b generalBuilder) DryRun(ctx context.Context, w io.Writer, format string) error {
+	if format != DryRunFormatJSON && format != DryRunFormatDot {
+		return errors.Newf("unsupported dry run format %s, must be %s or %s",
+			format, DryRunFormatJSON, DryRunFormatDot)
+	}
+	def, err := b.Compile(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to compile")
+	}
+	built, err := loadBuiltDigests(b.BuildContextDir)
+	if err != nil {
+		b.logger.Debugf("failed to load the digests of the last build: %s", err)
+	}
+	vertices, err := definitionVertices(def, built)
+	if err != nil {
+		return err
+	}
+	if format == DryRunFormatDot {
+		writeVerticesDot(w, vertices)
+		return nil
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(vertices)
+}
+
+// definitionVertices returns the vertices in the topological order of the
+// definition, with the cache status estimated by the built digests. All the
+// vertices are unknown if built is nil.
+func definitionVertices(def *llb.Definition, built map[digest.Digest]bool) ([]Vertex, error) {
+	status := map[digest.Digest]string{}
+	var vertices []Vertex
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, errors.Wrap(err, "failed to parse the op")
+		}
+		dgst := digest.FromBytes(dt)
+		// the last op is the terminal one without any operation
+		if op.Op == nil {
+			continue
+		}
+		v := Vertex{
+			Digest: dgst,
+			Name:   vertexName(op, def.Metadata[dgst]),
+			Cache:  cacheStatusUnknown,
+		}
+		for _, inp := range op.Inputs {
+			v.Inputs = append(v.Inputs, inp.Digest)
+		}
+		if built != nil {
+			switch {
+			case built[dgst]:
+				v.Cache = cacheStatusCached
+			case inputsChanged(v.Inputs, status):
+				v.Cache = cacheStatusInvalidated
+			default:
+				v.Cache = cacheStatusChanged
+			}
+		}
+		status[dgst] = v.Cache
+		vertices = append(vertices, v)
+	}
+	return vertices, nil
+}
+
+func inputsChanged(inputs []digest.Digest, status map[digest.Digest]string) bool {
+	for _, inp := range inputs {
+		if s := status[inp]; s == cacheStatusChanged || s == cacheStatusInvalidated {
+			return true
+		}
+	}
+	return false
+}
+
+// vertexName returns the custom name of the vertex, e.g. "[internal] downloading
+// julia binary", or the description of the op.
+func vertexName(op pb.Op, meta pb.OpMetadata) string {
+	if name, ok := meta.Description["llb.customname"]; ok {
+		return name
+	}
+	switch op := op.Op.(type) {
+	case *pb.Op_Source:
+		return op.Source.Identifier
+	case *pb.Op_Exec:
+		return strings.Join(op.Exec.Meta.Args, " ")
+	case *pb.Op_Merge:
+		return "merge"
+	case *pb.Op_Diff:
+		return "diff"
+	case *pb.Op_File:
+		return "file"
+	case *pb.Op_Build:
+		return "build"
+	}
+	return ""
+}
+
+func writeVerticesDot(w io.Writer, vertices []Vertex) {
+	colors := map[string]string{
+		cacheStatusCached:      "palegreen",
+		cacheStatusChanged:     "salmon",
+		cacheStatusInvalidated: "lightgoldenrod",
+		cacheStatusUnknown:     "white",
+	}
+	fmt.Fprintln(w, "digraph {")
+	for _, v := range vertices {
+		fmt.Fprintf(w, "  %q [label=%q style=filled fillcolor=%q];\n", v.Digest, v.Name, colors[v.Cache])
+	}
+	for _, v := range vertices {
+		for _, inp := range v.Inputs {
+			fmt.Fprintf(w, "  %q -> %q;\n", inp, v.Digest)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// builtDigestsFile is the cache file of the digests of the last build of the
+// build context.
+func builtDigestsFile(buildContextDir string) (string, error) {
+	return fileutil.CacheFile(fmt.Sprintf("llb-%s.json",
+		digest.FromString(buildContextDir).Encoded()[:16]))
+}
+
+// recordBuiltDigests saves the digests of the built definition for the dry run.
+func recordBuiltDigests(buildContextDir string, def *llb.Definition) error {
+	var digests []digest.Digest
+	for _, dt := range def.Def {
+		digests = append(digests, digest.FromBytes(dt))
+	}
+	data, err := json.Marshal(digests)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the digests")
+	}
+	file, err := builtDigestsFile(buildContextDir)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// loadBuiltDigests loads the digests of the last build, nil is returned if
+// the build context is not built.
+func loadBuiltDigests(buildContextDir string) (map[digest.Digest]bool, error) {
+	file, err := builtDigestsFile(buildContextDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read the digests")
+	}
+	var digests []digest.Digest
+	if err := json.Unmarshal(data, &digests); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the digests")
+	}
+	built := map[digest.Digest]bool{}
+	for _, d := range digests {
+		built[d] = true
+	}
+	return built, nil
+}
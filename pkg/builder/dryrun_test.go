@@ -0,0 +1,58 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefinitionVertices(t *testing.T) {
+	ctx := context.Background()
+	compile := func(command string) *llb.Definition {
+		base := llb.Image("docker.io/library/ubuntu:22.04")
+		first := base.Run(llb.Shlex(command), llb.WithCustomName("first step")).Root()
+		second := first.Run(llb.Shlex("echo second")).Root()
+		def, err := second.Marshal(ctx)
+		require.NoError(t, err)
+		return def
+	}
+
+	def := compile("echo first")
+	vertices, err := definitionVertices(def, nil)
+	require.NoError(t, err)
+	require.Len(t, vertices, 3)
+	require.Equal(t, "docker-image://docker.io/library/ubuntu:22.04", vertices[0].Name)
+	require.Equal(t, "first step", vertices[1].Name)
+	require.Equal(t, "echo second", vertices[2].Name)
+	require.Equal(t, []digest.Digest{vertices[1].Digest}, vertices[2].Inputs)
+	for _, v := range vertices {
+		require.Equal(t, cacheStatusUnknown, v.Cache)
+	}
+
+	built := map[digest.Digest]bool{}
+	for _, v := range vertices {
+		built[v.Digest] = true
+	}
+	vertices, err = definitionVertices(compile("echo changed"), built)
+	require.NoError(t, err)
+	require.Equal(t, cacheStatusCached, vertices[0].Cache)
+	require.Equal(t, cacheStatusChanged, vertices[1].Cache)
+	require.Equal(t, cacheStatusInvalidated, vertices[2].Cache)
+}
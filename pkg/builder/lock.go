@@ -0,0 +1,238 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	gatewayclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+const (
+	// LockFile is the lockfile in the build context.
+	LockFile    = "envd.lock"
+	lockVersion = "v1"
+)
+
+// Lock records the base image digest and the versions of the packages
+// installed in the image, to check if the build is reproducible.
+type Lock struct {
+	Version string `json:"version"`
+	// Image is the base image pinned by the digest
+	Image string `json:"image"`
+	// Packages are the installed `name=version` by the package manager
+	Packages map[string][]string `json:"packages"`
+}
+
+// builtLock is the lock recorded from the built image by BuildFunc, which
+// runs concurrently for the export entries.
+type builtLock struct {
+	mu   sync.Mutex
+	lock *Lock
+}
+
+// get returns the recorded lock, nil if the image is not built.
+func (l *builtLock) get() *Lock {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lock
+}
+
+// lockRequired checks if the image is built even if it's up to date, to read
+// the versions of the packages from it. The lockfile is kept if it exists.
+func (b generalBuilder) lockRequired() bool {
+	if b.Frozen {
+		return true
+	}
+	if !b.Lock {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(b.BuildContextDir, LockFile))
+	return err != nil
+}
+
+// recordLock reads the versions of the packages installed in the built image
+// by the lock definition, which shares the build cache with the image. It
+// fails for `--frozen` if they diverge from the lockfile, before the image is
+// exported.
+func (b generalBuilder) recordLock(ctx context.Context, c gatewayclient.Client, cacheImports []gatewayclient.CacheOptionsEntry) error {
+	if b.builtLock == nil {
+		return nil
+	}
+	image, err := b.graph.ResolveBaseImage(ctx)
+	if err != nil {
+		return err
+	}
+	packages := map[string][]string{}
+	if b.lockDefinition != nil {
+		res, err := c.Solve(ctx, gatewayclient.SolveRequest{
+			Definition:   b.lockDefinition.ToPB(),
+			CacheImports: cacheImports,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to export the versions of the packages")
+		}
+		ref, err := res.SingleRef()
+		if err != nil {
+			return errors.Wrap(err, "failed to get the versions of the packages")
+		}
+		files, err := ref.ReadDir(ctx, gatewayclient.ReadDirRequest{Path: "/", IncludePattern: "*.txt"})
+		if err != nil {
+			return errors.Wrap(err, "failed to list the versions of the packages")
+		}
+		for _, file := range files {
+			data, err := ref.ReadFile(ctx, gatewayclient.ReadRequest{Filename: file.Path})
+			if err != nil {
+				return errors.Wrapf(err, "failed to read %s", file.Path)
+			}
+			packages[strings.TrimSuffix(file.Path, ".txt")] = parsePackages(data)
+		}
+	}
+	lock := &Lock{
+		Version:  lockVersion,
+		Image:    image,
+		Packages: packages,
+	}
+	if b.Frozen {
+		if err := b.checkLock(*lock); err != nil {
+			return err
+		}
+	}
+	b.builtLock.mu.Lock()
+	defer b.builtLock.mu.Unlock()
+	b.builtLock.lock = lock
+	return nil
+}
+
+// ExportReproducer writes the portable manifest of the environment, with the
+// base image and the packages pinned to the versions resolved now.
+func (b generalBuilder) ExportReproducer(ctx context.Context, w io.Writer) error {
+	image, err := b.graph.ResolveBaseImage(ctx)
+	if err != nil {
+		return err
+	}
+	packages, err := b.resolvePackages(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := b.graph.ExportReproducer(image, packages)
+	if err != nil {
+		return errors.Wrap(err, "failed to export the reproducer")
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// checkLock fails the build if the packages diverge from the lockfile.
+func (b generalBuilder) checkLock(lock Lock) error {
+	locked, err := LoadLock(filepath.Join(b.BuildContextDir, LockFile))
+	if err != nil {
+		return errors.Wrap(err, "run `envd build --lock` to generate it")
+	}
+	if diff := diffLock(*locked, lock); len(diff) > 0 {
+		return errors.Newf("the packages diverge from %s, run `envd build --lock` to update it:\n%s",
+			LockFile, strings.Join(diff, "\n"))
+	}
+	return nil
+}
+
+// LoadLock reads the lockfile generated by `envd build --lock`.
+func LoadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the lockfile %s", path)
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the lockfile %s", path)
+	}
+	if lock.Version != lockVersion {
+		return nil, errors.Newf("unsupported lockfile version: %s", lock.Version)
+	}
+	return &lock, nil
+}
+
+// writeLock writes the lockfile after the build, nil lock is ignored.
+func (b generalBuilder) writeLock(lock *Lock) error {
+	if lock == nil || !b.Lock {
+		return nil
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the lockfile")
+	}
+	path := filepath.Join(b.BuildContextDir, LockFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write the lockfile %s", path)
+	}
+	b.logger.WithField("lockfile", path).Debug("lockfile is generated")
+	return nil
+}
+
+// diffLock returns the differences from the locked to the resolved, in the
+// format of `- removed` and `+ added`.
+func diffLock(locked, resolved Lock) []string {
+	var diff []string
+	if locked.Image != resolved.Image {
+		diff = append(diff, fmt.Sprintf("- image %s", locked.Image), fmt.Sprintf("+ image %s", resolved.Image))
+	}
+
+	managers := map[string]bool{}
+	for m := range locked.Packages {
+		managers[m] = true
+	}
+	for m := range resolved.Packages {
+		managers[m] = true
+	}
+	names := make([]string, 0, len(managers))
+	for m := range managers {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+
+	for _, m := range names {
+		before := map[string]bool{}
+		for _, p := range locked.Packages[m] {
+			before[p] = true
+		}
+		after := map[string]bool{}
+		for _, p := range resolved.Packages[m] {
+			after[p] = true
+		}
+		for _, p := range locked.Packages[m] {
+			if !after[p] {
+				diff = append(diff, fmt.Sprintf("- %s %s", m, p))
+			}
+		}
+		for _, p := range resolved.Packages[m] {
+			if !before[p] {
+				diff = append(diff, fmt.Sprintf("+ %s %s", m, p))
+			}
+		}
+	}
+	return diff
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	b := generalBuilder{
+		Options: Options{BuildContextDir: t.TempDir(), Lock: true},
+		logger:  logrus.WithField("test", "lock"),
+	}
+	lock := Lock{
+		Version: lockVersion,
+		Image:   "ubuntu:22.04@sha256:0bced47fffa3361afa981854fcabcd4577cd43cebbb808cea2b1f33a3dd7f508",
+		Packages: map[string][]string{
+			"pypi":   {"numpy==1.26.4"},
+			"system": {"curl=7.81.0-1ubuntu1.15", "libcurl4=7.81.0-1ubuntu1.15"},
+		},
+	}
+	require.Error(t, b.checkLock(lock))
+	require.NoError(t, b.writeLock(&lock))
+	require.NoError(t, b.checkLock(lock))
+
+	resolved := Lock{
+		Version: lockVersion,
+		Image:   lock.Image,
+		Packages: map[string][]string{
+			"julia":  {},
+			"pypi":   {"numpy==2.0.0"},
+			"system": lock.Packages["system"],
+		},
+	}
+	require.Equal(t, []string{"- pypi numpy==1.26.4", "+ pypi numpy==2.0.0"}, diffLock(lock, resolved))
+	require.ErrorContains(t, b.checkLock(resolved), "+ pypi numpy==2.0.0")
+}
+
+func TestLockRequired(t *testing.T) {
+	b := generalBuilder{Options: Options{BuildContextDir: t.TempDir()}}
+	require.False(t, b.lockRequired())
+	b.Lock = true
+	require.True(t, b.lockRequired())
+	require.NoError(t, os.WriteFile(filepath.Join(b.BuildContextDir, LockFile), []byte("{}"), 0o644))
+	require.False(t, b.lockRequired())
+	b.Lock = false
+	b.Frozen = true
+	require.True(t, b.lockRequired())
+}
+
+func TestParsePackages(t *testing.T) {
+	require.Equal(t, []string{}, parsePackages(nil))
+	require.Equal(t, []string{"Example@0.5.3", "JSON@0.21.4"},
+		parsePackages([]byte("JSON@0.21.4\n\n  Example@0.5.3\n")))
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/tensorchord/envd/pkg/flag"
+	"github.com/tensorchord/envd/pkg/home"
+	"github.com/tensorchord/envd/pkg/progress/progresswriter"
+)
+
+// Resolve resolves the versions of the packages without building the
+// image, and writes the resolved packages grouped by the package manager.
+func (b generalBuilder) Resolve(ctx context.Context, w io.Writer) error {
+	packages, err := b.resolvePackages(ctx)
+	if err != nil {
+		return err
+	}
+	if len(packages) == 0 {
+		return errors.New("there are no system, Julia or PyPI packages to resolve")
+	}
+
+	managers := make([]string, 0, len(packages))
+	for m := range packages {
+		managers = append(managers, m)
+	}
+	sort.Strings(managers)
+	for _, m := range managers {
+		fmt.Fprintf(w, "# %s\n", m)
+		for _, p := range packages[m] {
+			fmt.Fprintln(w, p)
+		}
+	}
+	return nil
+}
+
+// resolvePackages returns the resolved packages by the package manager.
+func (b generalBuilder) resolvePackages(ctx context.Context) (map[string][]string, error) {
+	def, err := b.graph.CompileResolution(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile the resolution")
+	}
+	packages := map[string][]string{}
+	if def == nil {
+		return packages, nil
+	}
+
+	dir, err := os.MkdirTemp("", "envd-resolve")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the output dir")
+	}
+	defer os.RemoveAll(dir)
+
+	secrets, err := secretsProvider(b.buildSecrets(), b.Secrets)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the build secrets")
+	}
+	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(b.dockerConfig())}
+	if secrets != nil {
+		attachable = append(attachable, secrets)
+	}
+	opt := client.SolveOpt{
+		Exports: []client.ExportEntry{{
+			Type:      client.ExporterLocal,
+			OutputDir: dir,
+		}},
+		LocalDirs: map[string]string{
+			flag.FlagCacheDir:     home.GetManager().CacheDir(),
+			flag.FlagBuildContext: b.BuildContextDir,
+		},
+		Session: attachable,
+	}
+
+	// the progress goes to stderr, thus stdout only has the resolved packages
+	pw, err := progresswriter.NewPrinter(ctx, os.Stderr, b.ProgressMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create progress writer")
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		if _, err := b.Client.Solve(ctx, def, opt, pw.Status()); err != nil {
+			return errors.Wrap(&BuildkitdErr{err: err}, "failed to resolve the packages")
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		<-pw.Done()
+		return pw.Err()
+	})
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the resolved packages")
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", file)
+		}
+		packages[strings.TrimSuffix(filepath.Base(file), ".txt")] = parsePackages(data)
+	}
+	return packages, nil
+}
+
+// parsePackages returns the sorted packages of the file, a package per line.
+// An empty list is kept since the packages may be in the base image.
+func parsePackages(data []byte) []string {
+	packages := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			packages = append(packages, line)
+		}
+	}
+	sort.Strings(packages)
+	return packages
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// StepTimeoutErr is returned if a step of the build is not completed in the
+// timeout.
+type StepTimeoutErr struct {
+	Name    string
+	Timeout time.Duration
+}
+
+func (e *StepTimeoutErr) Error() string {
+	return fmt.Sprintf("the step `%s` is not completed in %s", e.Name, e.Timeout)
+}
+
+// stepWatcher watches the status of the steps, to cancel the build if a step
+// is running longer than the timeout, and to name the failed step in the error.
+type stepWatcher struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	running map[digest.Digest]runningStep
+	failed  string
+	expired *StepTimeoutErr
+}
+
+type runningStep struct {
+	name    string
+	started time.Time
+}
+
+func newStepWatcher(timeout time.Duration) *stepWatcher {
+	return &stepWatcher{
+		timeout: timeout,
+		running: map[digest.Digest]runningStep{},
+	}
+}
+
+// stepTimeout returns the timeout of the steps, the one of the flag overrides
+// the one declared in build.envd.
+func (b generalBuilder) stepTimeout() time.Duration {
+	if b.StepTimeout > 0 {
+		return b.StepTimeout
+	}
+	if b.graph == nil {
+		return 0
+	}
+	if limits := b.graph.GetBuildLimits(); limits != nil {
+		return limits.StepTimeout
+	}
+	return 0
+}
+
+// update records the steps in the status. The start time is the local time
+// when the step is seen running, since the clock of buildkitd may differ.
+func (w *stepWatcher) update(s *client.SolveStatus, now time.Time) {
+	for _, v := range s.Vertexes {
+		switch {
+		case v.Error != "":
+			// the other steps are cancelled after the first failure
+			if w.failed == "" && !strings.Contains(v.Error, context.Canceled.Error()) {
+				w.failed = v.Name
+			}
+			delete(w.running, v.Digest)
+		case v.Completed != nil || v.Cached:
+			delete(w.running, v.Digest)
+		case v.Started != nil:
+			if _, ok := w.running[v.Digest]; !ok {
+				w.running[v.Digest] = runningStep{name: v.Name, started: now}
+			}
+		}
+	}
+}
+
+// check returns the error of the longest running step if it exceeds the
+// timeout, nil otherwise.
+func (w *stepWatcher) check(now time.Time) *StepTimeoutErr {
+	if w.timeout <= 0 {
+		return nil
+	}
+	var longest *runningStep
+	for _, s := range w.running {
+		s := s
+		if now.Sub(s.started) >= w.timeout && (longest == nil || s.started.Before(longest.started)) {
+			longest = &s
+		}
+	}
+	if longest == nil {
+		return nil
+	}
+	return &StepTimeoutErr{Name: longest.name, Timeout: w.timeout}
+}
+
+// watch consumes the status until the channel is closed, and cancels the
+// build if a step is timed out.
+func (w *stepWatcher) watch(ch chan *client.SolveStatus, cancel context.CancelFunc) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case s, ok := <-ch:
+			if !ok {
+				return
+			}
+			w.mu.Lock()
+			w.update(s, time.Now())
+			w.mu.Unlock()
+		case now := <-ticker.C:
+			w.mu.Lock()
+			if w.expired == nil {
+				if w.expired = w.check(now); w.expired != nil {
+					cancel()
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// wrap returns the timeout error if the build is cancelled by the watcher,
+// or names the failed step in the error of the build.
+func (w *stepWatcher) wrap(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired != nil {
+		return w.expired
+	}
+	if w.failed != "" {
+		return errors.Wrapf(err, "failed to build the step `%s`", w.failed)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStepWatcher(t *testing.T) {
+	start := time.Now()
+	julia := &client.Vertex{Digest: digest.FromString("julia"),
+		Name: "[internal] installing Julia packages", Started: &start}
+	apt := &client.Vertex{Digest: digest.FromString("apt"), Name: "apt-get update", Started: &start}
+
+	w := newStepWatcher(time.Minute)
+	w.update(&client.SolveStatus{Vertexes: []*client.Vertex{julia}}, start)
+	w.update(&client.SolveStatus{Vertexes: []*client.Vertex{apt}}, start.Add(10*time.Second))
+	require.Nil(t, w.check(start.Add(30*time.Second)))
+	require.Equal(t, &StepTimeoutErr{Name: julia.Name, Timeout: time.Minute}, w.check(start.Add(time.Minute)))
+
+	completed := start.Add(time.Minute)
+	done := *julia
+	done.Completed = &completed
+	w.update(&client.SolveStatus{Vertexes: []*client.Vertex{&done}}, completed)
+	require.Equal(t, apt.Name, w.check(start.Add(2*time.Minute)).Name)
+
+	failed := *apt
+	failed.Error = "process did not complete successfully: exit code: 1"
+	w.update(&client.SolveStatus{Vertexes: []*client.Vertex{&failed}}, completed)
+	require.Nil(t, w.check(start.Add(time.Hour)))
+	require.EqualError(t, w.wrap(errors.New("exit code: 1")), "failed to build the step `apt-get update`: exit code: 1")
+
+	require.Nil(t, newStepWatcher(0).check(start.Add(time.Hour)))
+}
@@ -15,6 +15,8 @@
 package builder
 
 import (
+	"time"
+
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/sirupsen/logrus"
@@ -29,7 +31,7 @@ type Options struct {
 	ManifestFilePath string
 	// ConfigFilePath is the path to the config file `config.envd`.
 	ConfigFilePath string
-	// ProgressMode is the output mode (auto, plain).
+	// ProgressMode is the output mode (auto, tty, plain, json).
 	ProgressMode string
 	// Tag is the name of the image.
 	Tag string
@@ -49,6 +51,26 @@ type Options struct {
 	ImportCache string
 	// UseHTTPProxy uses HTTPS_PROXY/HTTP_PROXY/NO_PROXY in the build process.
 	UseHTTPProxy bool
+	// PolicyFilePath is the path to the policy file the environment is validated against.
+	PolicyFilePath string
+	// ValidationWebhook is the URL to approve or reject the environment before the build.
+	ValidationWebhook string
+	// ValidationWebhookTimeout is the timeout of the validation webhook.
+	ValidationWebhookTimeout time.Duration
+	// ValidationWebhookFailOpen continues the build if the webhook can not be reached.
+	ValidationWebhookFailOpen bool
+	// Platform overrides the platform declared by `config.platform`, e.g. linux/arm64.
+	Platform string
+	// Secrets maps the secret id to the file in the host, overrides the
+	// source declared by `io.mount_secret` in build.envd.
+	Secrets map[string]string
+	// Lock writes the base image digest and the installed packages to envd.lock.
+	Lock bool
+	// Frozen fails the build if the installed packages diverge from envd.lock.
+	Frozen bool
+	// StepTimeout fails the build if a step is not completed in it, overrides
+	// the one declared by `config.build_limits` in build.envd.
+	StepTimeout time.Duration
 }
 
 type generalBuilder struct {
@@ -57,6 +79,10 @@ type generalBuilder struct {
 	entries          []client.ExportEntry
 
 	definition *llb.Definition
+	// lockDefinition exports the versions of the packages installed in the
+	// image, and builtLock is recorded from it, for `--lock` and `--frozen`
+	lockDefinition *llb.Definition
+	builtLock      *builtLock
 
 	logger *logrus.Entry
 	starlark.Interpreter
@@ -19,15 +19,22 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/cockroachdb/errors"
 	"github.com/containerd/console"
 	"github.com/containerd/containerd/platforms"
+	"github.com/docker/cli/cli/config"
 	"github.com/moby/buildkit/client"
 	gatewayclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
 )
 
 const (
@@ -36,8 +43,11 @@ const (
 )
 
 func ImageConfigStr(labels map[string]string, ports map[string]struct{},
-	entrypoint []string, env []string, user string) (string, error) {
-	pl := platforms.Normalize(platforms.DefaultSpec())
+	entrypoint []string, env []string, user string, stopSignal string, platform string) (string, error) {
+	pl, err := platforms.Parse(platform)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid platform: %s", platform)
+	}
 	img := v1.Image{
 		Config: v1.ImageConfig{
 			Labels:       labels,
@@ -46,6 +56,7 @@ func ImageConfigStr(labels map[string]string, ports map[string]struct{},
 			Env:          env,
 			ExposedPorts: ports,
 			Entrypoint:   entrypoint,
+			StopSignal:   stopSignal,
 		},
 		Architecture: pl.Architecture,
 		// Refer to https://github.com/tensorchord/envd/issues/269#issuecomment-1152944914
@@ -302,6 +313,35 @@ func resolveExporterDest(exporter, dest string) (func(map[string]string) (io.Wri
 	}
 }
 
+// secretsProvider returns the session attachable that serves the build secrets.
+// The files in overrides take precedence over the declared sources.
+func secretsProvider(secrets []ir.BuildSecret, overrides map[string]string) (session.Attachable, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	sources := make([]secretsprovider.Source, 0, len(secrets))
+	for _, s := range secrets {
+		source := secretsprovider.Source{
+			ID:       s.ID,
+			Env:      s.Env,
+			FilePath: s.File,
+		}
+		if file, ok := overrides[s.ID]; ok {
+			source.Env = ""
+			source.FilePath = file
+		}
+		if source.Env == "" && source.FilePath == "" {
+			return nil, errors.Newf("secret %s is not provided, use `envd build --secret %s=<file>`", s.ID, s.ID)
+		}
+		sources = append(sources, source)
+	}
+	store, err := secretsprovider.NewStore(sources)
+	if err != nil {
+		return nil, err
+	}
+	return secretsprovider.NewSecretProvider(store), nil
+}
+
 func ParseFromStr(fromStr string) (string, string, error) {
 	filename := defaultFile
 	funcname := defaultFunc
@@ -325,3 +365,79 @@ func ParseFromStr(fromStr string) (string, string, error) {
 	}
 	return filename, funcname, nil
 }
+
+// withCompression sets the layer compression of the image exporters, unless
+// the options are set in `--output`. The zstd layers require the OCI media types.
+func withCompression(entry client.ExportEntry, cc ir.CompressionConfig) client.ExportEntry {
+	attrs := make(map[string]string, len(entry.Attrs)+3)
+	for k, v := range entry.Attrs {
+		attrs[k] = v
+	}
+	setDefault := func(k, v string) {
+		if _, ok := attrs[k]; !ok {
+			attrs[k] = v
+		}
+	}
+	setDefault("compression", cc.Algorithm)
+	if cc.Level != nil {
+		setDefault("compression-level", strconv.Itoa(*cc.Level))
+	}
+	if cc.Algorithm == "zstd" || cc.Algorithm == "estargz" {
+		setDefault("oci-mediatypes", "true")
+	}
+	entry.Attrs = attrs
+	return entry
+}
+
+// outputEntry returns the exporter of the output target in build.envd except
+// docker, which is the default one. The relative path is resolved against the build context.
+func outputEntry(oc ir.OutputConfig, tag, buildContextDir string) (client.ExportEntry, error) {
+	name := oc.Name
+	if name == "" {
+		name = tag
+	}
+	path := oc.Path
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(buildContextDir, path)
+	}
+
+	switch oc.Target {
+	case "registry":
+		if oc.DockerConfigDir != "" {
+			if _, err := os.Stat(filepath.Join(oc.DockerConfigDir, config.ConfigFileName)); err != nil {
+				return client.ExportEntry{}, errors.Wrapf(err, "invalid docker config of the registry output")
+			}
+		}
+		attrs := map[string]string{
+			"name": name,
+			"push": "true",
+		}
+		if oc.Insecure {
+			attrs["registry.insecure"] = "true"
+		}
+		return client.ExportEntry{Type: client.ExporterImage, Attrs: attrs}, nil
+	case "oci":
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return client.ExportEntry{}, errors.Newf("OCI layout %s is not a directory", path)
+		}
+		if _, err := os.Stat(filepath.Dir(path)); err != nil {
+			return client.ExportEntry{}, errors.Wrapf(err, "invalid OCI layout %s", path)
+		}
+		attrs := map[string]string{"tar": "false"}
+		if oc.Name != "" {
+			attrs["name"] = oc.Name
+		}
+		return client.ExportEntry{Type: client.ExporterOCI, Attrs: attrs, OutputDir: path}, nil
+	case "tarball":
+		output, _, err := resolveExporterDest(client.ExporterDocker, path)
+		if err != nil {
+			return client.ExportEntry{}, errors.Wrapf(err, "invalid tarball %s", path)
+		}
+		return client.ExportEntry{
+			Type:   client.ExporterDocker,
+			Attrs:  map[string]string{"name": name},
+			Output: output,
+		}, nil
+	}
+	return client.ExportEntry{}, errors.Newf("unknown output target %s", oc.Target)
+}
@@ -15,12 +15,15 @@
 package builder
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/moby/buildkit/client"
 	gatewayclient "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
 )
 
 func TestParseImportCache(t *testing.T) {
@@ -128,6 +131,22 @@ func TestParseExportCache(t *testing.T) {
 	}
 }
 
+func TestCheckCacheExport(t *testing.T) {
+	b := generalBuilder{
+		Options: Options{ExportCache: "type=registry,ref=example.com/foo/bar,mode=max"},
+		entries: []client.ExportEntry{{Type: client.ExporterDocker}},
+	}
+	require.NoError(t, b.checkCacheExport())
+
+	b.entries = []client.ExportEntry{{Type: "moby"}}
+	require.Error(t, b.checkCacheExport())
+	b.ExportCache = "type=inline"
+	require.NoError(t, b.checkCacheExport())
+
+	b.ExportCache = "mode=max"
+	require.Error(t, b.checkCacheExport())
+}
+
 func TestParseOutput(t *testing.T) {
 	type args struct {
 		output string
@@ -259,3 +278,35 @@ func TestParseFromStr(t *testing.T) {
 		}
 	}
 }
+
+func TestOutputEntry(t *testing.T) {
+	dir := t.TempDir()
+
+	entry, err := outputEntry(ir.OutputConfig{Target: "registry", Name: "docker.io/foo/bar:v1", Insecure: true}, "bar:dev", dir)
+	require.NoError(t, err)
+	require.Equal(t, client.ExporterImage, entry.Type)
+	require.Equal(t, map[string]string{
+		"name":              "docker.io/foo/bar:v1",
+		"push":              "true",
+		"registry.insecure": "true",
+	}, entry.Attrs)
+
+	entry, err = outputEntry(ir.OutputConfig{Target: "oci", Path: "layout"}, "bar:dev", dir)
+	require.NoError(t, err)
+	require.Equal(t, client.ExporterOCI, entry.Type)
+	require.Equal(t, filepath.Join(dir, "layout"), entry.OutputDir)
+	require.Equal(t, "false", entry.Attrs["tar"])
+
+	entry, err = outputEntry(ir.OutputConfig{Target: "tarball", Path: "bar.tar"}, "bar:dev", dir)
+	require.NoError(t, err)
+	require.Equal(t, client.ExporterDocker, entry.Type)
+	require.Equal(t, "bar:dev", entry.Attrs["name"])
+	require.NotNil(t, entry.Output)
+
+	_, err = outputEntry(ir.OutputConfig{Target: "oci", Path: "bar.tar"}, "bar:dev", dir)
+	require.Error(t, err)
+	_, err = outputEntry(ir.OutputConfig{Target: "tarball", Path: "layout/missing/bar.tar"}, "bar:dev", dir)
+	require.Error(t, err)
+	_, err = outputEntry(ir.OutputConfig{Target: "registry", Name: "foo/bar", DockerConfigDir: dir}, "bar:dev", dir)
+	require.Error(t, err)
+}
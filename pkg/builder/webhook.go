@@ -0,0 +1,87 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	// maxWebhookMessage limits the message of the webhook in the error
+	maxWebhookMessage = 4096
+)
+
+// WebhookRequest is the payload posted to the validation webhook. The labels
+// are the same as the image labels, including the graph of the environment.
+type WebhookRequest struct {
+	Tag    string            `json:"tag"`
+	Labels map[string]string `json:"labels"`
+}
+
+// checkWebhook posts the environment to the validation webhook, the build is
+// rejected by the non-2xx response with the message in the body. If the
+// webhook can not be reached in time, the build is rejected unless it fails open.
+func (b generalBuilder) checkWebhook(ctx context.Context) error {
+	if b.ValidationWebhook == "" {
+		return nil
+	}
+	labels, err := b.graph.Labels()
+	if err != nil {
+		return errors.Wrap(err, "failed to get labels")
+	}
+	body, err := json.Marshal(WebhookRequest{Tag: b.Tag, Labels: labels})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the webhook request")
+	}
+
+	timeout := b.ValidationWebhookTimeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.ValidationWebhook, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "invalid validation webhook")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	b.logger.Debugf("validating the environment by the webhook %s", b.ValidationWebhook)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if b.ValidationWebhookFailOpen {
+			b.logger.Warnf("failed to call the validation webhook, the build continues: %s", err)
+			return nil
+		}
+		return errors.Wrap(err, "failed to call the validation webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookMessage))
+		return errors.Newf("the environment is rejected by the validation webhook (%s): %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/tensorchord/envd/pkg/lang/ir/v1"
+)
+
+func TestCheckWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Tag != "approved:dev" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("tag is not approved"))
+		}
+	}))
+	defer server.Close()
+
+	b := generalBuilder{
+		Options: Options{
+			Tag:               "approved:dev",
+			ValidationWebhook: server.URL,
+		},
+		graph:  v1.NewGraph(),
+		logger: logrus.WithField("test", "webhook"),
+	}
+	require.NoError(t, b.checkWebhook(context.Background()))
+
+	b.Tag = "rejected:dev"
+	err := b.checkWebhook(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tag is not approved")
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	b.ValidationWebhook = slow.URL
+	b.ValidationWebhookTimeout = 10 * time.Millisecond
+	require.Error(t, b.checkWebhook(context.Background()))
+	b.ValidationWebhookFailOpen = true
+	require.NoError(t, b.checkWebhook(context.Background()))
+}
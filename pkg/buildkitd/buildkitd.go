@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -46,6 +47,9 @@ var (
 	runningTimeout    = envutil.GetDurationWithDefault("BUILDKIT_RUNNING_TIMEOUT", time.Second*3)
 )
 
+// rootlessImageSuffix is the tag suffix of the rootless buildkitd image.
+const rootlessImageSuffix = "-rootless"
+
 // Client is a client for the buildkitd daemon.
 // It's up to the caller to close the client.
 type Client interface {
@@ -58,6 +62,8 @@ type Client interface {
 	) (*client.SolveResponse, error)
 	Prune(ctx context.Context, keepDuration time.Duration,
 		keepStorage float64, filter []string, verbose, all bool) error
+	// DiskUsage returns the records of the build cache.
+	DiskUsage(ctx context.Context, opts ...client.DiskUsageOption) ([]*client.UsageInfo, error)
 	Close() error
 }
 
@@ -65,6 +71,7 @@ type generalClient struct {
 	containerName string
 	image         string
 	mirror        string
+	rootless      bool
 
 	driver types.BuilderType
 	socket string
@@ -108,12 +115,19 @@ func NewMobyClient(ctx context.Context, driver types.BuilderType,
 	return c, nil
 }
 
+// NewClient creates the client of the buildkitd, which is started if it's not
+// running. The rootless image of buildkitd, i.e. the one with the `-rootless`
+// suffix, is used if rootless is true.
 func NewClient(ctx context.Context, driver types.BuilderType,
-	socket, mirror string) (Client, error) {
+	socket, mirror string, rootless bool) (Client, error) {
 	c := &generalClient{
 		containerName: socket,
 		image:         viper.GetString(flag.FlagBuildkitdImage),
 		mirror:        mirror,
+		rootless:      rootless,
+	}
+	if rootless && !strings.HasSuffix(c.image, rootlessImageSuffix) {
+		c.image += rootlessImageSuffix
 	}
 	c.socket = socket
 	c.driver = driver
@@ -171,7 +185,7 @@ func (c *generalClient) maybeStart(ctx context.Context,
 
 	if client != nil {
 		if _, err := client.StartBuildkitd(ctx,
-			c.image, c.containerName, c.mirror, runningTimeout); err != nil {
+			c.image, c.containerName, c.mirror, c.rootless, runningTimeout); err != nil {
 			return "", err
 		}
 	}
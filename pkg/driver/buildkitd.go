@@ -0,0 +1,40 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import "fmt"
+
+// BuildkitdSecurityOpts are the security options of the rootless buildkitd,
+// the seccomp and apparmor profiles block the mounts of rootlesskit.
+var BuildkitdSecurityOpts = []string{"seccomp=unconfined", "apparmor=unconfined"}
+
+// BuildkitdCommand returns the shell command to start buildkitd, with the
+// mirror of docker.io if it's not empty. The rootless buildkitd runs as the
+// user of the image in the user namespace of rootlesskit, thus it reads the
+// config in the home, and the process sandbox is disabled since it requires
+// the privileges.
+func BuildkitdCommand(mirror string, rootless bool) string {
+	configDir, buildkitd := "/etc/buildkit", "buildkitd"
+	if rootless {
+		configDir, buildkitd = "$HOME/.config/buildkit", "rootlesskit buildkitd --oci-worker-no-process-sandbox"
+	}
+	if mirror == "" {
+		return "exec " + buildkitd
+	}
+	cfg := fmt.Sprintf(`
+[registry."docker.io"]
+	mirrors = ["%s"]`, mirror)
+	return fmt.Sprintf("mkdir -p %[1]s && echo '%[2]s' > %[1]s/buildkitd.toml && exec %[3]s", configDir, cfg, buildkitd)
+}
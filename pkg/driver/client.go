@@ -25,7 +25,9 @@ import (
 type Client interface {
 	// Load loads the image from the reader to the docker host.
 	Load(ctx context.Context, r io.ReadCloser, quiet bool) error
-	StartBuildkitd(ctx context.Context, tag, name, mirror string, timeout time.Duration) (string, error)
+	// StartBuildkitd starts the buildkitd container, it runs without the
+	// privileges if rootless is true.
+	StartBuildkitd(ctx context.Context, tag, name, mirror string, rootless bool, timeout time.Duration) (string, error)
 
 	Exec(ctx context.Context, cname string, cmd []string) error
 
@@ -33,6 +35,8 @@ type Client interface {
 	RemoveImage(ctx context.Context, image string) error
 
 	PruneImage(ctx context.Context) (types.ImagesPruneReport, error)
+	// PruneEnvdImages removes the envd images which are not used by any container.
+	PruneEnvdImages(ctx context.Context) (types.ImagesPruneReport, error)
 
 	Stats(ctx context.Context, cname string, statChan chan<- *Stats, done <-chan bool) error
 }
@@ -17,7 +17,6 @@ package docker
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"os"
 	"regexp"
@@ -170,11 +169,12 @@ func (c dockerClient) ResumeContainer(ctx context.Context, name string) (string,
 }
 
 func (c dockerClient) StartBuildkitd(ctx context.Context,
-	tag, name, mirror string, timeout time.Duration) (string, error) {
+	tag, name, mirror string, rootless bool, timeout time.Duration) (string, error) {
 	logger := logrus.WithFields(logrus.Fields{
 		"tag":       tag,
 		"container": name,
 		"mirror":    mirror,
+		"rootless":  rootless,
 	})
 	logger.Debug("starting buildkitd")
 	if _, _, err := c.ImageInspectWithRaw(ctx, tag); err != nil {
@@ -198,20 +198,22 @@ func (c dockerClient) StartBuildkitd(ctx context.Context,
 	config := &container.Config{
 		Image: tag,
 	}
-	if mirror != "" {
-		cfg := fmt.Sprintf(`
-[registry."docker.io"]
-	mirrors = ["%s"]`, mirror)
+	if mirror != "" || rootless {
 		config.Entrypoint = []string{
 			"/bin/sh",
 			"-c",
-			fmt.Sprintf("mkdir /etc/buildkit && echo '%s' > /etc/buildkit/buildkitd.toml && buildkitd", cfg),
+			driver.BuildkitdCommand(mirror, rootless),
 		}
-		logger.Debugf("setting buildkit config: %s", cfg)
+		logger.Debugf("setting buildkitd command: %s", config.Entrypoint[2])
 	}
 	hostConfig := &container.HostConfig{
 		Privileged: true,
 	}
+	if rootless {
+		hostConfig = &container.HostConfig{
+			SecurityOpt: driver.BuildkitdSecurityOpts,
+		}
+	}
 	created, _ := c.Exists(ctx, name)
 	if created {
 		err := c.ContainerStart(ctx, name, types.ContainerStartOptions{})
@@ -303,6 +305,17 @@ func (c dockerClient) PruneImage(ctx context.Context) (types.ImagesPruneReport,
 	return pruneReport, nil
 }
 
+func (c dockerClient) PruneEnvdImages(ctx context.Context) (types.ImagesPruneReport, error) {
+	f := dockerFilters(false)
+	// the tagged images are also removed if they are not used by any container
+	f.Add("dangling", "false")
+	pruneReport, err := c.ImagesPrune(ctx, f)
+	if err != nil {
+		return types.ImagesPruneReport{}, errors.Wrap(err, "failed to prune envd images")
+	}
+	return pruneReport, nil
+}
+
 func (c dockerClient) Stats(ctx context.Context, cname string, statChan chan<- *driver.Stats, done <-chan bool) (retErr error) {
 	errC := make(chan error, 1)
 	containerStats, err := c.ContainerStats(ctx, cname, true)
@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"os/exec"
 	"time"
@@ -64,11 +63,12 @@ func (nc *nerdctlClient) Load(ctx context.Context, r io.ReadCloser, quiet bool)
 }
 
 func (nc *nerdctlClient) StartBuildkitd(ctx context.Context,
-	tag, name, mirror string, timeout time.Duration) (string, error) {
+	tag, name, mirror string, rootless bool, timeout time.Duration) (string, error) {
 	logger := logrus.WithFields(logrus.Fields{
 		"tag":       tag,
 		"container": name,
 		"mirror":    mirror,
+		"rootless":  rootless,
 		"driver":    "nerdctl",
 	})
 	logger.Debug("starting buildkitd")
@@ -81,20 +81,19 @@ func (nc *nerdctlClient) StartBuildkitd(ctx context.Context,
 
 	existed, _ := nc.containerExists(ctx, name)
 	if !existed {
-		buildkitdCmd := "buildkitd"
-		if mirror != "" {
-			cfg := fmt.Sprintf(`
-[registry."docker.io"]
-	mirrors = ["%s"]`, mirror)
-			buildkitdCmd = fmt.Sprintf("mkdir /etc/buildkit && echo '%s' > /etc/buildkit/buildkitd.toml && buildkitd", cfg)
-			logger.Debugf("setting buildkit config: %s", cfg)
-		}
+		buildkitdCmd := driver.BuildkitdCommand(mirror, rootless)
+		logger.Debugf("setting buildkitd command: %s", buildkitdCmd)
 
-		out, err := nc.exec(ctx, nil, "run", "-d",
-			"--name", name,
-			"--privileged",
-			"--entrypoint", "sh",
-			tag, "-c", buildkitdCmd)
+		args := []string{"run", "-d", "--name", name}
+		if rootless {
+			for _, opt := range driver.BuildkitdSecurityOpts {
+				args = append(args, "--security-opt", opt)
+			}
+		} else {
+			args = append(args, "--privileged")
+		}
+		args = append(args, "--entrypoint", "sh", tag, "-c", buildkitdCmd)
+		out, err := nc.exec(ctx, nil, args...)
 		if err != nil {
 			logrus.Error("can not run buildkitd", out, err)
 			return "", errors.Wrap(err, "running buildkitd")
@@ -119,6 +118,9 @@ func (nc *nerdctlClient) RemoveImage(ctx context.Context, image string) error {
 func (nc *nerdctlClient) PruneImage(ctx context.Context) (types.ImagesPruneReport, error) {
 	return types.ImagesPruneReport{}, nil
 }
+func (nc *nerdctlClient) PruneEnvdImages(ctx context.Context) (types.ImagesPruneReport, error) {
+	return types.ImagesPruneReport{}, nil
+}
 func (nc *nerdctlClient) Stats(ctx context.Context, cname string, statChan chan<- *driver.Stats, done <-chan bool) error {
 	return nil
 }
@@ -280,6 +280,23 @@ func (e dockerEngine) GetInfo(ctx context.Context) (*types.EnvdInfo, error) {
 	}, nil
 }
 
+// checkUserNS returns an error if the containers of the daemon are not in the
+// user namespace, i.e. the daemon is neither rootless nor with userns-remap.
+// The user namespace can not be set per container by docker.
+func (e dockerEngine) checkUserNS(ctx context.Context) error {
+	info, err := e.Info(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get docker client info")
+	}
+	for _, opt := range info.SecurityOptions {
+		if opt == "name=rootless" || opt == "name=userns" {
+			return nil
+		}
+	}
+	return errors.New("the user namespace requires the rootless docker daemon or the daemon with " +
+		"`userns-remap`, see https://docs.docker.com/engine/security/rootless/")
+}
+
 func (e dockerEngine) CleanEnvdIfExists(ctx context.Context, name string, force bool) error {
 	created, err := e.Exists(ctx, name)
 	if err != nil {
@@ -350,6 +367,7 @@ func (e dockerEngine) StartEnvd(ctx context.Context, so StartOptions) (*StartRes
 		"tag":           so.Image,
 		"environment":   so.EnvironmentName,
 		"gpu":           so.NumGPU,
+		"gpu-devices":   so.GPUDevices,
 		"shm":           so.ShmSize,
 		"cpu":           so.NumCPU,
 		"cpu-set":       so.CPUSet,
@@ -361,7 +379,7 @@ func (e dockerEngine) StartEnvd(ctx context.Context, so StartOptions) (*StartRes
 	defer bar.finish()
 	bar.updateTitle("configure the environment")
 
-	if so.NumGPU != 0 {
+	if so.GPURequested() {
 		nvruntimeExists, err := e.GPUEnabled(ctx)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to check if nvidia-runtime is installed")
@@ -432,12 +450,26 @@ func (e dockerEngine) StartEnvd(ctx context.Context, so StartOptions) (*StartRes
 	for _, m := range g.GetMount() {
 		logger.WithFields(logrus.Fields{
 			"mount-path":     m.Source,
+			"volume":         m.Volume,
 			"container-path": m.Destination,
+			"readonly":       m.ReadOnly,
 		}).Debug("setting up declared mount directory")
+		// the named volume is created by docker if it does not exist, and
+		// it's populated by the content of the image at the first mount
+		if m.Volume != "" {
+			mountOption = append(mountOption, mount.Mount{
+				Type:     mount.TypeVolume,
+				Source:   m.Volume,
+				Target:   m.Destination,
+				ReadOnly: m.ReadOnly,
+			})
+			continue
+		}
 		mountOption = append(mountOption, mount.Mount{
-			Type:   mount.TypeBind,
-			Source: m.Source,
-			Target: m.Destination,
+			Type:     mount.TypeBind,
+			Source:   m.Source,
+			Target:   m.Destination,
+			ReadOnly: m.ReadOnly,
 		})
 	}
 
@@ -542,6 +574,11 @@ func (e dockerEngine) StartEnvd(ctx context.Context, so StartOptions) (*StartRes
 	if len(g.GetExposedPorts()) > 0 {
 
 		for _, item := range g.GetExposedPorts() {
+			natPort := nat.Port(fmt.Sprintf("%d/tcp", item.EnvdPort))
+			config.ExposedPorts[natPort] = struct{}{}
+			if item.ExposeOnly {
+				continue
+			}
 			var err error
 			if item.HostPort == 0 {
 				item.HostPort, err = netutil.GetFreePort()
@@ -549,20 +586,33 @@ func (e dockerEngine) StartEnvd(ctx context.Context, so StartOptions) (*StartRes
 					return nil, errors.Wrap(err, "failed to get a free port")
 				}
 			}
-			natPort := nat.Port(fmt.Sprintf("%d/tcp", item.EnvdPort))
 			hostConfig.PortBindings[natPort] = []nat.PortBinding{
 				{
 					HostIP:   item.ListeningAddr,
 					HostPort: strconv.Itoa(item.HostPort),
 				},
 			}
-			config.ExposedPorts[natPort] = struct{}{}
 		}
 	}
 
-	if so.NumGPU != 0 {
+	if so.GPURequested() {
 		logger.Debug("GPU is enabled.")
-		hostConfig.DeviceRequests = deviceRequests(so.NumGPU)
+		hostConfig.DeviceRequests = deviceRequests(so.NumGPU, so.GPUDevices, so.GPUCapabilities)
+	}
+
+	if so.UserNS {
+		if err := e.checkUserNS(ctx); err != nil {
+			return nil, err
+		}
+		hostConfig.CapDrop = []string{"ALL"}
+		hostConfig.SecurityOpt = []string{"no-new-privileges:true"}
+	}
+
+	if sc := g.GetStopConfig(); sc != nil {
+		// leave some time for horust to terminate the services
+		timeout := sc.GracePeriod + stopTimeoutBuffer
+		config.StopSignal = sc.Signal
+		config.StopTimeout = &timeout
 	}
 
 	config.Labels = e.labels(g, so.EnvironmentName,
@@ -630,6 +680,22 @@ func (e dockerEngine) Destroy(ctx context.Context, name string) (string, error)
 		return "", errors.Wrap(err, "failed to inspect the container")
 	}
 
+	// Stop the container gracefully to run the `runtime.pre_destroy` hooks,
+	// the stop timeout of the container is used.
+	if rg, err := e.ListEnvRuntimeGraph(ctx, name); err == nil && len(rg.RuntimePreDestroy) > 0 {
+		// the processes of the paused container can not handle the signal
+		if ctr.State != nil && ctr.State.Paused {
+			logger.Debug("resuming the paused container to run the pre-destroy hooks")
+			if err := e.ContainerUnpause(ctx, name); err != nil {
+				return "", errors.Wrap(err, "failed to resume the container")
+			}
+		}
+		logger.Debug("stopping the container to run the pre-destroy hooks")
+		if err := e.ContainerStop(ctx, name, nil); err != nil {
+			return "", errors.Wrap(err, "failed to stop the container")
+		}
+	}
+
 	// Refer to https://docs.docker.com/engine/reference/commandline/container_kill/
 	if err := e.ContainerKill(ctx, name, "KILL"); err != nil {
 		errCause := errors.UnwrapAll(err).Error()
@@ -737,21 +803,32 @@ func (e dockerEngine) PruneImage(ctx context.Context) (dockertypes.ImagesPruneRe
 	return pruneReport, nil
 }
 
-func deviceRequests(count int) []container.DeviceRequest {
+// deviceRequests requests the GPUs by the count or the devices. All the
+// capabilities of the driver are enabled if capabilities is empty, the same
+// as `docker run --gpus '"capabilities=compute,utility"'` otherwise.
+func deviceRequests(count int, devices, capabilities []string) []container.DeviceRequest {
+	caps := [][]string{
+		{"gpu"},
+		{"nvidia"},
+		{"compute"},
+		{"compat32"},
+		{"graphics"},
+		{"utility"},
+		{"video"},
+		{"display"},
+	}
+	if len(capabilities) > 0 {
+		caps = [][]string{append([]string{"gpu"}, capabilities...)}
+	}
+	if len(devices) > 0 {
+		count = 0
+	}
 	return []container.DeviceRequest{
 		{
-			Driver: "nvidia",
-			Capabilities: [][]string{
-				{"gpu"},
-				{"nvidia"},
-				{"compute"},
-				{"compat32"},
-				{"graphics"},
-				{"utility"},
-				{"video"},
-				{"display"},
-			},
-			Count: count,
+			Driver:       "nvidia",
+			Capabilities: caps,
+			Count:        count,
+			DeviceIDs:    devices,
 		},
 	}
 }
@@ -277,6 +277,9 @@ func (e *envdServerEngine) StartEnvd(ctx context.Context, so StartOptions) (*Sta
 	if so.EnvdServerSource == nil {
 		return nil, errors.New("failed to get the envd server specific options")
 	}
+	if so.UserNS {
+		return nil, errors.New("the user namespace is not supported for the runner envd-server")
+	}
 
 	req := servertypes.EnvironmentCreateRequest{
 		Environment: servertypes.Environment{
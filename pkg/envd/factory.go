@@ -34,6 +34,13 @@ func New(ctx context.Context, opt Options) (Engine, error) {
 	if opt.Context == nil {
 		return nil, errors.New("failed to get the context")
 	}
+	if opt.Context.Runner == types.RunnerTypeKubernetes {
+		e := &kubernetesEngine{}
+		if opt.Context.RunnerAddress != nil {
+			e.namespace = *opt.Context.RunnerAddress
+		}
+		return e, nil
+	}
 	if opt.Context.Runner == types.RunnerTypeEnvdServer {
 		ac, err := home.GetManager().AuthGetCurrent()
 		if err != nil {
@@ -0,0 +1,681 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	servertypes "github.com/tensorchord/envd-server/api/types"
+
+	envdconfig "github.com/tensorchord/envd/pkg/config"
+	"github.com/tensorchord/envd/pkg/lang/ir"
+	"github.com/tensorchord/envd/pkg/ssh"
+	sshconfig "github.com/tensorchord/envd/pkg/ssh/config"
+	"github.com/tensorchord/envd/pkg/types"
+	"github.com/tensorchord/envd/pkg/util/fileutil"
+	"github.com/tensorchord/envd/pkg/util/netutil"
+)
+
+const (
+	kubernetesGPUResource = "nvidia.com/gpu"
+	kubernetesPodRunning  = "Running"
+	kubernetesWorkdirSize = "10Gi"
+	// kubernetesForwardTimeout is the time to wait for `kubectl port-forward`
+	// to listen on the local ports.
+	kubernetesForwardTimeout = 10 * time.Second
+)
+
+// kubernetesEngine runs the environments as pods in the cluster of the
+// current kubeconfig, it talks to the cluster by kubectl.
+type kubernetesEngine struct {
+	// namespace is the namespace of the pods, the namespace of the
+	// kubeconfig is used if it's empty.
+	namespace string
+}
+
+// kubernetesPod is the subset of the pod object used by envd.
+type kubernetesPod struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Labels            map[string]string `json:"labels"`
+		Annotations       map[string]string `json:"annotations"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []struct {
+			Image string `json:"image"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// kubernetesPortForward is the port forwarded from the host to the pod.
+type kubernetesPortForward struct {
+	Name       string
+	LocalPort  int
+	RemotePort int
+}
+
+func (e kubernetesEngine) kubectl(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	if e.namespace != "" {
+		args = append([]string{"--namespace", e.namespace}, args...)
+	}
+	logrus.WithField("args", args).Debug("running kubectl")
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "failed to run `kubectl %s`: %s",
+			strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// podPhase returns the phase of the pod, it's empty if the pod does not exist.
+func (e kubernetesEngine) podPhase(ctx context.Context, name string) (string, error) {
+	out, err := e.kubectl(ctx, nil, "get", "pod", name,
+		"--ignore-not-found", "-o", "jsonpath={.status.phase}")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get the pod %s", name)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (e kubernetesEngine) ListImage(ctx context.Context) ([]types.EnvdImage, error) {
+	return nil, errors.New("listing images is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) ListImageDependency(ctx context.Context, image string) (*types.Dependency, error) {
+	return nil, errors.New("listing image dependencies is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) GetImage(ctx context.Context, image string) (types.EnvdImage, error) {
+	return types.EnvdImage{}, errors.New("getting images is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) PruneImage(ctx context.Context) (dockertypes.ImagesPruneReport, error) {
+	return dockertypes.ImagesPruneReport{}, errors.New("pruning images is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) GetInfo(ctx context.Context) (*types.EnvdInfo, error) {
+	return nil, errors.New("not implemented for the runner k8s")
+}
+
+// GPUEnabled checks if any node in the cluster has the allocatable GPUs.
+func (e kubernetesEngine) GPUEnabled(ctx context.Context) (bool, error) {
+	out, err := e.kubectl(ctx, nil, "get", "nodes", "-o",
+		`jsonpath={.items[*].status.allocatable.nvidia\.com/gpu}`)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get the nodes")
+	}
+	for _, s := range strings.Fields(string(out)) {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e kubernetesEngine) PauseEnvironment(ctx context.Context, env string) (string, error) {
+	return "", errors.New("pausing/resuming environments is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) ResumeEnvironment(ctx context.Context, env string) (string, error) {
+	return "", errors.New("pausing/resuming environments is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) GetEnvironment(ctx context.Context, env string) (*types.EnvdEnvironment, error) {
+	out, err := e.kubectl(ctx, nil, "get", "pod", env, "-o", "json")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get environment: %s", env)
+	}
+	var pod kubernetesPod
+	if err := json.Unmarshal(out, &pod); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the pod: %s", env)
+	}
+	return newEnvironmentFromPod(pod)
+}
+
+func (e kubernetesEngine) ListEnvironment(ctx context.Context) ([]types.EnvdEnvironment, error) {
+	out, err := e.kubectl(ctx, nil, "get", "pods", "-l", types.ContainerLabelName, "-o", "json")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the pods")
+	}
+	var pods struct {
+		Items []kubernetesPod `json:"items"`
+	}
+	if err := json.Unmarshal(out, &pods); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the pods")
+	}
+	res := []types.EnvdEnvironment{}
+	for _, pod := range pods.Items {
+		env, err := newEnvironmentFromPod(pod)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *env)
+	}
+	return res, nil
+}
+
+func newEnvironmentFromPod(pod kubernetesPod) (*types.EnvdEnvironment, error) {
+	env := servertypes.Environment{
+		ObjectMeta: servertypes.ObjectMeta{
+			Name:        pod.Metadata.Labels[types.ContainerLabelName],
+			Labels:      pod.Metadata.Labels,
+			Annotations: pod.Metadata.Annotations,
+		},
+		Status:    servertypes.EnvironmentStatus{Phase: pod.Status.Phase},
+		CreatedAt: pod.Metadata.CreationTimestamp.Unix(),
+	}
+	if len(pod.Spec.Containers) > 0 {
+		env.Spec.Image = pod.Spec.Containers[0].Image
+	}
+	if addr, ok := pod.Metadata.Annotations[types.ContainerLabelJupyterAddr]; ok {
+		env.Status.JupyterAddr = &addr
+	}
+	if addr, ok := pod.Metadata.Annotations[types.ContainerLabelRStudioServerAddr]; ok {
+		env.Status.RStudioServerAddr = &addr
+	}
+	res, err := types.NewEnvironmentFromServer(env)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create env from the pod: %s", pod.Metadata.Name)
+	}
+	return res, nil
+}
+
+func (e kubernetesEngine) ListEnvRuntimeGraph(ctx context.Context, env string) (*ir.RuntimeGraph, error) {
+	return nil, errors.New("getting the runtime graph is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) ListEnvDependency(ctx context.Context, env string) (*types.Dependency, error) {
+	return nil, errors.New("listing env dependencies is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) ListEnvPortBinding(ctx context.Context, env string) ([]types.PortBinding, error) {
+	return nil, errors.New("listing env port bindings is not supported for the runner k8s")
+}
+
+func (e kubernetesEngine) CleanEnvdIfExists(ctx context.Context, name string, force bool) error {
+	created, err := e.Exists(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return nil
+	}
+	args := []string{"delete", "pod", name, "--wait"}
+	if force {
+		args = append(args, "--grace-period=0", "--force")
+	}
+	_, err = e.kubectl(ctx, nil, args...)
+	return err
+}
+
+func (e kubernetesEngine) Exists(ctx context.Context, name string) (bool, error) {
+	phase, err := e.podPhase(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return phase != "", nil
+}
+
+func (e kubernetesEngine) IsRunning(ctx context.Context, name string) (bool, error) {
+	phase, err := e.podPhase(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return phase == kubernetesPodRunning, nil
+}
+
+func (e kubernetesEngine) WaitUntilRunning(ctx context.Context,
+	name string, timeout time.Duration) error {
+	logger := logrus.WithField("pod", name)
+	logger.Debug("waiting to start")
+
+	ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		select {
+		case <-time.After(waitingInterval):
+			phase, err := e.podPhase(ctxTimeout, name)
+			if err != nil {
+				return errors.Wrap(err, "failed to check if the pod is running")
+			}
+			switch phase {
+			case kubernetesPodRunning:
+				logger.Debug("the pod is running")
+				return nil
+			case "Failed", "Succeeded":
+				return errors.Newf("the pod %s exited with phase %s", name, phase)
+			}
+		case <-ctxTimeout.Done():
+			return errors.Errorf("timeout %s: pod did not start", timeout)
+		}
+	}
+}
+
+// Destroy deletes the pod, the volume claim of the working directory is kept
+// for the next `envd up`.
+func (e kubernetesEngine) Destroy(ctx context.Context, name string) (string, error) {
+	created, err := e.Exists(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		logrus.Infof("cannot find pod %s, maybe it's already destroyed or the name is wrong", name)
+		return "", nil
+	}
+	if _, err := e.kubectl(ctx, nil, "delete", "pod", name, "--wait"); err != nil {
+		return "", errors.Wrap(err, "failed to delete the pod")
+	}
+	logrus.Infof("the volume claim %s is kept, delete it by `kubectl delete pvc %s`",
+		kubernetesWorkdirClaim(name), kubernetesWorkdirClaim(name))
+	return name, nil
+}
+
+func (e kubernetesEngine) GenerateSSHConfig(name, iface, privateKeyPath string,
+	startResult *StartResult) (sshconfig.EntryOptions, error) {
+	eo := sshconfig.EntryOptions{
+		Name:               name,
+		IFace:              iface,
+		Port:               startResult.SSHPort,
+		PrivateKeyPath:     privateKeyPath,
+		EnableHostKeyCheck: false,
+		EnableAgentForward: true,
+	}
+	return eo, nil
+}
+
+func (e kubernetesEngine) Attach(name, iface, privateKeyPath string,
+	startResult *StartResult, g ir.Graph) error {
+	opt := ssh.DefaultOptions()
+	opt.Server = iface
+	opt.PrivateKeyPath = privateKeyPath
+	opt.Port = startResult.SSHPort
+	sshClient, err := ssh.NewClient(opt)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the ssh client")
+	}
+
+	if err := sshClient.Attach(); err != nil {
+		return errors.Wrap(err, "failed to attach to the pod")
+	}
+	return nil
+}
+
+// StartEnvd creates the pod for the given tag and environment name, and
+// forwards the SSH and service ports to the host by `kubectl port-forward`.
+func (e kubernetesEngine) StartEnvd(ctx context.Context, so StartOptions) (*StartResult, error) {
+	logger := logrus.WithFields(logrus.Fields{
+		"tag":           so.Image,
+		"environment":   so.EnvironmentName,
+		"namespace":     e.namespace,
+		"gpu":           so.NumGPU,
+		"shm":           so.ShmSize,
+		"cpu":           so.NumCPU,
+		"memory":        so.NumMem,
+		"build-context": so.BuildContext,
+	})
+
+	bar := InitProgressBar(6)
+	defer bar.finish()
+	bar.updateTitle("configure the environment")
+
+	if so.DockerSource == nil || so.DockerSource.Graph == nil {
+		return nil, errors.New("failed to get the graph of the environment")
+	}
+	g := so.DockerSource.Graph
+	if len(so.DockerSource.MountOptions) > 0 || len(g.GetMount()) > 0 {
+		logger.Warn("the host directories and volumes cannot be mounted into the pod, the mounts are skipped")
+	}
+	if len(so.CPUSet) > 0 {
+		logger.Warn("`--cpu-set` is not supported for the runner k8s, it's ignored")
+	}
+	if len(so.GPUDevices) > 0 || len(so.GPUCapabilities) > 0 {
+		// the devices are scheduled by the device plugin
+		logger.Warn("the GPU devices and capabilities are not supported for the runner k8s, " +
+			"the number of the devices is requested instead")
+		if len(so.GPUDevices) > 0 {
+			so.NumGPU = len(so.GPUDevices)
+		}
+	}
+	if so.NumGPU < 0 {
+		return nil, errors.New("all the GPUs can not be requested for the runner k8s, set the count instead")
+	}
+
+	if so.NumGPU != 0 {
+		gpuEnabled, err := e.GPUEnabled(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to check if the cluster has GPUs")
+		}
+		if !gpuEnabled {
+			return nil, errors.Newf("GPU is required but no node in the cluster has the allocatable %s", kubernetesGPUResource)
+		}
+	}
+
+	forwards, err := kubernetesPortForwards(g)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.CleanEnvdIfExists(ctx, so.EnvironmentName, so.Forced); err != nil {
+		return nil, errors.Wrap(err, "failed to clean the envd environment")
+	}
+
+	manifest, err := kubernetesManifest(so, g, forwards)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("creating pod %s", so.EnvironmentName)
+
+	bar.updateTitle("create the environment")
+	if _, err := e.kubectl(ctx, manifest, "apply", "-f", "-"); err != nil {
+		return nil, errors.Wrap(err, "failed to create the pod")
+	}
+
+	bar.updateTitle("wait for the environment to start")
+	if err := e.WaitUntilRunning(ctx, so.EnvironmentName, so.Timeout); err != nil {
+		return nil, errors.Wrap(err, "failed to wait until the pod is running")
+	}
+
+	bar.updateTitle("sync the working directory")
+	if err := e.syncWorkdir(ctx, so.EnvironmentName, so.BuildContext, kubernetesWorkdir(so)); err != nil {
+		return nil, err
+	}
+
+	bar.updateTitle("forward the ports")
+	if err := e.portForward(so.EnvironmentName, so.SshdHost, forwards); err != nil {
+		return nil, err
+	}
+
+	bar.updateTitle("attach the environment")
+	return &StartResult{
+		SSHPort: forwards[0].LocalPort,
+		Address: so.SshdHost,
+		Name:    so.EnvironmentName,
+	}, nil
+}
+
+// syncWorkdir copies the build context into the volume of the working
+// directory, which is empty when the claim is created. The volume is kept
+// after the pod is deleted, thus it's never overwritten once it has files.
+func (e kubernetesEngine) syncWorkdir(ctx context.Context, name, buildContext, workdir string) error {
+	out, err := e.kubectl(ctx, nil, "exec", name, "--", "ls", "-A", workdir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list the working directory %s", workdir)
+	}
+	if len(strings.TrimSpace(string(out))) > 0 {
+		logrus.Debugf("the working directory %s is not empty, the build context is not copied", workdir)
+		return nil
+	}
+	if _, err := e.kubectl(ctx, nil, "cp", buildContext, name+":"+workdir); err != nil {
+		return errors.Wrapf(err, "failed to copy the build context to %s", workdir)
+	}
+	return nil
+}
+
+// portForward starts `kubectl port-forward` in the background, it keeps
+// running after envd exits and stops when the pod is deleted.
+func (e kubernetesEngine) portForward(name, address string, forwards []kubernetesPortForward) error {
+	args := []string{"port-forward", "--address", address, "pod/" + name}
+	if e.namespace != "" {
+		args = append([]string{"--namespace", e.namespace}, args...)
+	}
+	for _, f := range forwards {
+		args = append(args, fmt.Sprintf("%d:%d", f.LocalPort, f.RemotePort))
+	}
+	logrus.WithField("args", args).Debug("forwarding the ports of the pod")
+	cmd := exec.Command("kubectl", args...)
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to run `kubectl port-forward`")
+	}
+	if err := cmd.Process.Release(); err != nil {
+		return errors.Wrap(err, "failed to release `kubectl port-forward`")
+	}
+
+	sshAddress := net.JoinHostPort(address, strconv.Itoa(forwards[0].LocalPort))
+	deadline := time.Now().Add(kubernetesForwardTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", sshAddress, waitingInterval)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.Wrapf(err, "timeout %s: the port of the pod is not forwarded to %s",
+				kubernetesForwardTimeout, sshAddress)
+		}
+		time.Sleep(waitingInterval)
+	}
+	for _, f := range forwards[1:] {
+		logrus.Infof("service \"%s\" is listening at %s:%d", f.Name, address, f.LocalPort)
+	}
+	return nil
+}
+
+// kubernetesPortForwards returns the ports to forward, the SSH port is always
+// the first one.
+func kubernetesPortForwards(g ir.Graph) ([]kubernetesPortForward, error) {
+	freePort := func(port int) (int, error) {
+		if port != 0 {
+			return port, nil
+		}
+		port, err := netutil.GetFreePort()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get a free port")
+		}
+		return port, nil
+	}
+
+	var forwards []kubernetesPortForward
+	add := func(name string, localPort, remotePort int) error {
+		port, err := freePort(localPort)
+		if err != nil {
+			return err
+		}
+		forwards = append(forwards, kubernetesPortForward{
+			Name:       name,
+			LocalPort:  port,
+			RemotePort: remotePort,
+		})
+		return nil
+	}
+	if err := add("ssh", 0, envdconfig.SSHPortInContainer); err != nil {
+		return nil, err
+	}
+	if jc := g.GetJupyterConfig(); jc != nil {
+		if err := add("jupyter", int(jc.Port), envdconfig.JupyterPortInContainer); err != nil {
+			return nil, err
+		}
+	}
+	if g.GetRStudioServerConfig() != nil {
+		if err := add("rstudio-server", 0, envdconfig.RStudioServerPortInContainer); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range g.GetExposedPorts() {
+		if item.ExposeOnly {
+			continue
+		}
+		if err := add(item.ServiceName, item.HostPort, item.EnvdPort); err != nil {
+			return nil, err
+		}
+	}
+	return forwards, nil
+}
+
+func kubernetesWorkdirClaim(name string) string {
+	return name + "-workdir"
+}
+
+// kubernetesWorkdir returns the working directory of the environment, where
+// the volume claim is mounted.
+func kubernetesWorkdir(so StartOptions) string {
+	return fileutil.EnvdHomeDir(filepath.Base(so.BuildContext))
+}
+
+// kubernetesManifest returns the list of the volume claim for the working
+// directory and the pod of the environment.
+func kubernetesManifest(so StartOptions, g ir.Graph,
+	forwards []kubernetesPortForward) ([]byte, error) {
+	workdir := kubernetesWorkdir(so)
+	labels := map[string]string{
+		types.ContainerLabelName: so.EnvironmentName,
+	}
+	annotations := map[string]string{
+		types.ContainerLabelSSHPort: strconv.Itoa(forwards[0].LocalPort),
+	}
+
+	var ports []map[string]interface{}
+	for _, f := range forwards {
+		ports = append(ports, map[string]interface{}{
+			"containerPort": f.RemotePort,
+			"protocol":      "TCP",
+		})
+		switch f.RemotePort {
+		case envdconfig.JupyterPortInContainer:
+			if g.GetJupyterConfig() != nil {
+				annotations[types.ContainerLabelJupyterAddr] =
+					fmt.Sprintf("http://%s:%d", Localhost, f.LocalPort)
+			}
+		case envdconfig.RStudioServerPortInContainer:
+			if g.GetRStudioServerConfig() != nil {
+				annotations[types.ContainerLabelRStudioServerAddr] =
+					fmt.Sprintf("http://%s:%d", Localhost, f.LocalPort)
+			}
+		}
+	}
+
+	limits := map[string]string{}
+	if len(so.NumCPU) > 0 {
+		limits["cpu"] = so.NumCPU
+	}
+	if len(so.NumMem) > 0 {
+		limits["memory"] = so.NumMem
+	}
+	if so.NumGPU != 0 {
+		limits[kubernetesGPUResource] = strconv.Itoa(so.NumGPU)
+	}
+
+	volumeMounts := []map[string]interface{}{
+		{"name": "workdir", "mountPath": workdir},
+	}
+	volumes := []map[string]interface{}{
+		{
+			"name": "workdir",
+			"persistentVolumeClaim": map[string]string{
+				"claimName": kubernetesWorkdirClaim(so.EnvironmentName),
+			},
+		},
+	}
+	if so.ShmSize > 0 {
+		volumeMounts = append(volumeMounts, map[string]interface{}{
+			"name": "shm", "mountPath": "/dev/shm",
+		})
+		volumes = append(volumes, map[string]interface{}{
+			"name": "shm",
+			"emptyDir": map[string]string{
+				"medium":    "Memory",
+				"sizeLimit": fmt.Sprintf("%dMi", so.ShmSize),
+			},
+		})
+	}
+
+	container := map[string]interface{}{
+		"name":            "envd",
+		"image":           so.Image,
+		"imagePullPolicy": "Always",
+		"workingDir":      workdir,
+		"ports":           ports,
+		"volumeMounts":    volumeMounts,
+	}
+	if len(limits) > 0 {
+		// the requests default to the limits
+		container["resources"] = map[string]interface{}{"limits": limits}
+	}
+	if so.UserNS {
+		container["securityContext"] = map[string]interface{}{
+			"allowPrivilegeEscalation": false,
+			"capabilities":             map[string]interface{}{"drop": []string{"ALL"}},
+		}
+	}
+
+	spec := map[string]interface{}{
+		"containers": []interface{}{container},
+		"volumes":    volumes,
+	}
+	if so.UserNS {
+		// it requires the support of the user namespaces by the cluster
+		spec["hostUsers"] = false
+	}
+	if sc := g.GetStopConfig(); sc != nil {
+		// leave some time for horust to terminate the services
+		spec["terminationGracePeriodSeconds"] = sc.GracePeriod + stopTimeoutBuffer
+	}
+	pod := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":        so.EnvironmentName,
+			"labels":      labels,
+			"annotations": annotations,
+		},
+		"spec": spec,
+	}
+
+	claim := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata": map[string]interface{}{
+			"name":   kubernetesWorkdirClaim(so.EnvironmentName),
+			"labels": labels,
+		},
+		"spec": map[string]interface{}{
+			"accessModes": []string{"ReadWriteOnce"},
+			"resources": map[string]interface{}{
+				"requests": map[string]string{"storage": kubernetesWorkdirSize},
+			},
+		},
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      []interface{}{claim, pod},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the pod manifest")
+	}
+	return manifest, nil
+}
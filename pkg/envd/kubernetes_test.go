@@ -0,0 +1,174 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envd
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	envdconfig "github.com/tensorchord/envd/pkg/config"
+	v1 "github.com/tensorchord/envd/pkg/lang/ir/v1"
+)
+
+func TestKubernetesPortForwards(t *testing.T) {
+	defer func() { v1.DefaultGraph = v1.NewGraph() }()
+
+	tcs := []struct {
+		name     string
+		setup    func() error
+		expected []kubernetesPortForward
+	}{
+		{
+			name:     "ssh only",
+			setup:    func() error { return nil },
+			expected: []kubernetesPortForward{{Name: "ssh", RemotePort: envdconfig.SSHPortInContainer}},
+		},
+		{
+			name:  "jupyter with the host port",
+			setup: func() error { return v1.Jupyter("", 9999) },
+			expected: []kubernetesPortForward{
+				{Name: "ssh", RemotePort: envdconfig.SSHPortInContainer},
+				{Name: "jupyter", LocalPort: 9999, RemotePort: envdconfig.JupyterPortInContainer},
+			},
+		},
+		{
+			name:  "rstudio server",
+			setup: v1.RStudioServer,
+			expected: []kubernetesPortForward{
+				{Name: "ssh", RemotePort: envdconfig.SSHPortInContainer},
+				{Name: "rstudio-server", RemotePort: envdconfig.RStudioServerPortInContainer},
+			},
+		},
+		{
+			name: "exposed ports",
+			setup: func() error {
+				if err := v1.RuntimeExpose(8000, 18000, "api", "", true); err != nil {
+					return err
+				}
+				return v1.RuntimeExpose(9000, 0, "metrics", "", false)
+			},
+			expected: []kubernetesPortForward{
+				{Name: "ssh", RemotePort: envdconfig.SSHPortInContainer},
+				{Name: "api", LocalPort: 18000, RemotePort: 8000},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v1.DefaultGraph = v1.NewGraph()
+			if err := tc.setup(); err != nil {
+				t.Fatalf("failed to set up the graph: %v", err)
+			}
+			forwards, err := kubernetesPortForwards(v1.DefaultGraph)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(forwards) != len(tc.expected) {
+				t.Fatalf("expected %d forwards, got %v", len(tc.expected), forwards)
+			}
+			for i, f := range forwards {
+				e := tc.expected[i]
+				if f.LocalPort == 0 {
+					t.Errorf("the local port of %s is not allocated", f.Name)
+				}
+				if e.LocalPort == 0 {
+					// the free port is allocated
+					e.LocalPort = f.LocalPort
+				}
+				if f != e {
+					t.Errorf("forward %d: expected %+v, got %+v", i, e, f)
+				}
+			}
+		})
+	}
+}
+
+func TestKubernetesManifest(t *testing.T) {
+	forwards := []kubernetesPortForward{
+		{Name: "ssh", LocalPort: 2022, RemotePort: envdconfig.SSHPortInContainer},
+	}
+	tcs := []struct {
+		name            string
+		so              StartOptions
+		limits          map[string]interface{}
+		securityContext map[string]interface{}
+		hostUsers       interface{}
+	}{
+		{
+			name: "default",
+			so:   StartOptions{},
+		},
+		{
+			name:   "gpus",
+			so:     StartOptions{NumGPU: 2},
+			limits: map[string]interface{}{kubernetesGPUResource: "2"},
+		},
+		{
+			name:   "limits",
+			so:     StartOptions{NumCPU: "2", NumMem: "4Gi", NumGPU: 1},
+			limits: map[string]interface{}{"cpu": "2", "memory": "4Gi", kubernetesGPUResource: "1"},
+		},
+		{
+			name: "user namespace",
+			so:   StartOptions{UserNS: true},
+			securityContext: map[string]interface{}{
+				"allowPrivilegeEscalation": false,
+				"capabilities":             map[string]interface{}{"drop": []interface{}{"ALL"}},
+			},
+			hostUsers: false,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			so := tc.so
+			so.Image = "envd-test:dev"
+			so.EnvironmentName = "envd-test"
+			so.BuildContext = "/home/user/project"
+			manifest, err := kubernetesManifest(so, v1.NewGraph(), forwards)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var list struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			if err := json.Unmarshal(manifest, &list); err != nil {
+				t.Fatalf("failed to parse the manifest: %v", err)
+			}
+			if len(list.Items) != 2 || list.Items[0]["kind"] != "PersistentVolumeClaim" || list.Items[1]["kind"] != "Pod" {
+				t.Fatalf("unexpected items of the manifest: %s", manifest)
+			}
+			spec := list.Items[1]["spec"].(map[string]interface{})
+			container := spec["containers"].([]interface{})[0].(map[string]interface{})
+			if container["workingDir"] != "/home/envd/project" {
+				t.Errorf("unexpected working dir: %v", container["workingDir"])
+			}
+			var limits map[string]interface{}
+			if resources, ok := container["resources"].(map[string]interface{}); ok {
+				limits = resources["limits"].(map[string]interface{})
+			}
+			if !reflect.DeepEqual(limits, tc.limits) {
+				t.Errorf("expected the limits %v, got %v", tc.limits, limits)
+			}
+			securityContext, _ := container["securityContext"].(map[string]interface{})
+			if !reflect.DeepEqual(securityContext, tc.securityContext) {
+				t.Errorf("expected the security context %v, got %v", tc.securityContext, securityContext)
+			}
+			if spec["hostUsers"] != tc.hostUsers {
+				t.Errorf("expected hostUsers %v, got %v", tc.hostUsers, spec["hostUsers"])
+			}
+		})
+	}
+}
@@ -28,6 +28,8 @@ import (
 
 const (
 	Localhost = "127.0.0.1"
+	// stopTimeoutBuffer is the extra seconds before the container is killed
+	stopTimeoutBuffer = 5
 )
 
 var (
@@ -39,6 +41,11 @@ type StartOptions struct {
 	EnvironmentName string
 	BuildContext    string
 	NumGPU          int
+	// GPUDevices are the GPUs attached instead of NumGPU, by the indexes or
+	// the UUIDs of the GPUs or the MIG devices
+	GPUDevices []string
+	// GPUCapabilities are the capabilities of the driver, all if empty
+	GPUCapabilities []string
 	NumCPU          string
 	CPUSet          string
 	NumMem          string
@@ -46,10 +53,18 @@ type StartOptions struct {
 	ShmSize         int
 	Forced          bool
 	SshdHost        string
+	// UserNS runs the environment in the user namespace, with all the
+	// capabilities dropped and the privilege escalation disabled
+	UserNS bool
 
 	EngineSource
 }
 
+// GPURequested returns true if the GPUs are attached by the count or the devices.
+func (so StartOptions) GPURequested() bool {
+	return so.NumGPU != 0 || len(so.GPUDevices) > 0
+}
+
 type EngineSource struct {
 	DockerSource     *DockerSource
 	EnvdServerSource *EnvdServerSource
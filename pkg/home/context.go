@@ -104,8 +104,12 @@ func (m *generalManager) ContextCreate(ctx types.Context, use bool) error {
 	default:
 		return errors.New("unknown builder type")
 	}
+	if ctx.BuilderRootless && ctx.Builder != types.BuilderTypeDocker && ctx.Builder != types.BuilderTypeNerdctl {
+		return errors.Newf("the rootless builder is only supported for %s and %s",
+			types.BuilderTypeDocker, types.BuilderTypeNerdctl)
+	}
 	switch ctx.Runner {
-	case types.RunnerTypeDocker, types.RunnerTypeEnvdServer:
+	case types.RunnerTypeDocker, types.RunnerTypeEnvdServer, types.RunnerTypeKubernetes:
 		break
 	default:
 		return errors.New("unknown runner type")
@@ -93,4 +93,12 @@ var _ = Describe("context test", func() {
 			Expect(GetManager().ContextRemove(testContext)).To(Succeed())
 		})
 	})
+
+	Describe("create with the rootless builder", func() {
+		It("should reject the rootless builder for tcp", func() {
+			rootless := c
+			rootless.BuilderRootless = true
+			Expect(GetManager().ContextCreate(rootless, false)).NotTo(Succeed())
+		})
+	})
 })
@@ -15,11 +15,17 @@
 package config
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
 	"github.com/cockroachdb/errors"
+	"github.com/pelletier/go-toml/v2"
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 
+	"github.com/tensorchord/envd/pkg/lang/frontend/starlark/v1/builtin"
 	ir "github.com/tensorchord/envd/pkg/lang/ir/v1"
 	"github.com/tensorchord/envd/pkg/util/starlarkutil"
 )
@@ -42,10 +48,53 @@ var Module = &starlarkstruct.Module{
 			ruleCondaChannel, ruleFuncCondaChannel),
 		"julia_pkg_server": starlark.NewBuiltin(
 			ruleJuliaPackageServer, ruleFuncJuliaPackageServer),
+		"julia": starlark.NewBuiltin(ruleJulia, ruleFuncJulia),
+		"julia_registry": starlark.NewBuiltin(
+			ruleJuliaRegistry, ruleFuncJuliaRegistry),
+		"julia_offline": starlark.NewBuiltin(
+			ruleJuliaOffline, ruleFuncJuliaOffline),
 		"rstudio_server": starlark.NewBuiltin(ruleRStudioServer, ruleFuncRStudioServer),
 		"entrypoint":     starlark.NewBuiltin(ruleEntrypoint, ruleFuncEntrypoint),
 		"repo":           starlark.NewBuiltin(ruleRepo, ruleFuncRepo),
 		"owner":          starlark.NewBuiltin(ruleOwner, ruleFuncOwner),
+		"stop_signal":    starlark.NewBuiltin(ruleStopSignal, ruleFuncStopSignal),
+		"cache_dir":      starlark.NewBuiltin(ruleCacheDir, ruleFuncCacheDir),
+		"shell_completion": starlark.NewBuiltin(
+			ruleShellCompletion, ruleFuncShellCompletion),
+		"trusted_cert": starlark.NewBuiltin(ruleTrustedCert, ruleFuncTrustedCert),
+		"build_worker": starlark.NewBuiltin(ruleBuildWorker, ruleFuncBuildWorker),
+		"metadata":     starlark.NewBuiltin(ruleMetadata, ruleFuncMetadata),
+		"slim_runtime": starlark.NewBuiltin(ruleSlimRuntime, ruleFuncSlimRuntime),
+		"feature":      starlark.NewBuiltin(ruleFeature, ruleFuncFeature),
+		"attestation":  starlark.NewBuiltin(ruleAttestation, ruleFuncAttestation),
+		"julia_runtime": starlark.NewBuiltin(
+			ruleJuliaRuntime, ruleFuncJuliaRuntime),
+		"tmux": starlark.NewBuiltin(ruleTmux, ruleFuncTmux),
+		"init_process": starlark.NewBuiltin(
+			ruleInitProcess, ruleFuncInitProcess),
+		"julia_failure_hook": starlark.NewBuiltin(
+			ruleJuliaFailureHook, ruleFuncJuliaFailureHook),
+		"julia_repl": starlark.NewBuiltin(ruleJuliaREPL, ruleFuncJuliaREPL),
+		"vscode_settings": starlark.NewBuiltin(
+			ruleVSCodeSettings, ruleFuncVSCodeSettings),
+		"julia_preferences": starlark.NewBuiltin(
+			ruleJuliaPreferences, ruleFuncJuliaPreferences),
+		"image_compression": starlark.NewBuiltin(
+			ruleImageCompression, ruleFuncImageCompression),
+		"user":  starlark.NewBuiltin(ruleUser, ruleFuncUser),
+		"group": starlark.NewBuiltin(ruleGroup, ruleFuncGroup),
+		"output": starlark.NewBuiltin(
+			ruleOutput, ruleFuncOutput),
+		"julia_artifact_overrides": starlark.NewBuiltin(
+			ruleJuliaArtifacts, ruleFuncJuliaArtifacts),
+		"julia_build_log": starlark.NewBuiltin(
+			ruleJuliaBuildLog, ruleFuncJuliaBuildLog),
+		"platform": starlark.NewBuiltin(
+			rulePlatform, ruleFuncPlatform),
+		"proxy":   starlark.NewBuiltin(ruleProxy, ruleFuncProxy),
+		"ca_cert": starlark.NewBuiltin(ruleCACert, ruleFuncCACert),
+		"build_limits": starlark.NewBuiltin(
+			ruleBuildLimits, ruleFuncBuildLimits),
 	},
 }
 
@@ -151,6 +200,58 @@ func ruleFuncJuliaPackageServer(thread *starlark.Thread, _ *starlark.Builtin,
 	return starlark.None, nil
 }
 
+func ruleFuncJulia(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pkgServer, binaryMirror string
+
+	if err := starlark.UnpackArgs(ruleJulia, args, kwargs,
+		"pkg_server?", &pkgServer, "binary_mirror?", &binaryMirror); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, pkg_server=%s, binary_mirror=%s", ruleJulia, pkgServer, binaryMirror)
+	if err := ir.JuliaMirror(pkgServer, binaryMirror); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaRegistry(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url, tokenEnv starlark.String
+
+	if err := starlark.UnpackArgs(ruleJuliaRegistry, args, kwargs,
+		"url", &url, "token_env?", &tokenEnv); err != nil {
+		return nil, err
+	}
+
+	urlStr := url.GoString()
+	tokenEnvStr := tokenEnv.GoString()
+
+	logger.Debugf("rule `%s` is invoked, url=%s, token_env=%s",
+		ruleJuliaRegistry, urlStr, tokenEnvStr)
+	if err := ir.JuliaRegistry(urlStr, tokenEnvStr); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaOffline(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var releaseMirror string
+
+	if err := starlark.UnpackArgs(ruleJuliaOffline, args, kwargs,
+		"release_mirror?", &releaseMirror); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, release_mirror=%s", ruleJuliaOffline, releaseMirror)
+	if err := ir.JuliaOffline(releaseMirror); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
 func ruleFuncUbuntuAptSource(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var source starlark.String
@@ -246,3 +347,527 @@ func ruleFuncOwner(thread *starlark.Thread, _ *starlark.Builtin,
 	ir.Owner(uid, gid)
 	return starlark.None, nil
 }
+
+func ruleFuncStopSignal(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		signal      = "SIGTERM"
+		gracePeriod = 5
+	)
+
+	if err := starlark.UnpackArgs(ruleStopSignal, args, kwargs,
+		"signal?", &signal, "grace_period?", &gracePeriod); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, signal=%s, grace_period=%d",
+		ruleStopSignal, signal, gracePeriod)
+	if err := ir.StopSignal(signal, gracePeriod); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncCacheDir(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+
+	if err := starlark.UnpackArgs(ruleCacheDir, args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, path=%s", ruleCacheDir, path)
+	if err := ir.LanguageCacheDir(path); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncShellCompletion(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pip, starship bool
+
+	if err := starlark.UnpackArgs(ruleShellCompletion, args, kwargs,
+		"pip?", &pip, "starship?", &starship); err != nil {
+		return nil, err
+	}
+
+	tools := []string{}
+	if pip {
+		tools = append(tools, "pip")
+	}
+	if starship {
+		tools = append(tools, "starship")
+	}
+	logger.Debugf("rule `%s` is invoked, tools=%v", ruleShellCompletion, tools)
+	if err := ir.ShellCompletion(tools); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncTrustedCert(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var language, url, cert string
+
+	if err := starlark.UnpackArgs(ruleTrustedCert, args, kwargs,
+		"language", &language, "cert", &cert, "url?", &url); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, language=%s, url=%s, cert=%s",
+		ruleTrustedCert, language, url, cert)
+	if err := ir.TrustedCert(language, url, cert); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncProxy(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var httpProxy, httpsProxy, noProxy string
+
+	if err := starlark.UnpackArgs(ruleProxy, args, kwargs,
+		"http_proxy?", &httpProxy, "https_proxy?", &httpsProxy, "no_proxy?", &noProxy); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, http_proxy=%s, https_proxy=%s, no_proxy=%s",
+		ruleProxy, httpProxy, httpsProxy, noProxy)
+	if err := ir.Proxy(httpProxy, httpsProxy, noProxy); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncCACert(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+
+	if err := starlark.UnpackArgs(ruleCACert, args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, path=%s", ruleCACert, path)
+	if err := ir.CACert(path); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncBuildWorker(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var constraints, gpuConstraints *starlark.List
+
+	if err := starlark.UnpackArgs(ruleBuildWorker, args, kwargs,
+		"constraints?", &constraints, "gpu_constraints?", &gpuConstraints); err != nil {
+		return nil, err
+	}
+
+	constraintList, err := starlarkutil.ToStringSlice(constraints)
+	if err != nil {
+		return nil, err
+	}
+	gpuConstraintList, err := starlarkutil.ToStringSlice(gpuConstraints)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, constraints=%v, gpu_constraints=%v",
+		ruleBuildWorker, constraintList, gpuConstraintList)
+	if err := ir.BuildWorker(constraintList, gpuConstraintList); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncMetadata(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var author, maintainer, license string
+
+	if err := starlark.UnpackArgs(ruleMetadata, args, kwargs,
+		"author?", &author, "maintainer?", &maintainer, "license?", &license); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, author=%s, maintainer=%s, license=%s",
+		ruleMetadata, author, maintainer, license)
+	if err := ir.Metadata(author, maintainer, license); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncSlimRuntime(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	logger.Debugf("rule `%s` is invoked", ruleSlimRuntime)
+	ir.SlimRuntime()
+	return starlark.None, nil
+}
+
+func ruleFuncFeature(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	enabled := true
+
+	if err := starlark.UnpackArgs(ruleFeature, args, kwargs,
+		"name", &name, "enabled?", &enabled); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, name=%s, enabled=%t", ruleFeature, name, enabled)
+	ir.Feature(name, enabled)
+	return starlark.None, nil
+}
+
+func ruleFuncAttestation(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	output := "attestation.intoto.json"
+	var signingKey string
+
+	if err := starlark.UnpackArgs(ruleAttestation, args, kwargs,
+		"output?", &output, "signing_key?", &signingKey); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, output=%s, signing_key=%s",
+		ruleAttestation, output, signingKey)
+	if err := ir.Attestation(output, signingKey); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncImageCompression(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var algorithm string
+	level := -1
+
+	if err := starlark.UnpackArgs(ruleImageCompression, args, kwargs,
+		"algorithm", &algorithm, "level?", &level); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, algorithm=%s, level=%d",
+		ruleImageCompression, algorithm, level)
+	if err := ir.ImageCompression(algorithm, level); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncUser(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, group string
+	var uid int
+	var groups, directories *starlark.List
+
+	if err := starlark.UnpackArgs(ruleUser, args, kwargs,
+		"name", &name, "uid", &uid, "group?", &group,
+		"groups?", &groups, "directories?", &directories); err != nil {
+		return nil, err
+	}
+
+	groupList, err := starlarkutil.ToStringSlice(groups)
+	if err != nil {
+		return nil, err
+	}
+	dirList, err := starlarkutil.ToStringSlice(directories)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, name=%s, uid=%d, group=%s, groups=%v, directories=%v",
+		ruleUser, name, uid, group, groupList, dirList)
+	if err := ir.User(name, uid, group, groupList, dirList); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncGroup(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var gid int
+
+	if err := starlark.UnpackArgs(ruleGroup, args, kwargs,
+		"name", &name, "gid", &gid); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, name=%s, gid=%d", ruleGroup, name, gid)
+	if err := ir.Group(name, gid); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncOutput(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var target, name, path, dockerConfig string
+	var insecure bool
+
+	if err := starlark.UnpackArgs(ruleOutput, args, kwargs,
+		"target", &target, "name?", &name, "path?", &path,
+		"insecure?", &insecure, "docker_config?", &dockerConfig); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, target=%s, name=%s, path=%s, insecure=%t, docker_config=%s",
+		ruleOutput, target, name, path, insecure, dockerConfig)
+	if err := ir.Output(target, name, path, insecure, dockerConfig); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaArtifacts(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var file, artifactsDir string
+
+	if err := starlark.UnpackArgs(ruleJuliaArtifacts, args, kwargs,
+		"file", &file, "artifacts_dir?", &artifactsDir); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, file=%s, artifacts_dir=%s", ruleJuliaArtifacts, file, artifactsDir)
+	buildContextDir := ""
+	if dir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String); ok {
+		buildContextDir = dir.GoString()
+	}
+	data, err := os.ReadFile(filepath.Join(buildContextDir, file))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the Julia artifact overrides %s", file)
+	}
+	overrides := map[string]interface{}{}
+	if err := toml.Unmarshal(data, &overrides); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the Julia artifact overrides %s", file)
+	}
+	var artifacts []string
+	if artifactsDir != "" {
+		entries, err := os.ReadDir(filepath.Join(buildContextDir, artifactsDir))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the Julia artifacts %s", artifactsDir)
+		}
+		for _, entry := range entries {
+			artifacts = append(artifacts, entry.Name())
+		}
+	}
+	if err := ir.JuliaArtifactOverrides(overrides, artifactsDir, artifacts); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaRuntime(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var heapSizeHint, threads, cpuTarget string
+	var gcThreads int
+
+	if err := starlark.UnpackArgs(ruleJuliaRuntime, args, kwargs,
+		"heap_size_hint?", &heapSizeHint, "gc_threads?", &gcThreads,
+		"threads?", &threads, "cpu_target?", &cpuTarget); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, heap_size_hint=%s, gc_threads=%d, threads=%s, cpu_target=%s",
+		ruleJuliaRuntime, heapSizeHint, gcThreads, threads, cpuTarget)
+	if err := ir.JuliaRuntime(heapSizeHint, gcThreads, threads, cpuTarget); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaREPL(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	banner := true
+	var color string
+	var displayRows int
+
+	if err := starlark.UnpackArgs(ruleJuliaREPL, args, kwargs,
+		"banner?", &banner, "color?", &color, "display_rows?", &displayRows); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, banner=%t, color=%s, display_rows=%d",
+		ruleJuliaREPL, banner, color, displayRows)
+	if err := ir.JuliaREPL(banner, color, displayRows); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncTmux(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	defaultSession := true
+
+	if err := starlark.UnpackArgs(ruleTmux, args, kwargs,
+		"default_session?", &defaultSession); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, default_session=%t", ruleTmux, defaultSession)
+	ir.Tmux(defaultSession)
+	return starlark.None, nil
+}
+
+func ruleFuncInitProcess(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	name := "tini"
+
+	if err := starlark.UnpackArgs(ruleInitProcess, args, kwargs,
+		"name?", &name); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, name=%s", ruleInitProcess, name)
+	if err := ir.InitProcess(name); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaFailureHook(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var command string
+
+	if err := starlark.UnpackArgs(ruleJuliaFailureHook, args, kwargs,
+		"command", &command); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, command=%s", ruleJuliaFailureHook, command)
+	if err := ir.JuliaFailureHook(command); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaBuildLog(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	maxSize := "64KiB"
+
+	if err := starlark.UnpackArgs(ruleJuliaBuildLog, args, kwargs,
+		"max_size?", &maxSize); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, max_size=%s", ruleJuliaBuildLog, maxSize)
+	if err := ir.JuliaBuildLog(maxSize); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncPlatform(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var platform string
+
+	if err := starlark.UnpackArgs(rulePlatform, args, kwargs,
+		"platform", &platform); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, platform=%s", rulePlatform, platform)
+	if err := ir.Platform(platform); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncVSCodeSettings(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var file string
+	var settings *starlark.Dict
+
+	if err := starlark.UnpackArgs(ruleVSCodeSettings, args, kwargs,
+		"settings?", &settings, "file?", &file); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, settings=%v, file=%s", ruleVSCodeSettings, settings, file)
+	// The settings in the file are overridden by the structured ones
+	merged := map[string]interface{}{}
+	if file != "" {
+		if buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String); ok {
+			file = filepath.Join(buildContextDir.GoString(), file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the VS Code settings %s", file)
+		}
+		if err := json.Unmarshal(data, &merged); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the VS Code settings %s, the comments are not supported", file)
+		}
+	}
+	if settings != nil {
+		value, err := starlarkutil.ToGoValue(settings)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range value.(map[string]interface{}) {
+			merged[k] = v
+		}
+	}
+	if err := ir.VSCodeSettings(merged); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncJuliaPreferences(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var file string
+	var preferences *starlark.Dict
+
+	if err := starlark.UnpackArgs(ruleJuliaPreferences, args, kwargs,
+		"preferences?", &preferences, "file?", &file); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, preferences=%v, file=%s", ruleJuliaPreferences, preferences, file)
+	// The preferences in the file are overridden by the structured ones
+	if file != "" {
+		if buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String); ok {
+			file = filepath.Join(buildContextDir.GoString(), file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the Julia preferences %s", file)
+		}
+		fromFile := map[string]interface{}{}
+		if err := toml.Unmarshal(data, &fromFile); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the Julia preferences %s", file)
+		}
+		if err := ir.JuliaPreferences(fromFile); err != nil {
+			return nil, err
+		}
+	}
+	if preferences != nil {
+		value, err := starlarkutil.ToGoValue(preferences)
+		if err != nil {
+			return nil, err
+		}
+		if err := ir.JuliaPreferences(value.(map[string]interface{})); err != nil {
+			return nil, err
+		}
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncBuildLimits(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var stepTimeout, memory, cgroupParent string
+
+	if err := starlark.UnpackArgs(ruleBuildLimits, args, kwargs,
+		"step_timeout?", &stepTimeout, "memory?", &memory, "cgroup_parent?", &cgroupParent); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, step_timeout=%s, memory=%s, cgroup_parent=%s",
+		ruleBuildLimits, stepTimeout, memory, cgroupParent)
+	if err := ir.BuildLimits(stepTimeout, memory, cgroupParent); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
@@ -22,8 +22,37 @@ const (
 	ruleCondaChannel       = "config.conda_channel"
 	ruleGPU                = "config.gpu"
 	ruleJuliaPackageServer = "config.julia_pkg_server"
+	ruleJulia              = "config.julia"
+	ruleJuliaRegistry      = "config.julia_registry"
 	ruleRStudioServer      = "config.rstudio_server"
 	ruleEntrypoint         = "config.entrypoint"
 	ruleRepo               = "config.repo"
 	ruleOwner              = "config.owner"
+	ruleStopSignal         = "config.stop_signal"
+	ruleCacheDir           = "config.cache_dir"
+	ruleShellCompletion    = "config.shell_completion"
+	ruleTrustedCert        = "config.trusted_cert"
+	ruleBuildWorker        = "config.build_worker"
+	ruleMetadata           = "config.metadata"
+	ruleSlimRuntime        = "config.slim_runtime"
+	ruleFeature            = "config.feature"
+	ruleAttestation        = "config.attestation"
+	ruleJuliaRuntime       = "config.julia_runtime"
+	ruleTmux               = "config.tmux"
+	ruleInitProcess        = "config.init_process"
+	ruleJuliaFailureHook   = "config.julia_failure_hook"
+	ruleJuliaREPL          = "config.julia_repl"
+	ruleVSCodeSettings     = "config.vscode_settings"
+	ruleJuliaPreferences   = "config.julia_preferences"
+	ruleImageCompression   = "config.image_compression"
+	ruleUser               = "config.user"
+	ruleGroup              = "config.group"
+	ruleOutput             = "config.output"
+	ruleJuliaArtifacts     = "config.julia_artifact_overrides"
+	ruleJuliaBuildLog      = "config.julia_build_log"
+	rulePlatform           = "config.platform"
+	ruleJuliaOffline       = "config.julia_offline"
+	ruleProxy              = "config.proxy"
+	ruleCACert             = "config.ca_cert"
+	ruleBuildLimits        = "config.build_limits"
 )
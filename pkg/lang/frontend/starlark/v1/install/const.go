@@ -20,13 +20,24 @@ const (
 	ruleConda  = "install.conda"
 	ruleRLang  = "install.r_lang"
 	ruleJulia  = "install.julia"
+	ruleNode   = "install.node"
+	ruleRust   = "install.rust"
 
 	// packages
-	ruleSystemPackage = "install.apt_packages"
-	rulePyPIPackage   = "install.python_packages"
-	ruleCondaPackages = "install.conda_packages"
-	ruleRPackage      = "install.r_packages"
-	ruleJuliaPackages = "install.julia_packages"
+	ruleSystemPackage      = "install.apt_packages"
+	rulePyPIPackage        = "install.python_packages"
+	ruleCondaPackages      = "install.conda_packages"
+	ruleCondaEnv           = "install.conda_env"
+	ruleRPackage           = "install.r_packages"
+	ruleJuliaPackages      = "install.julia_packages"
+	ruleJuliaCachePackages = "install.julia_cache_packages"
+	ruleJuliaDevPackages   = "install.julia_dev_packages"
+	ruleJuliaProjects      = "install.julia_projects"
+	ruleJuliaDebugger      = "install.julia_debugger"
+	ruleJuliaSysimage      = "install.julia_sysimage"
+	ruleJuliaCUDA          = "install.julia_cuda"
+	ruleNPMPackages        = "install.npm_packages"
+	ruleCargoPackages      = "install.cargo_packages"
 
 	// others
 	ruleCUDA   = "install.cuda"
@@ -15,11 +15,15 @@
 package install
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/cockroachdb/errors"
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 
+	"github.com/tensorchord/envd/pkg/lang/frontend/starlark/v1/builtin"
 	ir "github.com/tensorchord/envd/pkg/lang/ir/v1"
 	"github.com/tensorchord/envd/pkg/util/starlarkutil"
 )
@@ -36,12 +40,31 @@ var Module = &starlarkstruct.Module{
 		"conda":  starlark.NewBuiltin(ruleConda, ruleFuncConda),
 		"r_lang": starlark.NewBuiltin(ruleRLang, ruleFuncRLang),
 		"julia":  starlark.NewBuiltin(ruleJulia, ruleFuncJulia),
+		"node":   starlark.NewBuiltin(ruleNode, ruleFuncNode),
+		"rust":   starlark.NewBuiltin(ruleRust, ruleFuncRust),
 		// packages
 		"apt_packages":    starlark.NewBuiltin(ruleSystemPackage, ruleFuncSystemPackage),
 		"python_packages": starlark.NewBuiltin(rulePyPIPackage, ruleFuncPyPIPackage),
 		"conda_packages":  starlark.NewBuiltin(ruleCondaPackages, ruleFuncCondaPackage),
+		"conda_env":       starlark.NewBuiltin(ruleCondaEnv, ruleFuncCondaEnv),
 		"r_packages":      starlark.NewBuiltin(ruleRPackage, ruleFuncRPackage),
 		"julia_packages":  starlark.NewBuiltin(ruleJuliaPackages, ruleFuncJuliaPackage),
+		"julia_cache_packages": starlark.NewBuiltin(
+			ruleJuliaCachePackages, ruleFuncJuliaCachePackage),
+		"julia_dev_packages": starlark.NewBuiltin(
+			ruleJuliaDevPackages, ruleFuncJuliaDevPackage),
+		"julia_projects": starlark.NewBuiltin(
+			ruleJuliaProjects, ruleFuncJuliaProject),
+		"julia_debugger": starlark.NewBuiltin(
+			ruleJuliaDebugger, ruleFuncJuliaDebugger),
+		"julia_sysimage": starlark.NewBuiltin(
+			ruleJuliaSysimage, ruleFuncJuliaSysimage),
+		"julia_cuda": starlark.NewBuiltin(
+			ruleJuliaCUDA, ruleFuncJuliaCUDA),
+		"npm_packages": starlark.NewBuiltin(
+			ruleNPMPackages, ruleFuncNPMPackage),
+		"cargo_packages": starlark.NewBuiltin(
+			ruleCargoPackages, ruleFuncCargoPackage),
 		// others
 		"cuda":              starlark.NewBuiltin(ruleCUDA, ruleFuncCUDA),
 		"vscode_extensions": starlark.NewBuiltin(ruleVSCode, ruleFuncVSCode),
@@ -51,15 +74,23 @@ var Module = &starlarkstruct.Module{
 func ruleFuncPython(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	version := ir.PythonVersionDefault
+	var pip, setuptools, wheel string
 
-	if err := starlark.UnpackArgs(rulePython, args, kwargs, "version?", &version); err != nil {
+	if err := starlark.UnpackArgs(rulePython, args, kwargs, "version?", &version,
+		"pip?", &pip, "setuptools?", &setuptools, "wheel?", &wheel); err != nil {
 		return nil, err
 	}
 
-	logger.Debugf("rule `%s` is invoked, version=%s", rulePython, version)
+	logger.Debugf("rule `%s` is invoked, version=%s, pip=%s, setuptools=%s, wheel=%s",
+		rulePython, version, pip, setuptools, wheel)
 	if err := ir.Python(version); err != nil {
 		return nil, err
 	}
+	if pip != "" || setuptools != "" || wheel != "" {
+		if err := ir.PipToolchain(pip, setuptools, wheel); err != nil {
+			return nil, err
+		}
+	}
 
 	return starlark.None, nil
 }
@@ -86,8 +117,49 @@ func ruleFuncRLang(thread *starlark.Thread, _ *starlark.Builtin,
 
 func ruleFuncJulia(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	logger.Debugf("rule `%s` is invoked", ruleJulia)
-	ir.Julia()
+	var lockDepot bool
+	var version, debugURL, debugSHA256, resolveStrategy string
+	var precompileSeed starlark.Value = starlark.None
+
+	if err := starlark.UnpackArgs(ruleJulia, args, kwargs,
+		"lock_depot?", &lockDepot, "debug_url?", &debugURL,
+		"debug_sha256?", &debugSHA256, "resolve_strategy?", &resolveStrategy,
+		"version?", &version, "precompile_seed?", &precompileSeed); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, version=%s, lock_depot=%t, debug_url=%s, resolve_strategy=%s, "+
+		"precompile_seed=%s", ruleJulia, version, lockDepot, debugURL, resolveStrategy, precompileSeed)
+	if version != "" && debugURL != "" {
+		return nil, errors.New("version and debug_url can not be set at the same time")
+	}
+	ir.Julia(lockDepot)
+	if version != "" {
+		if err := ir.JuliaVersion(version); err != nil {
+			return nil, err
+		}
+	}
+	if debugURL != "" {
+		if err := ir.JuliaDebug(debugURL, debugSHA256); err != nil {
+			return nil, err
+		}
+	}
+	if resolveStrategy != "" {
+		if err := ir.JuliaResolveStrategy(resolveStrategy); err != nil {
+			return nil, err
+		}
+	}
+	if precompileSeed != starlark.None {
+		seed, ok := precompileSeed.(starlark.Int)
+		if !ok {
+			return nil, errors.Newf("precompile_seed must be an integer, but got %s", precompileSeed.Type())
+		}
+		value, ok := seed.Uint64()
+		if !ok {
+			return nil, errors.Newf("precompile_seed must be a non-negative 64-bit integer, but got %s", seed)
+		}
+		ir.JuliaPrecompileSeed(value)
+	}
 	return starlark.None, nil
 }
 
@@ -117,10 +189,27 @@ func ruleFuncPyPIPackage(thread *starlark.Thread, _ *starlark.Builtin,
 	logger.Debugf("rule `%s` is invoked, name=%v, requirements=%s, local_wheels=%s",
 		rulePyPIPackage, nameList, requirementsFileStr, localWheels)
 
-	err = ir.PyPIPackage(nameList, requirementsFileStr, localWheels)
+	files, err := dependencyFiles(requirementsFileStr)
+	if err != nil {
+		return nil, err
+	}
+	err = ir.PyPIPackage(nameList, requirementsFileStr, files, localWheels)
 	return starlark.None, err
 }
 
+// dependencyFiles returns the files in the build context required by the
+// dependency file, refer to ir.DependencyFiles.
+func dependencyFiles(file string) ([]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String)
+	if !ok {
+		return nil, nil
+	}
+	return ir.DependencyFiles(buildContextDir.GoString(), file)
+}
+
 func ruleFuncRPackage(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var name *starlark.List
@@ -144,8 +233,221 @@ func ruleFuncRPackage(thread *starlark.Thread, _ *starlark.Builtin,
 func ruleFuncJuliaPackage(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var name *starlark.List
+	var platform, path string
+	var uuid *starlark.Dict
+	var precompile, sysimage bool
 
 	if err := starlark.UnpackArgs(ruleJuliaPackages,
+		args, kwargs, "name?", &name, "platform?", &platform, "uuid?", &uuid, "path?", &path,
+		"precompile?", &precompile, "sysimage?", &sysimage); err != nil {
+		return nil, err
+	}
+
+	nameList, err := starlarkutil.ToStringSlice(name)
+	if err != nil {
+		return nil, err
+	}
+	uuids := map[string]string{}
+	if uuid != nil {
+		for _, item := range uuid.Items() {
+			k, ok := starlark.AsString(item[0])
+			v, ok2 := starlark.AsString(item[1])
+			if !ok || !ok2 {
+				return nil, errors.Newf("invalid uuid of the Julia package (%s)", item.String())
+			}
+			uuids[k] = v
+		}
+	}
+	logger.Debugf("rule `%s` is invoked, name=%v, platform=%s, uuid=%v, path=%s, precompile=%t, sysimage=%t",
+		ruleJuliaPackages, nameList, platform, uuids, path, precompile, sysimage)
+
+	// The project is instantiated the same way as `install.julia_projects`
+	if path != "" {
+		if platform != "" {
+			return nil, errors.New("the Julia project can not be installed for the platform")
+		}
+		if filepath.Base(path) == "Project.toml" {
+			path = filepath.Dir(path)
+		}
+		if err := checkJuliaProjects([]string{path}); err != nil {
+			return nil, err
+		}
+		if err := ir.JuliaProject([]string{path}, false, false); err != nil {
+			return nil, err
+		}
+		if len(nameList) == 0 {
+			return starlark.None, nil
+		}
+	}
+	err = ir.JuliaPackage(nameList, platform, uuids, precompile, sysimage)
+
+	return starlark.None, err
+}
+
+func ruleFuncJuliaCachePackage(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name *starlark.List
+
+	if err := starlark.UnpackArgs(ruleJuliaCachePackages,
+		args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+
+	nameList, err := starlarkutil.ToStringSlice(name)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("rule `%s` is invoked, name=%v", ruleJuliaCachePackages, nameList)
+	err = ir.JuliaCachePackage(nameList)
+
+	return starlark.None, err
+}
+
+func ruleFuncJuliaDevPackage(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path *starlark.List
+
+	if err := starlark.UnpackArgs(ruleJuliaDevPackages,
+		args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+
+	pathList, err := starlarkutil.ToStringSlice(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("rule `%s` is invoked, path=%v", ruleJuliaDevPackages, pathList)
+
+	// Make sure the packages exist in the build context
+	if buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String); ok {
+		for _, p := range pathList {
+			project := filepath.Join(buildContextDir.GoString(), p, "Project.toml")
+			if _, err := os.Stat(project); err != nil {
+				return nil, errors.Wrapf(err, "failed to find the Julia package %s in the build context", p)
+			}
+		}
+	}
+	err = ir.JuliaDevPackage(pathList)
+
+	return starlark.None, err
+}
+
+func ruleFuncJuliaDebugger(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var debuggers *starlark.List
+
+	if err := starlark.UnpackArgs(ruleJuliaDebugger,
+		args, kwargs, "debuggers?", &debuggers); err != nil {
+		return nil, err
+	}
+
+	debuggerList := []string{"Debugger", "Infiltrator"}
+	if debuggers != nil {
+		var err error
+		if debuggerList, err = starlarkutil.ToStringSlice(debuggers); err != nil {
+			return nil, err
+		}
+	}
+	logger.Debugf("rule `%s` is invoked, debuggers=%v", ruleJuliaDebugger, debuggerList)
+
+	err := ir.JuliaDebugger(debuggerList)
+	return starlark.None, err
+}
+
+func ruleFuncJuliaSysimage(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var packages *starlark.List
+
+	if err := starlark.UnpackArgs(ruleJuliaSysimage,
+		args, kwargs, "packages?", &packages); err != nil {
+		return nil, err
+	}
+
+	packageList, err := starlarkutil.ToStringSlice(packages)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("rule `%s` is invoked, packages=%v", ruleJuliaSysimage, packageList)
+
+	err = ir.JuliaSysimage(packageList)
+	return starlark.None, err
+}
+
+func ruleFuncJuliaCUDA(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var version string
+	preload := true
+
+	if err := starlark.UnpackArgs(ruleJuliaCUDA,
+		args, kwargs, "version?", &version, "preload?", &preload); err != nil {
+		return nil, err
+	}
+	logger.Debugf("rule `%s` is invoked, version=%s, preload=%t", ruleJuliaCUDA, version, preload)
+
+	err := ir.JuliaCUDA(version, preload)
+	return starlark.None, err
+}
+
+func ruleFuncNode(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var version string
+
+	if err := starlark.UnpackArgs(ruleNode,
+		args, kwargs, "version?", &version); err != nil {
+		return nil, err
+	}
+	logger.Debugf("rule `%s` is invoked, version=%s", ruleNode, version)
+
+	err := ir.Node(version)
+	return starlark.None, err
+}
+
+func ruleFuncNPMPackage(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name *starlark.List
+
+	if err := starlark.UnpackArgs(ruleNPMPackages,
+		args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+
+	nameList, err := starlarkutil.ToStringSlice(name)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, name=%v", ruleNPMPackages, nameList)
+	err = ir.NPMPackage(nameList)
+
+	return starlark.None, err
+}
+
+func ruleFuncRust(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var toolchain string
+	var components *starlark.List
+
+	if err := starlark.UnpackArgs(ruleRust,
+		args, kwargs, "toolchain?", &toolchain, "components?", &components); err != nil {
+		return nil, err
+	}
+
+	componentList, err := starlarkutil.ToStringSlice(components)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, toolchain=%s, components=%v", ruleRust, toolchain, componentList)
+	err = ir.Rust(toolchain, componentList)
+
+	return starlark.None, err
+}
+
+func ruleFuncCargoPackage(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name *starlark.List
+
+	if err := starlark.UnpackArgs(ruleCargoPackages,
 		args, kwargs, "name", &name); err != nil {
 		return nil, err
 	}
@@ -154,12 +456,59 @@ func ruleFuncJuliaPackage(thread *starlark.Thread, _ *starlark.Builtin,
 	if err != nil {
 		return nil, err
 	}
-	logger.Debugf("rule `%s` is invoked, name=%v", ruleJuliaPackages, nameList)
-	err = ir.JuliaPackage(nameList)
+
+	logger.Debugf("rule `%s` is invoked, name=%v", ruleCargoPackages, nameList)
+	err = ir.CargoPackage(nameList)
+
+	return starlark.None, err
+}
+
+func ruleFuncJuliaProject(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path *starlark.List
+	var parallel, activate bool
+
+	if err := starlark.UnpackArgs(ruleJuliaProjects,
+		args, kwargs, "path", &path, "parallel?", &parallel, "activate?", &activate); err != nil {
+		return nil, err
+	}
+
+	pathList, err := starlarkutil.ToStringSlice(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("rule `%s` is invoked, path=%v, parallel=%t, activate=%t",
+		ruleJuliaProjects, pathList, parallel, activate)
+
+	if err := checkJuliaProjects(pathList); err != nil {
+		return nil, err
+	}
+	err = ir.JuliaProject(pathList, parallel, activate)
 
 	return starlark.None, err
 }
 
+// checkJuliaProjects makes sure the projects are in the build context, the
+// ones without the manifests are resolved from the projects, thus not
+// reproducible.
+func checkJuliaProjects(paths []string) error {
+	buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String)
+	if !ok {
+		return nil
+	}
+	for _, p := range paths {
+		dir := filepath.Join(buildContextDir.GoString(), p)
+		if _, err := os.Stat(filepath.Join(dir, "Project.toml")); err != nil {
+			return errors.Wrapf(err, "failed to find Project.toml of the Julia project %s in the build context", p)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "Manifest.toml")); err != nil {
+			logger.Warnf("no Manifest.toml in the Julia project %s, the dependencies are resolved "+
+				"from Project.toml, thus the versions are not reproducible", p)
+		}
+	}
+	return nil
+}
+
 func ruleFuncSystemPackage(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var name *starlark.List
@@ -183,17 +532,23 @@ func ruleFuncSystemPackage(thread *starlark.Thread, _ *starlark.Builtin,
 func ruleFuncCUDA(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var version, cudnn string
+	var capabilities *starlark.List
 
 	if err := starlark.UnpackArgs(ruleCUDA, args, kwargs,
-		"version", &version, "cudnn?", &cudnn); err != nil {
+		"version", &version, "cudnn?", &cudnn, "compute_capabilities?", &capabilities); err != nil {
 		return nil, err
 	}
 
-	logger.Debugf("rule `%s` is invoked, version=%s, cudnn=%s",
-		ruleCUDA, version, cudnn)
-	ir.CUDA(version, cudnn)
+	capabilityList, err := starlarkutil.ToStringSlice(capabilities)
+	if err != nil {
+		return nil, err
+	}
 
-	return starlark.None, nil
+	logger.Debugf("rule `%s` is invoked, version=%s, cudnn=%s, compute_capabilities=%v",
+		ruleCUDA, version, cudnn, capabilityList)
+	err = ir.CUDA(version, cudnn, capabilityList)
+
+	return starlark.None, err
 }
 
 func ruleFuncVSCode(thread *starlark.Thread, _ *starlark.Builtin,
@@ -246,9 +601,36 @@ func ruleFuncCondaPackage(thread *starlark.Thread, _ *starlark.Builtin,
 	}
 
 	logger.Debugf("rule `%s` is invoked, name=%v, channel=%v, env_file=%s", ruleCondaPackages, nameList, channelList, envFileStr)
-	if err := ir.CondaPackage(nameList, channelList, envFileStr); err != nil {
+	files, err := dependencyFiles(envFileStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := ir.CondaPackage(nameList, channelList, envFileStr, files); err != nil {
 		return starlark.None, err
 	}
 
 	return starlark.None, nil
 }
+
+func ruleFuncCondaEnv(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+
+	if err := starlark.UnpackArgs(ruleCondaEnv,
+		args, kwargs, "path?", &path); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		path = "environment.yml"
+	}
+
+	logger.Debugf("rule `%s` is invoked, path=%s", ruleCondaEnv, path)
+	files, err := dependencyFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ir.CondaPackage(nil, nil, path, files); err != nil {
+		return starlark.None, err
+	}
+	return starlark.None, nil
+}
@@ -102,54 +102,133 @@ func (s *generalInterpreter) exec(thread *starlark.Thread, module string) (starl
 	// Add a placeholder to indicate "load in progress".
 	s.cache[module] = nil
 
-	if !strings.HasPrefix(module, universe.GitPrefix) {
-		var data interface{}
-		globals, err := starlark.ExecFile(thread, module, data, s.predeclared)
-		e = &entry{globals, err}
-	} else {
+	globals, err := s.execModule(thread, module)
+	e = &entry{globals, err}
+
+	// Update the cache.
+	s.cache[module] = e
+
+	return e.globals, e.err
+}
+
+// execModule executes the envd file, or the envd files of the git repo or
+// the dir of the build context loaded by `include`.
+func (s *generalInterpreter) execModule(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	var data interface{}
+	switch {
+	case strings.HasPrefix(module, universe.GitPrefix):
 		// exec remote git repo
-		url := module[len(universe.GitPrefix):]
-		path, err := fileutil.DownloadOrUpdateGitRepo(url)
+		url, ref, subdir := universe.ParseGitModule(module)
+		path, err := fileutil.DownloadGitRepoRef(url, ref)
+		if err != nil {
+			return nil, err
+		}
+		if subdir != "" {
+			if path, err = subdirPath(path, subdir); err != nil {
+				return nil, err
+			}
+		}
+		return s.loadGitModule(thread, path)
+	case strings.HasPrefix(module, universe.PathPrefix):
+		path, err := subdirPath(s.buildContextDir, module[len(universe.PathPrefix):])
 		if err != nil {
 			return nil, err
 		}
-		globals, err := s.loadGitModule(thread, path)
-		e = &entry{globals, err}
+		isDir, err := fileutil.DirExists(path)
+		if err != nil {
+			return nil, err
+		}
+		if isDir {
+			return s.loadLocalModule(thread, path)
+		}
+		return starlark.ExecFile(thread, path, data, s.predeclared)
+	default:
+		return starlark.ExecFile(thread, module, data, s.predeclared)
 	}
+}
 
-	// Update the cache.
-	s.cache[module] = e
-
-	return e.globals, e.err
+// subdirPath returns the path of the subdir, which must be in the dir.
+func subdirPath(dir, subdir string) (string, error) {
+	path := filepath.Join(dir, subdir)
+	if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", errors.Newf("%s is not in %s", subdir, dir)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", errors.Wrapf(err, "failed to find %s", subdir)
+	}
+	return path, nil
 }
 
-func (s *generalInterpreter) loadGitModule(thread *starlark.Thread, path string) (globals starlark.StringDict, err error) {
-	var src interface{}
-	globals = starlark.StringDict{}
+// loadGitModule executes the envd files in the path in the lexical order, thus
+// the rules are applied to the graph deterministically.
+func (s *generalInterpreter) loadGitModule(thread *starlark.Thread, path string) (starlark.StringDict, error) {
 	logger := logrus.WithField("file", thread.Name)
 	logger.Debugf("load git module from: %s", path)
-	err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+	var files []string
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() || !strings.HasSuffix(d.Name(), ".envd") {
-			return nil
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".envd") {
+			files = append(files, path)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.execModuleFiles(thread, files)
+}
+
+// loadLocalModule executes the envd files in the dir of the build context,
+// the subdirs are not walked since they may have the envd files of the other
+// environments. The entry file is skipped, thus `include(path=".")` does not
+// execute it again.
+func (s *generalInterpreter) loadLocalModule(thread *starlark.Thread, path string) (starlark.StringDict, error) {
+	logger := logrus.WithField("file", thread.Name)
+	logger.Debugf("load local module from: %s", path)
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	entry, err := filepath.Abs(thread.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the absolute path of %s", thread.Name)
+	}
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".envd") {
+			continue
+		}
+		file := filepath.Join(path, e.Name())
+		if abs, err := filepath.Abs(file); err == nil && abs == entry {
+			continue
+		}
+		files = append(files, file)
+	}
+	return s.execModuleFiles(thread, files)
+}
+
+// execModuleFiles executes the envd files of the module in order, and merges
+// their globals except those with the `_` prefix.
+func (s *generalInterpreter) execModuleFiles(thread *starlark.Thread, files []string) (starlark.StringDict, error) {
+	var src interface{}
+	globals := starlark.StringDict{}
+	for _, path := range files {
 		dict, err := starlark.ExecFile(thread, path, src, s.predeclared)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for key, val := range dict {
 			if _, exist := globals[key]; exist {
-				return errors.Newf("found duplicated object name: %s in %s", key, path)
+				return nil, errors.Newf("found duplicated object name: %s in %s", key, path)
 			}
 			if !strings.HasPrefix(key, "_") {
 				globals[key] = val
 			}
 		}
-		return nil
-	})
-	return
+	}
+	return globals, nil
 }
 
 func (s generalInterpreter) ExecFile(filename string, funcname string) (interface{}, error) {
@@ -17,6 +17,9 @@ package v1
 import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"go.starlark.net/starlark"
+
+	"github.com/tensorchord/envd/pkg/lang/frontend/starlark/v1/universe"
 )
 
 var _ = Describe("Starlark", func() {
@@ -26,4 +29,29 @@ var _ = Describe("Starlark", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(hash).To(Equal("1292b11c2ac16f70"))
 	})
+	It("should be able to include the envd files in the build context", func() {
+		s := NewInterpreter("testdata")
+		globals, err := s.Eval(`t = include(path="templates")
+mirror, version = t.mirror(), t.python_version()`)
+		Expect(err).NotTo(HaveOccurred())
+		dict := globals.(starlark.StringDict)
+		Expect(dict["mirror"]).To(Equal(starlark.String("https://mirror.example.com")))
+		Expect(dict["version"]).To(Equal(starlark.String("3.11")))
+
+		_, err = s.Eval(`include(path="../templates")`)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should skip the entry file and the subdirs when including the dir", func() {
+		s := NewInterpreter("testdata")
+		globals, err := s.ExecFile("testdata/include.envd", "")
+		Expect(err).NotTo(HaveOccurred())
+		dict := globals.(starlark.StringDict)
+		Expect(dict["members"].String()).To(Equal(`["build"]`))
+	})
+	It("should be able to parse the included git module", func() {
+		url, ref, subdir := universe.ParseGitModule(universe.GitModule("https://github.com/org/templates", "v1.0", "pytorch"))
+		Expect([]string{url, ref, subdir}).To(Equal([]string{"https://github.com/org/templates", "v1.0", "pytorch"}))
+		url, ref, subdir = universe.ParseGitModule(universe.GitModule("https://github.com/tensorchord/envdlib", "", ""))
+		Expect([]string{url, ref, subdir}).To(Equal([]string{"https://github.com/tensorchord/envdlib", "", ""}))
+	})
 })
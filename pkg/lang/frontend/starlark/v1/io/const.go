@@ -15,6 +15,8 @@
 package io
 
 const (
-	ruleCopy = "io.copy"
-	ruleHTTP = "io.http"
+	ruleCopy        = "io.copy"
+	ruleHTTP        = "io.http"
+	ruleMountSecret = "io.mount_secret"
+	ruleMount       = "io.mount"
 )
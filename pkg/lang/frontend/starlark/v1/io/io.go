@@ -15,11 +15,16 @@
 package io
 
 import (
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 
+	"github.com/tensorchord/envd/pkg/lang/frontend/starlark/v1/builtin"
 	ir "github.com/tensorchord/envd/pkg/lang/ir/v1"
+	"github.com/tensorchord/envd/pkg/util/fileutil"
 )
 
 var (
@@ -29,8 +34,10 @@ var (
 var Module = &starlarkstruct.Module{
 	Name: "io",
 	Members: starlark.StringDict{
-		"copy": starlark.NewBuiltin(ruleCopy, ruleFuncCopy),
-		"http": starlark.NewBuiltin(ruleHTTP, ruleFuncHTTP),
+		"copy":         starlark.NewBuiltin(ruleCopy, ruleFuncCopy),
+		"http":         starlark.NewBuiltin(ruleHTTP, ruleFuncHTTP),
+		"mount_secret": starlark.NewBuiltin(ruleMountSecret, ruleFuncMountSecret),
+		"mount":        starlark.NewBuiltin(ruleMount, ruleFuncMount),
 	},
 }
 
@@ -68,3 +75,54 @@ func ruleFuncHTTP(thread *starlark.Thread, _ *starlark.Builtin,
 	}
 	return starlark.None, nil
 }
+
+func ruleFuncMountSecret(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id, target, env, file string
+	if err := starlark.UnpackArgs(ruleMountSecret, args, kwargs,
+		"id", &id, "target", &target, "env?", &env, "file?", &file); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, id=%s, target=%s, env=%s, file=%s",
+		ruleMountSecret, id, target, env, file)
+	if buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String); ok &&
+		file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(buildContextDir.GoString(), file)
+	}
+	if err := ir.MountSecret(id, target, env, file); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncMount(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var hostPath, envdPath, volume string
+	var readOnly bool
+	if err := starlark.UnpackArgs(ruleMount, args, kwargs,
+		"envd_path", &envdPath, "host_path?", &hostPath, "volume?", &volume,
+		"readonly?", &readOnly); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, host_path=%s, envd_path=%s, volume=%s, readonly=%t",
+		ruleMount, hostPath, envdPath, volume, readOnly)
+	if (hostPath == "") == (volume == "") {
+		return nil, errors.New("exactly one of `host_path` and `volume` must be specified")
+	}
+	envdPath = fileutil.ExpandEnvdHome(envdPath)
+	if volume != "" {
+		if err := ir.Volume(volume, envdPath, readOnly); err != nil {
+			return nil, err
+		}
+		return starlark.None, nil
+	}
+	hostPath = fileutil.ExpandHostHome(hostPath)
+	// docker requires the absolute path of the bind mount
+	if buildContextDir, ok := starlark.Universe[builtin.BuildContextDir].(starlark.String); ok && !filepath.IsAbs(hostPath) {
+		hostPath = filepath.Join(buildContextDir.GoString(), hostPath)
+	}
+	ir.Mount(hostPath, envdPath, readOnly)
+	return starlark.None, nil
+}
@@ -15,10 +15,16 @@
 package runtime
 
 const (
-	ruleCommand    = "runtime.command"
-	ruleExpose     = "runtime.expose"
-	ruleDaemon     = "runtime.daemon"
-	ruleEnviron    = "runtime.environ"
-	ruleMount      = "runtime.mount"
-	ruleInitScript = "runtime.init"
+	ruleCommand     = "runtime.command"
+	ruleExpose      = "runtime.expose"
+	ruleDaemon      = "runtime.daemon"
+	ruleEnviron     = "runtime.environ"
+	ruleMount       = "runtime.mount"
+	ruleInitScript  = "runtime.init"
+	rulePreDestroy  = "runtime.pre_destroy"
+	ruleEntryScript = "runtime.entry_script"
+	ruleArtifact    = "runtime.artifact"
+	ruleDependsOn   = "runtime.depends_on"
+	ruleSecret      = "runtime.secret"
+	ruleGPU         = "runtime.gpu"
 )
@@ -16,8 +16,6 @@ package runtime
 
 import (
 	"net"
-	"os/user"
-	"path/filepath"
 	"strings"
 
 	"github.com/cockroachdb/errors"
@@ -44,6 +42,14 @@ var Module = &starlarkstruct.Module{
 		"environ": starlark.NewBuiltin(ruleEnviron, ruleFuncEnviron),
 		"mount":   starlark.NewBuiltin(ruleMount, ruleFuncMount),
 		"init":    starlark.NewBuiltin(ruleInitScript, ruleFuncInitScript),
+		"pre_destroy": starlark.NewBuiltin(
+			rulePreDestroy, ruleFuncPreDestroy),
+		"entry_script": starlark.NewBuiltin(
+			ruleEntryScript, ruleFuncEntryScript),
+		"artifact":   starlark.NewBuiltin(ruleArtifact, ruleFuncArtifact),
+		"depends_on": starlark.NewBuiltin(ruleDependsOn, ruleFuncDependsOn),
+		"secret":     starlark.NewBuiltin(ruleSecret, ruleFuncSecret),
+		"gpu":        starlark.NewBuiltin(ruleGPU, ruleFuncGPU),
 	},
 }
 
@@ -76,8 +82,13 @@ func ruleFuncCommand(thread *starlark.Thread, _ *starlark.Builtin,
 func ruleFuncDaemon(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var commands *starlark.List
+	var probes *starlark.List
+	var names *starlark.List
+	var restart starlark.String
 
-	if err := starlark.UnpackArgs(ruleDaemon, args, kwargs, "commands", &commands); err != nil {
+	if err := starlark.UnpackArgs(ruleDaemon, args, kwargs,
+		"commands", &commands, "probes?", &probes, "names?", &names,
+		"restart?", &restart); err != nil {
 		return nil, err
 	}
 
@@ -95,8 +106,21 @@ func ruleFuncDaemon(thread *starlark.Thread, _ *starlark.Builtin,
 			commandList = append(commandList, argList)
 		}
 
-		logger.Debugf("rule `%s` is invoked, commands=%v", ruleDaemon, commandList)
-		ir.RuntimeDaemon(commandList)
+		probeList, err := starlarkutil.ToStringSlice(probes)
+		if err != nil {
+			return nil, err
+		}
+
+		nameList, err := starlarkutil.ToStringSlice(names)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Debugf("rule `%s` is invoked, commands=%v, probes=%v, names=%v, restart=%s",
+			ruleDaemon, commandList, probeList, nameList, restart.GoString())
+		if err := ir.RuntimeDaemon(commandList, probeList, nameList, restart.GoString()); err != nil {
+			return nil, err
+		}
 	}
 	return starlark.None, nil
 }
@@ -108,10 +132,12 @@ func ruleFuncExpose(thread *starlark.Thread, _ *starlark.Builtin,
 		hostPort      = starlark.MakeInt(0) // 0 means envd can randomly choose a free port
 		serviceName   = starlark.String("")
 		listeningAddr = starlark.String("127.0.0.1") // default to lisen only on local loopback interface
+		publish       = true
 	)
 
 	if err := starlark.UnpackArgs(ruleExpose,
-		args, kwargs, "envd_port", &envdPort, "host_port?", &hostPort, "service?", &serviceName, "listen_addr?", &listeningAddr); err != nil {
+		args, kwargs, "envd_port", &envdPort, "host_port?", &hostPort, "service?", &serviceName, "listen_addr?", &listeningAddr,
+		"publish?", &publish); err != nil {
 		return nil, err
 	}
 	envdPortInt, ok := envdPort.Int64()
@@ -128,8 +154,9 @@ func ruleFuncExpose(thread *starlark.Thread, _ *starlark.Builtin,
 		return nil, errors.New("listening_addr must be a valid IP address")
 	}
 
-	logger.Debugf("rule `%s` is invoked, envd_port=%d, host_port=%d, service=%s", ruleExpose, envdPortInt, hostPortInt, serviceNameStr)
-	err := ir.RuntimeExpose(int(envdPortInt), int(hostPortInt), serviceNameStr, listeningAddrStr)
+	logger.Debugf("rule `%s` is invoked, envd_port=%d, host_port=%d, service=%s, publish=%t",
+		ruleExpose, envdPortInt, hostPortInt, serviceNameStr, publish)
+	err := ir.RuntimeExpose(int(envdPortInt), int(hostPortInt), serviceNameStr, listeningAddrStr, publish)
 	return starlark.None, err
 }
 
@@ -196,22 +223,7 @@ func ruleFuncMount(thread *starlark.Thread, _ *starlark.Builtin,
 	logger.Debugf("rule `%s` is invoked, src=%s, dest=%s",
 		ruleMount, sourceStr, destinationStr)
 
-	// Expand source directory based on host user
-	usr, _ := user.Current()
-	dir := usr.HomeDir
-	if sourceStr == "~" {
-		sourceStr = dir
-	} else if strings.HasPrefix(sourceStr, "~/") {
-		sourceStr = filepath.Join(dir, sourceStr[2:])
-	}
-	// Expand dest directory based on container user envd
-	dir = fileutil.EnvdHomeDir()
-	if destinationStr == "~" {
-		destinationStr = dir
-	} else if strings.HasPrefix(destinationStr, "~/") {
-		destinationStr = fileutil.EnvdHomeDir(destinationStr[2:])
-	}
-	ir.Mount(sourceStr, destinationStr)
+	ir.Mount(fileutil.ExpandHostHome(sourceStr), fileutil.ExpandEnvdHome(destinationStr), false)
 
 	return starlark.None, nil
 }
@@ -236,3 +248,153 @@ func ruleFuncInitScript(thread *starlark.Thread, _ *starlark.Builtin,
 	ir.RuntimeInitScript(commandsSlice)
 	return starlark.None, nil
 }
+
+func ruleFuncPreDestroy(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var commands *starlark.List
+
+	if err := starlark.UnpackArgs(rulePreDestroy, args, kwargs,
+		"commands", &commands); err != nil {
+		return nil, err
+	}
+
+	commandsSlice, err := starlarkutil.ToStringSlice(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, commands: %v",
+		rulePreDestroy, commandsSlice)
+
+	ir.RuntimePreDestroy(commandsSlice)
+	return starlark.None, nil
+}
+
+func ruleFuncEntryScript(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path starlark.String
+	var commands *starlark.List
+
+	if err := starlark.UnpackArgs(ruleEntryScript, args, kwargs,
+		"path?", &path, "commands?", &commands); err != nil {
+		return nil, err
+	}
+
+	commandsSlice, err := starlarkutil.ToStringSlice(commands)
+	if err != nil {
+		return nil, err
+	}
+	pathStr := path.GoString()
+
+	logger.Debugf("rule `%s` is invoked, path=%s, commands=%v",
+		ruleEntryScript, pathStr, commandsSlice)
+
+	ir.RuntimeEntryScript(pathStr, commandsSlice)
+	return starlark.None, nil
+}
+
+func ruleFuncArtifact(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var ref, destination string
+
+	if err := starlark.UnpackArgs(ruleArtifact, args, kwargs,
+		"reference", &ref, "envd_path", &destination); err != nil {
+		return nil, err
+	}
+
+	// Expand dest directory based on container user envd
+	if destination == "~" {
+		destination = fileutil.EnvdHomeDir()
+	} else if strings.HasPrefix(destination, "~/") {
+		destination = fileutil.EnvdHomeDir(destination[2:])
+	}
+
+	logger.Debugf("rule `%s` is invoked, reference=%s, dest=%s",
+		ruleArtifact, ref, destination)
+
+	if err := ir.Artifact(ref, destination); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncDependsOn(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		host, name string
+		port       int
+		timeout    = 60
+	)
+
+	if err := starlark.UnpackArgs(ruleDependsOn, args, kwargs,
+		"host", &host, "port", &port, "name?", &name, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, name=%s, host=%s, port=%d, timeout=%d",
+		ruleDependsOn, name, host, port, timeout)
+	if err := ir.RuntimeDependsOn(name, host, port, timeout); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncSecret(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var source, destination string
+
+	if err := starlark.UnpackArgs(ruleSecret, args, kwargs,
+		"source", &source, "envd_path", &destination); err != nil {
+		return nil, err
+	}
+
+	// Expand dest path based on container user envd
+	if strings.HasPrefix(destination, "~/") {
+		destination = fileutil.EnvdHomeDir(destination[2:])
+	}
+
+	logger.Debugf("rule `%s` is invoked, source=%s, dest=%s",
+		ruleSecret, source, destination)
+
+	if err := ir.RuntimeSecret(source, destination); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+func ruleFuncGPU(thread *starlark.Thread, _ *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var count int
+	var devices, capabilities *starlark.List
+
+	if err := starlark.UnpackArgs(ruleGPU, args, kwargs,
+		"count?", &count, "devices?", &devices, "capabilities?", &capabilities); err != nil {
+		return nil, err
+	}
+
+	// the devices are the indexes or the UUIDs
+	var deviceList []string
+	if devices != nil {
+		for i := 0; i < devices.Len(); i++ {
+			switch d := devices.Index(i).(type) {
+			case starlark.Int:
+				deviceList = append(deviceList, d.String())
+			case starlark.String:
+				deviceList = append(deviceList, d.GoString())
+			default:
+				return nil, errors.Newf("invalid GPU device %s, must be the index or the UUID", d.String())
+			}
+		}
+	}
+	capabilityList, err := starlarkutil.ToStringSlice(capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("rule `%s` is invoked, count=%d, devices=%v, capabilities=%v",
+		ruleGPU, count, deviceList, capabilityList)
+	if err := ir.RuntimeGPU(count, deviceList, capabilityList); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
@@ -21,5 +21,6 @@ const (
 	ruleGitConfig = "git_config"
 	ruleInclude   = "include"
 
-	GitPrefix = "git@"
+	GitPrefix  = "git@"
+	PathPrefix = "path@"
 )
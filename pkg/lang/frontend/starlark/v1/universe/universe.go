@@ -15,8 +15,9 @@
 package universe
 
 import (
-	"fmt"
+	"strings"
 
+	"github.com/cockroachdb/errors"
 	"github.com/sirupsen/logrus"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -61,10 +62,12 @@ func ruleFuncBase(thread *starlark.Thread, _ *starlark.Builtin,
 func ruleFuncRun(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var commands *starlark.List
+	var user string
 	mountHost := false
+	test := false
 
-	if err := starlark.UnpackArgs(ruleRun,
-		args, kwargs, "commands", &commands, "mount_host?", &mountHost); err != nil {
+	if err := starlark.UnpackArgs(ruleRun, args, kwargs, "commands", &commands,
+		"mount_host?", &mountHost, "user?", &user, "test?", &test); err != nil {
 		return nil, err
 	}
 
@@ -73,8 +76,9 @@ func ruleFuncRun(thread *starlark.Thread, _ *starlark.Builtin,
 		return nil, err
 	}
 
-	logger.Debugf("rule `%s` is invoked, commands=%v, mount_host=%t", ruleRun, goCommands, mountHost)
-	if err := ir.Run(goCommands, mountHost); err != nil {
+	logger.Debugf("rule `%s` is invoked, commands=%v, mount_host=%t, user=%s, test=%t",
+		ruleRun, goCommands, mountHost, user, test)
+	if err := ir.Run(goCommands, mountHost, user, test); err != nil {
 		return nil, err
 	}
 
@@ -119,22 +123,88 @@ func ruleFuncGitConfig(thread *starlark.Thread, _ *starlark.Builtin,
 
 func ruleFuncInclude(thread *starlark.Thread, _ *starlark.Builtin,
 	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var gitRepo string
+	var gitRepo, ref, path string
 
 	if err := starlark.UnpackArgs(ruleInclude,
-		args, kwargs, "git?", &gitRepo); err != nil {
+		args, kwargs, "git?", &gitRepo, "ref?", &ref, "path?", &path); err != nil {
 		return nil, err
 	}
 
-	logger.Debugf("rule `%s` is invoked, git=%s", ruleInclude, gitRepo)
+	logger.Debugf("rule `%s` is invoked, git=%s, ref=%s, path=%s", ruleInclude, gitRepo, ref, path)
+
+	var module, name string
+	switch {
+	case gitRepo != "" && path != "":
+		return nil, errors.New("the git repo and the path can not be both included")
+	case gitRepo != "":
+		url, subdir := splitGitSubdir(gitRepo)
+		module, name = GitModule(url, ref, subdir), gitRepo
+	case path != "":
+		if ref != "" {
+			return nil, errors.New("the ref is only for the git repo")
+		}
+		module, name = PathPrefix+path, path
+	default:
+		return nil, errors.New("either the git repo or the path is required")
+	}
 
-	globals, err := thread.Load(thread, fmt.Sprintf("%s%s", GitPrefix, gitRepo))
+	globals, err := thread.Load(thread, module)
 	if err != nil {
 		return nil, err
 	}
-	module := &starlarkstruct.Module{
-		Name:    gitRepo,
+	return &starlarkstruct.Module{
+		Name:    name,
 		Members: globals,
+	}, nil
+}
+
+// GitModule returns the module of the git repo loaded by `include`, with the
+// optional ref and the subdir of the repo.
+func GitModule(url, ref, subdir string) string {
+	module := GitPrefix + url
+	if ref != "" || subdir != "" {
+		module += "#" + ref
+	}
+	if subdir != "" {
+		module += "#" + subdir
+	}
+	return module
+}
+
+// ParseGitModule returns the URL, the ref and the subdir of the module
+// returned by GitModule.
+func ParseGitModule(module string) (url, ref, subdir string) {
+	parts := strings.SplitN(strings.TrimPrefix(module, GitPrefix), "#", 3)
+	url = parts[0]
+	if len(parts) > 1 {
+		ref = parts[1]
+	}
+	if len(parts) > 2 {
+		subdir = parts[2]
+	}
+	return url, ref, subdir
+}
+
+// splitGitSubdir splits the subdir of the repo by the `//` after the host,
+// e.g. `https://github.com/org/templates//pytorch`, or the path after the
+// repo of GitHub without the scheme, e.g. `github.com/org/templates/pytorch`.
+func splitGitSubdir(git string) (url, subdir string) {
+	scheme, rest := "", git
+	if i := strings.Index(git, "://"); i >= 0 {
+		scheme, rest = git[:i+3], git[i+3:]
+	}
+	if i := strings.Index(rest, "//"); i >= 0 {
+		return scheme + rest[:i], strings.Trim(rest[i+2:], "/")
+	}
+	if scheme == "" && strings.HasPrefix(rest, "github.com/") {
+		parts := strings.SplitN(strings.TrimSuffix(rest, "/"), "/", 4)
+		if len(parts) >= 3 {
+			url = "https://" + strings.Join(parts[:3], "/")
+			if len(parts) == 4 {
+				subdir = parts[3]
+			}
+			return url, subdir
+		}
 	}
-	return module, nil
+	return git, ""
 }
@@ -28,10 +28,40 @@ type Graph interface {
 	graphDebugger
 	graphVisitor
 	graphSerializer
+	graphValidator
+	graphResolver
+	graphOverrider
 }
 
 type graphSerializer interface {
 	GeneralGraphFromLabel(label []byte) (Graph, error)
+	// ExportReproducer serializes the graph with the base image and the
+	// packages pinned to the resolved versions.
+	ExportReproducer(image string, packages map[string][]string) ([]byte, error)
+}
+
+type graphValidator interface {
+	CheckPolicy(policy Policy) error
+}
+
+type graphResolver interface {
+	// CompileResolution compiles the LLB which only resolves the versions of
+	// the packages, the output has a file per package manager. It returns nil
+	// if there is no package to resolve.
+	CompileResolution(ctx context.Context) (*llb.Definition, error)
+	// ResolveBaseImage returns the base image pinned by the digest.
+	ResolveBaseImage(ctx context.Context) (string, error)
+	// CompileLock compiles the LLB which exports the versions of the packages
+	// installed in the image of the last Compile, the output has a file per
+	// package manager. It returns nil if there is no package to lock.
+	CompileLock(ctx context.Context) (*llb.Definition, error)
+}
+
+// graphOverrider overrides the graph by the command line flags, after the
+// manifest is interpreted.
+type graphOverrider interface {
+	// SetPlatform builds the image for the platform instead of the declared one.
+	SetPlatform(platform string) error
 }
 
 type graphDebugger interface {
@@ -54,6 +84,23 @@ type graphVisitor interface {
 	DefaultCacheImporter() (*string, error)
 	GetEnviron() []string
 	GetHTTP() []HTTPInfo
+	GetBuildSecrets() []BuildSecret
+	GetStopConfig() *StopConfig
+	GetPlatform() string
+	GetAttestationConfig() *AttestationConfig
+	GetCompressionConfig() *CompressionConfig
+	GetOutputConfig() *OutputConfig
+	GetAttestationMaterials() []AttestationMaterial
 	GetRuntimeCommands() map[string]string
+	// GetRuntimeTests returns the commands run by `envd test`
+	GetRuntimeTests() [][]string
 	GetUser() string
+	// GetRuntimeGPU returns the GPUs declared by runtime.gpu, nil if none
+	GetRuntimeGPU() *GPUConfig
+	// GetBuildLimits returns the resource limits of the build steps, nil if none
+	GetBuildLimits() *BuildLimitsConfig
+	// GetVSCodeExtensions returns the IDs of the extensions, e.g. ms-python.python
+	GetVSCodeExtensions() []string
+	// GetVSCodeSettings returns the default settings of VS Code, nil if none
+	GetVSCodeSettings() map[string]interface{}
 }
@@ -15,6 +15,8 @@
 package ir
 
 import (
+	"time"
+
 	"github.com/opencontainers/go-digest"
 )
 
@@ -23,9 +25,28 @@ type RuntimeGraph struct {
 	RuntimeCommands   map[string]string `json:"commands,omitempty"`
 	RuntimeDaemon     [][]string        `json:"daemon,omitempty"`
 	RuntimeInitScript [][]string        `json:"init_script,omitempty"`
-	RuntimeEnviron    map[string]string `json:"environ,omitempty"`
-	RuntimeEnvPaths   []string          `json:"env_paths,omitempty"`
-	RuntimeExpose     []ExposeItem      `json:"expose,omitempty"`
+	// RuntimePreDestroy are run when the environment is stopped or destroyed
+	RuntimePreDestroy [][]string `json:"pre_destroy,omitempty"`
+	// RuntimeTests are the commands of `run(test=True)`, run by `envd test`
+	RuntimeTests     [][]string        `json:"tests,omitempty"`
+	RuntimeEnviron   map[string]string `json:"environ,omitempty"`
+	RuntimeEnvPaths  []string          `json:"env_paths,omitempty"`
+	RuntimeExpose    []ExposeItem      `json:"expose,omitempty"`
+	RuntimeDependsOn []ServiceDep      `json:"depends_on,omitempty"`
+	RuntimeSecrets   []SecretMount     `json:"secrets,omitempty"`
+	// RuntimeGPU is the GPUs attached by runtime.gpu, the one GPU of
+	// install.cuda if nil
+	RuntimeGPU *GPUConfig `json:"gpu,omitempty"`
+}
+
+// GPUConfig is the GPUs attached to the environment.
+type GPUConfig struct {
+	// Count is the number of GPUs, -1 for all the GPUs, 0 if Devices is set
+	Count int `json:"count,omitempty"`
+	// Devices are the indexes or the UUIDs of the GPUs or the MIG devices
+	Devices []string `json:"devices,omitempty"`
+	// Capabilities of the driver, e.g. compute and utility, all if empty
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 type CopyInfo struct {
@@ -36,6 +57,147 @@ type CopyInfo struct {
 type MountInfo struct {
 	Source      string
 	Destination string
+	// Volume is the named volume mounted instead of the host path Source,
+	// it's created by the runner if it does not exist
+	Volume   string
+	ReadOnly bool
+}
+
+type ArtifactInfo struct {
+	Reference   string
+	Destination string
+}
+
+// JuliaDebugBuild is the Julia distribution built with the debug symbols.
+type JuliaDebugBuild struct {
+	URL    string
+	SHA256 string
+}
+
+// TrustedCert is the cert of an internal server trusted by the language.
+type TrustedCert struct {
+	Language string
+	URL      string
+	Content  string
+}
+
+// ProxyConfig is the proxy used by the build steps, which is not kept in the
+// resulting image.
+type ProxyConfig struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// BuildLimitsConfig is the resource limits of every step of the build.
+type BuildLimitsConfig struct {
+	// StepTimeout fails the build if a step is not completed in it, no limit if 0
+	StepTimeout time.Duration
+	// Memory is the max data segment size in bytes of every process in the
+	// steps, no limit if 0. It does not cap the RSS or the mmap allocations,
+	// the hard limit is by the memory cgroup of CgroupParent.
+	Memory int64
+	// CgroupParent is the cgroup of the steps, which limits the CPU and memory
+	// by the configuration of the builder host
+	CgroupParent string
+}
+
+// ImageMetadata is the author, maintainer and license of the image.
+type ImageMetadata struct {
+	Author     string
+	Maintainer string
+	License    string
+}
+
+// Policy is the declarative rules enforced by the platform teams. The
+// environment violating any of them is rejected before the build.
+type Policy struct {
+	// AllowedBaseImages are the `path.Match` patterns of the approved base images
+	AllowedBaseImages []string `json:"allowed_base_images,omitempty"`
+	// RequiredLabels are the labels the image must have
+	RequiredLabels []string `json:"required_labels,omitempty"`
+	// RequireLanguageVersion rejects the language without a version
+	RequireLanguageVersion bool `json:"require_language_version,omitempty"`
+	// RequirePinnedPackages rejects the packages without a version pin
+	RequirePinnedPackages bool `json:"require_pinned_packages,omitempty"`
+}
+
+// CompressionConfig is the layer compression of the output image.
+type CompressionConfig struct {
+	// Algorithm is one of gzip, zstd, estargz and uncompressed
+	Algorithm string
+	// Level is the compression level, the default of the algorithm is used if nil
+	Level *int
+}
+
+// OutputConfig is the output target of the image, it's overridden by `--output`.
+type OutputConfig struct {
+	// Target is one of docker, registry, oci and tarball
+	Target string
+	// Name is the image reference, the tag of the build is used if it's empty
+	Name string
+	// Path is the OCI layout directory or the docker archive tarball
+	Path string
+	// Insecure allows pushing to the HTTP or self-signed registry
+	Insecure bool
+	// DockerConfigDir is the directory of the `config.json` with the registry
+	// credentials, the default docker config is used if it's empty
+	DockerConfigDir string
+}
+
+// AttestationConfig is the config of the build attestation.
+type AttestationConfig struct {
+	// Output is the path of the generated attestation in the host
+	Output string
+	// SigningKey is the PEM encoded PKCS8 private key to sign the attestation
+	SigningKey string
+}
+
+// AttestationMaterial is an input of the build recorded in the attestation.
+type AttestationMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// JuliaRuntimeConfig is the default flags of Julia at runtime.
+type JuliaRuntimeConfig struct {
+	// HeapSizeHint is the hint of the heap size in bytes, 0 means the default
+	HeapSizeHint int64
+	// GCThreads is the number of the GC threads, 0 means the default
+	GCThreads int
+}
+
+// JuliaREPLConfig is the interactive defaults of Julia. The zero values keep
+// the Julia defaults.
+type JuliaREPLConfig struct {
+	// NoBanner suppresses the startup banner
+	NoBanner bool
+	// Color is `yes` or `no` to force the color output
+	Color string
+	// DisplayRows is the number of the rows to display the arrays
+	DisplayRows int
+}
+
+// JuliaCUDAConfig is the GPU support of Julia by CUDA.jl.
+type JuliaCUDAConfig struct {
+	// Version is the version of CUDA.jl, the latest one if empty
+	Version string
+	// Preload loads CUDA.jl at build time to download the CUDA artifacts
+	Preload bool
+}
+
+// JuliaSysimageConfig is the custom sysimage of Julia built by PackageCompiler.
+type JuliaSysimageConfig struct {
+	// Packages are baked into the sysimage, all the Julia packages if empty
+	Packages []string
+}
+
+// RustConfig is the Rust toolchain installed by rustup.
+type RustConfig struct {
+	// Toolchain is the channel or the release, e.g. stable, 1.75.0
+	Toolchain string
+	// Components are added to the minimal profile, e.g. clippy
+	Components []string
 }
 
 type HTTPInfo struct {
@@ -60,6 +222,14 @@ type CondaConfig struct {
 	UseMicroMamba      bool
 }
 
+// PipToolchain is the pinned versions of pip, setuptools and wheel,
+// empty to keep the one shipped with the Python environment.
+type PipToolchain struct {
+	Pip        string
+	Setuptools string
+	Wheel      string
+}
+
 type GitConfig struct {
 	Name   string
 	Email  string
@@ -71,6 +241,57 @@ type ExposeItem struct {
 	HostPort      int
 	ServiceName   string
 	ListeningAddr string
+
+	// ExposeOnly documents the port in the image without publishing it to the host
+	ExposeOnly bool `json:",omitempty"`
+}
+
+// UserAccount is an additional user created in the image.
+type UserAccount struct {
+	Name string
+	UID  int
+	// Group is the primary group, the user private group with the gid of
+	// UID is created if it's empty
+	Group string
+	// Groups are the supplementary groups
+	Groups []string
+	// Directories are chowned to the user and its primary group
+	Directories []string
+}
+
+// GroupAccount is an additional group created in the image.
+type GroupAccount struct {
+	Name string
+	GID  int
+}
+
+// ServiceDep is an external service that the environment depends on.
+type ServiceDep struct {
+	Name string
+	Host string
+	Port int
+	// Timeout is the seconds to wait for the service to be reachable
+	Timeout int
+}
+
+// SecretMount is the secret file mounted read-only by the orchestrator at
+// runtime. Source is the name of the secret in the orchestrator.
+type SecretMount struct {
+	Source string `json:"source"`
+	Path   string `json:"path"`
+}
+
+// PlatformPackages are the packages installed only for the platform,
+// in place of the default ones.
+type PlatformPackages struct {
+	Platform string
+	Packages []string
+}
+
+// TmuxConfig is the config of the terminal multiplexer tmux.
+type TmuxConfig struct {
+	// DefaultSession generates `envd-tmux` to start the default session layout
+	DefaultSession bool
 }
 
 type JupyterConfig struct {
@@ -78,9 +299,38 @@ type JupyterConfig struct {
 	Port  int64
 }
 
+type StopConfig struct {
+	// Signal is the STOPSIGNAL of the image, e.g. SIGTERM
+	Signal string
+	// GracePeriod is the seconds to wait for the processes to exit
+	GracePeriod int
+}
+
 type RunBuildCommand struct {
 	Commands  []string
 	MountHost bool
+
+	// User runs the commands, the user of the build stage is used if empty
+	User string `json:",omitempty"`
+}
+
+// BuildSecret is only exposed to the build steps that require it,
+// it will not be persisted in the image layers.
+type BuildSecret struct {
+	ID string
+	// Env is the environment variable in the host that holds the secret.
+	Env string
+	// File is the file in the host that holds the secret.
+	File string `json:",omitempty"`
+	// Target is the path where the secret is mounted in the install steps,
+	// it's only mounted in the steps that require it if empty.
+	Target string `json:",omitempty"`
+}
+
+type JuliaRegistry struct {
+	URL string
+	// Secret is the ID of the build secret that holds the access token.
+	Secret string
 }
 
 type APTConfig struct {
@@ -15,7 +15,10 @@
 package ir
 
 import (
+	"bytes"
 	"encoding/json"
+	"os"
+	"path"
 
 	"github.com/cockroachdb/errors"
 )
@@ -36,3 +39,24 @@ func (rg *RuntimeGraph) Load(code []byte) error {
 	}
 	return nil
 }
+
+// LoadPolicy reads the policy from the JSON file. Unknown rules are rejected
+// to avoid the typos silently disabling a rule.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the policy file %s", file)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var policy Policy
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the policy file %s", file)
+	}
+	for _, pattern := range policy.AllowedBaseImages {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid base image pattern %s", pattern)
+		}
+	}
+	return &policy, nil
+}
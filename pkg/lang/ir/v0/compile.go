@@ -102,10 +102,38 @@ func (g generalGraph) GetRuntimeCommands() map[string]string {
 	return g.RuntimeCommands
 }
 
+func (g generalGraph) GetRuntimeTests() [][]string {
+	return nil
+}
+
+func (g generalGraph) GetRuntimeGPU() *ir.GPUConfig {
+	return nil
+}
+
+func (g generalGraph) GetBuildLimits() *ir.BuildLimitsConfig {
+	return nil
+}
+
 func (g generalGraph) GetUser() string {
 	return "envd"
 }
 
+func (g generalGraph) GetVSCodeExtensions() []string {
+	extensions := make([]string, 0, len(g.VSCodePlugins))
+	for _, p := range g.VSCodePlugins {
+		id := fmt.Sprintf("%s.%s", p.Publisher, p.Extension)
+		if p.Version != nil {
+			id += "@" + *p.Version
+		}
+		extensions = append(extensions, id)
+	}
+	return extensions
+}
+
+func (g generalGraph) GetVSCodeSettings() map[string]interface{} {
+	return nil
+}
+
 func (g *generalGraph) Compile(ctx context.Context, envName string, pub string) (*llb.Definition, error) {
 	w, err := compileui.New(ctx, os.Stdout, "auto")
 	if err != nil {
@@ -159,6 +187,11 @@ func (g generalGraph) Labels() (map[string]string, error) {
 		return nil, err
 	}
 	labels[types.ImageLabelR] = string(str)
+	str, err = json.Marshal(g.JuliaPackages)
+	if err != nil {
+		return nil, err
+	}
+	labels[types.ImageLabelJulia] = string(str)
 	if g.GPUEnabled() {
 		labels[types.ImageLabelGPU] = "true"
 		labels[types.ImageLabelCUDA] = *g.CUDA
@@ -267,6 +300,41 @@ func (g *generalGraph) SetWriter(w compileui.Writer) {
 	g.Writer = w
 }
 
+func (g generalGraph) GetBuildSecrets() []ir.BuildSecret {
+	return nil
+}
+
+func (g generalGraph) GetPlatform() string {
+	return "linux/amd64"
+}
+
+func (g *generalGraph) SetPlatform(platform string) error {
+	if platform != "linux/amd64" {
+		return errors.Newf("platform %s is not supported by the v0 syntax, only linux/amd64 is supported", platform)
+	}
+	return nil
+}
+
+func (g generalGraph) GetStopConfig() *ir.StopConfig {
+	return nil
+}
+
+func (g generalGraph) GetAttestationConfig() *ir.AttestationConfig {
+	return nil
+}
+
+func (g generalGraph) GetCompressionConfig() *ir.CompressionConfig {
+	return nil
+}
+
+func (g generalGraph) GetOutputConfig() *ir.OutputConfig {
+	return nil
+}
+
+func (g generalGraph) GetAttestationMaterials() []ir.AttestationMaterial {
+	return nil
+}
+
 func (g generalGraph) GetHTTP() []ir.HTTPInfo {
 	return g.HTTP
 }
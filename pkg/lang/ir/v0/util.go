@@ -16,6 +16,7 @@ package v0
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/gob"
 	"encoding/hex"
@@ -26,6 +27,7 @@ import (
 	"strings"
 
 	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
@@ -136,6 +138,26 @@ func (g *generalGraph) Load(code []byte) error {
 	return nil
 }
 
+func (g *generalGraph) ExportReproducer(image string, packages map[string][]string) ([]byte, error) {
+	return nil, errors.New("reproducer is only supported in v1")
+}
+
+func (g generalGraph) CheckPolicy(policy ir.Policy) error {
+	return errors.New("policy check is only supported in v1")
+}
+
+func (g generalGraph) CompileResolution(ctx context.Context) (*llb.Definition, error) {
+	return nil, errors.New("dependency resolution is only supported in v1")
+}
+
+func (g generalGraph) ResolveBaseImage(ctx context.Context) (string, error) {
+	return "", errors.New("dependency resolution is only supported in v1")
+}
+
+func (g generalGraph) CompileLock(ctx context.Context) (*llb.Definition, error) {
+	return nil, errors.New("dependency lock is only supported in v1")
+}
+
 func (g generalGraph) GeneralGraphFromLabel(label []byte) (ir.Graph, error) {
 	newg := generalGraph{}
 	err := newg.Load(label)
@@ -0,0 +1,75 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+// GetAttestationMaterials returns the inputs of the build. The packages are
+// recorded as package URLs without digest, since they are resolved in the build.
+func (g generalGraph) GetAttestationMaterials() []ir.AttestationMaterial {
+	materials := []ir.AttestationMaterial{}
+
+	base := ir.AttestationMaterial{URI: fmt.Sprintf("pkg:docker/%s", g.Image)}
+	if g.baseImageDigest != "" {
+		base.Digest = map[string]string{
+			g.baseImageDigest.Algorithm().String(): g.baseImageDigest.Encoded(),
+		}
+	}
+	materials = append(materials, base)
+
+	if g.Language.Name == "julia" {
+		// the invalid version fails the build before the attestation
+		url, sha256, _ := g.juliaDistribution()
+		materials = append(materials, ir.AttestationMaterial{
+			URI:    url,
+			Digest: map[string]string{"sha256": sha256},
+		})
+	}
+
+	for _, h := range g.HTTP {
+		m := ir.AttestationMaterial{URI: h.URL}
+		if h.Checksum != "" {
+			m.Digest = map[string]string{h.Checksum.Algorithm().String(): h.Checksum.Encoded()}
+		}
+		materials = append(materials, m)
+	}
+
+	packages := func(purlType string, names []string) {
+		for _, name := range names {
+			materials = append(materials, ir.AttestationMaterial{
+				URI: fmt.Sprintf("pkg:%s/%s", purlType, strings.TrimSpace(name)),
+			})
+		}
+	}
+	packages("deb", g.SystemPackages)
+	for _, p := range g.PyPIPackages {
+		packages("pypi", p)
+	}
+	if g.CondaConfig != nil {
+		packages("conda", g.CondaConfig.CondaPackages)
+	}
+	for _, p := range g.RPackages {
+		packages("cran", p)
+	}
+	for _, p := range g.juliaPackages(g.platform()) {
+		packages("julia", p)
+	}
+	return materials
+}
@@ -16,7 +16,9 @@ package v1
 
 import (
 	"fmt"
+	"path/filepath"
 
+	"github.com/moby/buildkit/client/llb"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,3 +32,32 @@ func (g generalGraph) CacheID(filename string) string {
 	logrus.Debugf("apt/pypi calculated cacheID: %s", cacheID)
 	return cacheID
 }
+
+// languageCacheDir returns the cache dir of the language under the
+// common parent `config.cache_dir`, or empty if it is not configured.
+func (g generalGraph) languageCacheDir(lang string) string {
+	if g.LanguageCacheDir == nil {
+		return ""
+	}
+	return filepath.Join(*g.LanguageCacheDir, lang)
+}
+
+func (g *generalGraph) compileLanguageCacheDir(root llb.State) llb.State {
+	if g.LanguageCacheDir == nil {
+		return root
+	}
+	g.RuntimeEnviron["PIP_CACHE_DIR"] = g.languageCacheDir("pip")
+	g.RuntimeEnviron["CONDA_PKGS_DIRS"] = g.languageCacheDir("conda")
+	langs := []string{"julia", "pip", "conda"}
+	// the npm cache is only used with `install.node`
+	if g.NodeVersion != nil {
+		g.RuntimeEnviron["NPM_CONFIG_CACHE"] = g.languageCacheDir("npm")
+		langs = append(langs, "npm")
+	}
+	for _, lang := range langs {
+		dir := g.languageCacheDir(lang)
+		root = root.File(llb.Mkdir(dir, 0755, llb.WithParents(true), llb.WithUIDGID(g.uid, g.gid)),
+			llb.WithCustomNamef("[internal] create %s cache dir %s", lang, dir))
+	}
+	return root
+}
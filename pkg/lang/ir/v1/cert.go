@@ -0,0 +1,146 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+const (
+	trustedCertDir    = "/etc/envd/certs"
+	systemCertsBundle = "/etc/ssl/certs/ca-certificates.crt"
+	// caCertDir is read by update-ca-certificates of Debian and Alpine
+	caCertDir = "/usr/local/share/ca-certificates/envd"
+)
+
+// trustedCertEnvs are the environments to specify the CA bundle of each language.
+var trustedCertEnvs = map[string]string{
+	"python": "PIP_CERT",
+	"conda":  "CONDA_SSL_VERIFY",
+	"julia":  "JULIA_SSL_CA_ROOTS_PATH",
+}
+
+// caCertEnvs point the tools without the system trust store by default to
+// the system CA bundle if config.ca_cert is declared.
+var caCertEnvs = []string{
+	"SSL_CERT_FILE",
+	"CURL_CA_BUNDLE",
+	"REQUESTS_CA_BUNDLE",
+	"PIP_CERT",
+	"CONDA_SSL_VERIFY",
+	"NODE_EXTRA_CA_CERTS",
+	"CARGO_HTTP_CAINFO",
+	"JULIA_SSL_CA_ROOTS_PATH",
+}
+
+// caCertScript regenerates the system CA bundle, or appends the CAs to the
+// bundle if update-ca-certificates is not installed in the base image. The
+// appended CAs are enclosed by the markers, and they are replaced instead of
+// appended again when the bundle is updated later.
+var caCertScript = fmt.Sprintf(`set -e
+if command -v update-ca-certificates > /dev/null; then
+  update-ca-certificates > /dev/null
+else
+  mkdir -p %[1]s
+  touch %[3]s
+  sed -i '/^# BEGIN envd CA certs$/,/^# END envd CA certs$/d' %[3]s
+  { echo '# BEGIN envd CA certs'; cat %[2]s/*.crt; echo '# END envd CA certs'; } >> %[3]s
+fi
+`, filepath.Dir(systemCertsBundle), caCertDir, systemCertsBundle)
+
+// compileCACerts installs the CAs into the system trust store, which is used
+// by apt-get, wget and curl in the following steps.
+func (g *generalGraph) compileCACerts(root llb.State) llb.State {
+	if len(g.CACerts) == 0 {
+		return root
+	}
+	for _, env := range caCertEnvs {
+		g.RuntimeEnviron[env] = systemCertsBundle
+	}
+	return g.installCACerts(root)
+}
+
+// compileBuilderImage returns the image of the download steps. The CAs are
+// installed into it the same way as into the base image, since the CA bundle
+// is set for every step by injectBuildEnv. The bundle is regenerated by root,
+// the image runs as curl_user.
+func (g generalGraph) compileBuilderImage() llb.State {
+	return g.installCACerts(llb.Image(builderImage), llb.User("root"))
+}
+
+// installCACerts writes the CAs and regenerates the system CA bundle.
+func (g generalGraph) installCACerts(root llb.State, opts ...llb.RunOption) llb.State {
+	if len(g.CACerts) == 0 {
+		return root
+	}
+	root = root.File(llb.Mkdir(caCertDir, 0755, llb.WithParents(true)),
+		llb.WithCustomName("[internal] create dir for CA certs"))
+	for i, c := range g.CACerts {
+		root = root.File(llb.Mkfile(filepath.Join(caCertDir, fmt.Sprintf("envd-%d.crt", i)),
+			0644, []byte(strings.TrimSpace(c)+"\n")),
+			llb.WithCustomNamef("[internal] add CA cert %d", i))
+	}
+	return g.updateCACerts(root, opts...)
+}
+
+// updateCACerts regenerates the system CA bundle, which is overwritten by the
+// installation of micromamba.
+func (g generalGraph) updateCACerts(root llb.State, opts ...llb.RunOption) llb.State {
+	if len(g.CACerts) == 0 {
+		return root
+	}
+	return root.Run(append([]llb.RunOption{llb.Args([]string{"sh", "-c", caCertScript}),
+		llb.WithCustomName("[internal] update the system CA bundle")}, opts...)...).Root()
+}
+
+// compileTrustedCerts generates a CA bundle for every language with trusted
+// certs, which contains the system CAs and the certs of the declared servers.
+func (g *generalGraph) compileTrustedCerts(root llb.State) llb.State {
+	if len(g.TrustedCerts) == 0 {
+		return root
+	}
+
+	certs := make(map[string][]string)
+	for _, c := range g.TrustedCerts {
+		certs[c.Language] = append(certs[c.Language],
+			fmt.Sprintf("# %s\n%s", c.URL, strings.TrimSpace(c.Content)))
+	}
+	languages := make([]string, 0, len(certs))
+	for lang := range certs {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	root = root.File(llb.Mkdir(trustedCertDir, 0755, llb.WithParents(true)),
+		llb.WithCustomName("[internal] create dir for trusted certs"))
+	for _, lang := range languages {
+		extra := filepath.Join(trustedCertDir, lang+"-extra.pem")
+		bundle := filepath.Join(trustedCertDir, lang+".pem")
+		root = root.
+			File(llb.Mkfile(extra, 0644, []byte(strings.Join(certs[lang], "\n")+"\n")),
+				llb.WithCustomNamef("[internal] add trusted certs for %s", lang)).
+			Run(llb.Shlexf(`sh -c "cat %s %s > %s 2>/dev/null || cp %s %s"`,
+				systemCertsBundle, extra, bundle, extra, bundle),
+				llb.WithCustomNamef("[internal] generate CA bundle for %s", lang)).Root().
+			AddEnv(trustedCertEnvs[lang], bundle)
+		g.RuntimeEnviron[trustedCertEnvs[lang]] = bundle
+	}
+	return root
+}
@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"github.com/cockroachdb/errors"
+	"github.com/containerd/containerd/platforms"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/sirupsen/logrus"
 	servertypes "github.com/tensorchord/envd-server/api/types"
@@ -56,7 +57,12 @@ func NewGraph() ir.Graph {
 		Exec:            []ir.RunBuildCommand{},
 		UserDirectories: []string{},
 		Shell:           shellBASH,
+		Features:        map[string]bool{},
 		RuntimeGraph:    runtimeGraph,
+		StopConfig: &ir.StopConfig{
+			Signal:      defaultStopSignal,
+			GracePeriod: defaultStopGracePeriod,
+		},
 	}
 }
 
@@ -70,6 +76,56 @@ func (g generalGraph) GetHTTP() []ir.HTTPInfo {
 	return g.HTTP
 }
 
+func (g generalGraph) GetBuildSecrets() []ir.BuildSecret {
+	return g.BuildSecrets
+}
+
+func (g generalGraph) GetPlatform() string {
+	return g.platform()
+}
+
+// SetPlatform builds the image for the platform, the architecture specific
+// artifacts, e.g. the Julia release, are downloaded for it.
+func (g *generalGraph) SetPlatform(platform string) error {
+	if strings.Contains(platform, ",") {
+		return errors.Newf("multi-platform build %s is not supported, build the platforms one by one", platform)
+	}
+	p, err := platforms.Parse(platform)
+	if err != nil {
+		return errors.Wrapf(err, "invalid platform: %s", platform)
+	}
+	normalized := platforms.Format(platforms.Normalize(p))
+	if !supportedPlatforms[normalized] {
+		return errors.Newf("unsupported platform %s, only linux/amd64 and linux/arm64 are supported", platform)
+	}
+	g.Platform = normalized
+	return nil
+}
+
+// platform returns the normalized platform the image is built for.
+func (g generalGraph) platform() string {
+	if g.Platform == "" {
+		return defaultPlatform
+	}
+	return g.Platform
+}
+
+func (g generalGraph) GetStopConfig() *ir.StopConfig {
+	return g.StopConfig
+}
+
+func (g generalGraph) GetAttestationConfig() *ir.AttestationConfig {
+	return g.AttestationConfig
+}
+
+func (g generalGraph) GetCompressionConfig() *ir.CompressionConfig {
+	return g.CompressionConfig
+}
+
+func (g generalGraph) GetOutputConfig() *ir.OutputConfig {
+	return g.OutputConfig
+}
+
 func (g generalGraph) GetNumGPUs() int {
 	return g.NumGPUs
 }
@@ -102,6 +158,18 @@ func (g generalGraph) GetRuntimeCommands() map[string]string {
 	return g.RuntimeCommands
 }
 
+func (g generalGraph) GetRuntimeTests() [][]string {
+	return g.RuntimeTests
+}
+
+func (g generalGraph) GetRuntimeGPU() *ir.GPUConfig {
+	return g.RuntimeGPU
+}
+
+func (g generalGraph) GetBuildLimits() *ir.BuildLimitsConfig {
+	return g.BuildLimits
+}
+
 func (g *generalGraph) Compile(ctx context.Context, envName string, pub string) (*llb.Definition, error) {
 	w, err := compileui.New(ctx, os.Stdout, "auto")
 	if err != nil {
@@ -119,12 +187,11 @@ func (g *generalGraph) Compile(ctx context.Context, envName string, pub string)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to compile the graph")
 	}
-	// TODO(gaocegege): Support multi platform.
-	def, err := state.Marshal(ctx, llb.LinuxAmd64)
+	def, err := state.Marshal(ctx, llb.Platform(platforms.MustParse(g.platform())), llb.Require(g.WorkerConstraints...))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal the llb definition")
 	}
-	return def, nil
+	return g.injectBuildEnv(def)
 }
 
 func (g generalGraph) GetEnviron() []string {
@@ -138,6 +205,25 @@ func (g generalGraph) GetUser() string {
 	return g.User
 }
 
+func (g generalGraph) GetVSCodeExtensions() []string {
+	extensions := make([]string, 0, len(g.VSCodePlugins))
+	for _, p := range g.VSCodePlugins {
+		id := fmt.Sprintf("%s.%s", p.Publisher, p.Extension)
+		if p.Version != nil {
+			id += "@" + *p.Version
+		}
+		extensions = append(extensions, id)
+	}
+	return extensions
+}
+
+func (g generalGraph) GetVSCodeSettings() map[string]interface{} {
+	if !g.hasVSCodeSettings() {
+		return nil
+	}
+	return g.vscodeSettings()
+}
+
 func (g generalGraph) GPUEnabled() bool {
 	return g.CUDA != nil
 }
@@ -166,6 +252,15 @@ func (g generalGraph) Labels() (map[string]string, error) {
 		return nil, err
 	}
 	labels[types.ImageLabelR] = string(str)
+	juliaPackages := []string{}
+	for _, pkg := range g.juliaPackages(g.platform()) {
+		juliaPackages = append(juliaPackages, pkg...)
+	}
+	str, err = json.Marshal(juliaPackages)
+	if err != nil {
+		return nil, err
+	}
+	labels[types.ImageLabelJulia] = string(str)
 	if g.GPUEnabled() {
 		labels[types.ImageLabelGPU] = "true"
 		labels[types.ImageLabelCUDA] = *g.CUDA
@@ -223,6 +318,16 @@ func (g generalGraph) Labels() (map[string]string, error) {
 	}
 	labels[types.ImageLabelRepo] = string(repoInfo)
 
+	if g.Metadata.Author != "" {
+		labels[types.ImageLabelAuthors] = g.Metadata.Author
+	}
+	if g.Metadata.Maintainer != "" {
+		labels[types.ImageLabelMaintainer] = g.Metadata.Maintainer
+	}
+	if g.Metadata.License != "" {
+		labels[types.ImageLabelLicenses] = g.Metadata.License
+	}
+
 	labels[types.ImageLabelContainerName] = g.EnvironmentName
 	return labels, nil
 }
@@ -267,25 +372,48 @@ func (g generalGraph) DefaultCacheImporter() (*string, error) {
 }
 
 func (g *generalGraph) GetEntrypoint(buildContextDir string) ([]string, error) {
-	if !g.Dev {
-		return g.Entrypoint, nil
+	ep := g.Entrypoint
+	if g.Dev {
+		g.RuntimeEnviron[types.EnvdWorkDir] = fileutil.EnvdHomeDir(filepath.Base(buildContextDir))
+		ep = []string{"horust"}
+	}
+	// wait for the dependencies before starting the entrypoint
+	if len(g.RuntimeDependsOn) > 0 {
+		ep = append([]string{waitDepsScriptPath}, ep...)
+	}
+	// the init process is PID 1, and the entrypoint is its child
+	if name := g.initProcess(); name != "" {
+		ep = append(append([]string{}, initProcessCommands[name]...), ep...)
 	}
-	g.RuntimeEnviron[types.EnvdWorkDir] = fileutil.EnvdHomeDir(filepath.Base(buildContextDir))
-	return []string{"horust"}, nil
+	return ep, nil
 }
 
 func (g *generalGraph) CompileLLB(uid, gid int) (llb.State, error) {
 	g.uid = uid
 	g.gid = gid
+	g.checks = nil
+	g.lock = nil
 	logrus.WithFields(logrus.Fields{
 		"uid": g.uid,
 		"gid": g.gid,
 	}).Debug("compile LLB")
 
+	if err := g.checkJuliaRelocatableDepot(); err != nil {
+		return llb.State{}, err
+	}
+	if err := g.checkJuliaOffline(); err != nil {
+		return llb.State{}, err
+	}
+	if err := g.checkCUDAFrameworks(); err != nil {
+		return llb.State{}, err
+	}
+
 	base, err := g.compileBaseImage()
 	if err != nil {
 		return llb.State{}, errors.Wrap(err, "failed to get the base image")
 	}
+	base = g.compileLanguageCacheDir(g.compileCACerts(base))
+	base = g.compileCUDAArch(base)
 
 	// prepare dev env: stable operations should be done here to make it cache friendly
 	if g.Dev {
@@ -296,21 +424,48 @@ func (g *generalGraph) CompileLLB(uid, gid int) (llb.State, error) {
 		userGroup := g.compileUserGroup(starship)
 		base = userGroup
 	}
+	base, err = g.compileAccounts(base)
+	if err != nil {
+		return llb.State{}, errors.Wrap(err, "failed to create the users and groups")
+	}
+	base, err = g.runLLBHooks(HookAfterBase, base)
+	if err != nil {
+		return llb.State{}, err
+	}
 
 	lang, err := g.compileLanguage(base)
 	if err != nil {
 		return llb.State{}, errors.Wrap(err, "failed to compile language")
 	}
+	if g.NodeVersion != nil {
+		lang = g.installNode(lang)
+	}
+	if g.RustConfig != nil {
+		lang = g.installRust(lang)
+	}
+	lang, err = g.runLLBHooks(HookAfterLanguage, lang)
+	if err != nil {
+		return llb.State{}, err
+	}
 	aptMirror := g.compileUbuntuAPT(base)
-	systemPackages := g.compileSystemPackages(aptMirror)
+	systemPackages, err := g.runLLBHooks(HookAfterSystemPackages, g.compileSystemPackages(aptMirror))
+	if err != nil {
+		return llb.State{}, err
+	}
 	merge := llb.Merge([]llb.State{
 		base,
 		llb.Diff(base, lang, llb.WithCustomName("[internal] prepare language")),
 		llb.Diff(base, systemPackages, llb.WithCustomName("[internal] install system packages")),
 	}, llb.WithCustomName("[internal] language environment and system packages"))
-	packages := g.compileLanguagePackages(merge)
+	packages, err := g.runLLBHooks(HookAfterLanguagePackages, g.compileLanguagePackages(merge))
 	if err != nil {
-		return llb.State{}, errors.Wrap(err, "failed to compile language")
+		return llb.State{}, err
+	}
+	if g.SlimRuntime {
+		packages, err = g.compileSlimRuntime(base, systemPackages, packages)
+		if err != nil {
+			return llb.State{}, errors.Wrap(err, "failed to compile slim runtime")
+		}
 	}
 
 	source, err := g.compileExtraSource(packages)
@@ -332,7 +487,11 @@ func (g *generalGraph) CompileLLB(uid, gid int) (llb.State, error) {
 			return llb.State{}, errors.Wrap(err, "failed to compile shell")
 		}
 		prompt := g.compilePrompt(shell)
-		entrypoint, err := g.compileEntrypoint(prompt)
+		settings, err := g.compileVSCodeSettings(prompt)
+		if err != nil {
+			return llb.State{}, errors.Wrap(err, "failed to compile VSCode settings")
+		}
+		entrypoint, err := g.compileEntrypoint(settings)
 		if err != nil {
 			return llb.State{}, errors.Wrap(err, "failed to compile entrypoint")
 		}
@@ -344,12 +503,42 @@ func (g *generalGraph) CompileLLB(uid, gid int) (llb.State, error) {
 			entrypoint,
 			vscode,
 		}, llb.WithCustomName("[internal] final dev environment"))
+	} else {
+		copy = g.compileAccountOwn(copy)
 	}
 
 	// it's necessary to exec `run` with the desired user
 	run := g.compileRun(copy)
 	mount := g.compileMountDir(run)
+	artifacts := g.compileArtifacts(mount)
+	deps := g.compileDependsOn(artifacts)
+	initProcess := g.compileInitProcess(deps)
+	info, err := g.compileEnvironmentInfo(initProcess)
+	if err != nil {
+		return llb.State{}, err
+	}
+	final, err := g.runLLBHooks(HookFinal, info)
+	if err != nil {
+		return llb.State{}, err
+	}
+
+	g.lock = g.compileLock(base, final)
 
 	g.Writer.Finish()
-	return mount, nil
+	return g.compileChecks(final), nil
+}
+
+// compileChecks runs the checks along with the image by merging their empty
+// diffs into it. The checks are never cached, thus they are kept out of the
+// chain of the image layers, otherwise all the steps after them run again in
+// every build.
+func (g generalGraph) compileChecks(root llb.State) llb.State {
+	if len(g.checks) == 0 {
+		return root
+	}
+	states := []llb.State{root}
+	for _, check := range g.checks {
+		states = append(states, llb.Diff(check, check, llb.WithCustomName("[internal] discarding the changes of the checks")))
+	}
+	return llb.Merge(states, llb.WithCustomName("[internal] running the checks of the build"))
 }
@@ -23,8 +23,6 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/sirupsen/logrus"
-
-	"github.com/tensorchord/envd/pkg/flag"
 )
 
 const (
@@ -124,9 +122,9 @@ func (g *generalGraph) compileCondaPackages(root llb.State) llb.State {
 	cmd := sb.String()
 	run = root.Dir(g.getWorkingDir()).
 		AddEnv("MAMBA_ROOT_PREFIX", condaRootPrefix).
-		Run(llb.Shlex(cmd), llb.WithCustomNamef("[internal] %s %s",
+		Run(llb.Shlex(cmd), g.gpuStageConstraint(), g.mountSecrets(), llb.WithCustomNamef("[internal] %s %s",
 			cmd, strings.Join(g.CondaConfig.CondaPackages, " ")))
-	run.AddMount(g.getWorkingDir(), llb.Local(flag.FlagBuildContext))
+	run.AddMount(g.getWorkingDir(), dependencyContext(g.CondaEnvFiles))
 	run.AddMount(cacheDir, cacheMount,
 		llb.AsPersistentCacheDir(g.CacheID(cacheDir), llb.CacheMountShared), llb.SourcePath("/cache-conda"))
 	return run.Root()
@@ -164,7 +162,7 @@ func (g *generalGraph) installConda(root llb.State) llb.State {
 }
 
 func (g generalGraph) installMiniConda(root llb.State) llb.State {
-	base := llb.Image(builderImage)
+	base := g.compileBuilderImage()
 	builder := base.AddEnv("CONDA_VERSION", condaVersionDefault).
 		Run(llb.Shlexf("sh -c '%s'", downloadCondaBash),
 			llb.WithCustomName("[internal] download conda")).Root()
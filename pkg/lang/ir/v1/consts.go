@@ -14,7 +14,11 @@
 
 package v1
 
-import "github.com/tensorchord/envd/pkg/util/fileutil"
+import (
+	"regexp"
+
+	"github.com/tensorchord/envd/pkg/util/fileutil"
+)
 
 const (
 	defaultImage        = "ubuntu:20.04"
@@ -23,6 +27,31 @@ const (
 	aptSourceFilePath = "/etc/apt/sources.list"
 	pypiIndexFilePath = "/etc/pip.conf"
 
+	defaultEntryScriptLocation = "/etc/profile.d/envd.sh"
+
+	defaultStopSignal      = "SIGTERM"
+	defaultStopGracePeriod = 5
+
+	waitDepsScriptPath = "/usr/local/bin/envd-wait-deps"
+	waitDepsTemplate   = `
+wait_for() {
+	name="$1"; host="$2"; port="$3"; timeout="$4"
+	start=$(date +%%s)
+	until (echo > "/dev/tcp/${host}/${port}") 2>/dev/null; do
+		if [ "${timeout}" -gt 0 ] && [ $(($(date +%%s) - start)) -ge "${timeout}" ]; then
+			echo "envd: ${name} (${host}:${port}) is not reachable after ${timeout}s" >&2
+			exit 1
+		fi
+		sleep 1
+	done
+	echo "envd: ${name} (${host}:${port}) is reachable"
+}
+%s
+if [ $# -gt 0 ]; then
+	exec "$@"
+fi
+`
+
 	pypiConfigTemplate = `
 [global]
 index-url=%s
@@ -34,7 +63,78 @@ src = /tmp
 `
 )
 
+// defaultPlatform is the normalized platform the image is built for, unless
+// it's set by `config.platform`.
+const defaultPlatform = "linux/amd64"
+
+// supportedPlatforms are the platforms the image can be built for.
+var supportedPlatforms = map[string]bool{
+	"linux/amd64": true,
+	"linux/arm64": true,
+}
+
 var (
+	// RFC 1123 hostname
+	hostnameRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?)*$`)
+	// PEP 440 public version, e.g. 23.0.1, 1.0rc1
+	pythonVersionRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*((a|b|rc)[0-9]+)?(\.post[0-9]+)?(\.dev[0-9]+)?$`)
+	// name or `uid[:gid]` of the user, e.g. envd, 1000:1000
+	userRegex = regexp.MustCompile(`^([a-z_][a-z0-9_\-]*\$?|[0-9]+(:[0-9]+)?)$`)
+	// name of the user or group account, e.g. www-data
+	accountNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_\-]{0,31}$`)
+	// Julia release, e.g. 1.6.7, 1.10.0-rc1
+	juliaVersionRegex = regexp.MustCompile(`^([0-9]+)\.([0-9]+)\.[0-9]+(-(alpha|beta|rc)[0-9]*)?$`)
+	// name of the registered Julia package, e.g. DataFrames
+	juliaPackageNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// version of the Julia package, e.g. 1.5.0, 0.13 for any 0.13.x
+	juliaPackageVersionRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,2}$`)
+	// Julia package on GitHub, e.g. JuliaLang/Example.jl
+	juliaGitHubRepoRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+$`)
+	// number of Julia threads, optionally with the interactive ones, e.g. auto, 4, 4,1
+	juliaThreadsRegex = regexp.MustCompile(`^(auto|[1-9][0-9]*)(,[0-9]+)?$`)
+	// SHA1 git tree hash of the Julia artifact
+	juliaArtifactHashRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	// Node.js release, e.g. 20.11.1
+	nodeVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+	// Rust toolchain by rustup, e.g. stable, nightly-2024-01-01, 1.75.0
+	rustToolchainRegex = regexp.MustCompile(`^(stable|beta|nightly|[0-9]+\.[0-9]+(\.[0-9]+)?)(-[0-9]{4}-[0-9]{2}-[0-9]{2})?$`)
+	// name of the rustup component, e.g. rust-src
+	rustComponentRegex = regexp.MustCompile(`^[a-z][a-z0-9\-]*$`)
+	// name of the secret in the orchestrator
+	secretNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]*$`)
+	// name of the docker volume, e.g. huggingface-cache
+	volumeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]+$`)
+	// GPU or MIG device by the index or UUID, e.g. 0, 0:1, GPU-<uuid> or MIG-<uuid>
+	gpuDeviceRegex = regexp.MustCompile(`^([0-9]+(:[0-9]+)?|(GPU|MIG)-[0-9a-zA-Z/\-]+)$`)
+	// capabilities of the NVIDIA driver
+	gpuCapabilities = map[string]bool{
+		"compute": true, "compat32": true, "graphics": true,
+		"utility": true, "video": true, "display": true,
+	}
+
+	// commands to generate the completion script for the installed CLIs
+	shellCompletionCommands = map[string]map[string]string{
+		"pip": {
+			shellBASH: "pip completion --bash",
+			shellZSH:  "pip completion --zsh",
+		},
+		"starship": {
+			shellBASH: "starship completions bash",
+			shellZSH:  "starship completions zsh",
+		},
+	}
+
+	// signals that can be forwarded by horust
+	stopSignals = map[string]struct{}{
+		"SIGTERM": {},
+		"SIGINT":  {},
+		"SIGQUIT": {},
+		"SIGHUP":  {},
+		"SIGUSR1": {},
+		"SIGUSR2": {},
+		"SIGKILL": {},
+	}
+
 	// used inside the container
 	defaultConfigDir   = fileutil.EnvdHomeDir(".config")
 	starshipConfigPath = fileutil.EnvdHomeDir(".config", "starship.toml")
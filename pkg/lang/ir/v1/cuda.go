@@ -0,0 +1,288 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+)
+
+var (
+	// CUDA version, e.g. 12.1 or 12.1.1
+	cudaVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+	// the pinned framework in the PyPI packages, e.g. torch==2.1.0
+	cudaFrameworkRegex = regexp.MustCompile(`^(torch|tensorflow|tensorflow-gpu)\s*==\s*([0-9]+\.[0-9]+)`)
+)
+
+// cudaReleases are the latest patch releases of the nvidia/cuda images, to
+// expand the `major.minor` version.
+var cudaReleases = map[string]string{
+	"11.0": "11.0.3",
+	"11.1": "11.1.1",
+	"11.2": "11.2.2",
+	"11.3": "11.3.1",
+	"11.4": "11.4.3",
+	"11.5": "11.5.2",
+	"11.6": "11.6.2",
+	"11.7": "11.7.1",
+	"11.8": "11.8.0",
+	"12.0": "12.0.1",
+	"12.1": "12.1.1",
+	"12.2": "12.2.2",
+	"12.3": "12.3.2",
+	"12.4": "12.4.1",
+	"12.5": "12.5.1",
+	"12.6": "12.6.3",
+	"12.8": "12.8.1",
+}
+
+// cudnnVersions are the cuDNN major versions of the nvidia/cuda images since
+// the CUDA version, the first one is the default.
+var cudnnVersions = []struct {
+	version [2]int
+	cudnn   []string
+}{
+	{version: [2]int{10, 0}, cudnn: []string{"8", "7"}},
+	{version: [2]int{11, 0}, cudnn: []string{"8"}},
+	{version: [2]int{12, 3}, cudnn: []string{"8", "9"}},
+	{version: [2]int{12, 4}, cudnn: []string{"9"}},
+}
+
+// cudaFrameworks are the CUDA versions of the official builds of the
+// frameworks. The PyTorch wheels bundle the CUDA runtime and cuDNN, thus only
+// the CUDA major version is required to match, e.g. for the extensions. The
+// TensorFlow builds require the CUDA of the same major and newer minor, and the
+// cuDNN of the same major version.
+var cudaFrameworks = []struct {
+	name     string
+	versions []string
+	cuda     []string
+	cudnn    string
+}{
+	{name: "torch", versions: []string{"1.10"}, cuda: []string{"10.2", "11.3"}},
+	{name: "torch", versions: []string{"1.11"}, cuda: []string{"10.2", "11.3", "11.5"}},
+	{name: "torch", versions: []string{"1.12"}, cuda: []string{"10.2", "11.3", "11.6"}},
+	{name: "torch", versions: []string{"1.13"}, cuda: []string{"11.6", "11.7"}},
+	{name: "torch", versions: []string{"2.0"}, cuda: []string{"11.7", "11.8"}},
+	{name: "torch", versions: []string{"2.1", "2.2", "2.3"}, cuda: []string{"11.8", "12.1"}},
+	{name: "torch", versions: []string{"2.4", "2.5"}, cuda: []string{"11.8", "12.1", "12.4"}},
+	{name: "torch", versions: []string{"2.6"}, cuda: []string{"11.8", "12.4", "12.6"}},
+	{name: "torch", versions: []string{"2.7"}, cuda: []string{"11.8", "12.6", "12.8"}},
+	{name: "tensorflow", versions: []string{"2.5", "2.6", "2.7", "2.8", "2.9", "2.10", "2.11"},
+		cuda: []string{"11.2"}, cudnn: "8"},
+	{name: "tensorflow", versions: []string{"2.12", "2.13", "2.14"}, cuda: []string{"11.8"}, cudnn: "8"},
+	{name: "tensorflow", versions: []string{"2.15"}, cuda: []string{"12.2"}, cudnn: "8"},
+	{name: "tensorflow", versions: []string{"2.16", "2.17"}, cuda: []string{"12.3"}, cudnn: "8"},
+	{name: "tensorflow", versions: []string{"2.18", "2.19"}, cuda: []string{"12.5"}, cudnn: "9"},
+}
+
+// cudaRelease expands the `major.minor` version to the patch release of the
+// nvidia/cuda images.
+func cudaRelease(version string) (string, error) {
+	if !cudaVersionRegex.MatchString(version) {
+		return "", errors.Newf("invalid CUDA version %s, should be like 12.1 or 12.1.1", version)
+	}
+	if strings.Count(version, ".") == 2 {
+		return version, nil
+	}
+	release, ok := cudaReleases[version]
+	if !ok {
+		return "", errors.Newf("unknown CUDA release %s, specify the full version like %s.0", version, version)
+	}
+	return release, nil
+}
+
+// supportedCUDNN returns the cuDNN major versions of the nvidia/cuda images,
+// or nil if the CUDA version is unknown.
+func supportedCUDNN(cuda string) []string {
+	version, ok := parseMajorMinor(cuda)
+	if !ok {
+		return nil
+	}
+	var cudnn []string
+	for _, c := range cudnnVersions {
+		if !lessMajorMinor(version, c.version) {
+			cudnn = c.cudnn
+		}
+	}
+	return cudnn
+}
+
+// cudnnTag returns the cuDNN in the tag of the nvidia/cuda images, the images
+// of cuDNN 9 since CUDA 12.4 are tagged without the version.
+func cudnnTag(cuda, cudnn string) string {
+	if version, ok := parseMajorMinor(cuda); ok && cudnn == "9" && !lessMajorMinor(version, [2]int{12, 4}) {
+		return "cudnn"
+	}
+	return "cudnn" + cudnn
+}
+
+// checkCUDAFrameworks fails the compile if the pinned PyTorch or TensorFlow in
+// the PyPI packages has no official builds for the CUDA version, instead of
+// producing an image which fails at runtime.
+func (g generalGraph) checkCUDAFrameworks() error {
+	if g.CUDA == nil {
+		return nil
+	}
+	cuda, ok := parseMajorMinor(*g.CUDA)
+	if !ok {
+		return nil
+	}
+	for _, packages := range g.PyPIPackages {
+		for _, p := range packages {
+			m := cudaFrameworkRegex.FindStringSubmatch(strings.TrimSpace(p))
+			if m == nil {
+				continue
+			}
+			name := strings.TrimSuffix(m[1], "-gpu")
+			if err := checkCUDAFramework(name, m[2], cuda, g.CUDNN); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkCUDAFramework(name, version string, cuda [2]int, cudnn string) error {
+	for _, f := range cudaFrameworks {
+		matched := false
+		for _, v := range f.versions {
+			matched = matched || (f.name == name && v == version)
+		}
+		if !matched {
+			continue
+		}
+		for _, c := range f.cuda {
+			build, _ := parseMajorMinor(c)
+			if build[0] != cuda[0] {
+				continue
+			}
+			if f.cudnn == "" {
+				return nil
+			}
+			if !lessMajorMinor(cuda, build) && f.cudnn == cudnn {
+				return nil
+			}
+		}
+		required := "CUDA " + strings.Join(f.cuda, ", ")
+		if f.cudnn != "" {
+			required = fmt.Sprintf("CUDA %s or newer of the same major version, cuDNN %s",
+				strings.Join(f.cuda, ", "), f.cudnn)
+		}
+		return errors.Newf("%s %s requires %s, which does not match CUDA %d.%d and cuDNN %s of install.cuda",
+			name, version, required, cuda[0], cuda[1], cudnn)
+	}
+	// unknown versions are not checked
+	return nil
+}
+
+// compute capability of the NVIDIA GPU, e.g. 8.6
+var computeCapabilityRegex = regexp.MustCompile(`^([0-9]+)\.([0-9])$`)
+
+// cudaComputeCapabilities are the compute capabilities supported by the CUDA
+// toolkit since the version, refer to the CUDA toolkit release notes.
+var cudaComputeCapabilities = []struct {
+	version  [2]int
+	min, max [2]int
+}{
+	{version: [2]int{10, 0}, min: [2]int{3, 0}, max: [2]int{7, 5}},
+	{version: [2]int{11, 0}, min: [2]int{3, 5}, max: [2]int{8, 0}},
+	{version: [2]int{11, 1}, min: [2]int{3, 5}, max: [2]int{8, 6}},
+	{version: [2]int{11, 4}, min: [2]int{3, 5}, max: [2]int{8, 7}},
+	{version: [2]int{11, 8}, min: [2]int{3, 5}, max: [2]int{9, 0}},
+	{version: [2]int{12, 0}, min: [2]int{5, 0}, max: [2]int{9, 0}},
+	{version: [2]int{12, 8}, min: [2]int{5, 0}, max: [2]int{12, 0}},
+}
+
+// parseMajorMinor parses the leading `major.minor` of the version.
+func parseMajorMinor(version string) ([2]int, bool) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return [2]int{}, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return [2]int{}, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return [2]int{}, false
+	}
+	return [2]int{major, minor}, true
+}
+
+func lessMajorMinor(a, b [2]int) bool {
+	return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+}
+
+// isComputeCapabilitySupported returns false only if the CUDA version is known
+// and it cannot generate the code for the compute capability.
+func isComputeCapabilitySupported(cuda, capability string) bool {
+	version, ok := parseMajorMinor(cuda)
+	if !ok {
+		return true
+	}
+	cc, ok := parseMajorMinor(capability)
+	if !ok {
+		return false
+	}
+	supported := -1
+	for i, c := range cudaComputeCapabilities {
+		if !lessMajorMinor(version, c.version) {
+			supported = i
+		}
+	}
+	if supported < 0 {
+		return true
+	}
+	c := cudaComputeCapabilities[supported]
+	return !lessMajorMinor(cc, c.min) && !lessMajorMinor(c.max, cc)
+}
+
+// cudaArchEnvs returns the environment variables which make the ahead-of-time
+// CUDA builds target the compute capabilities, e.g. the PyTorch extensions
+// and the CMake projects.
+func (g generalGraph) cudaArchEnvs() map[string]string {
+	if len(g.CUDAComputeCapabilities) == 0 {
+		return nil
+	}
+	archs := make([]string, 0, len(g.CUDAComputeCapabilities))
+	for _, c := range g.CUDAComputeCapabilities {
+		archs = append(archs, strings.Replace(c, ".", "", 1))
+	}
+	return map[string]string{
+		"TORCH_CUDA_ARCH_LIST": strings.Join(g.CUDAComputeCapabilities, ";"),
+		"CUDAARCHS":            strings.Join(archs, ";"),
+	}
+}
+
+// compileCUDAArch exports the compute capabilities to the build and the
+// resulting image. They are set before any language is installed, thus all
+// the builds in the image see them, not only the Julia packages.
+func (g *generalGraph) compileCUDAArch(root llb.State) llb.State {
+	envs := g.cudaArchEnvs()
+	for _, k := range []string{"TORCH_CUDA_ARCH_LIST", "CUDAARCHS"} {
+		if v, ok := envs[k]; ok {
+			root = root.AddEnv(k, v)
+			g.RuntimeEnviron[k] = v
+		}
+	}
+	return root
+}
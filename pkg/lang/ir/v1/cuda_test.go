@@ -0,0 +1,94 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+)
+
+func TestCUDA(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := CUDA("12.1", "", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *g.CUDA != "12.1.1" || g.CUDNN != "8" {
+		t.Errorf("unexpected CUDA %s and cuDNN %s", *g.CUDA, g.CUDNN)
+	}
+	if err := CUDA("12.4", "", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image := GetCUDAImage("ubuntu:22.04", g.CUDA, g.CUDNN, false); image != "docker.io/nvidia/cuda:12.4.1-cudnn-runtime-ubuntu22.04" {
+		t.Errorf("unexpected CUDA image %s", image)
+	}
+
+	for _, c := range [][2]string{{"12", ""}, {"12.7", ""}, {"12.4.1", "8"}, {"11.8.0", "9"}} {
+		if err := CUDA(c[0], c[1], nil); err == nil {
+			t.Errorf("expected error for CUDA %s and cuDNN %s", c[0], c[1])
+		}
+	}
+}
+
+func TestCheckCUDAFrameworks(t *testing.T) {
+	cases := []struct {
+		cuda, cudnn string
+		packages    []string
+		valid       bool
+	}{
+		{"12.1.1", "8", []string{"torch==2.1.0", "numpy"}, true},
+		{"11.2.2", "8", []string{"torch==2.1.0"}, true},
+		{"10.2", "8", []string{"torch==2.1.0"}, false},
+		{"11.2.2", "8", []string{"tensorflow==2.11.0"}, true},
+		{"11.8.0", "8", []string{"tensorflow-gpu == 2.5.0"}, true},
+		{"11.2.2", "8", []string{"tensorflow==2.15.0"}, false},
+		{"12.5.1", "8", []string{"tensorflow==2.18.0"}, false},
+		{"11.2.2", "8", []string{"torch>=2.1", "tensorflow==3.0.0"}, true},
+	}
+	for _, c := range cases {
+		g := generalGraph{CUDA: &c.cuda, CUDNN: c.cudnn, PyPIPackages: [][]string{c.packages}}
+		if err := g.checkCUDAFrameworks(); (err == nil) != c.valid {
+			t.Errorf("unexpected result for CUDA %s and %v: %v", c.cuda, c.packages, err)
+		}
+	}
+}
+
+func TestRuntimeGPU(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := RuntimeGPU(0, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.RuntimeGPU.Count != 1 {
+		t.Errorf("expected one GPU by default, got %d", g.RuntimeGPU.Count)
+	}
+	if err := RuntimeGPU(0, []string{"0", "2", "MIG-4b3c2d1e-aaaa-bbbb-cccc-0123456789ab"}, []string{"compute", "video"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.RuntimeGPU.Count != 0 || len(g.RuntimeGPU.Devices) != 3 {
+		t.Errorf("unexpected GPUs: %+v", g.RuntimeGPU)
+	}
+	for _, tc := range []struct {
+		count        int
+		devices      []string
+		capabilities []string
+	}{
+		{count: -2},
+		{count: 1, devices: []string{"0"}},
+		{devices: []string{"gpu0"}},
+		{capabilities: []string{"gpu"}},
+	} {
+		if err := RuntimeGPU(tc.count, tc.devices, tc.capabilities); err == nil {
+			t.Errorf("expected error of %+v", tc)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+
+	"github.com/tensorchord/envd/pkg/flag"
+)
+
+// requirementsIncludeFlags refer to the other files of pip
+var requirementsIncludeFlags = []string{"-r", "--requirement", "-c", "--constraint"}
+
+// requirementsLocalPrefixes refer to the local packages or dirs of pip
+var requirementsLocalPrefixes = []string{"-e", "--editable", "-f", "--find-links", ".", "/", "file:"}
+
+// DependencyFiles returns the files in the build context which are required
+// to install from the pip requirements file or the conda environment file,
+// i.e. the file itself and the nested requirements and constraints. It
+// returns nil if the local packages are referred, thus the whole build
+// context is required.
+func DependencyFiles(buildContextDir, file string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	var visit func(file string) (bool, error)
+	visit = func(file string) (bool, error) {
+		file = filepath.Clean(file)
+		if filepath.IsAbs(file) || file == ".." || strings.HasPrefix(file, "../") {
+			return false, nil
+		}
+		if seen[file] {
+			return true, nil
+		}
+		seen[file] = true
+		files = append(files, file)
+
+		data, err := os.ReadFile(filepath.Join(buildContextDir, file))
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to read the dependency file %s", file)
+		}
+		yaml := strings.HasSuffix(file, ".yml") || strings.HasSuffix(file, ".yaml")
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if i := strings.Index(line, " #"); i >= 0 {
+				line = strings.TrimSpace(line[:i])
+			}
+			if yaml {
+				// only the pip requirements in the conda environment refer to the files
+				if !strings.HasPrefix(line, "- ") {
+					continue
+				}
+				line = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+			}
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if include, ok := requirementsInclude(line); ok {
+				local, err := visit(filepath.Join(filepath.Dir(file), include))
+				if err != nil || !local {
+					return false, err
+				}
+				continue
+			}
+			for _, prefix := range requirementsLocalPrefixes {
+				if strings.HasPrefix(line, prefix) || strings.Contains(line, "@ file:") {
+					return false, nil
+				}
+			}
+		}
+		return true, nil
+	}
+
+	local, err := visit(file)
+	if err != nil || !local {
+		return nil, err
+	}
+	return files, nil
+}
+
+// requirementsInclude returns the file of `-r file`, `--requirement=file`, etc.
+func requirementsInclude(line string) (string, bool) {
+	for _, f := range requirementsIncludeFlags {
+		if !strings.HasPrefix(line, f) {
+			continue
+		}
+		rest := line[len(f):]
+		if strings.HasPrefix(f, "--") {
+			rest = strings.TrimPrefix(rest, "=")
+		}
+		if rest = strings.TrimSpace(rest); rest != "" {
+			return rest, true
+		}
+	}
+	return "", false
+}
+
+// dependencyContext is the build context to install from the dependency file.
+// Only the files are transferred if they are known, thus the cache of the
+// step depends on the content of them, instead of the whole build context.
+func dependencyContext(files []string) llb.State {
+	if len(files) == 0 {
+		return llb.Local(flag.FlagBuildContext)
+	}
+	return llb.Local(flag.FlagBuildContext, llb.FollowPaths(files),
+		llb.WithCustomNamef("[internal] loading the dependency files %s", strings.Join(files, " ")))
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestDependencyFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"requirements.txt":      "numpy==1.26.4  # pinned\n-r requirements/base.txt\n--constraint=constraints.txt\n",
+		"requirements/base.txt": "--index-url https://pypi.org/simple\nrequests\n",
+		"constraints.txt":       "urllib3<2\n",
+		"editable.txt":          "-r requirements.txt\n-e .\n",
+		"environment.yml":       "name: envd\ndependencies:\n  - python=3.11\n  - pip:\n    - -r requirements.txt\n",
+		"local.yml":             "dependencies:\n  - pip:\n    - ./pkg\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string][]string{
+		"./requirements.txt": {"requirements.txt", "requirements/base.txt", "constraints.txt"},
+		"environment.yml":    {"environment.yml", "requirements.txt", "requirements/base.txt", "constraints.txt"},
+		"editable.txt":       nil,
+		"local.yml":          nil,
+	}
+	for file, expected := range cases {
+		got, err := DependencyFiles(dir, file)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", file, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected files of %s: %v", file, got)
+		}
+	}
+	if _, err := DependencyFiles(dir, "missing.txt"); err == nil {
+		t.Error("expected error for the missing file")
+	}
+}
+
+func TestCondaPackageKeepsEnvFile(t *testing.T) {
+	g := resetDefaultGraph(t)
+	g.CondaConfig = &ir.CondaConfig{}
+
+	if err := CondaPackage(nil, nil, "environment.yml", []string{"environment.yml"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := CondaPackage([]string{"numpy"}, nil, "", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.CondaConfig.CondaEnvFileName != "environment.yml" || !reflect.DeepEqual(g.CondaEnvFiles, []string{"environment.yml"}) {
+		t.Errorf("the env file is dropped: %s, %v", g.CondaConfig.CondaEnvFileName, g.CondaEnvFiles)
+	}
+	if !reflect.DeepEqual(g.CondaConfig.CondaPackages, []string{"numpy"}) {
+		t.Errorf("unexpected conda packages: %v", g.CondaConfig.CondaPackages)
+	}
+}
@@ -15,7 +15,9 @@
 package v1
 
 import (
+	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strconv"
 
 	"github.com/cockroachdb/errors"
@@ -58,6 +60,75 @@ func (g generalGraph) compileVSCode() (llb.State, error) {
 	return layer, nil
 }
 
+const (
+	vscodeSettingsPath       = "/etc/envd/vscode/settings.json"
+	vscodeSettingsScriptPath = "/usr/local/bin/envd-vscode-settings"
+	// The existing settings win over the defaults, jq fails on the comments
+	// in the settings, then the file is kept as is.
+	vscodeSettingsScript = `#!/bin/sh
+target="%[2]s"
+mkdir -p "$(dirname "${target}")"
+if [ ! -s "${target}" ]; then
+	cp %[1]s "${target}"
+elif jq -s '.[0] * .[1]' %[1]s "${target}" > "${target}.envd"; then
+	mv "${target}.envd" "${target}"
+else
+	rm -f "${target}.envd"
+fi
+`
+)
+
+// vscodeSettings returns the default settings, the Julia executable is
+// populated from the install location if it's not set.
+func (g generalGraph) vscodeSettings() map[string]interface{} {
+	settings := make(map[string]interface{}, len(g.VSCodeSettings)+1)
+	for k, v := range g.VSCodeSettings {
+		settings[k] = v
+	}
+	if _, ok := settings["julia.executablePath"]; !ok && g.Language.Name == "julia" {
+		julia := filepath.Join(juliaBinDir, "julia")
+		if g.hasJuliaWrapper() {
+			julia = juliaWrapperPath
+		}
+		settings["julia.executablePath"] = julia
+	}
+	if _, ok := settings["launch"]; !ok && g.juliaDebugAdapterEnabled() {
+		settings["launch"] = juliaLaunchConfig
+	}
+	return settings
+}
+
+// hasVSCodeSettings checks if there are the default settings to bake.
+func (g generalGraph) hasVSCodeSettings() bool {
+	return g.VSCodeSettings != nil || g.juliaDebugAdapterEnabled()
+}
+
+// compileVSCodeSettings bakes the default settings of VS Code server. They are
+// merged into the machine settings of the user when the environment starts,
+// instead of overwriting them.
+func (g generalGraph) compileVSCodeSettings(root llb.State) (llb.State, error) {
+	if !g.hasVSCodeSettings() {
+		return root, nil
+	}
+	settings, err := json.MarshalIndent(g.vscodeSettings(), "", "  ")
+	if err != nil {
+		return llb.State{}, errors.Wrap(err, "failed to marshal VS Code settings")
+	}
+	target := fileutil.EnvdHomeDir(".vscode-server", "data", "Machine", "settings.json")
+	opts := append([]llb.RunOption{
+		llb.Shlex(`bash -c "apt-get update && apt-get install -y --no-install-recommends jq"`),
+		llb.User("root"),
+		llb.WithCustomName("[internal] install jq to merge VS Code settings")}, g.aptListsRunOptions()...)
+	return root.Run(opts...).Root().
+		File(llb.Mkdir(filepath.Dir(vscodeSettingsPath), 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] creating folder for %s", vscodeSettingsPath)).
+		File(llb.Mkfile(vscodeSettingsPath, 0644, append(settings, '\n')),
+			llb.WithCustomNamef("[internal] generating %s", vscodeSettingsPath)).
+		File(llb.Mkfile(vscodeSettingsScriptPath, 0755,
+			[]byte(fmt.Sprintf(vscodeSettingsScript, vscodeSettingsPath, target))),
+			llb.WithCustomNamef("[internal] generating %s", vscodeSettingsScriptPath)), nil
+}
+
 // nolint:unused
 func (g *generalGraph) compileJupyter() error {
 	if g.JupyterConfig == nil {
@@ -82,6 +82,44 @@ func TestGenerateCommand(t *testing.T) {
 	}
 }
 
+func TestVSCodeJuliaExecutable(t *testing.T) {
+	julia := ir.Language{Name: "julia"}
+	testcases := []struct {
+		graph    generalGraph
+		expected string
+	}{
+		{
+			graph:    generalGraph{Language: julia},
+			expected: "/opt/julia/bin/julia",
+		},
+		{
+			graph:    generalGraph{Language: julia, JuliaREPLConfig: &ir.JuliaREPLConfig{NoBanner: true}},
+			expected: juliaWrapperPath,
+		},
+		{
+			// the wrapper is not generated without the flags
+			graph:    generalGraph{Language: julia, JuliaREPLConfig: &ir.JuliaREPLConfig{DisplayRows: 40}},
+			expected: "/opt/julia/bin/julia",
+		},
+		{
+			// the sysimage is not built without the packages
+			graph:    generalGraph{Language: julia, JuliaSysimage: &ir.JuliaSysimageConfig{}},
+			expected: "/opt/julia/bin/julia",
+		},
+		{
+			graph: generalGraph{Language: julia, JuliaSysimage: &ir.JuliaSysimageConfig{},
+				JuliaPackages: [][]string{{"Example"}}},
+			expected: juliaWrapperPath,
+		},
+	}
+	for _, tc := range testcases {
+		actual := tc.graph.vscodeSettings()["julia.executablePath"]
+		if actual != tc.expected {
+			t.Errorf("failed to get the julia executable: expected %s, got %v", tc.expected, actual)
+		}
+	}
+}
+
 // Equal tells whether a and b contain the same elements.
 // A nil argument is equivalent to an empty slice.
 func equal(a, b []string) bool {
@@ -0,0 +1,101 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+const (
+	featureJuliaLockDepot      = "julia.lock_depot"
+	featureJuliaPrecompile     = "julia.precompile"
+	featureAptCleanupLists     = "apt.cleanup_lists"
+	featureJuliaSharedCache    = "julia.shared_cache"
+	featureJuliaRelocatable    = "julia.relocatable_depot"
+	featureJuliaDownloads      = "julia.download_cache"
+	featureJuliaGrouped        = "julia.grouped_install"
+	featureJuliaPrecompileOnce = "julia.precompile_once"
+	featureBuildRootless       = "build.rootless"
+)
+
+// knownFeatures are the features consulted by the installers, and their defaults.
+var knownFeatures = map[string]bool{
+	featureJuliaLockDepot:      false,
+	featureJuliaPrecompile:     true,
+	featureAptCleanupLists:     true,
+	featureJuliaSharedCache:    false,
+	featureJuliaRelocatable:    false,
+	featureJuliaDownloads:      true,
+	featureJuliaGrouped:        false,
+	featureJuliaPrecompileOnce: false,
+	featureBuildRootless:       false,
+}
+
+// featureEnabled returns the value of the feature, or its default if it's not set.
+func (g generalGraph) featureEnabled(name string) bool {
+	if enabled, ok := g.Features[name]; ok {
+		return enabled
+	}
+	return knownFeatures[name]
+}
+
+// isJuliaDepotLocked returns true if the baked Julia depot is read-only.
+func (g generalGraph) isJuliaDepotLocked() bool {
+	return g.featureEnabled(featureJuliaLockDepot)
+}
+
+// isJuliaPrecompileEnabled returns true if the Julia packages are precompiled
+// when they are installed.
+func (g generalGraph) isJuliaPrecompileEnabled() bool {
+	return g.featureEnabled(featureJuliaPrecompile)
+}
+
+// isAptListsCleanupEnabled returns true if the apt lists are kept out of
+// the image.
+func (g generalGraph) isAptListsCleanupEnabled() bool {
+	return g.featureEnabled(featureAptCleanupLists)
+}
+
+// isJuliaSharedCacheEnabled returns true if the compiled Julia artifacts are
+// shared with the other envd builds on the host.
+func (g generalGraph) isJuliaSharedCacheEnabled() bool {
+	return g.featureEnabled(featureJuliaSharedCache)
+}
+
+// isJuliaDepotRelocatable returns true if the baked Julia depot should not
+// have the absolute paths, thus it can be extracted elsewhere.
+func (g generalGraph) isJuliaDepotRelocatable() bool {
+	return g.featureEnabled(featureJuliaRelocatable)
+}
+
+// isRootlessBuildEnabled returns true if the dirs of the user are owned by
+// the user when they are created, instead of being chowned by root afterwards.
+func (g generalGraph) isRootlessBuildEnabled() bool {
+	return g.featureEnabled(featureBuildRootless)
+}
+
+// isJuliaDownloadCacheEnabled returns true if the downloaded Julia packages
+// and artifacts are reused by the builds on the host.
+func (g generalGraph) isJuliaDownloadCacheEnabled() bool {
+	return g.featureEnabled(featureJuliaDownloads)
+}
+
+// isJuliaGroupedInstallEnabled returns true if every group of the Julia
+// packages is added by its own Julia process.
+func (g generalGraph) isJuliaGroupedInstallEnabled() bool {
+	return g.featureEnabled(featureJuliaGrouped)
+}
+
+// isJuliaPrecompileOnce returns true if the Julia packages are precompiled by
+// a single build step after all the Pkg operations.
+func (g generalGraph) isJuliaPrecompileOnce() bool {
+	return g.featureEnabled(featureJuliaPrecompileOnce)
+}
@@ -19,6 +19,10 @@ import (
 )
 
 func (g *generalGraph) CompileCacheDir(root llb.State, cacheDir string) llb.State {
+	if g.isRootlessBuild() {
+		return root.File(llb.Mkdir(cacheDir, 0755, g.userMkdirOptions()...),
+			llb.WithCustomName("[internal] create cache dir"))
+	}
 	g.UserDirectories = append(g.UserDirectories, cacheDir)
 	run := root.Run(llb.Shlexf("mkdir -p %s", cacheDir), llb.WithCustomName("[internal] create cache dir"))
 	return run.Root()
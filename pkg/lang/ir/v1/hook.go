@@ -0,0 +1,91 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+)
+
+// HookPoint is the named point in the pipeline of CompileLLB, where the LLB
+// hooks are inserted.
+type HookPoint string
+
+const (
+	// HookAfterBase is after the base image (and the dev packages).
+	HookAfterBase HookPoint = "after_base"
+	// HookAfterLanguage is after the language (e.g. Julia) is installed.
+	HookAfterLanguage HookPoint = "after_language"
+	// HookAfterSystemPackages is after the apt packages are installed.
+	HookAfterSystemPackages HookPoint = "after_system_packages"
+	// HookAfterLanguagePackages is after the language packages are installed.
+	HookAfterLanguagePackages HookPoint = "after_language_packages"
+	// HookFinal is the last step before the image is exported.
+	HookFinal HookPoint = "final"
+)
+
+var hookPoints = map[HookPoint]bool{
+	HookAfterBase:             true,
+	HookAfterLanguage:         true,
+	HookAfterSystemPackages:   true,
+	HookAfterLanguagePackages: true,
+	HookFinal:                 true,
+}
+
+// LLBHook is the custom LLB operation which is not exposed by the IR,
+// e.g. a specific mount or cache id.
+type LLBHook func(llb.State) llb.State
+
+// RegisterLLBHook inserts the hook at the point of the pipeline. It's the
+// escape hatch for the Go API users, thus it's not exposed to the starlark
+// frontend. The hooks at the same point run in the registration order.
+func RegisterLLBHook(point HookPoint, hook LLBHook) error {
+	if !hookPoints[point] {
+		return errors.Newf("unknown LLB hook point: %s", point)
+	}
+	if hook == nil {
+		return errors.New("LLB hook is required")
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	if g.hooks == nil {
+		g.hooks = map[HookPoint][]LLBHook{}
+	}
+	g.hooks[point] = append(g.hooks[point], hook)
+	return nil
+}
+
+// runLLBHooks applies the hooks at the point. The later steps assume the
+// filesystem and the working dir of the state, thus the hook must not drop
+// the filesystem, and the working dir is restored after it.
+func (g generalGraph) runLLBHooks(point HookPoint, root llb.State) (llb.State, error) {
+	hooks := g.hooks[point]
+	if len(hooks) == 0 {
+		return root, nil
+	}
+	dir, err := root.GetDir(context.TODO())
+	if err != nil {
+		return llb.State{}, errors.Wrapf(err, "failed to get the working dir before the LLB hook %s", point)
+	}
+	for i, hook := range hooks {
+		root = hook(root)
+		if root.Output() == nil {
+			return llb.State{}, errors.Newf("LLB hook %d at %s drops the filesystem", i, point)
+		}
+	}
+	return root.Dir(dir), nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+)
+
+const (
+	envInfoPath       = "/etc/envd/info.json"
+	envInfoScriptPath = "/usr/local/bin/envd-info"
+	envInfoScript     = `#!/bin/sh
+cat %s
+`
+)
+
+// EnvironmentInfo is the metadata of the environment baked into the image,
+// it's printed by `envd-info` in the container.
+type EnvironmentInfo struct {
+	Language string `json:"language"`
+	Version  string `json:"version,omitempty"`
+	// Packages are the declared packages by the package manager
+	Packages map[string][]string `json:"packages,omitempty"`
+	Ports    []EnvironmentPort   `json:"ports,omitempty"`
+	Daemons  [][]string          `json:"daemons,omitempty"`
+}
+
+// EnvironmentPort is the port exposed by the environment.
+type EnvironmentPort struct {
+	Name     string `json:"name,omitempty"`
+	Port     int    `json:"port"`
+	HostPort int    `json:"host_port,omitempty"`
+}
+
+// environmentInfo collects the metadata of the environment from the graph.
+func (g generalGraph) environmentInfo() EnvironmentInfo {
+	info := EnvironmentInfo{
+		Language: g.Language.Name,
+		Packages: map[string][]string{},
+		Daemons:  g.RuntimeDaemon,
+	}
+	if g.Language.Version != nil {
+		info.Version = *g.Language.Version
+	}
+
+	add := func(manager string, packages []string) {
+		if len(packages) > 0 {
+			info.Packages[manager] = append(info.Packages[manager], packages...)
+		}
+	}
+	add("system", g.SystemPackages)
+	for _, p := range g.PyPIPackages {
+		add("pypi", p)
+	}
+	if g.CondaConfig != nil {
+		add("conda", g.CondaConfig.CondaPackages)
+	}
+	for _, p := range g.RPackages {
+		add("r", p)
+	}
+	for _, p := range g.juliaPackages(g.platform()) {
+		add("julia", p)
+	}
+	for _, p := range g.NPMPackages {
+		add("npm", p)
+	}
+	for _, p := range g.CargoPackages {
+		add("cargo", p)
+	}
+
+	for _, item := range g.RuntimeExpose {
+		info.Ports = append(info.Ports, EnvironmentPort{
+			Name:     item.ServiceName,
+			Port:     item.EnvdPort,
+			HostPort: item.HostPort,
+		})
+	}
+	return info
+}
+
+// compileEnvironmentInfo bakes the metadata of the environment and the
+// `envd-info` script to print it.
+func (g generalGraph) compileEnvironmentInfo(root llb.State) (llb.State, error) {
+	info, err := json.MarshalIndent(g.environmentInfo(), "", "  ")
+	if err != nil {
+		return llb.State{}, errors.Wrap(err, "failed to marshal the environment info")
+	}
+	return root.
+		File(llb.Mkdir(filepath.Dir(envInfoPath), 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] creating folder for %s", envInfoPath)).
+		File(llb.Mkfile(envInfoPath, 0644, append(info, '\n')),
+			llb.WithCustomNamef("[internal] generating %s", envInfoPath)).
+		File(llb.Mkfile(envInfoScriptPath, 0755, []byte(fmt.Sprintf(envInfoScript, envInfoPath))),
+			llb.WithCustomNamef("[internal] generating %s", envInfoScriptPath)), nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestEnvironmentInfo(t *testing.T) {
+	version := "3.9"
+	g := generalGraph{
+		Language:       ir.Language{Name: "python", Version: &version},
+		SystemPackages: []string{"curl"},
+		PyPIPackages:   [][]string{{"numpy"}, {"torch==2.0.0"}},
+		RuntimeGraph: ir.RuntimeGraph{
+			RuntimeDaemon: [][]string{{"python3", "serve.py"}},
+			RuntimeExpose: []ir.ExposeItem{{EnvdPort: 8000, HostPort: 18000, ServiceName: "api"}},
+		},
+	}
+	expected := EnvironmentInfo{
+		Language: "python",
+		Version:  "3.9",
+		Packages: map[string][]string{
+			"system": {"curl"},
+			"pypi":   {"numpy", "torch==2.0.0"},
+		},
+		Ports:   []EnvironmentPort{{Name: "api", Port: 8000, HostPort: 18000}},
+		Daemons: [][]string{{"python3", "serve.py"}},
+	}
+	if info := g.environmentInfo(); !reflect.DeepEqual(info, expected) {
+		t.Errorf("unexpected environment info: %+v", info)
+	}
+}
@@ -15,10 +15,25 @@
 package v1
 
 import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/containerd/containerd/filters"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/go-units"
+	"github.com/google/uuid"
 	"github.com/opencontainers/go-digest"
+	"github.com/pelletier/go-toml/v2"
 	"github.com/sirupsen/logrus"
 
 	"github.com/tensorchord/envd/pkg/editor/vscode"
@@ -50,6 +65,24 @@ func Python(version string) error {
 	return nil
 }
 
+// PipToolchain pins the versions of pip, setuptools and wheel, which are
+// installed before the Python packages.
+func PipToolchain(pip, setuptools, wheel string) error {
+	for _, version := range []string{pip, setuptools, wheel} {
+		if version != "" && !pythonVersionRegex.MatchString(version) {
+			return errors.Newf("invalid version of the pip toolchain: %s", version)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.PipToolchain = &ir.PipToolchain{
+		Pip:        pip,
+		Setuptools: setuptools,
+		Wheel:      wheel,
+	}
+	return nil
+}
+
 func Conda(mamba bool) {
 	g := DefaultGraph.(*generalGraph)
 
@@ -66,15 +99,409 @@ func RLang() {
 	}
 }
 
-func Julia() {
+func Julia(lockDepot bool) {
 	g := DefaultGraph.(*generalGraph)
 
 	g.Language = ir.Language{
 		Name: "julia",
 	}
+	if lockDepot {
+		g.Features[featureJuliaLockDepot] = true
+	}
+}
+
+// Feature sets the feature consulted by the installers, e.g. `julia.precompile`.
+func Feature(name string, enabled bool) {
+	if _, ok := knownFeatures[name]; !ok {
+		logrus.Warnf("unknown feature %s, it will be ignored", name)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.Features[name] = enabled
+}
+
+// JuliaRuntime sets the default heap size hint (e.g. `4G`), the number of GC
+// threads, the number of threads (e.g. `auto`, `4,1`) and the CPU target of
+// Julia. An empty value or zero keeps the Julia default.
+func JuliaRuntime(heapSizeHint string, gcThreads int, threads, cpuTarget string) error {
+	cfg := ir.JuliaRuntimeConfig{
+		GCThreads: gcThreads,
+	}
+	if heapSizeHint != "" {
+		size, err := units.RAMInBytes(heapSizeHint)
+		if err != nil {
+			return errors.Wrapf(err, "invalid heap size hint: %s", heapSizeHint)
+		}
+		if size < juliaMinHeapSizeHint {
+			return errors.Newf("heap size hint %s is too small, it must be at least %s",
+				heapSizeHint, units.BytesSize(juliaMinHeapSizeHint))
+		}
+		cfg.HeapSizeHint = size
+	}
+	if gcThreads < 0 {
+		return errors.Newf("invalid number of GC threads: %d", gcThreads)
+	}
+	if threads != "" && !juliaThreadsRegex.MatchString(threads) {
+		return errors.Newf("invalid number of Julia threads %s, expect `auto`, `N` or `N,M`", threads)
+	}
+	if strings.ContainsAny(cpuTarget, " \t\n") {
+		return errors.Newf("invalid Julia CPU target: %s", cpuTarget)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	// the environment variables are not flags of the wrapper
+	g.JuliaNumThreads = threads
+	g.JuliaCPUTarget = cpuTarget
+
+	// keep the Julia defaults
+	if cfg.HeapSizeHint == 0 && cfg.GCThreads == 0 {
+		g.JuliaRuntimeConfig = nil
+		return nil
+	}
+	g.JuliaRuntimeConfig = &cfg
+	return nil
+}
+
+// JuliaREPL sets the interactive defaults of Julia, each of them is optional:
+// suppressing the banner, forcing the color (`yes` or `no`), and the number
+// of the rows to display the arrays.
+func JuliaREPL(banner bool, color string, displayRows int) error {
+	if color != "" && color != "yes" && color != "no" {
+		return errors.Newf("invalid Julia color option %s, it must be yes or no", color)
+	}
+	if displayRows < 0 {
+		return errors.Newf("invalid number of the Julia display rows: %d", displayRows)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	cfg := ir.JuliaREPLConfig{
+		NoBanner:    !banner,
+		Color:       color,
+		DisplayRows: displayRows,
+	}
+	// keep the Julia defaults
+	if cfg == (ir.JuliaREPLConfig{}) {
+		g.JuliaREPLConfig = nil
+		return nil
+	}
+	g.JuliaREPLConfig = &cfg
+	return nil
+}
+
+// JuliaVersion installs the Julia release instead of the default one. The
+// releases before 1.6, the oldest LTS, are rejected, since the package server
+// and the precompilation settings are not supported by their Pkg.
+func JuliaVersion(version string) error {
+	if !juliaVersionRegex.MatchString(version) {
+		return errors.Newf("invalid Julia version %s, should be the full release, e.g. 1.6.7", version)
+	}
+	if v, _ := parseMajorMinor(version); lessMajorMinor(v, juliaMinVersion) {
+		return errors.Newf("Julia %s is not supported, the oldest supported release is %d.%d",
+			version, juliaMinVersion[0], juliaMinVersion[1])
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaVersion = version
+	g.Language.Version = &version
+	return nil
+}
+
+// JuliaDebug installs the Julia distribution with the debug symbols from the
+// url instead of the stripped release, which provides `julia-debug` as well.
+func JuliaDebug(url, sha256 string) error {
+	if url == "" {
+		return errors.New("url of the Julia debug build is required")
+	}
+	if _, err := digest.Parse("sha256:" + sha256); err != nil {
+		return errors.Wrapf(err, "invalid sha256 checksum of the Julia debug build: %s", sha256)
+	}
+	logrus.Warn("the Julia debug build will increase the image size significantly")
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaDebugBuild = &ir.JuliaDebugBuild{
+		URL:    url,
+		SHA256: sha256,
+	}
+	return nil
+}
+
+// JuliaFailureHook sets the command run when a Julia Pkg operation fails,
+// before the build aborts.
+func JuliaFailureHook(command string) error {
+	if strings.TrimSpace(command) == "" {
+		return errors.New("command of the Julia failure hook is required")
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaFailureHook = &command
+	return nil
+}
+
+// JuliaResolveStrategy sets how aggressively the resolver of `Pkg.add` moves
+// the versions of the installed packages.
+func JuliaResolveStrategy(strategy string) error {
+	if _, ok := juliaPreserveLevels[strategy]; !ok {
+		return errors.Newf("unknown Julia resolve strategy %s, valid strategies are %s",
+			strategy, strings.Join(juliaResolveStrategies(), ", "))
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaResolveStrategy = strategy
+	return nil
+}
+
+// JuliaPrecompileSeed sets the fixed RNG seed of the precompilation of the
+// Julia packages.
+func JuliaPrecompileSeed(seed uint64) {
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaPrecompileSeed = &seed
+}
+
+// JuliaPreferences merges the preferences of the packages, which are stored
+// by Preferences.jl in the tables named after the packages.
+func JuliaPreferences(preferences map[string]interface{}) error {
+	g := DefaultGraph.(*generalGraph)
+
+	merged := map[string]interface{}{}
+	if g.JuliaPreferences != "" {
+		if err := toml.Unmarshal([]byte(g.JuliaPreferences), &merged); err != nil {
+			return errors.Wrap(err, "failed to parse the Julia preferences")
+		}
+	}
+	for name, prefs := range preferences {
+		table, ok := prefs.(map[string]interface{})
+		if !ok {
+			return errors.Newf("preferences of the Julia package %s should be a table", name)
+		}
+		existing, _ := merged[name].(map[string]interface{})
+		if existing == nil {
+			existing = map[string]interface{}{}
+		}
+		for k, v := range table {
+			existing[k] = v
+		}
+		merged[name] = existing
+	}
+	data, err := toml.Marshal(merged)
+	if err != nil {
+		return errors.Wrap(err, "invalid Julia preferences")
+	}
+	g.JuliaPreferences = string(data)
+	return nil
+}
+
+// JuliaArtifactOverrides sets the artifact overrides in the depot. The keys
+// are the artifact hashes or the package UUIDs, and the values are the hashes
+// or the paths of the baked artifacts. The paths relative to the artifacts dir
+// are rewritten to the baked ones, and each of them must be in the artifacts,
+// which are the entries of the artifacts dir.
+func JuliaArtifactOverrides(overrides map[string]interface{}, artifactsDir string, artifacts []string) error {
+	baked := make(map[string]bool, len(artifacts))
+	for _, a := range artifacts {
+		baked[a] = true
+	}
+	resolve := func(key string, value interface{}) (string, error) {
+		s, ok := value.(string)
+		if !ok {
+			return "", errors.Newf("override of the artifact %s should be a string", key)
+		}
+		if juliaArtifactHashRegex.MatchString(s) {
+			return s, nil
+		}
+		p := s
+		if filepath.IsAbs(p) {
+			rel, err := filepath.Rel(juliaArtifactOverridesDir, p)
+			if err != nil {
+				return "", errors.Wrapf(err, "invalid override %s of the artifact %s", s, key)
+			}
+			p = rel
+		}
+		p = filepath.Clean(p)
+		if !baked[strings.Split(p, "/")[0]] {
+			return "", errors.Newf("override %s of the artifact %s is not baked from the artifacts dir", s, key)
+		}
+		return filepath.Join(juliaArtifactOverridesDir, p), nil
+	}
+
+	rendered := make(map[string]interface{}, len(overrides))
+	for key, value := range overrides {
+		if juliaArtifactHashRegex.MatchString(key) {
+			resolved, err := resolve(key, value)
+			if err != nil {
+				return err
+			}
+			rendered[key] = resolved
+			continue
+		}
+		if _, err := uuid.Parse(key); err != nil {
+			return errors.Newf("%s should be the artifact hash or the package uuid", key)
+		}
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return errors.Newf("overrides of the package %s should be a table", key)
+		}
+		resolvedTable := make(map[string]interface{}, len(table))
+		for name, v := range table {
+			resolved, err := resolve(fmt.Sprintf("%s of %s", name, key), v)
+			if err != nil {
+				return err
+			}
+			resolvedTable[name] = resolved
+		}
+		rendered[key] = resolvedTable
+	}
+	data, err := toml.Marshal(rendered)
+	if err != nil {
+		return errors.Wrap(err, "invalid Julia artifact overrides")
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaArtifactOverrides = string(data)
+	g.JuliaArtifactsDir = artifactsDir
+	return nil
+}
+
+// JuliaDebugger adds the Julia debuggers, i.e. Debugger and Infiltrator, in
+// the dev environment.
+func JuliaDebugger(debuggers []string) error {
+	g := DefaultGraph.(*generalGraph)
+
+	added := make(map[string]bool, len(g.JuliaDebuggers))
+	for _, d := range g.JuliaDebuggers {
+		added[d] = true
+	}
+	for _, d := range debuggers {
+		if !juliaDebuggers[d] {
+			return errors.Newf("unknown Julia debugger %s, valid values are Debugger and Infiltrator", d)
+		}
+		if !added[d] {
+			g.JuliaDebuggers = append(g.JuliaDebuggers, d)
+			added[d] = true
+		}
+	}
+	return nil
+}
+
+// JuliaCUDA adds CUDA.jl of the version, the latest one if empty. The CUDA
+// artifacts are downloaded at build time if preload is true and the builder
+// has a GPU, otherwise at the first use in the container.
+func JuliaCUDA(version string, preload bool) error {
+	if version != "" && !juliaPackageVersionRegex.MatchString(version) {
+		return errors.Newf("invalid version of CUDA.jl: %s", version)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaCUDA = &ir.JuliaCUDAConfig{Version: version, Preload: preload}
+	return nil
+}
+
+// JuliaSysimage builds the sysimage with the packages by PackageCompiler, and
+// uses it by default. All the Julia packages are baked if packages is empty.
+func JuliaSysimage(packages []string) error {
+	for _, p := range packages {
+		if !juliaPackageNameRegex.MatchString(p) {
+			return errors.Newf("invalid Julia package name of the sysimage: %s", p)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.addJuliaSysimagePackages(packages)
+	return nil
+}
+
+// addJuliaSysimagePackages adds the packages to the sysimage, the packages of
+// `install.julia_sysimage` and `install.julia_packages(sysimage=True)` are
+// merged. Empty packages mean all the Julia packages.
+func (g *generalGraph) addJuliaSysimagePackages(packages []string) {
+	if g.JuliaSysimage == nil {
+		g.JuliaSysimage = &ir.JuliaSysimageConfig{Packages: packages}
+		return
+	}
+	if len(packages) == 0 || len(g.JuliaSysimage.Packages) == 0 {
+		g.JuliaSysimage.Packages = nil
+		return
+	}
+	existing := make(map[string]bool, len(g.JuliaSysimage.Packages))
+	for _, p := range g.JuliaSysimage.Packages {
+		existing[p] = true
+	}
+	for _, p := range packages {
+		if !existing[p] {
+			existing[p] = true
+			g.JuliaSysimage.Packages = append(g.JuliaSysimage.Packages, p)
+		}
+	}
 }
 
-func PyPIPackage(deps []string, requirementsFile string, wheels []string) error {
+// Node installs the Node.js release, the default LTS one if version is empty.
+// Node.js is independent of the language of the environment.
+func Node(version string) error {
+	if version != "" && !nodeVersionRegex.MatchString(version) {
+		return errors.Newf("invalid Node.js version %s, should be the full release, e.g. 20.11.1", version)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.NodeVersion = &version
+	return nil
+}
+
+// NPMPackage installs the global packages by npm. The default Node.js is
+// installed if Node is not declared.
+func NPMPackage(deps []string) error {
+
+	if len(deps) == 0 {
+		return errors.New("Can not install empty npm package")
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	if g.NodeVersion == nil {
+		version := ""
+		g.NodeVersion = &version
+	}
+	g.NPMPackages = append(g.NPMPackages, deps)
+	return nil
+}
+
+// Rust installs the Rust toolchain by rustup, the stable one if toolchain is
+// empty, with the components added to the minimal profile.
+func Rust(toolchain string, components []string) error {
+	if toolchain != "" && !rustToolchainRegex.MatchString(toolchain) {
+		return errors.Newf("invalid Rust toolchain %s, should be the channel or the release, e.g. 1.75.0", toolchain)
+	}
+	for _, c := range components {
+		if !rustComponentRegex.MatchString(c) {
+			return errors.Newf("invalid rustup component: %s", c)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.RustConfig = &ir.RustConfig{Toolchain: toolchain, Components: components}
+	return nil
+}
+
+// CargoPackage installs the tools by `cargo install`. The stable Rust is
+// installed if Rust is not declared.
+func CargoPackage(deps []string) error {
+
+	if len(deps) == 0 {
+		return errors.New("Can not install empty cargo package")
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	if g.RustConfig == nil {
+		g.RustConfig = &ir.RustConfig{}
+	}
+	g.CargoPackages = append(g.CargoPackages, deps)
+	return nil
+}
+
+// PyPIPackage installs the packages, the requirements file and the wheels. The
+// files are the ones in the build context required by the requirements file,
+// refer to DependencyFiles.
+func PyPIPackage(deps []string, requirementsFile string, files []string, wheels []string) error {
 	g := DefaultGraph.(*generalGraph)
 
 	if len(deps) > 0 {
@@ -84,114 +511,633 @@ func PyPIPackage(deps []string, requirementsFile string, wheels []string) error
 
 	if requirementsFile != "" {
 		g.RequirementsFile = &requirementsFile
+		g.RequirementsFiles = files
+	}
+
+	return nil
+}
+
+func RPackage(deps []string) error {
+
+	if len(deps) == 0 {
+		return errors.New("Can not install empty R package")
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	g.RPackages = append(g.RPackages, deps)
+
+	return nil
+}
+
+// JuliaPackage installs the Julia packages. If the platform is set, e.g.
+// `linux/arm64`, the packages replace the default ones for the platform.
+// The uuids map the package names to the UUIDs, to disambiguate the packages
+// with the same name in different registries. The packages are precompiled
+// even if the `julia.precompile` feature is disabled if precompile is true, and
+// baked into the sysimage if sysimage is true.
+func JuliaPackage(deps []string, platform string, uuids map[string]string, precompile, sysimage bool) error {
+
+	if len(deps) == 0 {
+		return errors.New("Can not install empty Julia package")
+	}
+	if err := validateJuliaPackages(deps); err != nil {
+		return err
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	names := make(map[string]bool, len(deps))
+	nameList := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		p, _ := parseJuliaPackage(dep)
+		names[p.Name] = true
+		nameList = append(nameList, p.Name)
+	}
+	for name, id := range uuids {
+		if !names[name] {
+			return errors.Newf("Julia package %s of the uuid is not in the packages", name)
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return errors.Wrapf(err, "invalid uuid of the Julia package %s: %s", name, id)
+		}
+		if g.JuliaPackageUUIDs == nil {
+			g.JuliaPackageUUIDs = map[string]string{}
+		}
+		g.JuliaPackageUUIDs[name] = parsed.String()
+	}
+	if precompile {
+		g.JuliaPrecompilePackages = append(g.JuliaPrecompilePackages, nameList...)
+	}
+	if sysimage {
+		g.addJuliaSysimagePackages(nameList)
+	}
+
+	if platform == "" {
+		g.JuliaPackages = append(g.JuliaPackages, deps)
+		return nil
 	}
+	p, err := platforms.Parse(platform)
+	if err != nil {
+		return errors.Wrapf(err, "invalid platform of the Julia packages: %s", platform)
+	}
+	g.JuliaPlatformPackages = append(g.JuliaPlatformPackages, ir.PlatformPackages{
+		Platform: platforms.Format(platforms.Normalize(p)),
+		Packages: deps,
+	})
+	return nil
+}
+
+func SystemPackage(deps []string) {
+	g := DefaultGraph.(*generalGraph)
+
+	g.SystemPackages = append(g.SystemPackages, deps...)
+}
+
+func GPU(numGPUs int) {
+	g := DefaultGraph.(*generalGraph)
+
+	g.NumGPUs = numGPUs
+}
+
+func CUDA(version, cudnn string, capabilities []string) error {
+	g := DefaultGraph.(*generalGraph)
+
+	version, err := cudaRelease(version)
+	if err != nil {
+		return err
+	}
+	if supported := supportedCUDNN(version); len(supported) > 0 {
+		if cudnn == "" {
+			cudnn = supported[0]
+		}
+		found := false
+		for _, c := range supported {
+			found = found || c == cudnn
+		}
+		if !found {
+			return errors.Newf("cuDNN %s is not available for CUDA %s, the supported are %s",
+				cudnn, version, strings.Join(supported, ", "))
+		}
+	}
+
+	seen := make(map[string]bool, len(capabilities))
+	for _, c := range capabilities {
+		if !computeCapabilityRegex.MatchString(c) {
+			return errors.Newf("invalid compute capability %s, should be like 8.6", c)
+		}
+		if seen[c] {
+			return errors.Newf("duplicate compute capability %s", c)
+		}
+		seen[c] = true
+		if !isComputeCapabilitySupported(version, c) {
+			logrus.Warnf("the compute capability %s may not be supported by CUDA %s", c, version)
+		}
+	}
+
+	g.CUDA = &version
+	if len(cudnn) > 0 {
+		g.CUDNN = cudnn
+	}
+	g.CUDAComputeCapabilities = capabilities
+	return nil
+}
+
+func VSCodePlugins(plugins []string) error {
+	g := DefaultGraph.(*generalGraph)
+
+	for _, p := range plugins {
+		plugin, err := vscode.ParsePlugin(p)
+		if err != nil {
+			return err
+		}
+		g.VSCodePlugins = append(g.VSCodePlugins, *plugin)
+	}
+	return nil
+}
+
+// VSCodeSettings adds the default settings of VS Code server, the later ones
+// take precedence over the earlier ones.
+func VSCodeSettings(settings map[string]interface{}) error {
+	g := DefaultGraph.(*generalGraph)
+
+	if _, err := json.Marshal(settings); err != nil {
+		return errors.Wrap(err, "invalid VS Code settings")
+	}
+	if g.VSCodeSettings == nil {
+		g.VSCodeSettings = make(map[string]interface{}, len(settings))
+	}
+	for k, v := range settings {
+		g.VSCodeSettings[k] = v
+	}
+	return nil
+}
+
+// UbuntuAPT updates the Ubuntu apt source.list in the image.
+func UbuntuAPT(source string) error {
+	if source == "" {
+		return errors.New("source is required")
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.UbuntuAPTSource = &source
+	return nil
+}
+
+func PyPIIndex(url, extraURL string, trust bool) error {
+	if url == "" {
+		return errors.New("url is required")
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.PyPIIndexURL = &url
+	if len(extraURL) > 0 {
+		g.PyPIExtraIndexURL = &extraURL
+	}
+	g.PyPITrust = trust
+	return nil
+}
+
+func CRANMirror(url string) error {
+	g := DefaultGraph.(*generalGraph)
+
+	g.CRANMirrorURL = &url
+	return nil
+}
+
+// validateJuliaPackages rejects the packages which can not be added
+// non-interactively in the build.
+func validateJuliaPackages(deps []string) error {
+	for _, dep := range deps {
+		if strings.ContainsAny(dep, `'"`) {
+			return errors.Newf("invalid Julia package name: %s", dep)
+		}
+		if strings.HasPrefix(dep, "/") || strings.HasPrefix(dep, ".") || strings.HasPrefix(dep, "~") {
+			return errors.Newf("local Julia package %s is not supported, "+
+				"it requires `Pkg.develop` in the interactive session", dep)
+		}
+		if _, err := parseJuliaPackage(dep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JuliaDevPackage develops the Julia packages in the paths relative to the
+// build context with `Pkg.develop`, thus the edits are picked up live.
+func JuliaDevPackage(paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("Can not develop empty Julia package")
+	}
+	if err := validateJuliaPaths(paths); err != nil {
+		return err
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaDevPackages = append(g.JuliaDevPackages, paths...)
+
+	return nil
+}
+
+// JuliaProject instantiates the Julia projects in the paths relative to the
+// build context with their manifests. The projects are instantiated
+// concurrently if parallel is set. The project is the active one at runtime
+// if activate is set, which requires the single path.
+func JuliaProject(paths []string, parallel, activate bool) error {
+	if len(paths) == 0 {
+		return errors.New("Can not instantiate empty Julia project")
+	}
+	if err := validateJuliaPaths(paths); err != nil {
+		return err
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	if activate {
+		if len(paths) != 1 {
+			return errors.Newf("only one Julia project can be activated, got %d", len(paths))
+		}
+		if g.JuliaActiveProject != "" && g.JuliaActiveProject != paths[0] {
+			return errors.Newf("the Julia project %s is activated already", g.JuliaActiveProject)
+		}
+		g.JuliaActiveProject = paths[0]
+	}
+	g.JuliaProjects = append(g.JuliaProjects, paths...)
+	if parallel {
+		g.JuliaParallelInstantiate = true
+	}
+	return nil
+}
+
+func validateJuliaPaths(paths []string) error {
+	for _, p := range paths {
+		if filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p), "..") {
+			return errors.Newf("Julia package path %s must be relative to the build context", p)
+		}
+	}
+	return nil
+}
+
+// JuliaCachePackage fetches the Julia packages at build time without
+// installing them, to speed up `Pkg.add` at runtime.
+func JuliaCachePackage(deps []string) error {
+	if len(deps) == 0 {
+		return errors.New("Can not cache empty Julia package")
+	}
+	if err := validateJuliaPackages(deps); err != nil {
+		return err
+	}
+
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaCachePackages = append(g.JuliaCachePackages, deps)
+
+	return nil
+}
+
+// JuliaBuildLog caps the build logs of the Julia packages to the max size, only
+// their tail is kept. The logs are untouched if the max size is empty.
+func JuliaBuildLog(maxSize string) error {
+	var size int64
+	if maxSize != "" {
+		s, err := units.RAMInBytes(maxSize)
+		if err != nil {
+			return errors.Wrapf(err, "invalid max size of the Julia build logs: %s", maxSize)
+		}
+		if s <= 0 {
+			return errors.Newf("the max size of the Julia build logs must be positive: %s", maxSize)
+		}
+		size = s
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaBuildLogMaxSize = &size
+	return nil
+}
+
+func JuliaPackageServer(url string) error {
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaPackageServer = &url
+	return nil
+}
+
+// JuliaOffline builds the Julia environment without the public endpoints. The
+// Julia release is downloaded from the mirror, which has the same layout as
+// https://julialang-s3.julialang.org, and the packages from the pkg server.
+func JuliaOffline(releaseMirror string) error {
+	if err := checkJuliaReleaseMirror(releaseMirror); err != nil {
+		return err
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.JuliaOffline = true
+	if releaseMirror != "" {
+		g.JuliaReleaseMirror = releaseMirror
+	}
+	return nil
+}
+
+// JuliaMirror configures the mirrors of Julia, the empty ones are left as is.
+// The pkg server applies to both the build and the runtime, and the binary
+// mirror replaces https://julialang-s3.julialang.org for the Julia release.
+func JuliaMirror(pkgServer, binaryMirror string) error {
+	if err := checkJuliaReleaseMirror(binaryMirror); err != nil {
+		return err
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	if pkgServer != "" {
+		g.JuliaPackageServer = &pkgServer
+	}
+	if binaryMirror != "" {
+		g.JuliaReleaseMirror = binaryMirror
+	}
+	return nil
+}
+
+func checkJuliaReleaseMirror(mirror string) error {
+	if mirror == "" {
+		return nil
+	}
+	u, err := url.Parse(mirror)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Newf("invalid Julia release mirror %s, expect the http(s) URL", mirror)
+	}
+	return nil
+}
+
+// JuliaRegistry adds a Julia registry. The access token is read from the
+// host environment variable `tokenEnv` and only mounted during the build.
+func JuliaRegistry(url, tokenEnv string) error {
+	if url == "" {
+		return errors.New("registry url is required")
+	}
+	// the Pkg statements are single quoted for the shell
+	if strings.ContainsAny(url, "'\n") {
+		return errors.Newf("invalid registry url %q", url)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	registry := ir.JuliaRegistry{
+		URL: url,
+	}
+	if tokenEnv != "" {
+		registry.Secret = fmt.Sprintf("julia-registry-%d", len(g.JuliaRegistries))
+		g.BuildSecrets = append(g.BuildSecrets, ir.BuildSecret{
+			ID:  registry.Secret,
+			Env: tokenEnv,
+		})
+	}
+	g.JuliaRegistries = append(g.JuliaRegistries, registry)
+	return nil
+}
+
+// MountSecret mounts the secret at the target path in the install steps of
+// the packages and `run`. The secret is read from the host environment
+// variable `env` or the file, or provided by `envd build --secret` if both
+// are empty.
+func MountSecret(id, target, env, file string) error {
+	if !secretNameRegex.MatchString(id) {
+		return errors.Newf("invalid secret id: %s", id)
+	}
+	if !filepath.IsAbs(target) || filepath.Clean(target) != target || target == "/" {
+		return errors.Newf("secret target must be a clean absolute file path: %s", target)
+	}
+	if env != "" && file != "" {
+		return errors.New("only one of env and file can be specified for the secret")
+	}
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the absolute path of %s", file)
+		}
+		file = abs
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	for _, s := range g.BuildSecrets {
+		if s.ID == id {
+			return errors.Newf("duplicate secret id: %s", id)
+		}
+		if s.Target == target {
+			return errors.Newf("duplicate secret target: %s", target)
+		}
+	}
+	g.BuildSecrets = append(g.BuildSecrets, ir.BuildSecret{
+		ID:     id,
+		Env:    env,
+		File:   file,
+		Target: target,
+	})
+	return nil
+}
+
+// TrustedCert trusts the cert of the internal server for the language.
+// The cert file is read from the host and must be PEM encoded.
+func TrustedCert(language, url, certFile string) error {
+	if _, ok := trustedCertEnvs[language]; !ok {
+		return errors.Newf("trusted cert is not supported for %s", language)
+	}
+	content, err := readPEMCerts(certFile)
+	if err != nil {
+		return err
+	}
+	g := DefaultGraph.(*generalGraph)
 
+	g.TrustedCerts = append(g.TrustedCerts, ir.TrustedCert{
+		Language: language,
+		URL:      url,
+		Content:  string(content),
+	})
 	return nil
 }
 
-func RPackage(deps []string) error {
-
-	if len(deps) == 0 {
-		return errors.New("Can not install empty R package")
+// CACert installs the CA into the system trust store of the image, and the
+// build steps and the languages are configured to use the system trust store.
+// The cert file is read from the host and must be PEM encoded.
+func CACert(certFile string) error {
+	content, err := readPEMCerts(certFile)
+	if err != nil {
+		return err
 	}
-
 	g := DefaultGraph.(*generalGraph)
 
-	g.RPackages = append(g.RPackages, deps)
-
+	g.CACerts = append(g.CACerts, string(content))
 	return nil
 }
 
-func JuliaPackage(deps []string) error {
-
-	if len(deps) == 0 {
-		return errors.New("Can not install empty Julia package")
+// readPEMCerts reads the cert file and checks that all the PEM blocks are
+// valid certs.
+func readPEMCerts(certFile string) ([]byte, error) {
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the cert file %s", certFile)
+	}
+	rest := content
+	count := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the cert in %s", certFile)
+		}
+		count++
+	}
+	if count == 0 {
+		return nil, errors.Newf("no PEM encoded cert found in %s", certFile)
 	}
+	return content, nil
+}
 
+// Proxy sets the proxy of the build steps. The empty one falls back to the
+// environment variable of the host, e.g. `HTTPS_PROXY` or `https_proxy`.
+func Proxy(httpProxy, httpsProxy, noProxy string) error {
+	proxy := ir.ProxyConfig{
+		HTTPProxy:  proxyOrHostEnv(httpProxy, "HTTP_PROXY"),
+		HTTPSProxy: proxyOrHostEnv(httpsProxy, "HTTPS_PROXY"),
+		NoProxy:    proxyOrHostEnv(noProxy, "NO_PROXY"),
+	}
+	if proxy.HTTPProxy == "" && proxy.HTTPSProxy == "" {
+		return errors.New("no proxy is declared or found in the environment variables of the host")
+	}
+	for _, p := range []string{proxy.HTTPProxy, proxy.HTTPSProxy} {
+		if p == "" {
+			continue
+		}
+		if u, err := url.Parse(p); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Newf("invalid proxy %s, must be a URL like http://proxy:3128", p)
+		}
+	}
 	g := DefaultGraph.(*generalGraph)
 
-	g.JuliaPackages = append(g.JuliaPackages, deps)
-
+	g.ProxyConfig = &proxy
 	return nil
 }
 
-func SystemPackage(deps []string) {
+// BuildLimits sets the resource limits of every step of the build, e.g. `30m`
+// of the step timeout and `8g` of the memory. The empty ones are not limited.
+func BuildLimits(stepTimeout, memory, cgroupParent string) error {
+	limits := ir.BuildLimitsConfig{CgroupParent: cgroupParent}
+	if stepTimeout != "" {
+		timeout, err := time.ParseDuration(stepTimeout)
+		if err != nil || timeout <= 0 {
+			return errors.Newf("invalid step timeout %s, must be a positive duration like 30m", stepTimeout)
+		}
+		limits.StepTimeout = timeout
+	}
+	if memory != "" {
+		mem, err := units.RAMInBytes(memory)
+		if err != nil || mem <= 0 {
+			return errors.Newf("invalid memory limit %s, must be a size like 8g", memory)
+		}
+		limits.Memory = mem
+	}
 	g := DefaultGraph.(*generalGraph)
 
-	g.SystemPackages = append(g.SystemPackages, deps...)
+	g.BuildLimits = &limits
+	return nil
 }
 
-func GPU(numGPUs int) {
-	g := DefaultGraph.(*generalGraph)
-
-	g.NumGPUs = numGPUs
+func proxyOrHostEnv(proxy, env string) string {
+	if proxy != "" {
+		return proxy
+	}
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(env))
 }
 
-func CUDA(version, cudnn string) {
+// BuildWorker restricts the build to the BuildKit workers matching the
+// constraints, and the CUDA related stages to the ones matching gpuConstraints.
+// The constraints are containerd filters, e.g. `labels.gpu==true`.
+func BuildWorker(constraints, gpuConstraints []string) error {
+	for _, c := range append(append([]string{}, constraints...), gpuConstraints...) {
+		if _, err := filters.Parse(c); err != nil {
+			return errors.Wrapf(err, "invalid worker constraint: %s", c)
+		}
+	}
 	g := DefaultGraph.(*generalGraph)
 
-	g.CUDA = &version
-	if len(cudnn) > 0 {
-		g.CUDNN = cudnn
-	}
+	g.WorkerConstraints = constraints
+	g.GPUWorkerConstraints = gpuConstraints
+	return nil
 }
 
-func VSCodePlugins(plugins []string) error {
+// Platform builds the image for the platform, e.g. `linux/arm64`, instead of
+// the default `linux/amd64`.
+func Platform(platform string) error {
 	g := DefaultGraph.(*generalGraph)
 
-	for _, p := range plugins {
-		plugin, err := vscode.ParsePlugin(p)
-		if err != nil {
-			return err
-		}
-		g.VSCodePlugins = append(g.VSCodePlugins, *plugin)
-	}
-	return nil
+	return g.SetPlatform(platform)
 }
 
-// UbuntuAPT updates the Ubuntu apt source.list in the image.
-func UbuntuAPT(source string) error {
-	if source == "" {
-		return errors.New("source is required")
+// LanguageCacheDir relocates the package caches of all the languages
+// (Julia depot, pip cache, conda pkgs) under the given directory.
+func LanguageCacheDir(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return errors.Newf("cache dir must be an absolute path: %s", dir)
 	}
 	g := DefaultGraph.(*generalGraph)
 
-	g.UbuntuAPTSource = &source
+	g.LanguageCacheDir = &dir
 	return nil
 }
 
-func PyPIIndex(url, extraURL string, trust bool) error {
-	if url == "" {
-		return errors.New("url is required")
-	}
+func Shell(shell string) error {
 	g := DefaultGraph.(*generalGraph)
 
-	g.PyPIIndexURL = &url
-	if len(extraURL) > 0 {
-		g.PyPIExtraIndexURL = &extraURL
-	}
-	g.PyPITrust = trust
+	g.Shell = shell
 	return nil
 }
 
-func CRANMirror(url string) error {
+// Tmux installs tmux with the default config in the dev environment.
+func Tmux(defaultSession bool) {
 	g := DefaultGraph.(*generalGraph)
 
-	g.CRANMirrorURL = &url
-	return nil
+	g.Tmux = &ir.TmuxConfig{
+		DefaultSession: defaultSession,
+	}
 }
 
-func JuliaPackageServer(url string) error {
+// ShellCompletion enables the completions of the given tools in the shell.
+func ShellCompletion(tools []string) error {
+	for _, tool := range tools {
+		if _, ok := shellCompletionCommands[tool]; !ok {
+			return errors.Newf("shell completion is not supported for %s", tool)
+		}
+	}
 	g := DefaultGraph.(*generalGraph)
 
-	g.JuliaPackageServer = &url
+	g.ShellCompletions = tools
 	return nil
 }
 
-func Shell(shell string) error {
+// StopSignal configures the signal to stop the container and the seconds
+// to wait for the processes to exit gracefully.
+func StopSignal(signal string, gracePeriod int) error {
+	sig := strings.ToUpper(signal)
+	if !strings.HasPrefix(sig, "SIG") {
+		sig = "SIG" + sig
+	}
+	if _, ok := stopSignals[sig]; !ok {
+		return errors.Newf("unsupported stop signal: %s", signal)
+	}
+	if gracePeriod < 0 {
+		return errors.Newf("grace period must not be negative: %d", gracePeriod)
+	}
 	g := DefaultGraph.(*generalGraph)
 
-	g.Shell = shell
+	g.StopConfig = &ir.StopConfig{
+		Signal:      sig,
+		GracePeriod: gracePeriod,
+	}
 	return nil
 }
 
@@ -212,13 +1158,89 @@ func RStudioServer() error {
 	return nil
 }
 
-func Run(commands []string, mount bool) error {
+func Run(commands []string, mount bool, user string, test bool) error {
+	if user != "" && !userRegex.MatchString(user) {
+		return errors.Newf("invalid user %s, should be the name or uid[:gid]", user)
+	}
 	g := DefaultGraph.(*generalGraph)
 
+	// the tests are run in the environment by `envd test` instead of the build
+	if test {
+		if mount || user != "" {
+			return errors.New("`mount_host` and `user` are not supported for the test commands")
+		}
+		g.RuntimeTests = append(g.RuntimeTests, commands)
+		return nil
+	}
+
 	g.Exec = append(g.Exec, ir.RunBuildCommand{
 		Commands:  commands,
 		MountHost: mount,
+		User:      user,
+	})
+	return nil
+}
+
+// Group declares an additional group created in the image.
+func Group(name string, gid int) error {
+	if err := checkAccountName(name); err != nil {
+		return err
+	}
+	if gid <= 0 {
+		return errors.Newf("gid of group %s should be positive", name)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	groups := append(append([]ir.GroupAccount{}, g.Groups...), ir.GroupAccount{Name: name, GID: gid})
+	if err := validateAccounts(g.Users, groups); err != nil {
+		return err
+	}
+	g.Groups = groups
+	return nil
+}
+
+// User declares an additional user created in the image. The user private
+// group with the gid of uid is created if the primary group is empty.
+func User(name string, uid int, group string, groups, directories []string) error {
+	if err := checkAccountName(name); err != nil {
+		return err
+	}
+	if uid <= 0 {
+		return errors.Newf("uid of user %s should be positive", name)
+	}
+	for _, gr := range append([]string{group}, groups...) {
+		if gr != "" && !accountNameRegex.MatchString(gr) {
+			return errors.Newf("invalid group name %s of user %s", gr, name)
+		}
+	}
+	for _, dir := range directories {
+		if !filepath.IsAbs(dir) {
+			return errors.Newf("directory %s of user %s should be an absolute path", dir, name)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	users := append(append([]ir.UserAccount{}, g.Users...), ir.UserAccount{
+		Name:        name,
+		UID:         uid,
+		Group:       group,
+		Groups:      groups,
+		Directories: directories,
 	})
+	if err := validateAccounts(users, g.Groups); err != nil {
+		return err
+	}
+	g.Users = users
+	return nil
+}
+
+func checkAccountName(name string) error {
+	if !accountNameRegex.MatchString(name) {
+		return errors.Newf("invalid account name %s", name)
+	}
+	if reservedAccountNames[name] {
+		return errors.Newf("account %s is managed by envd", name)
+	}
 	return nil
 }
 
@@ -243,13 +1265,22 @@ func CondaChannel(channel string) error {
 	return nil
 }
 
-func CondaPackage(deps []string, channel []string, envFile string) error {
+// CondaPackage installs the packages or the env file, the files are the same
+// as PyPIPackage.
+func CondaPackage(deps []string, channel []string, envFile string, files []string) error {
 	g := DefaultGraph.(*generalGraph)
+	if g.CondaConfig == nil {
+		return errors.New("conda is not installed, add `install.conda()` before the conda packages")
+	}
 
 	g.CondaConfig.CondaPackages = append(
 		g.CondaConfig.CondaPackages, deps...)
 
-	g.CondaConfig.CondaEnvFileName = envFile
+	// the env file declared by the previous call is kept
+	if envFile != "" {
+		g.CondaConfig.CondaEnvFileName = envFile
+		g.CondaEnvFiles = files
+	}
 
 	if len(channel) != 0 {
 		g.CondaConfig.AdditionalChannels = append(
@@ -267,13 +1298,65 @@ func Copy(src, dest string) {
 	})
 }
 
-func Mount(src, dest string) {
+func Mount(src, dest string, readOnly bool) {
 	g := DefaultGraph.(*generalGraph)
 
 	g.Mount = append(g.Mount, ir.MountInfo{
 		Source:      src,
 		Destination: dest,
+		ReadOnly:    readOnly,
+	})
+}
+
+// Volume mounts the named volume at the destination, e.g. the model cache
+// shared by the environments.
+func Volume(name, dest string, readOnly bool) error {
+	if !volumeNameRegex.MatchString(name) {
+		return errors.Newf("invalid volume name %s, must match %s", name, volumeNameRegex)
+	}
+	if !filepath.IsAbs(dest) {
+		return errors.Newf("the destination of the volume %s must be an absolute path", name)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	for _, m := range g.Mount {
+		if m.Destination == dest {
+			return errors.Newf("the destination %s is already mounted", dest)
+		}
+	}
+	g.Mount = append(g.Mount, ir.MountInfo{
+		Volume:      name,
+		Destination: dest,
+		ReadOnly:    readOnly,
+	})
+	return nil
+}
+
+// Artifact bakes the content of the artifact image pulled by the reference at
+// the destination, read-only. The reference must be pinned by digest.
+func Artifact(ref, dest string) error {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return errors.Wrapf(err, "invalid artifact reference: %s", ref)
+	}
+	if _, ok := named.(reference.Canonical); !ok {
+		return errors.Newf("artifact reference must be pinned by digest: %s", ref)
+	}
+	if !filepath.IsAbs(dest) {
+		return errors.Newf("artifact path must be an absolute path: %s", dest)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	for _, a := range g.Artifacts {
+		if a.Destination == dest {
+			return errors.Newf("artifact path %s is already used", dest)
+		}
+	}
+	g.Artifacts = append(g.Artifacts, ir.ArtifactInfo{
+		Reference:   named.String(),
+		Destination: dest,
 	})
+	return nil
 }
 
 func HTTP(url, checksum, filename string) error {
@@ -308,20 +1391,197 @@ func RuntimeCommands(commands map[string]string) {
 	}
 }
 
-func RuntimeDaemon(commands [][]string) {
+// RuntimeDaemon runs the commands in the background. The probes and names, if
+// any, are the readiness probes and the service names of the daemons
+// respectively, empty for none. A probe is the HTTP endpoint to GET, or the
+// absolute path of the file created by the daemon when it's ready. The
+// restart strategy applies to all of them.
+func RuntimeDaemon(commands [][]string, probes, names []string, restart string) error {
+	if len(probes) > 0 && len(probes) != len(commands) {
+		return errors.Newf("the number of daemon probes (%d) must match the commands (%d)",
+			len(probes), len(commands))
+	}
+	for _, probe := range probes {
+		if probe != "" && !isHTTPProbe(probe) && !filepath.IsAbs(probe) {
+			return errors.Newf("invalid daemon probe %s, expect the HTTP endpoint or the absolute file path", probe)
+		}
+	}
+	if len(names) > 0 && len(names) != len(commands) {
+		return errors.Newf("the number of daemon names (%d) must match the commands (%d)",
+			len(names), len(commands))
+	}
+	if restart == "" {
+		restart = restartOnFailure
+	}
+	switch restart {
+	case restartAlways, restartOnFailure, restartNever:
+	default:
+		return errors.Newf("invalid daemon restart strategy %s, must be one of %s, %s, %s",
+			restart, restartAlways, restartOnFailure, restartNever)
+	}
 	g := DefaultGraph.(*generalGraph)
 
+	seen := map[string]bool{}
+	for _, n := range g.RuntimeDaemonNames {
+		seen[n] = true
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if !types.ServiceNameRegex.MatchString(name) {
+			return errors.Newf("invalid daemon name %s, must match %s", name, types.ServiceNameRegex)
+		}
+		if reservedServiceName(name) {
+			return errors.Newf("daemon name %s is reserved by envd", name)
+		}
+		if seen[name] {
+			return errors.Newf("duplicate daemon name %s", name)
+		}
+		seen[name] = true
+	}
+
 	g.RuntimeDaemon = append(g.RuntimeDaemon, commands...)
+	if len(probes) == 0 {
+		probes = make([]string, len(commands))
+	}
+	g.RuntimeDaemonProbes = append(g.RuntimeDaemonProbes, probes...)
+	if len(names) == 0 {
+		names = make([]string, len(commands))
+	}
+	g.RuntimeDaemonNames = append(g.RuntimeDaemonNames, names...)
+	for range commands {
+		g.RuntimeDaemonRestart = append(g.RuntimeDaemonRestart, restart)
+	}
+	return nil
 }
 
-func RuntimeExpose(envdPort, hostPort int, serviceName string, listeningAddr string) error {
+// RuntimeExpose exposes the port in the image, and publishes it to the host
+// unless `publish` is false.
+func RuntimeExpose(envdPort, hostPort int, serviceName string, listeningAddr string, publish bool) error {
+	if !publish && hostPort != 0 {
+		return errors.Newf("host port %d is set for the port %d, which is not published", hostPort, envdPort)
+	}
 	g := DefaultGraph.(*generalGraph)
 
+	for _, item := range g.RuntimeExpose {
+		if item.EnvdPort == envdPort {
+			return errors.Newf("port %d is exposed more than once", envdPort)
+		}
+		if !publish || item.ExposeOnly || hostPort == 0 || item.HostPort != hostPort {
+			continue
+		}
+		if item.ListeningAddr == listeningAddr || isUnspecifiedAddr(item.ListeningAddr) || isUnspecifiedAddr(listeningAddr) {
+			return errors.Newf("host port %d is published for both the port %d and %d", hostPort, item.EnvdPort, envdPort)
+		}
+	}
+
 	g.RuntimeExpose = append(g.RuntimeExpose, ir.ExposeItem{
 		EnvdPort:      envdPort,
 		HostPort:      hostPort,
 		ServiceName:   serviceName,
 		ListeningAddr: listeningAddr,
+		ExposeOnly:    !publish,
+	})
+	return nil
+}
+
+// isUnspecifiedAddr returns true if the address listens on all the interfaces.
+func isUnspecifiedAddr(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// InitProcess sets the init process run as PID 1, which reaps the zombies and
+// forwards the signals to the entrypoint. `none` disables it.
+func InitProcess(name string) error {
+	if _, ok := initProcessCommands[name]; !ok && name != initProcessNone {
+		return errors.Newf("unknown init process %s, valid values are tini, dumb-init and none", name)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.InitProcess = &name
+	return nil
+}
+
+// RuntimeGPU attaches the GPUs to the environment, by the count (-1 for all
+// the GPUs) or the devices, with the driver capabilities. One GPU is attached
+// if neither the count nor the devices is set.
+func RuntimeGPU(count int, devices, capabilities []string) error {
+	if count < -1 {
+		return errors.Newf("invalid count of the GPUs: %d", count)
+	}
+	if count != 0 && len(devices) > 0 {
+		return errors.New("the count and the devices of the GPUs are mutually exclusive")
+	}
+	if count == 0 && len(devices) == 0 {
+		count = 1
+	}
+	for _, d := range devices {
+		if !gpuDeviceRegex.MatchString(d) {
+			return errors.Newf("invalid GPU device %s, must be the index or the UUID, e.g. 0 or MIG-<uuid>", d)
+		}
+	}
+	for _, c := range capabilities {
+		if !gpuCapabilities[c] {
+			return errors.Newf("invalid GPU capability %s", c)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.RuntimeGPU = &ir.GPUConfig{
+		Count:        count,
+		Devices:      devices,
+		Capabilities: capabilities,
+	}
+	return nil
+}
+
+// RuntimeDependsOn declares the external service, the entrypoint waits for
+// it to be reachable before starting.
+func RuntimeDependsOn(name, host string, port, timeout int) error {
+	if net.ParseIP(host) == nil && !hostnameRegex.MatchString(host) {
+		return errors.Newf("invalid host of the dependency: %s", host)
+	}
+	if port < 1 || port > 65535 {
+		return errors.Newf("invalid port of the dependency: %d", port)
+	}
+	if timeout < 0 {
+		return errors.Newf("invalid timeout of the dependency: %d", timeout)
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s:%d", host, port)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.RuntimeDependsOn = append(g.RuntimeDependsOn, ir.ServiceDep{
+		Name:    name,
+		Host:    host,
+		Port:    port,
+		Timeout: timeout,
+	})
+	return nil
+}
+
+// RuntimeSecret declares the secret file provided by the orchestrator at
+// runtime. Only the parent dir is created in the image.
+func RuntimeSecret(source, path string) error {
+	if !secretNameRegex.MatchString(source) {
+		return errors.Newf("invalid secret source: %s", source)
+	}
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || path == "/" {
+		return errors.Newf("secret path must be a clean absolute file path: %s", path)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	for _, s := range g.RuntimeSecrets {
+		if s.Path == path {
+			return errors.Newf("duplicate secret path: %s", path)
+		}
+	}
+	g.RuntimeSecrets = append(g.RuntimeSecrets, ir.SecretMount{
+		Source: source,
+		Path:   path,
 	})
 	return nil
 }
@@ -341,6 +1601,24 @@ func RuntimeInitScript(commands []string) {
 	g.RuntimeInitScript = append(g.RuntimeInitScript, commands)
 }
 
+// RuntimePreDestroy runs the commands before the environment is stopped or
+// destroyed, within the grace period of `config.stop_signal`.
+func RuntimePreDestroy(commands []string) {
+	g := DefaultGraph.(*generalGraph)
+
+	g.RuntimePreDestroy = append(g.RuntimePreDestroy, commands)
+}
+
+func RuntimeEntryScript(location string, commands []string) {
+	g := DefaultGraph.(*generalGraph)
+
+	if location == "" {
+		location = defaultEntryScriptLocation
+	}
+	g.EntryScript = &location
+	g.EntryScriptCommands = append(g.EntryScriptCommands, commands...)
+}
+
 func Repo(url, description string) {
 	g := DefaultGraph.(*generalGraph)
 
@@ -350,6 +1628,120 @@ func Repo(url, description string) {
 	}
 }
 
+// SlimRuntime splits the build into the builder and runtime stages, only the
+// language runtime and packages are copied into the final image.
+func SlimRuntime() {
+	g := DefaultGraph.(*generalGraph)
+
+	g.SlimRuntime = true
+}
+
+// Attestation generates the attestation of the build inputs and the output
+// image to the output path, signed by the key if it's provided.
+func Attestation(output, signingKey string) error {
+	if output == "" {
+		return errors.New("output of the attestation is required")
+	}
+	if signingKey != "" {
+		if _, err := os.Stat(signingKey); err != nil {
+			return errors.Wrapf(err, "failed to find the signing key %s", signingKey)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.AttestationConfig = &ir.AttestationConfig{
+		Output:     output,
+		SigningKey: signingKey,
+	}
+	return nil
+}
+
+// compressionLevels are the valid levels of the compression algorithms
+var compressionLevels = map[string][2]int{
+	"gzip":         {0, 9},
+	"estargz":      {0, 9},
+	"zstd":         {0, 22},
+	"uncompressed": {0, 0},
+}
+
+// ImageCompression sets the layer compression of the output image, a negative
+// level keeps the default of the algorithm.
+func ImageCompression(algorithm string, level int) error {
+	levels, ok := compressionLevels[algorithm]
+	if !ok {
+		return errors.Newf("unknown compression %s, valid values are gzip, zstd, estargz and uncompressed", algorithm)
+	}
+	config := &ir.CompressionConfig{Algorithm: algorithm}
+	if level >= 0 {
+		if algorithm == "uncompressed" {
+			return errors.New("level is not supported by the uncompressed layers")
+		}
+		if level < levels[0] || level > levels[1] {
+			return errors.Newf("level of %s should be in [%d, %d]", algorithm, levels[0], levels[1])
+		}
+		config.Level = &level
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.CompressionConfig = config
+	return nil
+}
+
+// Output sets the output target of the image, the path is checked when the
+// build starts since it's on the host.
+func Output(target, name, path string, insecure bool, dockerConfigDir string) error {
+	switch target {
+	case "docker":
+	case "registry":
+		if name == "" {
+			return errors.New("name is required to push the image to the registry")
+		}
+	case "oci", "tarball":
+		if path == "" {
+			return errors.Newf("path is required by the %s output", target)
+		}
+	default:
+		return errors.Newf("unknown output target %s, valid values are docker, registry, oci and tarball", target)
+	}
+	if path != "" && (target == "docker" || target == "registry") {
+		return errors.Newf("path is not supported by the %s output", target)
+	}
+	if (insecure || dockerConfigDir != "") && target != "registry" {
+		return errors.New("insecure and docker_config are only supported by the registry output")
+	}
+	if name != "" {
+		if _, err := reference.ParseNormalizedNamed(name); err != nil {
+			return errors.Wrapf(err, "invalid image name %s", name)
+		}
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.OutputConfig = &ir.OutputConfig{
+		Target:          target,
+		Name:            name,
+		Path:            path,
+		Insecure:        insecure,
+		DockerConfigDir: dockerConfigDir,
+	}
+	return nil
+}
+
+// Metadata sets the author, maintainer and license of the image.
+// The license must be a SPDX license expression, e.g. `MIT OR Apache-2.0`.
+func Metadata(author, maintainer, license string) error {
+	if license != "" && !isSPDXExpression(license) {
+		return errors.Newf("license is not a valid SPDX expression: %s", license)
+	}
+	g := DefaultGraph.(*generalGraph)
+
+	g.Metadata = ir.ImageMetadata{
+		Author:     author,
+		Maintainer: maintainer,
+		License:    license,
+	}
+	return nil
+}
+
 func Owner(uid, gid int) {
 	g := DefaultGraph.(*generalGraph)
 	g.uid = uid
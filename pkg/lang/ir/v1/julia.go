@@ -20,6 +20,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cockroachdb/errors"
+	"github.com/docker/go-units"
 	"github.com/moby/buildkit/client/llb"
 )
 
@@ -28,22 +30,131 @@ const (
 	juliaBinDir  = "/opt/julia/bin"           // Location of Julia executable binary file
 	juliaPkgDir  = "/opt/julia/user_packages" // Location of additional packages installed via Julia
 	juliaBinName = "julia.tar.gz"             // Julia archive name
+
+	juliaMinHeapSizeHint = 64 * units.MiB         // Minimum sensible heap size hint
+	juliaBuildLogMaxSize = 64 * units.KiB         // Default cap of the package build logs
+	juliaWrapperPath     = "/usr/local/bin/julia" // Location of the wrapper with the default flags
+
+	juliaPkgServerTimeout = 30                           // Seconds to wait for the pkg server before the Pkg operations
+	juliaPkgServerLogDir  = "/tmp/envd-julia-pkg-server" // Location of the response of the pkg server, on a scratch mount
+
+	juliaReleaseHost   = "https://julialang-s3.julialang.org"
+	juliaDefaultSHA256 = "e71a24816e8fe9d5f4807664cbbb42738f5aa9fe05397d35c81d4c5d649b9d05" // Checksum of the default release on linux/amd64
+	juliaDefaultVer    = "1.8.5"
+	juliaReleaseURL    = "https://julialang-s3.julialang.org/bin/linux/%s/%s.%s/julia-%s-linux-%s.tar.gz"
+	juliaChecksumURL   = "https://julialang-s3.julialang.org/bin/checksums/julia-%s.sha256"
+
+	juliaPkgCacheDir = "/opt/julia/cached_packages" // Location of the packages fetched but not installed
+	juliaSecretDir   = "/run/secrets/julia"         // Location of the mounted registry tokens
+	juliaAskPassDir  = "/tmp/envd-askpass"          // Location of the git askpass script
+	juliaHookDir     = "/tmp/envd-julia-hook"       // Location of the failure hook script
+	juliaScriptsDir  = "/tmp/envd-julia-scripts"    // Location of the Julia programs of the build steps
+	juliaPrefsDir    = "/tmp/envd-julia-prefs"      // Location of the generated preferences
+
+	juliaArtifactOverridesDir = "/opt/julia/artifact_overrides" // Location of the baked artifacts
+
+	juliaSysimageDir      = "/opt/julia/sysimage"            // Location of the sysimage built by PackageCompiler
+	juliaSysimageDepotDir = "/tmp/envd-julia-sysimage-depot" // Location of the depot of PackageCompiler
+
+	juliaUnlockScriptPath = "/usr/local/bin/envd-unlock" // Location of the script to unlock the depot
+	juliaUnlockScript     = `#!/bin/sh
+set -e
+sudo chmod -R u+w %[1]s
+sudo chown -R envd:envd %[1]s
+echo "%[1]s is unlocked, set JULIA_DEPOT_PATH=%[1]s to modify the baked packages"
+`
 )
 
+// juliaStartupPath is the system wide startup file of Julia.
+const juliaStartupPath = "/opt/julia/etc/julia/startup.jl"
+
+// juliaLibcCheck rejects the musl based images, e.g. Alpine, since the Julia
+// release installed is linked against glibc, and the musl build of Julia is
+// only a tier 3 platform.
+const juliaLibcCheck = `sh -c "if [ -f /etc/alpine-release ] || ldd --version 2>&1 | grep -qi musl; then ` +
+	`echo 'envd: Julia requires a glibc based image, the musl based images such as Alpine are not supported' >&2; ` +
+	`exit 1; fi"`
+
 //go:embed julia.sh
 var downloadJuliaBashScript string
 
+var (
+	//go:embed julia_offline_check.jl
+	juliaOfflineCheckScript string
+	//go:embed julia_depot_export.jl
+	juliaDepotExportScript string
+)
+
+// juliaDistribution returns the url and sha256 checksum of the Julia tarball
+// of the platform. The checksum of the configured release, or of the default
+// one on the other platforms than the default, is empty, it's fetched from the
+// checksums of the release during the build.
+func (g generalGraph) juliaDistribution() (string, string, error) {
+	if g.JuliaDebugBuild != nil {
+		return g.JuliaDebugBuild.URL, g.JuliaDebugBuild.SHA256, nil
+	}
+	arch := juliaArchs[g.platform()]
+	version := g.juliaVersion()
+	m := juliaVersionRegex.FindStringSubmatch(version)
+	if m == nil {
+		return "", "", errors.Newf("invalid Julia version %s, expect a release such as 1.8.5", version)
+	}
+	url := g.juliaMirrored(fmt.Sprintf(juliaReleaseURL, arch[0], m[1], m[2], version, arch[1]))
+	if g.JuliaVersion == "" && g.platform() == defaultPlatform {
+		return url, juliaDefaultSHA256, nil
+	}
+	return url, "", nil
+}
+
+// juliaMirrored returns the URL of the Julia release on the mirror of the
+// offline build, if any.
+func (g generalGraph) juliaMirrored(url string) string {
+	if g.JuliaReleaseMirror == "" {
+		return url
+	}
+	return strings.TrimSuffix(g.JuliaReleaseMirror, "/") + strings.TrimPrefix(url, juliaReleaseHost)
+}
+
+// juliaMinVersion is the oldest supported Julia release, i.e. the 1.6 LTS.
+var juliaMinVersion = [2]int{1, 6}
+
+// juliaArchs are the dir and the file suffix of the Julia releases by the
+// platform.
+var juliaArchs = map[string][2]string{
+	"linux/amd64": {"x64", "x86_64"},
+	"linux/arm64": {"aarch64", "aarch64"},
+}
+
+// juliaVersion returns the Julia release to install.
+func (g generalGraph) juliaVersion() string {
+	if g.JuliaVersion == "" {
+		return juliaDefaultVer
+	}
+	return g.JuliaVersion
+}
+
 // getJuliaBinary returns the llb.State only after setting up Julia environment
 // A successful run of getJuliaBinary should set up the Julia environment
-func (g generalGraph) getJuliaBinary(root llb.State) llb.State {
+func (g generalGraph) getJuliaBinary(root llb.State) (llb.State, error) {
 
-	base := llb.Image(builderImage)
+	url, sha256, err := g.juliaDistribution()
+	if err != nil {
+		return llb.State{}, err
+	}
+	base := g.compileBuilderImage().
+		AddEnv("JULIA_URL", url).
+		AddEnv("JULIA_SHA256SUM", sha256)
+	if sha256 == "" {
+		base = base.AddEnv("JULIA_CHECKSUM_URL", g.juliaMirrored(fmt.Sprintf(juliaChecksumURL, g.juliaVersion())))
+	}
 	builder := base.
 		Run(llb.Shlexf("sh -c '%s'", downloadJuliaBashScript),
 			llb.WithCustomName("[internal] downloading julia binary")).Root()
 
 	var path = filepath.Join("/tmp", juliaBinName)
 	setJulia := root.
+		Run(llb.Shlex(juliaLibcCheck),
+			llb.WithCustomName("[internal] checking the libc of the base image for julia")).Root().
 		File(llb.Copy(builder, path, path),
 			llb.WithCustomNamef("[internal] copying %s to /tmp", juliaBinName)).
 		File(llb.Mkdir(juliaRootDir, 0755, llb.WithParents(true)),
@@ -51,48 +162,117 @@ func (g generalGraph) getJuliaBinary(root llb.State) llb.State {
 		Run(llb.Shlexf(`bash -c "tar zxvf %s --strip 1 -C %s && rm %s"`, path, juliaRootDir, path),
 			llb.WithCustomNamef("[internal] unpack julia archive under %s", juliaRootDir))
 
-	return setJulia.Root()
+	if g.JuliaDebugBuild != nil {
+		setJulia = setJulia.Run(llb.Shlexf("test -x %s", filepath.Join(juliaBinDir, "julia-debug")),
+			llb.WithCustomName("[internal] checking julia-debug in the debug build"))
+	}
+	return setJulia.Root(), nil
 }
 
 // installJulia returns the llb.State only after adding the Julia environment to $PATH
-// A successful run of installJulia should add Julia to global environment path
-func (g *generalGraph) installJulia(root llb.State) llb.State {
+// A successful run of installJulia should add Julia to global environment path,
+// which exposes both `julia` and `julia-debug` for the debug build
+func (g *generalGraph) installJulia(root llb.State) (llb.State, error) {
 
-	confJulia := g.getJuliaBinary(root)
+	confJulia, err := g.getJuliaBinary(root)
+	if err != nil {
+		return llb.State{}, err
+	}
 	confJulia = g.updateEnvPath(confJulia, juliaBinDir)
+	if g.JuliaRuntimeConfig != nil || g.JuliaREPLConfig != nil {
+		confJulia = g.compileJuliaRuntimeFlags(confJulia)
+	}
+	if g.JuliaREPLConfig != nil && g.JuliaREPLConfig.DisplayRows > 0 {
+		confJulia = g.compileJuliaStartup(confJulia)
+	}
+	if g.JuliaNumThreads != "" {
+		g.RuntimeEnviron["JULIA_NUM_THREADS"] = g.JuliaNumThreads
+	}
+	// the runtime Pkg operations use the same server as the build
+	if g.JuliaPackageServer != nil && *g.JuliaPackageServer != "" {
+		g.RuntimeEnviron["JULIA_PKG_SERVER"] = *g.JuliaPackageServer
+	}
+	// The packages are precompiled and the sysimage is built for the target
+	if g.JuliaCPUTarget != "" {
+		g.RuntimeEnviron["JULIA_CPU_TARGET"] = g.JuliaCPUTarget
+		confJulia = confJulia.AddEnv("JULIA_CPU_TARGET", g.JuliaCPUTarget)
+	}
 
-	return confJulia
+	return confJulia, nil
 }
 
-// installJuliaPackages returns the llb.State only after installing required Julia packages
-// A successful run of installJuliaPackages should install Julia packages under "/opt/julia/user_packages" and export the path
-func (g *generalGraph) installJuliaPackages(root llb.State) llb.State {
-
-	if len(g.JuliaPackages) == 0 {
+// compileJuliaRuntimeFlags generates a wrapper of Julia with the default flags,
+// which takes precedence over the Julia binary in $PATH. The flags are checked
+// against the installed Julia, since they are not supported by all versions.
+func (g generalGraph) compileJuliaRuntimeFlags(root llb.State) llb.State {
+	flags := g.juliaRuntimeFlags()
+	if len(flags) == 0 {
 		return root
 	}
 
-	root = root.File(llb.Mkdir(juliaPkgDir, 0755, llb.WithParents(true)),
-		llb.WithCustomName("[internal] creating folder for julia packages"))
-
-	// Allow root to utilize the installed Julia environment
-	root = g.updateEnvPath(root, juliaBinDir)
+	julia := filepath.Join(juliaBinDir, "julia")
+	return root.
+		Run(llb.Shlexf("%s %s -e nothing", julia, strings.Join(flags, " ")),
+			llb.WithCustomNamef("[internal] checking julia flags: %s", strings.Join(flags, " "))).Root().
+		File(llb.Mkfile(juliaWrapperPath, 0755, []byte(juliaWrapper(flags))),
+			llb.WithCustomNamef("[internal] generating julia wrapper %s", juliaWrapperPath))
+}
 
-	// Export "/opt/julia/user_packages" as the additional library path for root
-	root = root.AddEnv("JULIA_DEPOT_PATH", juliaPkgDir)
+// juliaWrapper returns the script to exec the Julia binary with the flags.
+func juliaWrapper(flags []string) string {
+	return fmt.Sprintf("#!/bin/sh\nexec %s %s \"$@\"\n",
+		filepath.Join(juliaBinDir, "julia"), strings.Join(flags, " "))
+}
 
-	// Export "/opt/julia/user_packages" as the additional library path for users
-	g.RuntimeEnviron["JULIA_DEPOT_PATH"] = juliaPkgDir
+// juliaRuntimeFlags returns the default flags of Julia from the runtime and
+// REPL configs.
+func (g generalGraph) juliaRuntimeFlags() []string {
+	var flags []string
+	if c := g.JuliaRuntimeConfig; c != nil {
+		if c.HeapSizeHint > 0 {
+			flags = append(flags, fmt.Sprintf("--heap-size-hint=%d", c.HeapSizeHint))
+		}
+		if c.GCThreads > 0 {
+			flags = append(flags, fmt.Sprintf("--gcthreads=%d", c.GCThreads))
+		}
+	}
+	if c := g.JuliaREPLConfig; c != nil {
+		if c.NoBanner {
+			flags = append(flags, "--banner=no")
+		}
+		if c.Color != "" {
+			flags = append(flags, fmt.Sprintf("--color=%s", c.Color))
+		}
+	}
+	return flags
+}
 
-	// Change owner of the "/opt/julia/user_packages" to users
-	g.UserDirectories = append(g.UserDirectories, juliaPkgDir)
+// compileJuliaStartup generates the system wide startup.jl with the defaults
+// of the REPL. The display rows of the arrays are taken from `LINES`, which
+// is respected if it's set by the user.
+func (g generalGraph) compileJuliaStartup(root llb.State) llb.State {
+	startup := fmt.Sprintf("haskey(ENV, \"LINES\") || (ENV[\"LINES\"] = \"%d\")\n",
+		g.JuliaREPLConfig.DisplayRows)
+	return root.
+		File(llb.Mkdir(filepath.Dir(juliaStartupPath), 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] creating folder for %s", juliaStartupPath)).
+		File(llb.Mkfile(juliaStartupPath, 0644, []byte(startup)),
+			llb.WithCustomNamef("[internal] generating %s", juliaStartupPath))
+}
 
-	for _, packages := range g.JuliaPackages {
-		command := fmt.Sprintf(`julia -e 'using Pkg; Pkg.add(["%s"])'`, strings.Join(packages, `","`))
-		run := root.
-			Run(llb.Shlex(command), llb.WithCustomNamef("[internal] installing Julia packages: %s", strings.Join(packages, " ")))
-		root = run.Root()
-	}
+// juliaScriptCommand returns the command to run the Julia program mounted by
+// juliaScriptsMount.
+func juliaScriptCommand(script string, args ...string) []string {
+	return append([]string{"julia", "--startup-file=no", "--history-file=no",
+		filepath.Join(juliaScriptsDir, script)}, args...)
+}
 
-	return root
+// juliaScriptsMount mounts the Julia programs of the build steps, thus they
+// are not in the image.
+func juliaScriptsMount() llb.RunOption {
+	scripts := llb.Scratch().
+		File(llb.Mkfile("julia_offline_check.jl", 0644, []byte(juliaOfflineCheckScript))).
+		File(llb.Mkfile("julia_depot_export.jl", 0644, []byte(juliaDepotExportScript)),
+			llb.WithCustomName("[internal] generating the Julia programs of the build"))
+	return llb.AddMount(juliaScriptsDir, scripts, llb.Readonly)
 }
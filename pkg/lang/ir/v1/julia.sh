@@ -1,6 +1,13 @@
 set -o pipefail && \
-JULIA_URL="https://julialang-s3.julialang.org/bin/linux/x64/1.8/julia-1.8.5-linux-x86_64.tar.gz"; \
-SHA256SUM="e71a24816e8fe9d5f4807664cbbb42738f5aa9fe05397d35c81d4c5d649b9d05"; \
+SHA256SUM="${JULIA_SHA256SUM}"; \
+
+if [ -z "${SHA256SUM}" ]; then
+    SHA256SUM=$(wget -q "${JULIA_CHECKSUM_URL}" -O - | grep "$(basename "${JULIA_URL}")" | cut -d " " -f 1)
+    if [ -z "${SHA256SUM}" ]; then
+        echo "failed to get the checksum of ${JULIA_URL} from ${JULIA_CHECKSUM_URL}, the Julia version may not exist"
+        exit 1
+    fi
+fi
 
 wget "${JULIA_URL}" -O /tmp/julia.tar.gz && \
 echo "${SHA256SUM}  /tmp/julia.tar.gz" > /tmp/sha256sum && \
@@ -0,0 +1,156 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/sirupsen/logrus"
+)
+
+// juliaDepotSubdirs are the dirs of the depot written by Pkg at runtime. They
+// are created at build time, thus they are owned by the user with the depot
+// even if no Pkg operation of the build writes them.
+var juliaDepotSubdirs = []string{"packages", "compiled", "registries", "environments", "artifacts", "logs", "scratchspaces"}
+
+// juliaSharedCacheDir is where the host wide caches of the Julia depot are
+// mounted to copy the used files into the depot.
+const juliaSharedCacheDir = "/tmp/envd-julia-shared"
+
+// checkJuliaRelocatableDepot rejects the settings which bake the absolute
+// paths into the relocatable depot.
+func (g generalGraph) checkJuliaRelocatableDepot() error {
+	if !g.isJuliaDepotRelocatable() {
+		return nil
+	}
+	if strings.Contains(g.JuliaArtifactOverrides, juliaArtifactOverridesDir) {
+		return errors.New("the Julia artifact overrides of the paths can not be relocated with the depot, " +
+			"override them by the artifact hashes instead")
+	}
+	if len(g.JuliaDevPackages) > 0 || len(g.JuliaProjects) > 0 {
+		logrus.Warn("the Julia dev packages and projects are outside of the depot, they are not relocated with it")
+	}
+	return nil
+}
+
+// verifyJuliaRelocatableDepot fails the build if the absolute path of the depot
+// is in any TOML file of it, e.g. the manifests and the preferences. The
+// registries, the package sources and the usage logs are skipped, since they
+// are not consulted by the path.
+func (g generalGraph) verifyJuliaRelocatableDepot(root llb.State) llb.State {
+	command := fmt.Sprintf(`sh -c "leaked=$(find %[1]s -name '*.toml' -not -path '%[1]s/registries/*' `+
+		`-not -path '%[1]s/packages/*' -not -path '%[1]s/logs/*' -exec grep -l %[1]s {} +); `+
+		`if [ -n \"${leaked}\" ]; then echo \"envd: the depot path %[1]s is baked into ${leaked}\" >&2; exit 1; fi"`,
+		juliaPkgDir)
+	return root.Run(llb.Shlex(command),
+		llb.WithCustomName("[internal] verifying the Julia depot is relocatable")).Root()
+}
+
+// juliaSharedCacheID returns the ID of the host wide cache of the compiled
+// Julia artifacts. The precompiled files are only valid for the exact Julia
+// build, thus the cache is keyed by the hash of the compile inputs, i.e. the
+// Julia distribution, the debug build and the platform. Julia validates every
+// precompiled file against the sources and dependencies of the package, so
+// the environments with overlapping packages share them safely.
+func (g generalGraph) juliaSharedCacheID() string {
+	url, sha256sum, err := g.juliaDistribution()
+	if err != nil {
+		// the invalid version fails installJulia before the packages
+		url = g.juliaVersion()
+	}
+	h := sha256.New()
+	for _, input := range []string{url, sha256sum, fmt.Sprint(g.JuliaDebugBuild != nil), g.platform()} {
+		h.Write([]byte(input))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("envd-julia-compiled/%s", hex.EncodeToString(h.Sum(nil))[:16])
+}
+
+// juliaDepotCache is the host wide cache of a dir in the depot.
+type juliaDepotCache struct {
+	dir string
+	id  string
+}
+
+// juliaDepotCaches returns the host wide caches of the depot. The package
+// sources and the artifacts are content addressed by their slugs and tree
+// hashes, so they are shared by all the Julia versions, while the compiled
+// files are keyed by the Julia build. The artifacts are not cached with the
+// artifact overrides, since the mount hides the `Overrides.toml` of the depot.
+func (g generalGraph) juliaDepotCaches() []juliaDepotCache {
+	var caches []juliaDepotCache
+	if g.isJuliaDownloadCacheEnabled() {
+		caches = append(caches, juliaDepotCache{dir: "packages", id: "envd-julia-packages"})
+	}
+	if (g.isJuliaDownloadCacheEnabled() || g.isJuliaSharedCacheEnabled()) && g.JuliaArtifactOverrides == "" {
+		caches = append(caches, juliaDepotCache{dir: "artifacts", id: "envd-julia-artifacts"})
+	}
+	if g.isJuliaSharedCacheEnabled() {
+		caches = append(caches, juliaDepotCache{dir: "compiled", id: g.juliaSharedCacheID()})
+	}
+	return caches
+}
+
+// juliaDepotCacheMounts mounts the host wide caches over the dirs of the
+// depot for the Pkg operations, thus the downloaded and compiled files are
+// written to the caches instead of the image layers. The caches are locked to
+// serialize the concurrent builds.
+func (g generalGraph) juliaDepotCacheMounts() []llb.RunOption {
+	var opts []llb.RunOption
+	for _, c := range g.juliaDepotCaches() {
+		opts = append(opts, g.userCacheMount(filepath.Join(juliaPkgDir, c.dir), c.id, llb.CacheMountLocked))
+	}
+	return opts
+}
+
+// exportJuliaDepotCache copies the files used by the environments from the
+// host wide caches into the depot after the Pkg operations, see
+// julia_depot_export.jl, thus only they are baked into the image instead of
+// the whole cache. The caches are mounted as the second depot so that Pkg locates the package sources in them. The build
+// context with the manifests of the projects is mounted in the dev environment
+// for the projects and the dev packages.
+func (g generalGraph) exportJuliaDepotCache(root, projects llb.State) llb.State {
+	args := []string{juliaSharedCacheDir, juliaPkgDir}
+	for _, p := range g.JuliaProjects {
+		args = append(args, filepath.Join(g.getWorkingDir(), p))
+	}
+	opts := []llb.RunOption{llb.Args(juliaScriptCommand("julia_depot_export.jl", args...)), juliaScriptsMount(),
+		llb.AddEnv("JULIA_DEPOT_PATH", fmt.Sprintf("%s:%s", juliaPkgDir, juliaSharedCacheDir)),
+		llb.WithCustomName("[internal] copying the used Julia packages from the shared cache")}
+	for _, c := range g.juliaDepotCaches() {
+		opts = append(opts, g.userCacheMount(filepath.Join(juliaSharedCacheDir, c.dir), c.id, llb.CacheMountShared))
+	}
+	if g.Dev && (len(g.JuliaProjects) > 0 || len(g.JuliaDevPackages) > 0) {
+		opts = append(opts, llb.AddMount(g.getWorkingDir(), projects, llb.Readonly))
+	}
+	return root.Run(append(opts, g.userRunOptions()...)...).Root()
+}
+
+// lockJuliaDepot sets the baked depot read-only, and generates the
+// `envd-unlock` script as the escape hatch.
+func (g generalGraph) lockJuliaDepot(root llb.State) llb.State {
+	return root.
+		Run(llb.Shlexf("chmod -R a-w %s", juliaPkgDir),
+			llb.WithCustomNamef("[internal] locking julia depot %s", juliaPkgDir)).Root().
+		File(llb.Mkfile(juliaUnlockScriptPath, 0755,
+			[]byte(fmt.Sprintf(juliaUnlockScript, juliaPkgDir))),
+			llb.WithCustomNamef("[internal] generating %s", juliaUnlockScriptPath))
+}
@@ -0,0 +1,37 @@
+# Copies the files of the dependencies of every environment from the caches
+# in the shared depot into the depot: the package sources, the artifacts
+# listed by them and their precompiled files. The environments are the default
+# one and the projects.
+#
+# Usage: julia julia_depot_export.jl <shared depot> <depot> [<project>...]
+using Pkg, TOML
+
+shared = ARGS[1]
+depot = ARGS[2]
+
+function bake(src)
+    startswith(src, shared * "/") || return
+    dst = depot * src[length(shared)+1:end]
+    if ispath(src) && !ispath(dst)
+        mkpath(dirname(dst))
+        cp(src, dst)
+    end
+end
+
+compiled = joinpath(shared, "compiled")
+for env in ["", ARGS[3:end]...]
+    isempty(env) ? Pkg.activate() : Pkg.activate(env)
+    for info in values(Pkg.dependencies())
+        bake(info.source)
+        for f in ("Artifacts.toml", "JuliaArtifacts.toml")
+            file = joinpath(info.source, f)
+            isfile(file) || continue
+            for v in values(TOML.parsefile(file)), meta in (v isa AbstractVector ? v : [v])
+                bake(joinpath(shared, "artifacts", meta["git-tree-sha1"]))
+            end
+        end
+        for d in (isdir(compiled) ? readdir(compiled; join=true) : String[])
+            bake(joinpath(d, info.name))
+        end
+    end
+end
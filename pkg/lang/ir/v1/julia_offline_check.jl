@@ -0,0 +1,21 @@
+# Fails if any of the packages is missing in the registries of the depots,
+# thus the offline build names the missing packages instead of hanging on the
+# fallbacks. The `Registry.toml` of the unpacked registries are read instead
+# of the internal Pkg APIs.
+#
+# Usage: julia julia_offline_check.jl <package>...
+using TOML
+
+registered = Set{String}()
+for depot in DEPOT_PATH
+    dir = joinpath(depot, "registries")
+    isdir(dir) || continue
+    for registry in readdir(dir; join=true)
+        file = joinpath(registry, "Registry.toml")
+        isfile(file) || continue
+        union!(registered, p["name"] for p in values(get(TOML.parsefile(file), "packages", Dict())))
+    end
+end
+
+absent = setdiff(ARGS, registered)
+isempty(absent) || error("envd: the Julia packages are not in the registries of the mirror: " * join(absent, ", "))
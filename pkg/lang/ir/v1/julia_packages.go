@@ -0,0 +1,867 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/sirupsen/logrus"
+
+	"github.com/tensorchord/envd/pkg/flag"
+	"github.com/tensorchord/envd/pkg/util/fileutil"
+)
+
+// juliaDefaultResolveStrategy keeps the versions of the packages installed by
+// the previous steps, for reproducibility.
+const juliaDefaultResolveStrategy = "all"
+
+// juliaPreserveLevels maps the resolve strategies to the preserve levels of Pkg.
+var juliaPreserveLevels = map[string]string{
+	"all":    "Pkg.PRESERVE_ALL",
+	"direct": "Pkg.PRESERVE_DIRECT",
+	"semver": "Pkg.PRESERVE_SEMVER",
+	"tiered": "Pkg.PRESERVE_TIERED",
+	"none":   "Pkg.PRESERVE_NONE",
+}
+
+// juliaResolveStrategies returns the sorted names of the resolve strategies.
+func juliaResolveStrategies() []string {
+	strategies := make([]string, 0, len(juliaPreserveLevels))
+	for s := range juliaPreserveLevels {
+		strategies = append(strategies, s)
+	}
+	sort.Strings(strategies)
+	return strategies
+}
+
+// juliaPreserveLevel returns the preserve level of Pkg for the resolve strategy.
+func (g generalGraph) juliaPreserveLevel() string {
+	if g.JuliaResolveStrategy == "" {
+		return juliaPreserveLevels[juliaDefaultResolveStrategy]
+	}
+	return juliaPreserveLevels[g.JuliaResolveStrategy]
+}
+
+// juliaCUDADriverLibDirs are where the NVIDIA container runtime mounts the
+// driver libraries, they are set in the CUDA base images.
+var juliaCUDADriverLibDirs = []string{"/usr/local/nvidia/lib", "/usr/local/nvidia/lib64"}
+
+// juliaDebuggers are the supported Julia debuggers.
+var juliaDebuggers = map[string]bool{
+	"Debugger":    true,
+	"Infiltrator": true,
+}
+
+// juliaVSCodeExtension is the VS Code extension of Julia, which has the debug
+// adapter built in.
+const juliaVSCodeExtension = "julialang.language-julia"
+
+// juliaLaunchConfig is the default launch config to debug the Julia files.
+var juliaLaunchConfig = map[string]interface{}{
+	"version": "0.2.0",
+	"configurations": []interface{}{
+		map[string]interface{}{
+			"type":        "julia",
+			"request":     "launch",
+			"name":        "Run active Julia file",
+			"program":     "${file}",
+			"stopOnEntry": false,
+			"cwd":         "${workspaceFolder}",
+			"juliaEnv":    "${command:activeJuliaEnvironment}",
+		},
+	},
+}
+
+// withJuliaDebuggers batches the debuggers into the first `Pkg.add` of the
+// packages. They are only added in the dev environment.
+func (g generalGraph) withJuliaDebuggers(packages [][]string) [][]string {
+	if !g.Dev || len(g.JuliaDebuggers) == 0 {
+		return packages
+	}
+	added := make(map[string]bool)
+	for _, group := range packages {
+		for _, p := range group {
+			parsed, _ := parseJuliaPackage(p)
+			added[parsed.Name] = true
+		}
+	}
+	var debuggers []string
+	for _, d := range g.JuliaDebuggers {
+		if !added[d] {
+			debuggers = append(debuggers, d)
+		}
+	}
+	if len(debuggers) == 0 {
+		return packages
+	}
+	if len(packages) == 0 {
+		return [][]string{debuggers}
+	}
+	res := append([][]string{}, packages...)
+	res[0] = append(append([]string{}, packages[0]...), debuggers...)
+	return res
+}
+
+// withJuliaCUDA batches CUDA.jl into the first `Pkg.add` of the packages,
+// unless it's added already.
+func (g generalGraph) withJuliaCUDA(packages [][]string) [][]string {
+	if g.JuliaCUDA == nil {
+		return packages
+	}
+	for _, group := range packages {
+		for _, p := range group {
+			if parsed, _ := parseJuliaPackage(p); parsed.Name == "CUDA" {
+				return packages
+			}
+		}
+	}
+	cuda := "CUDA"
+	if g.JuliaCUDA.Version != "" {
+		cuda = "CUDA@" + g.JuliaCUDA.Version
+	}
+	if len(packages) == 0 {
+		return [][]string{{cuda}}
+	}
+	res := append([][]string{}, packages...)
+	res[0] = append(append([]string{}, packages[0]...), cuda)
+	return res
+}
+
+// compileJuliaCUDAEnviron sets the runtime environment of CUDA.jl. The NVIDIA
+// container runtime mounts the driver only if the capabilities are requested,
+// which are set in the CUDA base images, but not in the others. The toolkit of
+// the CUDA base image is used by CUDA.jl before 4.0 instead of the artifacts.
+func (g *generalGraph) compileJuliaCUDAEnviron() {
+	defaults := map[string]string{
+		"NVIDIA_VISIBLE_DEVICES":     g.juliaCUDAVisibleDevices(),
+		"NVIDIA_DRIVER_CAPABILITIES": "compute,utility",
+	}
+	for k, v := range defaults {
+		if _, ok := g.RuntimeEnviron[k]; !ok {
+			g.RuntimeEnviron[k] = v
+		}
+	}
+	paths := filepath.SplitList(g.RuntimeEnviron["LD_LIBRARY_PATH"])
+	existing := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		existing[p] = true
+	}
+	for _, dir := range juliaCUDADriverLibDirs {
+		if !existing[dir] {
+			paths = append(paths, dir)
+		}
+	}
+	g.RuntimeEnviron["LD_LIBRARY_PATH"] = strings.Join(paths, ":")
+	if g.CUDA != nil {
+		g.RuntimeEnviron["JULIA_CUDA_USE_BINARYBUILDER"] = "false"
+	}
+}
+
+// juliaCUDAVisibleDevices returns the GPUs visible to CUDA.jl, which are the
+// ones attached by `runtime.gpu`, or the one GPU attached by `envd up` by
+// default.
+func (g generalGraph) juliaCUDAVisibleDevices() string {
+	gpu := g.RuntimeGPU
+	switch {
+	case gpu == nil:
+		return "0"
+	case len(gpu.Devices) > 0:
+		return strings.Join(gpu.Devices, ",")
+	case gpu.Count < 0:
+		return "all"
+	}
+	devices := make([]string, 0, gpu.Count)
+	for i := 0; i < gpu.Count; i++ {
+		devices = append(devices, strconv.Itoa(i))
+	}
+	return strings.Join(devices, ",")
+}
+
+// preloadJuliaCUDA loads CUDA.jl to download the CUDA artifacts and compile
+// them, thus the first `using CUDA` in the container does not stall. The
+// artifacts are selected by the driver, so they are left to the first use if
+// the builder has no GPU.
+func (g generalGraph) preloadJuliaCUDA(root llb.State, auth []llb.RunOption) llb.State {
+	command := g.juliaPkgCommand(`using CUDA; Base.invokelatest(CUDA.functional) ? ` +
+		`Base.invokelatest(CUDA.versioninfo) : println(stderr, "envd: no GPU is available in the build, ` +
+		`the CUDA artifacts are downloaded at the first use of CUDA.jl")`)
+	opts := append([]llb.RunOption{llb.Shlex(command), g.gpuStageConstraint(),
+		llb.WithCustomName("[internal] preloading CUDA.jl")}, auth...)
+	return root.Run(opts...).Root()
+}
+
+// juliaDebugAdapterEnabled checks if the launch config of the Julia debug
+// adapter should be added to the VS Code settings.
+func (g generalGraph) juliaDebugAdapterEnabled() bool {
+	if !g.Dev || len(g.JuliaDebuggers) == 0 {
+		return false
+	}
+	for _, p := range g.VSCodePlugins {
+		if strings.EqualFold(fmt.Sprintf("%s.%s", p.Publisher, p.Extension), juliaVSCodeExtension) {
+			return true
+		}
+	}
+	return false
+}
+
+// juliaPackages returns the Julia packages for the platform, the platform
+// without the overrides inherits the default ones.
+func (g generalGraph) juliaPackages(platform string) [][]string {
+	var packages [][]string
+	for _, p := range g.JuliaPlatformPackages {
+		if p.Platform == platform {
+			packages = append(packages, p.Packages)
+		}
+	}
+	if len(packages) == 0 {
+		return g.JuliaPackages
+	}
+	return packages
+}
+
+// installJuliaPackages returns the llb.State only after installing required Julia packages
+// A successful run of installJuliaPackages should install Julia packages under "/opt/julia/user_packages" and export the path
+func (g *generalGraph) installJuliaPackages(root llb.State) llb.State {
+
+	juliaPackages := g.withJuliaCUDA(g.withJuliaDebuggers(g.juliaPackages(g.platform())))
+	if !g.hasJuliaPackages() {
+		if g.JuliaSysimage != nil {
+			logrus.Warn("there are no Julia packages, the sysimage is not built")
+		}
+		return root
+	}
+
+	root = root.File(llb.Mkdir(juliaPkgDir, 0755, g.userMkdirOptions()...),
+		llb.WithCustomName("[internal] creating folder for julia packages"))
+
+	// The artifacts are overridden before any of them is downloaded by Pkg
+	if g.JuliaArtifactOverrides != "" {
+		root = g.compileJuliaArtifactOverrides(root)
+	}
+
+	// Allow root to utilize the installed Julia environment
+	root = g.updateEnvPath(root, juliaBinDir)
+
+	// Export "/opt/julia/user_packages" as the additional library path for root
+	root = root.AddEnv("JULIA_DEPOT_PATH", juliaPkgDir)
+
+	// Export "/opt/julia/user_packages" as the additional library path for users
+	depots := []string{juliaPkgDir}
+	// Packages added at runtime go to the relocated cache dir first
+	writableDepot := g.languageCacheDir("julia")
+	if writableDepot == "" && g.isJuliaDepotLocked() {
+		writableDepot = fileutil.DefaultHomeDir(".julia")
+		if g.Dev {
+			writableDepot = fileutil.EnvdHomeDir(".julia")
+		}
+	}
+	if writableDepot != "" {
+		depots = append([]string{writableDepot}, depots...)
+	}
+	// The fetched packages are reused by `Pkg.add` at runtime without downloading
+	if len(g.JuliaCachePackages) > 0 {
+		depots = append(depots, juliaPkgCacheDir)
+	}
+	g.RuntimeEnviron["JULIA_DEPOT_PATH"] = strings.Join(depots, ":")
+	// The default environment is still in the load path after the project
+	if g.JuliaActiveProject != "" {
+		g.RuntimeEnviron["JULIA_PROJECT"] = filepath.Join(g.getWorkingDir(), g.JuliaActiveProject)
+	}
+
+	// Change owner of the "/opt/julia/user_packages" to users, unless it's
+	// locked to be shared read-only. It's owned by the user up front in the
+	// rootless build, and the Pkg operations run as the user.
+	if !g.isJuliaDepotLocked() {
+		subdirs := make([]string, 0, len(juliaDepotSubdirs))
+		for _, d := range juliaDepotSubdirs {
+			subdirs = append(subdirs, filepath.Join(juliaPkgDir, d))
+		}
+		root = root.Run(append([]llb.RunOption{llb.Shlexf("mkdir -p %s", strings.Join(subdirs, " ")),
+			llb.WithCustomName("[internal] creating the writable dirs of the julia depot")},
+			g.userRunOptions()...)...).Root()
+		if !g.isRootlessBuild() {
+			g.UserDirectories = append(g.UserDirectories, juliaPkgDir)
+		}
+	}
+
+	auth := append(juliaNonInteractiveRunOptions(), g.juliaRegistryRunOptions()...)
+	auth = append(auth, g.juliaPkgServerRunOptions()...)
+	auth = append(auth, g.juliaOfflineRunOptions()...)
+	auth = append(auth, g.mountSecrets())
+	auth = append(auth, g.userRunOptions()...)
+	if g.JuliaPackageServer != nil && *g.JuliaPackageServer != "" {
+		g.checks = append(g.checks, g.waitJuliaPkgServer(root))
+	}
+	if !g.isJuliaPrecompileEnabled() || g.isJuliaPrecompileDeferred() {
+		auth = append(auth, llb.AddEnv("JULIA_PKG_PRECOMPILE_AUTO", "0"))
+	}
+	// The Pkg operations write the caches, while the later steps use the
+	// files exported into the depot
+	sysimageAuth := auth
+	auth = append(auth[:len(auth):len(auth)], g.juliaDepotCacheMounts()...)
+	if len(g.JuliaRegistries) > 0 || g.JuliaOffline {
+		opts := append([]llb.RunOption{llb.Shlex(g.juliaPkgCommand(g.juliaRegistryStatements())),
+			llb.WithCustomName("[internal] adding Julia registries")}, auth...)
+		root = root.Run(append(opts, g.juliaFailureHookRunOptions(nil)...)...).Root()
+	}
+
+	if g.JuliaOffline {
+		var packages []string
+		for _, p := range juliaPackages {
+			packages = append(packages, p...)
+		}
+		for _, p := range g.JuliaCachePackages {
+			packages = append(packages, p...)
+		}
+		if check := juliaOfflineCheck(packages); check != nil {
+			opts := append([]llb.RunOption{llb.Args(check), juliaScriptsMount(),
+				llb.WithCustomName("[internal] checking Julia packages in the registries of the mirror")}, auth...)
+			root = root.Run(opts...).Root()
+		}
+	}
+
+	if g.JuliaPreferences != "" {
+		root = g.compileJuliaPreferences(root)
+	}
+
+	for _, packages := range g.juliaInstallGroups(juliaPackages) {
+		command := g.juliaPkgCommand(fmt.Sprintf(`Pkg.add(%s; preserve=%s)`,
+			g.juliaPackageSpecs(packages), g.juliaPreserveLevel()))
+		opts := append([]llb.RunOption{llb.Shlex(command), g.gpuStageConstraint(),
+			llb.WithCustomNamef("[internal] installing Julia packages: %s", strings.Join(packages, " "))}, auth...)
+		run := root.
+			Run(append(opts, g.juliaFailureHookRunOptions(packages)...)...)
+		root = run.Root()
+	}
+
+	if g.JuliaCUDA != nil {
+		g.compileJuliaCUDAEnviron()
+		if g.JuliaCUDA.Preload {
+			root = g.preloadJuliaCUDA(root, auth)
+		}
+	}
+
+	projects := llb.Local(flag.FlagBuildContext)
+	if len(g.JuliaProjects) > 0 {
+		root, projects = g.instantiateJuliaProjects(root, auth)
+	}
+
+	if len(g.JuliaDevPackages) > 0 {
+		root = g.developJuliaPackages(root, auth)
+	}
+
+	if len(g.JuliaCachePackages) > 0 {
+		root = g.cacheJuliaPackages(root, auth)
+	}
+
+	if g.isJuliaPrecompileEnabled() && g.isJuliaPrecompileDeferred() {
+		root = g.precompileJuliaPackages(root, projects, auth)
+	} else if !g.isJuliaPrecompileEnabled() {
+		// The packages are precompiled by `Pkg.add` if the feature is enabled
+		root = g.precompileJuliaPackageNames(root, auth)
+	}
+
+	if len(g.juliaDepotCaches()) > 0 {
+		root = g.exportJuliaDepotCache(root, projects)
+	}
+
+	if g.JuliaSysimage != nil {
+		root = g.compileJuliaSysimage(root, sysimageAuth)
+	}
+
+	if g.juliaBuildLogMaxSize() > 0 {
+		root = g.truncateJuliaBuildLogs(root)
+	}
+
+	if g.isJuliaDepotRelocatable() {
+		root = g.verifyJuliaRelocatableDepot(root)
+	}
+
+	if g.isJuliaDepotLocked() {
+		root = g.lockJuliaDepot(root)
+	}
+	return root
+}
+
+// isJuliaPrecompileDeferred checks if the packages are precompiled by a single
+// step after all the Pkg operations instead of by every operation, which is
+// required by the fixed seed of the precompilation.
+func (g generalGraph) isJuliaPrecompileDeferred() bool {
+	return g.isJuliaPrecompileOnce() || g.JuliaPrecompileSeed != nil
+}
+
+// juliaPrecompileSeedRunOptions passes the fixed seed of the precompilation by
+// `ENVD_JULIA_PRECOMPILE_SEED`, which is inherited by the precompile workers,
+// and precompiles the packages one by one, thus in a fixed order.
+func (g generalGraph) juliaPrecompileSeedRunOptions() []llb.RunOption {
+	if g.JuliaPrecompileSeed == nil {
+		return nil
+	}
+	return []llb.RunOption{
+		llb.AddEnv("ENVD_JULIA_PRECOMPILE_SEED", strconv.FormatUint(*g.JuliaPrecompileSeed, 10)),
+		llb.AddEnv("JULIA_NUM_PRECOMPILE_TASKS", "1"),
+	}
+}
+
+// juliaPrecompileStatement seeds the RNG of the Pkg process from
+// `ENVD_JULIA_PRECOMPILE_SEED` before the precompile statement, if the seed
+// is fixed.
+func (g generalGraph) juliaPrecompileStatement(statement string) string {
+	if g.JuliaPrecompileSeed == nil {
+		return statement
+	}
+	return `using Random; Random.seed!(parse(UInt64, ENV["ENVD_JULIA_PRECOMPILE_SEED"])); ` + statement
+}
+
+// precompileJuliaPackages precompiles the default environment and the projects
+// at once after all the Pkg operations, thus the packages are not precompiled
+// again by every operation. The build context with the manifests of the
+// projects is mounted in the dev environment.
+func (g generalGraph) precompileJuliaPackages(root, projects llb.State, auth []llb.RunOption) llb.State {
+	statements := []string{"Pkg.precompile()"}
+	for _, p := range g.JuliaProjects {
+		statements = append(statements, fmt.Sprintf(`Pkg.activate("%s"); Pkg.precompile()`,
+			filepath.Join(g.getWorkingDir(), p)))
+	}
+	opts := []llb.RunOption{llb.Shlex(g.juliaPkgCommand(g.juliaPrecompileStatement(strings.Join(statements, "; ")))),
+		g.gpuStageConstraint(), llb.WithCustomName("[internal] precompiling Julia packages")}
+	if g.Dev && len(g.JuliaProjects) > 0 {
+		opts = append(opts, llb.AddMount(g.getWorkingDir(), projects, llb.Readonly))
+	}
+	opts = append(opts, g.juliaPrecompileSeedRunOptions()...)
+	opts = append(opts, g.juliaFailureHookRunOptions(nil)...)
+	return root.Run(append(opts, auth...)...).Root()
+}
+
+// juliaPrecompilePackageNames returns the packages of
+// `install.julia_packages(precompile=True)` installed for the platform.
+func (g generalGraph) juliaPrecompilePackageNames() []string {
+	installed := map[string]bool{}
+	for _, packages := range g.juliaPackages(g.platform()) {
+		for _, dep := range packages {
+			p, _ := parseJuliaPackage(dep)
+			installed[p.Name] = true
+		}
+	}
+	var names []string
+	for _, name := range g.JuliaPrecompilePackages {
+		if installed[name] {
+			installed[name] = false
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// precompileJuliaPackageNames precompiles the packages of
+// `install.julia_packages(precompile=True)` when the auto precompilation is
+// disabled by the `julia.precompile` feature. Precompiling the given packages
+// requires Julia 1.8, the older releases precompile the whole environment.
+func (g generalGraph) precompileJuliaPackageNames(root llb.State, auth []llb.RunOption) llb.State {
+	names := g.juliaPrecompilePackageNames()
+	if len(names) == 0 {
+		return root
+	}
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, fmt.Sprintf(`"%s"`, name))
+	}
+	// the version is checked by Julia since the debug build has no version declared
+	statement := fmt.Sprintf(`VERSION >= v"1.8" ? Pkg.precompile([%s]) : Pkg.precompile()`, strings.Join(quoted, ", "))
+	opts := []llb.RunOption{llb.Shlex(g.juliaPkgCommand(g.juliaPrecompileStatement(statement))),
+		g.gpuStageConstraint(), llb.WithCustomNamef("[internal] precompiling Julia packages: %s", strings.Join(names, " "))}
+	opts = append(opts, g.juliaPrecompileSeedRunOptions()...)
+	opts = append(opts, g.juliaFailureHookRunOptions(names)...)
+	return root.Run(append(opts, auth...)...).Root()
+}
+
+// hasJuliaPackages checks if there is anything to install into the depot.
+func (g generalGraph) hasJuliaPackages() bool {
+	return len(g.withJuliaCUDA(g.withJuliaDebuggers(g.juliaPackages(g.platform())))) > 0 ||
+		len(g.JuliaDevPackages) > 0 || len(g.JuliaProjects) > 0 || len(g.JuliaCachePackages) > 0 ||
+		len(g.JuliaRegistries) > 0 || g.JuliaArtifactOverrides != ""
+}
+
+// juliaSysimageBuilt checks if the sysimage is built, it's skipped if there
+// are no packages to bake.
+func (g generalGraph) juliaSysimageBuilt() bool {
+	return g.JuliaSysimage != nil && g.hasJuliaPackages() && len(g.juliaSysimagePackages()) > 0
+}
+
+// hasJuliaWrapper checks if the julia wrapper is generated, either for the
+// runtime flags or for the sysimage.
+func (g generalGraph) hasJuliaWrapper() bool {
+	return len(g.juliaRuntimeFlags()) > 0 || g.juliaSysimageBuilt()
+}
+
+// juliaSysimagePackages returns the packages baked into the sysimage, which
+// are all the Julia packages by default.
+func (g generalGraph) juliaSysimagePackages() []string {
+	if len(g.JuliaSysimage.Packages) > 0 {
+		return g.JuliaSysimage.Packages
+	}
+	var names []string
+	for _, packages := range g.juliaPackages(g.platform()) {
+		for _, dep := range packages {
+			p, _ := parseJuliaPackage(dep)
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// compileJuliaSysimage builds the sysimage of the packages by PackageCompiler
+// and regenerates the julia wrapper to use it. PackageCompiler is added in a
+// temporary environment of a scratch depot, thus it's not baked into the
+// image. The C compiler is required to link the sysimage.
+func (g *generalGraph) compileJuliaSysimage(root llb.State, auth []llb.RunOption) llb.State {
+	packages := g.juliaSysimagePackages()
+	if len(packages) == 0 {
+		logrus.Warn("there are no Julia packages, the sysimage is not built")
+		return root
+	}
+	sysimage := filepath.Join(juliaSysimageDir, "sys.so")
+	quoted := make([]string, 0, len(packages))
+	for _, p := range packages {
+		quoted = append(quoted, fmt.Sprintf(`"%s"`, p))
+	}
+
+	root = root.
+		Run(llb.Shlex(`sh -c "command -v gcc > /dev/null || { echo 'envd: the Julia sysimage requires gcc, `+
+			`add install.apt_packages(name=[build-essential])' >&2; exit 1; }"`),
+			llb.WithCustomName("[internal] checking the C compiler for the Julia sysimage")).Root().
+		File(llb.Mkdir(juliaSysimageDir, 0755, g.userMkdirOptions()...),
+			llb.WithCustomNamef("[internal] creating folder for %s", juliaSysimageDir))
+
+	command := g.juliaPkgCommand(fmt.Sprintf(`Pkg.activate(; temp=true); Pkg.add("PackageCompiler"); `+
+		`using PackageCompiler; Pkg.activate(); Base.invokelatest(PackageCompiler.create_sysimage, [%s]; sysimage_path="%s")`,
+		strings.Join(quoted, ", "), sysimage))
+	opts := append([]llb.RunOption{llb.Shlex(command),
+		llb.WithCustomNamef("[internal] building Julia sysimage: %s", strings.Join(packages, " "))}, auth...)
+	opts = append(opts,
+		llb.AddEnv("JULIA_DEPOT_PATH", fmt.Sprintf("%s:%s", juliaSysimageDepotDir, juliaPkgDir)))
+	if g.isRootlessBuild() {
+		depot := llb.Scratch().File(llb.Mkdir("/depot", 0755, llb.WithUIDGID(g.uid, g.gid)))
+		opts = append(opts, llb.AddMount(juliaSysimageDepotDir, depot, llb.SourcePath("/depot")))
+	} else {
+		opts = append(opts, llb.AddMount(juliaSysimageDepotDir, llb.Scratch()))
+		g.UserDirectories = append(g.UserDirectories, juliaSysimageDir)
+	}
+	root = root.Run(opts...).Root()
+
+	flags := append(g.juliaRuntimeFlags(), fmt.Sprintf("--sysimage=%s", sysimage))
+	return root.File(llb.Mkfile(juliaWrapperPath, 0755, []byte(juliaWrapper(flags))),
+		llb.WithCustomNamef("[internal] generating julia wrapper %s", juliaWrapperPath))
+}
+
+// juliaBuildLogMaxSize returns the max size of the package build logs, 0 for
+// no limit.
+func (g generalGraph) juliaBuildLogMaxSize() int64 {
+	if g.JuliaBuildLogMaxSize == nil {
+		return juliaBuildLogMaxSize
+	}
+	return *g.JuliaBuildLogMaxSize
+}
+
+// truncateJuliaBuildLogs keeps only the tail of the package build logs, thus a
+// verbose `deps/build.jl` does not inflate the image. The logs are rewritten in
+// place to keep their ownership.
+func (g generalGraph) truncateJuliaBuildLogs(root llb.State) llb.State {
+	size := g.juliaBuildLogMaxSize()
+	command := fmt.Sprintf(`sh -c "find %[1]s -path '%[1]s/packages/*/deps/build.log' -size +%[2]dc -exec sh -c `+
+		`'t=$(mktemp); for f; do tail -c %[2]d \"$f\" > $t && cat $t > \"$f\"; done; rm -f $t' _ {} +"`,
+		juliaPkgDir, size)
+	return root.Run(append([]llb.RunOption{llb.Shlex(command),
+		llb.WithCustomName("[internal] truncating Julia package build logs")},
+		g.userRunOptions()...)...).Root()
+}
+
+// compileJuliaPreferences writes the preferences to the `LocalPreferences.toml`
+// of the default environment before the packages are added, thus they are
+// picked up during the precompilation.
+func (g generalGraph) compileJuliaPreferences(root llb.State) llb.State {
+	prefs := llb.Scratch().
+		File(llb.Mkfile("LocalPreferences.toml", 0644, []byte(g.JuliaPreferences)),
+			llb.WithCustomName("[internal] generating the Julia preferences"))
+	command := fmt.Sprintf(`julia --startup-file=no --history-file=no -e `+
+		`'p = joinpath(dirname(Base.active_project()), "LocalPreferences.toml"); mkpath(dirname(p)); `+
+		`cp("%s", p; force=true)'`, filepath.Join(juliaPrefsDir, "LocalPreferences.toml"))
+	return root.Run(append([]llb.RunOption{llb.Shlex(command),
+		llb.AddMount(juliaPrefsDir, prefs, llb.Readonly),
+		llb.WithCustomName("[internal] baking the Julia preferences")},
+		g.userRunOptions()...)...).Root()
+}
+
+// compileJuliaArtifactOverrides copies the artifacts from the build context,
+// and writes the `Overrides.toml` in the depot pointing to them.
+func (g generalGraph) compileJuliaArtifactOverrides(root llb.State) llb.State {
+	if g.JuliaArtifactsDir != "" {
+		root = root.File(llb.Copy(llb.Local(flag.FlagBuildContext), g.JuliaArtifactsDir, juliaArtifactOverridesDir,
+			&llb.CopyInfo{CopyDirContentsOnly: true, CreateDestPath: true}),
+			llb.WithCustomNamef("[internal] baking the Julia artifacts of %s", g.JuliaArtifactsDir))
+	}
+	return root.
+		File(llb.Mkdir(filepath.Join(juliaPkgDir, "artifacts"), 0755, g.userMkdirOptions()...).
+			Mkfile(filepath.Join(juliaPkgDir, "artifacts", "Overrides.toml"), 0644, []byte(g.JuliaArtifactOverrides)),
+			llb.WithCustomName("[internal] writing the Julia artifact overrides"))
+}
+
+// juliaInstallGroups returns the groups of the packages added by one `Pkg.add`
+// each. All the packages are added at once by default, thus the dependencies
+// are resolved and precompiled together in a single Julia process, and the
+// duplicates across the groups are added once.
+func (g generalGraph) juliaInstallGroups(packages [][]string) [][]string {
+	if g.isJuliaGroupedInstallEnabled() || len(packages) <= 1 {
+		return packages
+	}
+	var all []string
+	added := make(map[string]bool)
+	for _, group := range packages {
+		for _, p := range group {
+			if !added[p] {
+				added[p] = true
+				all = append(all, p)
+			}
+		}
+	}
+	return [][]string{all}
+}
+
+// juliaPackage is the parsed spec of the Julia package, either the registered
+// `name[@version]` or the Git `url[#rev]`.
+type juliaPackage struct {
+	Name    string
+	Version string
+	URL     string
+	Rev     string
+}
+
+// parseJuliaPackage parses the spec of the Julia package. The Git packages are
+// the HTTPS or SSH URLs, or `owner/repo` on GitHub, optionally followed by the
+// `#rev`, i.e. the branch, tag or commit. Their names are the repo names
+// without the `.jl` suffix.
+func parseJuliaPackage(dep string) (juliaPackage, error) {
+	if strings.ContainsAny(dep, "/:") {
+		url, rev, found := strings.Cut(dep, "#")
+		if found && rev == "" {
+			return juliaPackage{}, errors.Newf("empty rev of the Julia package %s", dep)
+		}
+		if !strings.Contains(url, ":") {
+			if !juliaGitHubRepoRegex.MatchString(url) {
+				return juliaPackage{}, errors.Newf("invalid Julia package %s, "+
+					"expect the Git URL or `owner/repo`, e.g. `JuliaLang/Example.jl#v0.5.3`", dep)
+			}
+			url = "https://github.com/" + url
+		}
+		name := url[strings.LastIndexAny(url, "/:")+1:]
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".git"), ".jl")
+		return juliaPackage{Name: name, URL: url, Rev: rev}, nil
+	}
+
+	name, version, found := strings.Cut(dep, "@")
+	if !found {
+		return juliaPackage{Name: dep}, nil
+	}
+	if !juliaPackageNameRegex.MatchString(name) || !juliaPackageVersionRegex.MatchString(version) {
+		return juliaPackage{}, errors.Newf("invalid Julia package %s, expect `name@version`, e.g. `DataFrames@1.5.0`", dep)
+	}
+	return juliaPackage{Name: name, Version: version}, nil
+}
+
+// juliaPackageSpecs returns the Julia vector of the packages to add. The names
+// are used as is, unless any of them has the UUID, the pinned version or the
+// Git URL.
+func (g generalGraph) juliaPackageSpecs(packages []string) string {
+	plain := true
+	parsed := make([]juliaPackage, 0, len(packages))
+	for _, dep := range packages {
+		p, _ := parseJuliaPackage(dep)
+		if _, ok := g.JuliaPackageUUIDs[p.Name]; ok || p.Version != "" || p.URL != "" {
+			plain = false
+		}
+		parsed = append(parsed, p)
+	}
+	if plain {
+		return fmt.Sprintf(`["%s"]`, strings.Join(packages, `","`))
+	}
+
+	specs := make([]string, 0, len(parsed))
+	for _, p := range parsed {
+		var spec string
+		if p.URL != "" {
+			spec = "url=" + juliaStringLiteral(p.URL)
+			if p.Rev != "" {
+				spec += ", rev=" + juliaStringLiteral(p.Rev)
+			}
+		} else {
+			spec = fmt.Sprintf(`name="%s"`, p.Name)
+			if id, ok := g.JuliaPackageUUIDs[p.Name]; ok {
+				spec += fmt.Sprintf(`, uuid="%s"`, id)
+			}
+			if p.Version != "" {
+				spec += fmt.Sprintf(`, version="%s"`, p.Version)
+			}
+		}
+		specs = append(specs, fmt.Sprintf("PackageSpec(%s)", spec))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(specs, ", "))
+}
+
+// developJuliaPackages tracks the packages in the build context by
+// `Pkg.develop`. The build context is mounted to the working dir in the dev
+// environment, otherwise the sources are copied into the image.
+func (g generalGraph) developJuliaPackages(root llb.State, auth []llb.RunOption) llb.State {
+	workDir := g.getWorkingDir()
+	specs := make([]string, 0, len(g.JuliaDevPackages))
+	for _, p := range g.JuliaDevPackages {
+		dest := filepath.Join(workDir, p)
+		specs = append(specs, fmt.Sprintf(`PackageSpec(path="%s")`, dest))
+		if !g.Dev {
+			root = root.File(llb.Copy(llb.Local(flag.FlagBuildContext), p, dest,
+				&llb.CopyInfo{CopyDirContentsOnly: true, CreateDestPath: true}),
+				llb.WithCustomNamef("[internal] copying Julia package %s", p))
+		}
+	}
+
+	command := g.juliaPkgCommand(fmt.Sprintf("Pkg.develop([%s]; preserve=%s)",
+		strings.Join(specs, ", "), g.juliaPreserveLevel()))
+	opts := []llb.RunOption{llb.Shlex(command),
+		llb.WithCustomNamef("[internal] developing Julia packages: %s", strings.Join(g.JuliaDevPackages, " "))}
+	if g.Dev {
+		opts = append(opts, llb.AddMount(workDir, llb.Local(flag.FlagBuildContext), llb.Readonly))
+	}
+	opts = append(opts, g.juliaFailureHookRunOptions(g.JuliaDevPackages)...)
+	return root.Run(append(opts, auth...)...).Root()
+}
+
+// instantiateJuliaProjects installs the dependencies of the projects into the
+// depot by `Pkg.instantiate`. The sources are handled the same way as
+// developJuliaPackages, but the build context is mounted read-write in the dev
+// environment, since `Pkg.instantiate` writes the manifest if it's missing.
+// The returned build context has the manifests, and it's mounted by the later
+// steps with the projects. In parallel, every project is instantiated in its
+// own branch from the same depot, and the branches are merged. The files of
+// the same package version are identical, the other shared files (e.g. the
+// registry) come from the last project.
+func (g generalGraph) instantiateJuliaProjects(root llb.State, auth []llb.RunOption) (llb.State, llb.State) {
+	workDir := g.getWorkingDir()
+	buildContext := llb.Local(flag.FlagBuildContext)
+	if !g.Dev {
+		for _, p := range g.JuliaProjects {
+			root = root.File(llb.Copy(buildContext, p, filepath.Join(workDir, p),
+				&llb.CopyInfo{CopyDirContentsOnly: true, CreateDestPath: true}),
+				llb.WithCustomNamef("[internal] copying Julia project %s", p))
+		}
+	}
+
+	instantiate := func(base, projects llb.State, p string) (llb.State, llb.State) {
+		command := g.juliaPkgCommand(fmt.Sprintf(`Pkg.activate("%s"); Pkg.instantiate()`,
+			filepath.Join(workDir, p)))
+		opts := []llb.RunOption{llb.Shlex(command), g.gpuStageConstraint(),
+			llb.WithCustomNamef("[internal] instantiating Julia project %s", p)}
+		opts = append(opts, g.juliaFailureHookRunOptions([]string{p})...)
+		run := base.Run(append(opts, auth...)...)
+		if g.Dev {
+			projects = run.AddMount(workDir, projects)
+		}
+		return run.Root(), projects
+	}
+
+	if !g.JuliaParallelInstantiate || len(g.JuliaProjects) == 1 {
+		for _, p := range g.JuliaProjects {
+			root, buildContext = instantiate(root, buildContext, p)
+		}
+		return root, buildContext
+	}
+
+	states := []llb.State{root}
+	contexts := []llb.State{buildContext}
+	for _, p := range g.JuliaProjects {
+		depot, projects := instantiate(root, buildContext, p)
+		states = append(states, llb.Diff(root, depot,
+			llb.WithCustomNamef("[internal] depot of Julia project %s", p)))
+		contexts = append(contexts, llb.Diff(buildContext, projects,
+			llb.WithCustomNamef("[internal] manifest of Julia project %s", p)))
+	}
+	return llb.Merge(states, llb.WithCustomName("[internal] merging the depots of Julia projects")),
+		llb.Merge(contexts, llb.WithCustomName("[internal] merging the manifests of Julia projects"))
+}
+
+// cacheJuliaPackages fetches the packages into a separate depot in a temporary
+// environment, thus they are not installed, but `Pkg.add` at runtime is fast
+// and works offline.
+func (g generalGraph) cacheJuliaPackages(root llb.State, auth []llb.RunOption) llb.State {
+	root = root.File(llb.Mkdir(juliaPkgCacheDir, 0755, g.userMkdirOptions()...),
+		llb.WithCustomName("[internal] creating folder for cached julia packages"))
+	for _, packages := range g.JuliaCachePackages {
+		command := g.juliaPkgCommand(fmt.Sprintf(`Pkg.activate(temp=true); Pkg.add(%s)`,
+			g.juliaPackageSpecs(packages)))
+		opts := append([]llb.RunOption{llb.Shlex(command), g.gpuStageConstraint(),
+			llb.AddEnv("JULIA_DEPOT_PATH", fmt.Sprintf("%s:%s", juliaPkgCacheDir, juliaPkgDir)),
+			llb.WithCustomNamef("[internal] caching Julia packages: %s", strings.Join(packages, " "))}, auth...)
+		root = root.Run(append(opts, g.juliaFailureHookRunOptions(packages)...)...).Root()
+	}
+	return root
+}
+
+// juliaPkgCommand composes the command to run the Pkg statements without the
+// startup file. The failure of the operations which can not be automated,
+// e.g. waiting for the credentials, is reported instead of hanging the build.
+// The failure hook, if any, runs before the error is rethrown.
+func (g generalGraph) juliaPkgCommand(statements string) string {
+	var hook string
+	if g.JuliaFailureHook != nil {
+		hook = fmt.Sprintf("run(ignorestatus(`%s`)); ", filepath.Join(juliaHookDir, "hook"))
+	}
+	return fmt.Sprintf(`julia --startup-file=no --history-file=no -e 'using Pkg; try %s; `+
+		`catch e; println(stderr, "envd: the Julia Pkg operation failed, note that it can not be interactive"); `+
+		`%srethrow(); end'`, statements, hook)
+}
+
+// juliaFailureHookRunOptions mounts the failure hook script, and exposes the
+// packages of the Pkg operation to it by `ENVD_JULIA_PACKAGES`.
+func (g generalGraph) juliaFailureHookRunOptions(packages []string) []llb.RunOption {
+	if g.JuliaFailureHook == nil {
+		return nil
+	}
+	names := make([]string, 0, len(packages))
+	for _, p := range packages {
+		parsed, _ := parseJuliaPackage(p)
+		names = append(names, parsed.Name)
+	}
+	hook := llb.Scratch().
+		File(llb.Mkfile("hook", 0755, []byte(fmt.Sprintf("#!/bin/sh\n%s\n", *g.JuliaFailureHook))),
+			llb.WithCustomName("[internal] generating the Julia failure hook"))
+	return []llb.RunOption{
+		llb.AddMount(juliaHookDir, hook, llb.Readonly),
+		llb.AddEnv("ENVD_JULIA_PACKAGES", strings.Join(names, " ")),
+	}
+}
+
+// juliaNonInteractiveRunOptions disables the prompts of git and ssh during the
+// Pkg operations. The new host keys are accepted since it's safe to trust on
+// the first use in the build.
+func juliaNonInteractiveRunOptions() []llb.RunOption {
+	return []llb.RunOption{
+		llb.AddEnv("GIT_TERMINAL_PROMPT", "0"),
+		llb.AddEnv("GIT_SSH_COMMAND", "ssh -o BatchMode=yes -o StrictHostKeyChecking=accept-new"),
+	}
+}
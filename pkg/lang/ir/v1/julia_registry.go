@@ -0,0 +1,186 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/sirupsen/logrus"
+)
+
+// checkJuliaOffline checks that the offline build does not fall back to the
+// public endpoints, i.e. the Julia release and the packages are both fetched
+// from the mirrors.
+func (g generalGraph) checkJuliaOffline() error {
+	if !g.JuliaOffline || g.Language.Name != "julia" {
+		return nil
+	}
+	if g.JuliaPackageServer == nil || *g.JuliaPackageServer == "" {
+		return errors.New("the offline Julia build requires the mirror of the pkg server by `config.julia_pkg_server`")
+	}
+	if g.JuliaReleaseMirror == "" && g.JuliaDebugBuild == nil {
+		return errors.Newf("the offline Julia build requires the mirror of %s for the Julia release", juliaReleaseHost)
+	}
+	return nil
+}
+
+// juliaRegistryStatements returns the Pkg statements to add the registries.
+// The General registry is added along with the private ones, since Pkg only
+// adds it by default if there are no registries. In the offline build, it's
+// only added from the pkg server if there are no private registries, thus
+// GitHub is never cloned.
+func (g generalGraph) juliaRegistryStatements() string {
+	statements := []string{`Pkg.Registry.add("General")`}
+	if g.JuliaOffline && len(g.JuliaRegistries) > 0 {
+		statements = nil
+	}
+	for _, r := range g.JuliaRegistries {
+		statements = append(statements, juliaRegistryAdd(r.URL))
+	}
+	return strings.Join(statements, "; ")
+}
+
+// juliaRegistryAdd returns the Pkg statement to add the registry of the URL.
+func juliaRegistryAdd(url string) string {
+	return fmt.Sprintf(`Pkg.Registry.add(RegistrySpec(url=%s))`, juliaStringLiteral(url))
+}
+
+// juliaStringLiteral quotes the string as a Julia string literal, the `$` is
+// escaped to prevent the interpolation.
+func juliaStringLiteral(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(s) + `"`
+}
+
+// juliaOfflineCheck returns the command to fail if any of the registered
+// packages is missing in the registries, see julia_offline_check.jl. The Git
+// packages are skipped, and nil is returned if none is left. The registries
+// are unpacked by juliaOfflineRunOptions.
+func juliaOfflineCheck(packages []string) []string {
+	var names []string
+	for _, dep := range packages {
+		if p, _ := parseJuliaPackage(dep); p.URL == "" {
+			names = append(names, p.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return juliaScriptCommand("julia_offline_check.jl", names...)
+}
+
+// juliaOfflineRunOptions unpacks the registries in the offline build, which
+// are read by juliaOfflineCheck, since Pkg keeps them as the tarballs.
+func (g generalGraph) juliaOfflineRunOptions() []llb.RunOption {
+	if !g.JuliaOffline {
+		return nil
+	}
+	return []llb.RunOption{llb.AddEnv("JULIA_PKG_UNPACK_REGISTRY", "true")}
+}
+
+// juliaPkgServerRunOptions points Pkg to the package server by the environment
+// variable, thus nothing is left in the working dir or the image.
+func (g generalGraph) juliaPkgServerRunOptions() []llb.RunOption {
+	if g.JuliaPackageServer == nil || *g.JuliaPackageServer == "" {
+		return nil
+	}
+	return []llb.RunOption{llb.AddEnv("JULIA_PKG_SERVER", *g.JuliaPackageServer)}
+}
+
+// waitJuliaPkgServer waits until the package server is reachable, otherwise Pkg
+// falls back to the git clones silently. The build fails if it's not reachable
+// after juliaPkgServerTimeout seconds, or if it does not serve the registries,
+// e.g. a proxy in front of the crashed server. The response is printed on
+// failure, it's written to a scratch mount thus it's not left in the image.
+// The checks are never served from the build cache, thus the image build runs
+// them as the checks beside the Pkg operations instead of before them.
+func (g generalGraph) waitJuliaPkgServer(root llb.State) llb.State {
+	if g.JuliaPackageServer == nil || *g.JuliaPackageServer == "" {
+		return root
+	}
+	server := *g.JuliaPackageServer
+	// Pkg defaults to https if the scheme is omitted
+	target := server
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		logrus.Warnf("failed to parse the Julia pkg server %s, skip waiting for it", server)
+		return root
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	command := fmt.Sprintf(`bash -c "start=$(date +%%s); until (echo > /dev/tcp/%[1]s/%[2]s) 2>/dev/null; do `+
+		`if [ $(($(date +%%s) - start)) -ge %[3]d ]; then `+
+		`echo 'envd: the Julia pkg server %[4]s (%[1]s:%[2]s) is not reachable after %[3]ds' >&2; exit 1; fi; `+
+		`sleep 1; done"`, u.Hostname(), port, juliaPkgServerTimeout, server)
+	check := fmt.Sprintf(`bash -c "julia --startup-file=no -e 'using Downloads; `+
+		`Downloads.download(\"%[1]s/registries\", stdout)' > %[2]s 2>&1 || { `+
+		`echo 'envd: the Julia pkg server %[3]s does not serve the registries:' >&2; cat %[2]s >&2; exit 1; }"`,
+		strings.TrimSuffix(target, "/"), filepath.Join(juliaPkgServerLogDir, "registries.log"), server)
+	return root.
+		Run(llb.Shlex(command), llb.IgnoreCache,
+			llb.WithCustomNamef("[internal] waiting for the Julia pkg server %s", server)).Root().
+		Run(llb.Shlex(check), llb.IgnoreCache, llb.AddMount(juliaPkgServerLogDir, llb.Scratch()),
+			llb.WithCustomNamef("[internal] checking the registries of the Julia pkg server %s", server)).Root()
+}
+
+// juliaRegistryRunOptions returns the run options to access the private registries.
+// The tokens are mounted as build secrets and fed to git by GIT_ASKPASS,
+// thus they are never persisted in the image.
+func (g generalGraph) juliaRegistryRunOptions() []llb.RunOption {
+	var opts []llb.RunOption
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\ncase \"$1\" in\nUsername*) echo envd ;;\n")
+	for _, r := range g.JuliaRegistries {
+		if r.Secret == "" {
+			continue
+		}
+		host := r.URL
+		if u, err := url.Parse(r.URL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		target := filepath.Join(juliaSecretDir, r.Secret)
+		sb.WriteString(fmt.Sprintf("*%s*) cat %s ;;\n", host, target))
+		secretOpts := []llb.SecretOption{llb.SecretID(r.Secret)}
+		if g.isRootlessBuild() {
+			secretOpts = append(secretOpts, llb.SecretFileOpt(g.uid, g.gid, 0400))
+		}
+		opts = append(opts, llb.AddSecret(target, secretOpts...))
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	sb.WriteString("esac\n")
+
+	askpass := llb.Scratch().
+		File(llb.Mkfile("askpass", 0755, []byte(sb.String())),
+			llb.WithCustomName("[internal] generating git askpass for Julia registries"))
+	return append(opts,
+		llb.AddMount(juliaAskPassDir, askpass, llb.Readonly),
+		llb.AddEnv("JULIA_PKG_USE_CLI_GIT", "true"),
+		llb.AddEnv("GIT_ASKPASS", filepath.Join(juliaAskPassDir, "askpass")),
+	)
+}
@@ -0,0 +1,801 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestJuliaArtifactOverrides(t *testing.T) {
+	g := resetDefaultGraph(t)
+	hash := "a8f3f5a0e9f5b7e0d0c6b6c7f1e2d3c4b5a69788"
+	artifacts := []string{"MPICH"}
+
+	err := JuliaArtifactOverrides(map[string]interface{}{
+		hash: "MPICH",
+		"7cb0a576-ebde-5e09-9194-50597f1243b4": map[string]interface{}{
+			"MPICH": juliaArtifactOverridesDir + "/MPICH/lib",
+		},
+	}, "artifacts", artifacts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rendered := g.JuliaArtifactOverrides
+	for _, expected := range []string{
+		hash + " = '" + juliaArtifactOverridesDir + "/MPICH'",
+		"MPICH = '" + juliaArtifactOverridesDir + "/MPICH/lib'",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Errorf("expected %q in the overrides:\n%s", expected, rendered)
+		}
+	}
+
+	invalid := []map[string]interface{}{
+		{hash: "OpenBLAS"},
+		{hash: "../MPICH"},
+		{hash: "/usr/lib/mpich"},
+		{"MPICH": "MPICH"},
+		{"7cb0a576-ebde-5e09-9194-50597f1243b4": "MPICH"},
+	}
+	for _, overrides := range invalid {
+		if err := JuliaArtifactOverrides(overrides, "artifacts", artifacts); err == nil {
+			t.Errorf("expected an error for %v", overrides)
+		}
+	}
+}
+
+func TestWithJuliaDebuggers(t *testing.T) {
+	g := generalGraph{Dev: true, JuliaDebuggers: []string{"Debugger", "Infiltrator"}}
+	packages := [][]string{{"Flux", "Infiltrator"}, {"CUDA"}}
+	res := g.withJuliaDebuggers(packages)
+	if strings.Join(res[0], ",") != "Flux,Infiltrator,Debugger" || strings.Join(res[1], ",") != "CUDA" {
+		t.Errorf("unexpected packages: %v", res)
+	}
+	if len(packages[0]) != 2 {
+		t.Errorf("the packages are modified: %v", packages)
+	}
+	if res := g.withJuliaDebuggers(nil); len(res) != 1 || len(res[0]) != 2 {
+		t.Errorf("unexpected packages without the others: %v", res)
+	}
+
+	g.Dev = false
+	if res := g.withJuliaDebuggers(packages); len(res[0]) != 2 {
+		t.Errorf("the debuggers are added out of the dev environment: %v", res)
+	}
+}
+
+func TestJuliaPkgServerNoStrayOutput(t *testing.T) {
+	server := "https://pkg.julialang.org"
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	g.JuliaPackageServer = &server
+
+	ops := marshalOps(t, g.compileChecks(g.installJuliaPackages(llb.Image("ubuntu:22.04"))))
+	found, logged := false, false
+	for _, op := range ops {
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if mkfile := action.GetMkfile(); mkfile != nil && strings.HasPrefix(mkfile.Path, juliaPkgServerLogDir) {
+					t.Errorf("unexpected file %s of the pkg server in the image", mkfile.Path)
+				}
+			}
+		}
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		args := strings.Join(exec.Meta.Args, " ")
+		// the response of the pkg server is written to a scratch mount, which
+		// is not a layer of the image
+		if strings.Contains(args, juliaPkgServerLogDir) {
+			logged = true
+			scratch := false
+			for _, m := range exec.Mounts {
+				if m.Dest == juliaPkgServerLogDir && m.Input == pb.Empty && m.Output != 0 {
+					scratch = true
+				}
+			}
+			if !scratch {
+				t.Errorf("the response of the pkg server is written to the image by %s", args)
+			}
+		}
+		if !strings.Contains(args, "Pkg.add") {
+			continue
+		}
+		found = true
+		hasEnv := false
+		for _, env := range exec.Meta.Env {
+			if env == "JULIA_PKG_SERVER="+server {
+				hasEnv = true
+			}
+		}
+		if !hasEnv {
+			t.Errorf("JULIA_PKG_SERVER is not set for %s", args)
+		}
+	}
+	if !found {
+		t.Fatal("no Pkg.add in the LLB")
+	}
+	if !logged {
+		t.Error("the response of the pkg server is not kept for the failure")
+	}
+}
+
+func TestJuliaPkgServerChecksUncached(t *testing.T) {
+	server := "https://pkg.julialang.org"
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	g.JuliaPackageServer = &server
+
+	root := g.installJuliaPackages(llb.Image("ubuntu:22.04"))
+	def, err := root.Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	// the Pkg operations are cached, the checks run beside them
+	for _, op := range parseOps(t, def) {
+		if def.Metadata[op.Digest].IgnoreCache {
+			t.Errorf("unexpected uncached step in the image: %v", op.Op)
+		}
+	}
+	if len(g.checks) != 1 {
+		t.Fatalf("unexpected checks of the pkg server: %d", len(g.checks))
+	}
+
+	def, err = g.compileChecks(root).Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	ops := parseOps(t, def)
+	uncached := 0
+	for _, op := range ops {
+		if def.Metadata[op.Digest].IgnoreCache {
+			uncached++
+		}
+	}
+	if uncached != 2 {
+		t.Errorf("expected the wait and the registries check to be uncached, got %d", uncached)
+	}
+	// the last op is the output, the one before merges the checks
+	merge := ops[len(ops)-2].GetMerge()
+	if merge == nil || len(merge.Inputs) != 2 {
+		t.Fatalf("the checks are not merged into the image: %v", ops[len(ops)-2].Op)
+	}
+	for _, op := range ops {
+		if diff := op.GetDiff(); diff != nil &&
+			op.Inputs[diff.Lower.Input].Digest != op.Inputs[diff.Upper.Input].Digest {
+			t.Errorf("the changes of the checks are merged into the image: %v", diff)
+		}
+	}
+}
+
+func TestJuliaDistribution(t *testing.T) {
+	g := generalGraph{}
+	if url, sha, _ := g.juliaDistribution(); url != "https://julialang-s3.julialang.org/bin/linux/x64/1.8/julia-1.8.5-linux-x86_64.tar.gz" ||
+		sha != juliaDefaultSHA256 {
+		t.Errorf("unexpected default distribution: %s %s", url, sha)
+	}
+	g.JuliaVersion = "1.6.7"
+	url, sha, _ := g.juliaDistribution()
+	if url != "https://julialang-s3.julialang.org/bin/linux/x64/1.6/julia-1.6.7-linux-x86_64.tar.gz" || sha != "" {
+		t.Errorf("unexpected distribution of 1.6.7: %s %s", url, sha)
+	}
+	g = generalGraph{Platform: "linux/arm64"}
+	url, sha, _ = g.juliaDistribution()
+	if url != "https://julialang-s3.julialang.org/bin/linux/aarch64/1.8/julia-1.8.5-linux-aarch64.tar.gz" || sha != "" {
+		t.Errorf("unexpected default distribution of arm64: %s %s", url, sha)
+	}
+	g = generalGraph{JuliaVersion: "1.8"}
+	if _, _, err := g.juliaDistribution(); err == nil {
+		t.Error("expected an error for the invalid version 1.8")
+	}
+	if _, err := g.installJulia(llb.Image("ubuntu:22.04")); err == nil {
+		t.Error("expected an error to install the invalid version 1.8")
+	}
+	for _, p := range []string{"linux/386", "windows/amd64", "linux/arm/v7", "linux/amd64,linux/arm64"} {
+		if err := Platform(p); err == nil {
+			t.Errorf("expected an error for the platform %s", p)
+		}
+	}
+	for _, v := range []string{"1.10", "v1.10.0", "latest", "1.5.4", "0.7.0"} {
+		if err := JuliaVersion(v); err == nil {
+			t.Errorf("expected an error for the version %s", v)
+		}
+	}
+}
+
+func TestCheckJuliaRelocatableDepot(t *testing.T) {
+	g := generalGraph{JuliaArtifactOverrides: `"MPICH" = "` + juliaArtifactOverridesDir + `/MPICH"`}
+	if err := g.checkJuliaRelocatableDepot(); err != nil {
+		t.Errorf("unexpected error without the feature: %v", err)
+	}
+	g.Features = map[string]bool{featureJuliaRelocatable: true}
+	if err := g.checkJuliaRelocatableDepot(); err == nil {
+		t.Error("expected an error for the artifact overrides of the paths")
+	}
+	g.JuliaArtifactOverrides = `a8f3f5a0e9f5b7e0d0c6b6c7f1e2d3c4b5a69788 = "0c6b6c7f1e2d3c4b5a69788a8f3f5a0e9f5b7e0d"`
+	if err := g.checkJuliaRelocatableDepot(); err != nil {
+		t.Errorf("unexpected error for the artifact overrides of the hashes: %v", err)
+	}
+}
+
+func TestTruncateJuliaBuildLogs(t *testing.T) {
+	g := generalGraph{}
+	ops := marshalOps(t, g.truncateJuliaBuildLogs(llb.Image("ubuntu:22.04")))
+	expected := `find /opt/julia/user_packages -path '/opt/julia/user_packages/packages/*/deps/build.log' ` +
+		`-size +65536c -exec sh -c ` +
+		`'t=$(mktemp); for f; do tail -c 65536 "$f" > $t && cat $t > "$f"; done; rm -f $t' _ {} +`
+	found := false
+	for _, op := range ops {
+		if exec := op.GetExec(); exec != nil {
+			found = true
+			if len(exec.Meta.Args) != 3 || exec.Meta.Args[2] != expected {
+				t.Errorf("unexpected command: %q", exec.Meta.Args)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("no truncation in the LLB")
+	}
+
+	for _, size := range []string{"-1", "1x"} {
+		if err := JuliaBuildLog(size); err == nil {
+			t.Errorf("expected an error for the max size %s", size)
+		}
+	}
+}
+
+func TestJuliaPackageSpecs(t *testing.T) {
+	g := generalGraph{}
+	if specs := g.juliaPackageSpecs([]string{"Flux", "CUDA"}); specs != `["Flux","CUDA"]` {
+		t.Errorf("unexpected specs of the plain packages: %s", specs)
+	}
+	g.JuliaPackageUUIDs = map[string]string{"Example": "7876af07-990d-54b4-ab0e-23690620f79a"}
+	specs := g.juliaPackageSpecs([]string{"DataFrames@1.5.0", "Example@0.5", "Flux"})
+	expected := `[PackageSpec(name="DataFrames", version="1.5.0"), ` +
+		`PackageSpec(name="Example", uuid="7876af07-990d-54b4-ab0e-23690620f79a", version="0.5"), ` +
+		`PackageSpec(name="Flux")]`
+	if specs != expected {
+		t.Errorf("unexpected specs of the pinned packages: %s", specs)
+	}
+
+	for _, dep := range []string{"Flux@", "@1.0", "Flux@latest", "Flux@1.2.3.4", "Data-Frames@1.0"} {
+		if err := validateJuliaPackages([]string{dep}); err == nil {
+			t.Errorf("expected an error for the package %s", dep)
+		}
+	}
+	if err := validateJuliaPackages([]string{"git@github.com:JuliaLang/Example.jl.git"}); err != nil {
+		t.Errorf("unexpected error for the URL: %v", err)
+	}
+}
+
+func TestJuliaGitPackageSpecs(t *testing.T) {
+	g := generalGraph{}
+	specs := g.juliaPackageSpecs([]string{
+		"Flux",
+		"https://gitlab.example.com/team/Internal.jl.git#v1.2.0",
+		"git@gitlab.example.com:team/Tools.jl",
+		"JuliaLang/Example.jl#main",
+	})
+	expected := `[PackageSpec(name="Flux"), ` +
+		`PackageSpec(url="https://gitlab.example.com/team/Internal.jl.git", rev="v1.2.0"), ` +
+		`PackageSpec(url="git@gitlab.example.com:team/Tools.jl"), ` +
+		`PackageSpec(url="https://github.com/JuliaLang/Example.jl", rev="main")]`
+	if specs != expected {
+		t.Errorf("unexpected specs of the Git packages: %s", specs)
+	}
+
+	specs = g.juliaPackageSpecs([]string{`JuliaLang/Example.jl#v"$(run(cmd))`})
+	expected = `[PackageSpec(url="https://github.com/JuliaLang/Example.jl", rev="v\"\$(run(cmd))")]`
+	if specs != expected {
+		t.Errorf("unexpected specs of the quoted revision: %s", specs)
+	}
+
+	p, err := parseJuliaPackage("git@gitlab.example.com:team/Tools.jl.git#abc123")
+	if err != nil || p.Name != "Tools" || p.Rev != "abc123" {
+		t.Errorf("unexpected Git package: %+v, %v", p, err)
+	}
+	for _, dep := range []string{"JuliaLang/Example.jl#", "JuliaLang/Example/jl", "owner/re po"} {
+		if _, err := parseJuliaPackage(dep); err == nil {
+			t.Errorf("expected an error for the package %s", dep)
+		}
+	}
+}
+
+func TestJuliaDepotCaches(t *testing.T) {
+	dirs := func(g generalGraph) []string {
+		var res []string
+		for _, c := range g.juliaDepotCaches() {
+			res = append(res, c.dir)
+		}
+		return res
+	}
+	g := generalGraph{}
+	if d := dirs(g); strings.Join(d, ",") != "packages,artifacts" {
+		t.Errorf("unexpected default caches: %v", d)
+	}
+	g.Features = map[string]bool{featureJuliaSharedCache: true}
+	if d := dirs(g); strings.Join(d, ",") != "packages,artifacts,compiled" {
+		t.Errorf("unexpected caches with the shared cache: %v", d)
+	}
+	g.Features = map[string]bool{featureJuliaDownloads: false}
+	if d := dirs(g); len(d) != 0 {
+		t.Errorf("unexpected caches without the download cache: %v", d)
+	}
+}
+
+func TestJuliaInstallGroups(t *testing.T) {
+	packages := [][]string{{"Flux", "CUDA"}, {"DataFrames", "Flux"}}
+	g := generalGraph{}
+	if groups := g.juliaInstallGroups(packages); !reflect.DeepEqual(groups, [][]string{{"Flux", "CUDA", "DataFrames"}}) {
+		t.Errorf("unexpected batched groups: %v", groups)
+	}
+	g.Features = map[string]bool{featureJuliaGrouped: true}
+	if groups := g.juliaInstallGroups(packages); !reflect.DeepEqual(groups, packages) {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+}
+
+func TestWaitJuliaPkgServer(t *testing.T) {
+	for server, target := range map[string]string{
+		"http://127.0.0.1:9999":     "/dev/tcp/127.0.0.1/9999",
+		"http://pkg.example.com":    "/dev/tcp/pkg.example.com/80",
+		"pkg.julialang.org":         "/dev/tcp/pkg.julialang.org/443",
+		"https://pkg.julialang.org": "/dev/tcp/pkg.julialang.org/443",
+	} {
+		server := server
+		g := generalGraph{JuliaPackageServer: &server}
+		def, err := g.waitJuliaPkgServer(llb.Image("ubuntu:22.04")).Marshal(context.Background())
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+		var found, checked bool
+		for _, op := range parseOps(t, def) {
+			if exec := op.GetExec(); exec != nil {
+				args := strings.Join(exec.Meta.Args, " ")
+				if strings.Contains(args, target) {
+					found = true
+					if !def.Metadata[op.Digest].IgnoreCache {
+						t.Errorf("the wait for the pkg server %s is served from the cache", server)
+					}
+				}
+				if strings.Contains(args, "/registries") {
+					checked = true
+					if !def.Metadata[op.Digest].IgnoreCache {
+						t.Errorf("the registries check of the pkg server %s is served from the cache", server)
+					}
+				}
+			}
+		}
+		if !found {
+			t.Errorf("the pkg server %s is not waited at %s", server, target)
+		}
+		if !checked {
+			t.Errorf("the registries of the pkg server %s are not checked", server)
+		}
+	}
+}
+
+func TestJuliaSysimagePackages(t *testing.T) {
+	g := generalGraph{
+		JuliaPackages: [][]string{{"Plots", "DataFrames@1.5.0"}, {"JuliaLang/Example.jl#v0.5.3"}},
+		JuliaSysimage: &ir.JuliaSysimageConfig{},
+	}
+	if packages := g.juliaSysimagePackages(); !reflect.DeepEqual(packages, []string{"Plots", "DataFrames", "Example"}) {
+		t.Errorf("unexpected packages of the sysimage: %v", packages)
+	}
+	g.JuliaSysimage.Packages = []string{"Plots"}
+	if packages := g.juliaSysimagePackages(); !reflect.DeepEqual(packages, []string{"Plots"}) {
+		t.Errorf("unexpected packages of the sysimage: %v", packages)
+	}
+}
+
+func TestJuliaPackagePrecompileSysimage(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := JuliaPackage([]string{"Plots@1.38", "DataFrames"}, "", nil, true, true); err != nil {
+		t.Fatalf("failed to add the Julia packages: %v", err)
+	}
+	if err := JuliaPackage([]string{"Flux"}, "linux/arm64", nil, true, false); err != nil {
+		t.Fatalf("failed to add the Julia packages: %v", err)
+	}
+	if err := JuliaSysimage([]string{"Plots", "CSV"}); err != nil {
+		t.Fatalf("failed to set the Julia sysimage: %v", err)
+	}
+	if !reflect.DeepEqual(g.JuliaSysimage.Packages, []string{"Plots", "DataFrames", "CSV"}) {
+		t.Errorf("unexpected packages of the sysimage: %v", g.JuliaSysimage.Packages)
+	}
+	// the platform overrides are not installed for the default platform
+	if names := g.juliaPrecompilePackageNames(); !reflect.DeepEqual(names, []string{"Plots", "DataFrames"}) {
+		t.Errorf("unexpected packages to precompile: %v", names)
+	}
+	ops := marshalOps(t, g.precompileJuliaPackageNames(llb.Image("ubuntu:22.04"), nil))
+	precompiled := false
+	for _, op := range ops {
+		// the packages are only given to Pkg.precompile since Julia 1.8
+		if exec := op.GetExec(); exec != nil && strings.Contains(strings.Join(exec.Meta.Args, " "),
+			`VERSION >= v"1.8" ? Pkg.precompile(["Plots", "DataFrames"]) : Pkg.precompile()`) {
+			precompiled = true
+		}
+	}
+	if !precompiled {
+		t.Error("the packages are not precompiled")
+	}
+	if err := JuliaSysimage(nil); err != nil {
+		t.Fatalf("failed to set the Julia sysimage: %v", err)
+	}
+	if g.JuliaSysimage.Packages != nil {
+		t.Errorf("expected all the packages in the sysimage, got %v", g.JuliaSysimage.Packages)
+	}
+}
+
+func TestJuliaPrecompileSeed(t *testing.T) {
+	g := resetDefaultGraph(t)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	JuliaPrecompileSeed(42)
+
+	var precompiled bool
+	for _, op := range marshalOps(t, g.installJuliaPackages(llb.Image("ubuntu:22.04"))) {
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		args := strings.Join(exec.Meta.Args, " ")
+		env := strings.Join(exec.Meta.Env, " ")
+		if strings.Contains(args, "Pkg.add") && !strings.Contains(env, "JULIA_PKG_PRECOMPILE_AUTO=0") {
+			t.Errorf("the packages are precompiled by Pkg.add with the fixed seed: %s", env)
+		}
+		if !strings.Contains(args, "Pkg.precompile()") {
+			continue
+		}
+		precompiled = true
+		if !strings.Contains(args, `Random.seed!(parse(UInt64, ENV["ENVD_JULIA_PRECOMPILE_SEED"]))`) {
+			t.Errorf("the precompilation is not seeded: %s", args)
+		}
+		for _, expected := range []string{"ENVD_JULIA_PRECOMPILE_SEED=42", "JULIA_NUM_PRECOMPILE_TASKS=1"} {
+			if !strings.Contains(env, expected) {
+				t.Errorf("expected %s in the env of the precompilation: %s", expected, env)
+			}
+		}
+	}
+	if !precompiled {
+		t.Fatal("no Pkg.precompile in the LLB")
+	}
+}
+
+func TestJuliaRuntimeThreads(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := JuliaRuntime("", 0, "4,1", "generic"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.JuliaRuntimeConfig != nil {
+		t.Errorf("unexpected julia flags: %+v", g.JuliaRuntimeConfig)
+	}
+	if _, err := g.installJulia(llb.Image("ubuntu:22.04")); err != nil {
+		t.Fatalf("failed to install julia: %v", err)
+	}
+	if g.RuntimeEnviron["JULIA_NUM_THREADS"] != "4,1" || g.RuntimeEnviron["JULIA_CPU_TARGET"] != "generic" {
+		t.Errorf("unexpected runtime environ: %v", g.RuntimeEnviron)
+	}
+
+	for _, threads := range []string{"0", "four", "auto,"} {
+		if err := JuliaRuntime("", 0, threads, ""); err == nil {
+			t.Errorf("expected error for the threads %s", threads)
+		}
+	}
+}
+
+func TestJuliaOffline(t *testing.T) {
+	server := "https://julia-mirror.example.com"
+	g := generalGraph{
+		Language:           ir.Language{Name: "julia"},
+		JuliaOffline:       true,
+		JuliaPackageServer: &server,
+	}
+	if err := g.checkJuliaOffline(); err == nil {
+		t.Error("expected error without the release mirror")
+	}
+	g.JuliaReleaseMirror = "https://julia-releases.example.com/"
+	if err := g.checkJuliaOffline(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if url, _, _ := g.juliaDistribution(); !strings.HasPrefix(url, "https://julia-releases.example.com/bin/linux/x64/") {
+		t.Errorf("unexpected url of the Julia release: %s", url)
+	}
+
+	if statements := g.juliaRegistryStatements(); statements != `Pkg.Registry.add("General")` {
+		t.Errorf("unexpected registry statements: %s", statements)
+	}
+	g.JuliaRegistries = []ir.JuliaRegistry{{URL: "https://git.example.com/registry.git"}}
+	if statements := g.juliaRegistryStatements(); strings.Contains(statements, "General") {
+		t.Errorf("unexpected General registry in the offline build: %s", statements)
+	}
+
+	check := juliaOfflineCheck([]string{"Flux@0.13", "JuliaLang/Example.jl#v0.5.3"})
+	if !reflect.DeepEqual(check, juliaScriptCommand("julia_offline_check.jl", "Flux")) {
+		t.Errorf("unexpected offline check: %v", check)
+	}
+	if check := juliaOfflineCheck([]string{"JuliaLang/Example.jl#v0.5.3"}); check != nil {
+		t.Errorf("unexpected offline check of the Git packages: %v", check)
+	}
+
+	g = *NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaOffline = true
+	g.JuliaPackages = [][]string{{"Flux"}}
+	def, err := g.installJuliaPackages(llb.Image("ubuntu:22.04")).Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	checked := false
+	for _, op := range parseOps(t, def) {
+		exec := op.GetExec()
+		if exec == nil || !reflect.DeepEqual(exec.Meta.Args, check) {
+			continue
+		}
+		for _, m := range exec.Mounts {
+			checked = checked || (m.Dest == juliaScriptsDir && m.Readonly && m.Output == -1)
+		}
+	}
+	if !checked {
+		t.Error("the offline check is not run from the mounted script")
+	}
+}
+
+func TestJuliaRegistryAdd(t *testing.T) {
+	cases := map[string]string{
+		"https://git.example.com/registry.git":  `Pkg.Registry.add(RegistrySpec(url="https://git.example.com/registry.git"))`,
+		`https://git.example.com/$(run(x)).git`: `Pkg.Registry.add(RegistrySpec(url="https://git.example.com/\$(run(x)).git"))`,
+		`https://git.example.com/a"b\c.git`:     `Pkg.Registry.add(RegistrySpec(url="https://git.example.com/a\"b\\c.git"))`,
+	}
+	for url, expected := range cases {
+		if actual := juliaRegistryAdd(url); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	}
+
+	resetDefaultGraph(t)
+	if err := JuliaRegistry("https://git.example.com/it's.git", ""); err == nil {
+		t.Error("expected error for the single quote in the registry url")
+	}
+}
+
+func TestJuliaMirror(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := JuliaMirror("https://mirrors.example.com/julia", "https://mirrors.example.com/julia-releases"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := JuliaOffline(""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.Language = ir.Language{Name: "julia"}
+	if err := g.checkJuliaOffline(); err != nil {
+		t.Errorf("unexpected error with the mirrors: %v", err)
+	}
+	if _, err := g.installJulia(llb.Image("ubuntu:22.04")); err != nil {
+		t.Fatalf("failed to install julia: %v", err)
+	}
+	if server := g.RuntimeEnviron["JULIA_PKG_SERVER"]; server != "https://mirrors.example.com/julia" {
+		t.Errorf("unexpected runtime pkg server: %s", server)
+	}
+
+	if err := JuliaMirror("", "ftp://mirrors.example.com"); err == nil {
+		t.Error("expected error for the binary mirror")
+	}
+}
+
+func TestJuliaCUDA(t *testing.T) {
+	g := generalGraph{JuliaCUDA: &ir.JuliaCUDAConfig{Version: "5.1"}}
+	g.RuntimeEnviron = map[string]string{"LD_LIBRARY_PATH": "/usr/local/nvidia/lib"}
+	packages := g.withJuliaCUDA([][]string{{"Flux"}, {"JSON"}})
+	if !reflect.DeepEqual(packages, [][]string{{"Flux", "CUDA@5.1"}, {"JSON"}}) {
+		t.Errorf("unexpected packages with CUDA.jl: %v", packages)
+	}
+	if packages := g.withJuliaCUDA([][]string{{"CUDA@4"}}); !reflect.DeepEqual(packages, [][]string{{"CUDA@4"}}) {
+		t.Errorf("unexpected packages with CUDA.jl added: %v", packages)
+	}
+
+	g.compileJuliaCUDAEnviron()
+	if path := g.RuntimeEnviron["LD_LIBRARY_PATH"]; path != "/usr/local/nvidia/lib:/usr/local/nvidia/lib64" {
+		t.Errorf("unexpected LD_LIBRARY_PATH: %s", path)
+	}
+	if g.RuntimeEnviron["NVIDIA_DRIVER_CAPABILITIES"] == "" {
+		t.Errorf("the driver capabilities are not set: %v", g.RuntimeEnviron)
+	}
+	if devices := g.RuntimeEnviron["NVIDIA_VISIBLE_DEVICES"]; devices != "0" {
+		t.Errorf("unexpected visible devices without runtime.gpu: %s", devices)
+	}
+
+	for gpu, expected := range map[*ir.GPUConfig]string{
+		{Count: 2}:                    "0,1",
+		{Count: -1}:                   "all",
+		{Devices: []string{"1", "3"}}: "1,3",
+	} {
+		g.RuntimeGPU = gpu
+		if devices := g.juliaCUDAVisibleDevices(); devices != expected {
+			t.Errorf("unexpected visible devices of %+v: %s", *gpu, devices)
+		}
+	}
+}
+
+func TestJuliaRootlessBuild(t *testing.T) {
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	g.Dev = true
+	g.uid, g.gid = 1000, 1001
+	g.Features[featureBuildRootless] = true
+
+	ops := marshalOps(t, g.installJuliaPackages(llb.Image("ubuntu:22.04")))
+	for _, dir := range g.UserDirectories {
+		if dir == juliaPkgDir {
+			t.Errorf("the depot %s is chowned in the rootless build", dir)
+		}
+	}
+	owned, installed := false, false
+	for _, op := range ops {
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if mkdir := action.GetMkdir(); mkdir != nil && mkdir.Path == juliaPkgDir {
+					owned = mkdir.Owner != nil && mkdir.Owner.User.GetByID() == 1000 &&
+						mkdir.Owner.Group.GetByID() == 1001
+				}
+			}
+		}
+		exec := op.GetExec()
+		if exec == nil || !strings.Contains(strings.Join(exec.Meta.Args, " "), "Pkg.add") {
+			continue
+		}
+		installed = true
+		if exec.Meta.User != "1000:1001" {
+			t.Errorf("unexpected user of Pkg.add: %q", exec.Meta.User)
+		}
+	}
+	if !owned {
+		t.Errorf("the depot %s is not created owned by the user", juliaPkgDir)
+	}
+	if !installed {
+		t.Fatal("no Pkg.add in the LLB")
+	}
+
+	// the root user still chowns the depot
+	g = NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	g.Dev = true
+	g.Features[featureBuildRootless] = true
+	g.installJuliaPackages(llb.Image("ubuntu:22.04"))
+	if len(g.UserDirectories) != 1 || g.UserDirectories[0] != juliaPkgDir {
+		t.Errorf("unexpected user directories: %v", g.UserDirectories)
+	}
+}
+
+func TestJuliaDepotCacheMounts(t *testing.T) {
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+
+	def, err := g.installJuliaPackages(llb.Image("ubuntu:22.04")).Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	mounted, exported := false, false
+	for _, op := range parseOps(t, def) {
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		args := strings.Join(exec.Meta.Args, " ")
+		if strings.Contains(args, "cp -a") {
+			t.Errorf("the cache is copied into the depot: %s", args)
+		}
+		for _, m := range exec.Mounts {
+			if m.MountType != pb.MountType_CACHE || m.CacheOpt.ID != "envd-julia-packages" {
+				continue
+			}
+			switch {
+			case strings.Contains(args, "Pkg.add"):
+				mounted = m.Dest == filepath.Join(juliaPkgDir, "packages")
+			case strings.Contains(args, "julia_depot_export.jl"):
+				exported = m.Dest == filepath.Join(juliaSharedCacheDir, "packages")
+			}
+		}
+	}
+	if !mounted {
+		t.Error("the package cache is not mounted over the depot for Pkg.add")
+	}
+	if !exported {
+		t.Error("the used packages are not copied from the cache")
+	}
+
+	// the compiled files are written to the shared cache by the Pkg operations
+	g = NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	g.Features[featureJuliaSharedCache] = true
+	def, err = g.installJuliaPackages(llb.Image("ubuntu:22.04")).Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	compiled := false
+	for _, op := range parseOps(t, def) {
+		exec := op.GetExec()
+		if exec == nil || !strings.Contains(strings.Join(exec.Meta.Args, " "), "Pkg.add") {
+			continue
+		}
+		for _, m := range exec.Mounts {
+			if m.MountType == pb.MountType_CACHE && m.CacheOpt.ID == g.juliaSharedCacheID() {
+				compiled = m.Dest == filepath.Join(juliaPkgDir, "compiled")
+			}
+		}
+	}
+	if !compiled {
+		t.Error("the compiled cache is not mounted over the depot for Pkg.add")
+	}
+}
+
+func TestJuliaInstantiateProjects(t *testing.T) {
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.JuliaProjects = []string{"app"}
+	g.Dev = true
+
+	def, err := g.installJuliaPackages(llb.Image("ubuntu:22.04")).Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	workDir := g.getWorkingDir()
+	var instantiated digest.Digest
+	exports := map[digest.Digest]bool{}
+	for _, op := range parseOps(t, def) {
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		args := strings.Join(exec.Meta.Args, " ")
+		for _, m := range exec.Mounts {
+			if m.Dest != workDir {
+				continue
+			}
+			switch {
+			case strings.Contains(args, "Pkg.instantiate"):
+				instantiated = op.Digest
+				if m.Readonly || m.Output == pb.SkipOutput {
+					t.Error("the build context is not writable for Pkg.instantiate")
+				}
+			case strings.Contains(args, "julia_depot_export.jl"):
+				exports[op.Inputs[m.Input].Digest] = true
+			}
+		}
+	}
+	if instantiated == "" {
+		t.Fatal("no Pkg.instantiate with the build context in the LLB")
+	}
+	if !exports[instantiated] {
+		t.Error("the manifest written by Pkg.instantiate is not used by the later steps")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	_ "embed"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	nodeRootDir    = "/opt/node"     // Location of the Node.js distribution and the global packages
+	nodeBinDir     = "/opt/node/bin" // Location of node, npm and the binaries of the global packages
+	nodeBinName    = "node.tar.gz"   // Node.js archive name
+	nodeDefaultVer = "20.11.1"       // Default Node.js release, the LTS one
+
+	nodeReleaseURL  = "https://nodejs.org/dist/v%[1]s/node-v%[1]s-linux-%[2]s.tar.gz"
+	nodeChecksumURL = "https://nodejs.org/dist/v%s/SHASUMS256.txt"
+)
+
+// nodeArchs are the file suffixes of the Node.js releases by the platform.
+var nodeArchs = map[string]string{
+	"linux/amd64": "x64",
+	"linux/arm64": "arm64",
+}
+
+//go:embed node.sh
+var downloadNodeBashScript string
+
+// nodeVersion returns the Node.js release to install.
+func (g generalGraph) nodeVersion() string {
+	if g.NodeVersion == nil || *g.NodeVersion == "" {
+		return nodeDefaultVer
+	}
+	return *g.NodeVersion
+}
+
+// getNodeBinary downloads the official release of Node.js in the builder,
+// verified by the checksums of the release, and unpacks it under nodeRootDir.
+func (g generalGraph) getNodeBinary(root llb.State) llb.State {
+	version := g.nodeVersion()
+	builder := g.compileBuilderImage().
+		AddEnv("NODE_URL", fmt.Sprintf(nodeReleaseURL, version, nodeArchs[g.platform()])).
+		AddEnv("NODE_CHECKSUM_URL", fmt.Sprintf(nodeChecksumURL, version)).
+		Run(llb.Shlexf("sh -c '%s'", downloadNodeBashScript),
+			llb.WithCustomNamef("[internal] downloading node %s binary", version)).Root()
+
+	var path = filepath.Join("/tmp", nodeBinName)
+	return root.
+		File(llb.Copy(builder, path, path),
+			llb.WithCustomNamef("[internal] copying %s to /tmp", nodeBinName)).
+		File(llb.Mkdir(nodeRootDir, 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] creating %s folder for node binary", nodeRootDir)).
+		Run(llb.Shlexf(`bash -c "tar zxf %s --strip 1 -C %s && rm %s"`, path, nodeRootDir, path),
+			llb.WithCustomNamef("[internal] unpack node archive under %s", nodeRootDir)).Root()
+}
+
+// installNode adds node and npm to $PATH. The global prefix of npm is
+// nodeRootDir, which is owned by the user, thus `npm install -g` works in
+// the container without sudo. pnpm and yarn are enabled by corepack, which
+// fetches them at the first use.
+func (g *generalGraph) installNode(root llb.State) llb.State {
+	node := g.getNodeBinary(root)
+	node = g.updateEnvPath(node, nodeBinDir)
+	node = node.Run(llb.Shlex(`sh -c "command -v corepack > /dev/null && corepack enable || true"`),
+		llb.WithCustomName("[internal] enabling pnpm and yarn by corepack")).Root()
+	g.UserDirectories = append(g.UserDirectories, nodeRootDir)
+	return node
+}
+
+// installNPMPackages installs the global packages by npm, with the cache of
+// npm shared across the builds.
+func (g generalGraph) installNPMPackages(root llb.State) llb.State {
+	if len(g.NPMPackages) == 0 {
+		return root
+	}
+	cacheDir := filepath.Join("/", "root", ".npm")
+	cache := llb.Scratch().File(llb.Mkdir("/cache/npm", 0755, llb.WithParents(true)),
+		llb.WithCustomName("[internal] setting npm cache mount permissions"))
+
+	root = root.AddEnv("PATH", strings.Join(g.RuntimeEnvPaths, ":"))
+	for _, packages := range g.NPMPackages {
+		command := fmt.Sprintf("npm install -g %s", strings.Join(packages, " "))
+		logrus.WithField("command", command).Debug("Configure npm install statements")
+		run := root.Run(llb.Shlex(command), llb.AddEnv("NPM_CONFIG_CACHE", cacheDir), g.mountSecrets(),
+			llb.WithCustomNamef("[internal] npm install -g %s", strings.Join(packages, " ")))
+		run.AddMount(cacheDir, cache,
+			llb.AsPersistentCacheDir(g.CacheID(cacheDir), llb.CacheMountShared), llb.SourcePath("/cache/npm"))
+		root = run.Root()
+	}
+	return root
+}
@@ -0,0 +1,11 @@
+set -o pipefail && \
+SHA256SUM=$(wget -q "${NODE_CHECKSUM_URL}" -O - | grep " $(basename "${NODE_URL}")$" | cut -d " " -f 1)
+if [ -z "${SHA256SUM}" ]; then
+    echo "failed to get the checksum of ${NODE_URL} from ${NODE_CHECKSUM_URL}, the Node.js version may not exist"
+    exit 1
+fi
+
+wget "${NODE_URL}" -O /tmp/node.tar.gz && \
+echo "${SHA256SUM}  /tmp/node.tar.gz" > /tmp/sha256sum && \
+sha256sum -c -s /tmp/sha256sum || { echo "CHECKSUM FAILED"; exit 1; }
+echo "CHECKSUM PASSED"
@@ -0,0 +1,91 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+func TestNode(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := NPMPackage([]string{"pnpm"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.NodeVersion == nil || g.nodeVersion() != nodeDefaultVer {
+		t.Errorf("the default node is not installed by the npm packages: %v", g.NodeVersion)
+	}
+	if err := Node("18.19.1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.nodeVersion() != "18.19.1" {
+		t.Errorf("unexpected node version: %s", g.nodeVersion())
+	}
+
+	g.installNode(llb.Image("ubuntu:22.04"))
+	if g.RuntimeEnvPaths[len(g.RuntimeEnvPaths)-1] != nodeBinDir {
+		t.Errorf("node is not in the PATH: %v", g.RuntimeEnvPaths)
+	}
+	if !reflect.DeepEqual(g.UserDirectories, []string{nodeRootDir}) {
+		t.Errorf("unexpected user directories: %v", g.UserDirectories)
+	}
+
+	for _, version := range []string{"20", "v20.11.1", "lts"} {
+		if err := Node(version); err == nil {
+			t.Errorf("expected error for the version %s", version)
+		}
+	}
+	if err := NPMPackage(nil); err == nil {
+		t.Error("expected error for the empty npm packages")
+	}
+}
+
+func TestParallelLanguagePackages(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := NPMPackage([]string{"pnpm"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.Language.Name = "julia"
+
+	ctx := context.Background()
+	root := llb.Image("ubuntu:22.04").AddEnv("FOO", "bar")
+	pack := g.compileLanguagePackages(root)
+	if v, ok, err := pack.GetEnv(ctx, "FOO"); err != nil || !ok || v != "bar" {
+		t.Errorf("the env is not kept after the merge: %s, %v, %v", v, ok, err)
+	}
+	if _, err := pack.Marshal(ctx); err != nil {
+		t.Fatalf("failed to marshal the merged packages: %v", err)
+	}
+}
+
+func TestNodeCacheDir(t *testing.T) {
+	cacheDir := "/home/envd/.cache/envd"
+	g := NewGraph().(*generalGraph)
+	g.LanguageCacheDir = &cacheDir
+	g.compileLanguageCacheDir(llb.Image("ubuntu:22.04"))
+	if _, ok := g.RuntimeEnviron["NPM_CONFIG_CACHE"]; ok {
+		t.Error("the npm cache is configured without node")
+	}
+
+	version := nodeDefaultVer
+	g.NodeVersion = &version
+	g.compileLanguageCacheDir(llb.Image("ubuntu:22.04"))
+	if dir := g.RuntimeEnviron["NPM_CONFIG_CACHE"]; dir != "/home/envd/.cache/envd/npm" {
+		t.Errorf("unexpected npm cache dir: %s", dir)
+	}
+}
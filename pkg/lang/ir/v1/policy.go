@@ -0,0 +1,125 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/docker/distribution/reference"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+// CheckPolicy validates the graph against the policy, all the violations are
+// listed in the returned error.
+func (g generalGraph) CheckPolicy(policy ir.Policy) error {
+	var violations []string
+
+	if len(policy.AllowedBaseImages) > 0 {
+		image := g.Image
+		if g.CUDA != nil && !isCUDAImage(image) {
+			image = GetCUDAImage(image, g.CUDA, g.CUDNN, g.Dev)
+		}
+		if !isImageAllowed(image, policy.AllowedBaseImages) {
+			violations = append(violations, fmt.Sprintf("base image %s is not approved", image))
+		}
+	}
+
+	if len(policy.RequiredLabels) > 0 {
+		labels, err := g.Labels()
+		if err != nil {
+			return errors.Wrap(err, "failed to get the labels")
+		}
+		for _, l := range policy.RequiredLabels {
+			if labels[l] == "" {
+				violations = append(violations, fmt.Sprintf("label %s is missing", l))
+			}
+		}
+	}
+
+	if policy.RequireLanguageVersion && g.Language.Name != "" &&
+		(g.Language.Version == nil || *g.Language.Version == "") {
+		violations = append(violations, fmt.Sprintf("%s has no version", g.Language.Name))
+	}
+
+	if policy.RequirePinnedPackages {
+		violations = append(violations, g.unpinnedPackages()...)
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.Newf("the environment violates the policy:\n  - %s",
+		strings.Join(violations, "\n  - "))
+}
+
+// isImageAllowed matches the image as written and its normalized name,
+// e.g. both `ubuntu:20.04` and `docker.io/library/ubuntu:20.04`.
+func isImageAllowed(image string, patterns []string) bool {
+	names := []string{image}
+	if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		names = append(names, named.String())
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unpinnedPackages lists the packages without an exact version. R packages
+// cannot be pinned in envd, thus they are always reported.
+func (g generalGraph) unpinnedPackages() []string {
+	var violations []string
+	check := func(kind, sep string, packages []string) {
+		for _, p := range packages {
+			if sep == "" || !strings.Contains(p, sep) {
+				violations = append(violations, fmt.Sprintf("%s package %s is not pinned", kind, p))
+			}
+		}
+	}
+
+	check("system", "=", g.SystemPackages)
+	for _, pkgs := range g.PyPIPackages {
+		check("Python", "==", pkgs)
+	}
+	if g.CondaConfig != nil {
+		check("conda", "=", g.CondaPackages)
+	}
+	for _, pkgs := range g.RPackages {
+		check("R", "", pkgs)
+	}
+	// the Julia packages are pinned by the version, or the rev if from Git
+	checkJulia := func(kind string, packages []string) {
+		for _, dep := range packages {
+			if p, _ := parseJuliaPackage(dep); p.Version == "" && p.Rev == "" {
+				violations = append(violations, fmt.Sprintf("%s package %s is not pinned", kind, dep))
+			}
+		}
+	}
+	for _, pkgs := range g.JuliaPackages {
+		checkJulia("Julia", pkgs)
+	}
+	for _, p := range g.JuliaPlatformPackages {
+		checkJulia(fmt.Sprintf("Julia (%s)", p.Platform), p.Packages)
+	}
+	return violations
+}
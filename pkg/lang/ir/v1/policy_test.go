@@ -0,0 +1,84 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	version := "1.8.5"
+	testcases := []struct {
+		graph      generalGraph
+		policy     ir.Policy
+		violations []string
+	}{
+		{
+			graph: generalGraph{
+				Image:    "ubuntu:20.04",
+				Language: ir.Language{Name: "julia", Version: &version},
+			},
+			policy: ir.Policy{
+				AllowedBaseImages:      []string{"docker.io/library/ubuntu:*"},
+				RequireLanguageVersion: true,
+			},
+		},
+		{
+			graph: generalGraph{
+				Image:    "debian:11",
+				Language: ir.Language{Name: "julia"},
+			},
+			policy: ir.Policy{
+				AllowedBaseImages:      []string{"ubuntu:*"},
+				RequireLanguageVersion: true,
+			},
+			violations: []string{"base image debian:11 is not approved", "julia has no version"},
+		},
+		{
+			graph: generalGraph{
+				SystemPackages: []string{"curl=7.68.0-1ubuntu2", "git"},
+				PyPIPackages:   [][]string{{"numpy==1.23.5", "pandas>=1.5"}},
+				JuliaPackages:  [][]string{{"Example@0.5", "Flux"}},
+			},
+			policy: ir.Policy{RequirePinnedPackages: true},
+			violations: []string{
+				"system package git is not pinned",
+				"Python package pandas>=1.5 is not pinned",
+				"Julia package Flux is not pinned",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		err := tc.graph.CheckPolicy(tc.policy)
+		if len(tc.violations) == 0 {
+			if err != nil {
+				t.Errorf("expected no violation, got %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected violations %v, got nil", tc.violations)
+			continue
+		}
+		for _, v := range tc.violations {
+			if !strings.Contains(err.Error(), v) {
+				t.Errorf("expected violation %q in %v", v, err)
+			}
+		}
+	}
+}
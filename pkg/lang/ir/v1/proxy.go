@@ -0,0 +1,154 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/util/apicaps"
+	"github.com/opencontainers/go-digest"
+)
+
+// injectBuildEnv sets the proxy, the CA bundle and the resource limits of
+// every Run step in the definition, including the ones after llb.Merge, which
+// does not keep the environment variables of the inputs. The proxy is not a
+// part of the cache key thus changing it does not invalidate the cache, while
+// the limits are.
+func (g generalGraph) injectBuildEnv(def *llb.Definition) (*llb.Definition, error) {
+	if g.ProxyConfig == nil && len(g.CACerts) == 0 && !g.hasExecLimits() {
+		return def, nil
+	}
+
+	// the digests of the ops are changed, and the inputs of the following ops
+	// are updated since the ops are in the topological order
+	digests := make(map[digest.Digest]digest.Digest, len(def.Def))
+	res := &llb.Definition{
+		Metadata:    make(map[digest.Digest]pb.OpMetadata, len(def.Metadata)),
+		Source:      def.Source,
+		Constraints: def.Constraints,
+	}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, errors.Wrap(err, "failed to parse the op")
+		}
+		for _, inp := range op.Inputs {
+			if d, ok := digests[inp.Digest]; ok {
+				inp.Digest = d
+			}
+		}
+		exec := op.GetExec()
+		if exec != nil {
+			g.setExecEnv(exec.Meta)
+			g.setExecLimits(exec.Meta)
+		}
+		newDt, err := op.Marshal()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal the op")
+		}
+		oldDgst, newDgst := digest.FromBytes(dt), digest.FromBytes(newDt)
+		digests[oldDgst] = newDgst
+		res.Def = append(res.Def, newDt)
+		meta, ok := def.Metadata[oldDgst]
+		if exec != nil && g.hasExecLimits() {
+			meta.Caps = g.execLimitsCaps(meta.Caps)
+			ok = true
+		}
+		if ok {
+			res.Metadata[newDgst] = meta
+		}
+	}
+
+	if def.Source != nil && len(def.Source.Locations) > 0 {
+		res.Source = &pb.Source{
+			Locations: make(map[string]*pb.Locations, len(def.Source.Locations)),
+			Infos:     def.Source.Infos,
+		}
+		for d, loc := range def.Source.Locations {
+			if newDgst, ok := digests[digest.Digest(d)]; ok {
+				d = newDgst.String()
+			}
+			res.Source.Locations[d] = loc
+		}
+	}
+	return res, nil
+}
+
+// setExecEnv sets the proxy, and the CA bundle if the step does not use the
+// bundle of config.trusted_cert.
+func (g generalGraph) setExecEnv(meta *pb.Meta) {
+	if g.ProxyConfig != nil {
+		meta.ProxyEnv = &pb.ProxyEnv{
+			HttpProxy:  g.ProxyConfig.HTTPProxy,
+			HttpsProxy: g.ProxyConfig.HTTPSProxy,
+			NoProxy:    g.ProxyConfig.NoProxy,
+		}
+	}
+	if len(g.CACerts) == 0 {
+		return
+	}
+	declared := make(map[string]bool, len(meta.Env))
+	for _, e := range meta.Env {
+		declared[strings.SplitN(e, "=", 2)[0]] = true
+	}
+	for _, env := range caCertEnvs {
+		if !declared[env] {
+			meta.Env = append(meta.Env, env+"="+systemCertsBundle)
+		}
+	}
+}
+
+func (g generalGraph) hasExecLimits() bool {
+	return g.BuildLimits != nil && (g.BuildLimits.Memory > 0 || g.BuildLimits.CgroupParent != "")
+}
+
+// setExecLimits limits the data segment size by the ulimit, and sets the
+// cgroup parent of the step. BuildKit has no memory cgroup of the step, thus
+// the ulimit is the best effort, the RSS and the mmap are only capped by the
+// cgroup parent.
+func (g generalGraph) setExecLimits(meta *pb.Meta) {
+	if g.BuildLimits == nil {
+		return
+	}
+	if g.BuildLimits.Memory > 0 {
+		meta.Ulimit = append(meta.Ulimit, &pb.Ulimit{
+			Name: string(llb.UlimitData),
+			Soft: g.BuildLimits.Memory,
+			Hard: g.BuildLimits.Memory,
+		})
+	}
+	if g.BuildLimits.CgroupParent != "" {
+		meta.CgroupParent = g.BuildLimits.CgroupParent
+	}
+}
+
+// execLimitsCaps adds the capabilities required by the limits of the step,
+// which are set by llb.AddUlimit and llb.WithCgroupParent otherwise.
+func (g generalGraph) execLimitsCaps(caps map[apicaps.CapID]bool) map[apicaps.CapID]bool {
+	res := make(map[apicaps.CapID]bool, len(caps)+2)
+	for c, v := range caps {
+		res[c] = v
+	}
+	if g.BuildLimits.Memory > 0 {
+		res[pb.CapExecMetaUlimit] = true
+	}
+	if g.BuildLimits.CgroupParent != "" {
+		res[pb.CapExecMetaCgroupParent] = true
+	}
+	return res
+}
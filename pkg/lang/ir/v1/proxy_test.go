@@ -0,0 +1,208 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "http://host-proxy:3128")
+	t.Setenv("NO_PROXY", "localhost")
+	g := resetDefaultGraph(t)
+	if err := Proxy("http://proxy:3128", "", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := g.ProxyConfig; p == nil || p.HTTPProxy != "http://proxy:3128" ||
+		p.HTTPSProxy != "http://host-proxy:3128" || p.NoProxy != "localhost" {
+		t.Errorf("unexpected proxy: %+v", p)
+	}
+	if err := Proxy("proxy:3128", "", ""); err == nil {
+		t.Errorf("expected error for the proxy without the scheme")
+	}
+}
+
+func TestInjectBuildEnv(t *testing.T) {
+	g := resetDefaultGraph(t)
+	g.ProxyConfig = &ir.ProxyConfig{HTTPSProxy: "http://proxy:3128"}
+	g.CACerts = []string{"cert"}
+
+	base := llb.Image("docker.io/library/ubuntu:22.04")
+	apt := base.Run(llb.Shlex("apt-get update")).Root()
+	merge := llb.Merge([]llb.State{base, llb.Diff(base, apt)})
+	pip := merge.AddEnv("PIP_CERT", "/etc/envd/certs/python.pem").
+		Run(llb.Shlex("pip install numpy")).Root()
+	def, err := pip.Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	res, err := g.injectBuildEnv(def)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Def) != len(def.Def) || len(res.Metadata) != len(def.Metadata) {
+		t.Fatalf("unexpected ops: %d, metadata: %d", len(res.Def), len(res.Metadata))
+	}
+
+	seen := map[digest.Digest]bool{}
+	execs := 0
+	for _, op := range parseOps(t, res) {
+		for _, inp := range op.Inputs {
+			if !seen[inp.Digest] {
+				t.Errorf("input %s is not in the definition", inp.Digest)
+			}
+		}
+		seen[op.Digest] = true
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		execs++
+		if exec.Meta.ProxyEnv == nil || exec.Meta.ProxyEnv.HttpsProxy != "http://proxy:3128" {
+			t.Errorf("unexpected proxy of %v: %+v", exec.Meta.Args, exec.Meta.ProxyEnv)
+		}
+		pipCert := ""
+		for _, e := range exec.Meta.Env {
+			if strings.HasPrefix(e, "PIP_CERT=") {
+				if pipCert != "" {
+					t.Errorf("PIP_CERT is set twice in %v", exec.Meta.Args)
+				}
+				pipCert = strings.TrimPrefix(e, "PIP_CERT=")
+			}
+		}
+		expected := systemCertsBundle
+		if exec.Meta.Args[0] == "pip" {
+			expected = "/etc/envd/certs/python.pem"
+		}
+		if pipCert != expected {
+			t.Errorf("unexpected PIP_CERT of %v: %s", exec.Meta.Args, pipCert)
+		}
+	}
+	if execs != 2 {
+		t.Errorf("expected 2 exec ops, got %d", execs)
+	}
+}
+
+func TestInjectBuildEnvBuilderImage(t *testing.T) {
+	g := resetDefaultGraph(t)
+	g.CACerts = []string{"cert"}
+
+	julia, err := g.getJuliaBinary(llb.Image("docker.io/library/ubuntu:22.04"))
+	if err != nil {
+		t.Fatalf("failed to get julia: %v", err)
+	}
+	def, err := julia.Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	res, err := g.injectBuildEnv(def)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ops := map[digest.Digest]parsedOp{}
+	var download *parsedOp
+	for _, op := range parseOps(t, res) {
+		op := op
+		ops[op.Digest] = op
+		if exec := op.GetExec(); exec != nil && strings.Contains(strings.Join(exec.Meta.Args, " "), "JULIA_URL") {
+			download = &op
+		}
+	}
+	if download == nil {
+		t.Fatal("no download of the Julia release in the LLB")
+	}
+	if !strings.Contains(strings.Join(download.GetExec().Meta.Env, " "), "SSL_CERT_FILE="+systemCertsBundle) {
+		t.Errorf("the CA bundle is not set for the download: %v", download.GetExec().Meta.Env)
+	}
+
+	// the CA bundle of the builder image is regenerated with the CAs by root
+	updated := false
+	for _, op := range walkInputs(ops, *download) {
+		exec := op.GetExec()
+		if exec != nil && strings.Join(exec.Meta.Args, " ") == "sh -c "+caCertScript {
+			updated = true
+			if exec.Meta.User != "root" {
+				t.Errorf("unexpected user of the CA bundle update: %q", exec.Meta.User)
+			}
+		}
+	}
+	if !updated {
+		t.Error("the CAs are not installed into the builder image")
+	}
+}
+
+// walkInputs returns the ops which the op depends on, directly or not.
+func walkInputs(ops map[digest.Digest]parsedOp, op parsedOp) []parsedOp {
+	var res []parsedOp
+	for _, inp := range op.Inputs {
+		input := ops[inp.Digest]
+		res = append(append(res, input), walkInputs(ops, input)...)
+	}
+	return res
+}
+
+func TestInjectBuildLimits(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := BuildLimits("30m", "1m", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := BuildLimits("-1s", "", ""); err == nil {
+		t.Errorf("expected error of the negative step timeout")
+	}
+	if err := BuildLimits("", "8x", ""); err == nil {
+		t.Errorf("expected error of the invalid memory limit")
+	}
+	if err := BuildLimits("30m", "8g", "envd-build"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.BuildLimits.StepTimeout != 30*time.Minute || g.BuildLimits.Memory != 8<<30 {
+		t.Errorf("unexpected limits: %+v", g.BuildLimits)
+	}
+
+	def, err := llb.Image("docker.io/library/ubuntu:22.04").
+		Run(llb.Shlex("apt-get update")).Root().Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	res, err := g.injectBuildEnv(def)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, op := range parseOps(t, res) {
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		if len(exec.Meta.Ulimit) != 1 || exec.Meta.Ulimit[0].Hard != 8<<30 || exec.Meta.CgroupParent != "envd-build" {
+			t.Errorf("unexpected limits of %v: %+v", exec.Meta.Args, exec.Meta)
+		}
+		caps := res.Metadata[op.Digest].Caps
+		if !caps[pb.CapExecMetaUlimit] || !caps[pb.CapExecMetaCgroupParent] {
+			t.Errorf("unexpected caps of %v: %v", exec.Meta.Args, caps)
+		}
+	}
+}
@@ -97,6 +97,29 @@ func (g generalGraph) compileAlternative(root llb.State) llb.State {
 	return run.Root()
 }
 
+// compilePipToolchain installs the pinned pip, setuptools and wheel before
+// any Python package, thus the installs are reproducible.
+func (g generalGraph) compilePipToolchain(root llb.State) llb.State {
+	if g.PipToolchain == nil {
+		return root
+	}
+	var pins []string
+	for _, p := range []struct{ name, version string }{
+		{"pip", g.PipToolchain.Pip},
+		{"setuptools", g.PipToolchain.Setuptools},
+		{"wheel", g.PipToolchain.Wheel},
+	} {
+		if p.version != "" {
+			pins = append(pins, fmt.Sprintf("%s==%s", p.name, p.version))
+		}
+	}
+	if len(pins) == 0 {
+		return root
+	}
+	return root.Run(llb.Shlexf("python -m pip install --no-cache-dir %s", strings.Join(pins, " ")),
+		llb.WithCustomNamef("[internal] pin pip toolchain %s", strings.Join(pins, " "))).Root()
+}
+
 func (g generalGraph) compilePyPIPackages(root llb.State) llb.State {
 	if len(g.PyPIPackages) == 0 && g.RequirementsFile == nil && len(g.PythonWheels) == 0 {
 		return root
@@ -115,7 +138,7 @@ func (g generalGraph) compilePyPIPackages(root llb.State) llb.State {
 			command := fmt.Sprintf("python -m pip install %s", strings.Join(packages, " "))
 			logrus.WithField("command", command).Debug("Configure pip install statements")
 			run := root.
-				Run(llb.Shlex(command), llb.WithCustomNamef("[internal] pip install %s",
+				Run(llb.Shlex(command), g.gpuStageConstraint(), g.mountSecrets(), llb.WithCustomNamef("[internal] pip install %s",
 					strings.Join(packages, " ")))
 			run.AddMount(cacheDir, cache,
 				llb.AsPersistentCacheDir(g.CacheID(cacheDir), llb.CacheMountShared), llb.SourcePath("/cache/pip"))
@@ -128,12 +151,11 @@ func (g generalGraph) compilePyPIPackages(root llb.State) llb.State {
 			Debug("Configure pip install requirements statements")
 		root = root.Dir(g.getWorkingDir())
 		run := root.
-			Run(llb.Shlexf("python -m pip install -r %s", *g.RequirementsFile),
+			Run(llb.Shlexf("python -m pip install -r %s", *g.RequirementsFile), g.gpuStageConstraint(), g.mountSecrets(),
 				llb.WithCustomNamef("pip install -r %s", *g.RequirementsFile))
 		run.AddMount(cacheDir, cache,
 			llb.AsPersistentCacheDir(g.CacheID(cacheDir), llb.CacheMountShared), llb.SourcePath("/cache/pip"))
-		run.AddMount(g.getWorkingDir(),
-			llb.Local(flag.FlagBuildContext))
+		run.AddMount(g.getWorkingDir(), dependencyContext(g.RequirementsFiles))
 		root = run.Root()
 	}
 
@@ -141,7 +163,7 @@ func (g generalGraph) compilePyPIPackages(root llb.State) llb.State {
 		root = root.Dir(g.getWorkingDir())
 		cmdTemplate := "python -m pip install %s"
 		for _, wheel := range g.PythonWheels {
-			run := root.Run(llb.Shlexf(cmdTemplate, wheel), llb.WithCustomNamef("pip install %s", wheel))
+			run := root.Run(llb.Shlexf(cmdTemplate, wheel), g.mountSecrets(), llb.WithCustomNamef("pip install %s", wheel))
 			run.AddMount(g.getWorkingDir(), llb.Local(flag.FlagBuildContext), llb.Readonly)
 			run.AddMount(cacheDir, cache,
 				llb.AsPersistentCacheDir(g.CacheID(cacheDir), llb.CacheMountShared), llb.SourcePath("/cache/pip"))
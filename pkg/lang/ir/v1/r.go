@@ -21,13 +21,37 @@ import (
 	"github.com/moby/buildkit/client/llb"
 )
 
-func (g generalGraph) installRLang(root llb.State) llb.State {
+const (
+	rSiteLibrary   = "/usr/local/lib/R/site-library" // Location of the R packages shared by the users
+	rProfileSite   = "/etc/R/Rprofile.site"          // Location of the site wide R profile
+	rDefaultMirror = "https://cran.rstudio.com"
+)
+
+// installRLang installs R from the CRAN repository. The site library is owned
+// by the user, and the CRAN mirror is set in the site profile, thus the
+// packages can be installed at runtime by `install.packages` without sudo.
+func (g *generalGraph) installRLang(root llb.State) llb.State {
 
 	installR := "apt-get update && apt-get install -y -t focal-cran40 r-base"
 
-	run := root.Run(llb.Shlexf("bash -c \"%s\"", installR),
-		llb.WithCustomNamef("[internal] apt install R environment from CRAN repository"))
-	return run.Root()
+	opts := append([]llb.RunOption{llb.Shlexf("bash -c \"%s\"", installR),
+		llb.WithCustomNamef("[internal] apt install R environment from CRAN repository")},
+		g.aptListsRunOptions()...)
+	run := root.Run(opts...)
+
+	g.UserDirectories = append(g.UserDirectories, rSiteLibrary)
+	return run.Root().
+		Run(llb.Shlexf(`sh -c "mkdir -p %[1]s && echo 'options(repos = c(CRAN = \"%[2]s\"))' >> %[3]s"`,
+			rSiteLibrary, g.cranMirror(), rProfileSite),
+			llb.WithCustomNamef("[internal] configuring CRAN mirror %s", g.cranMirror())).Root()
+}
+
+// cranMirror returns the configured CRAN mirror, or the default one.
+func (g generalGraph) cranMirror() string {
+	if g.CRANMirrorURL != nil {
+		return *g.CRANMirrorURL
+	}
+	return rDefaultMirror
 }
 
 func (g generalGraph) installRPackages(root llb.State) llb.State {
@@ -36,20 +60,11 @@ func (g generalGraph) installRPackages(root llb.State) llb.State {
 		return root
 	}
 
-	mirrorURL := "https://cran.rstudio.com"
-	if g.CRANMirrorURL != nil {
-		mirrorURL = *g.CRANMirrorURL
-	}
-
-	lib := "/usr/local/lib/R/site-library/"
-
-	root = root.
-		Run(llb.Shlexf("chmod 777 %s", lib), llb.WithCustomNamef("[internal] setting execute permission for default R package library for envd users")).Root()
-
 	for _, packages := range g.RPackages {
-		command := fmt.Sprintf(`R -e 'options(repos = "%s"); install.packages(c("%s"), lib = "%s")'`, mirrorURL, strings.Join(packages, `","`), lib)
+		command := fmt.Sprintf(`R -e 'options(repos = "%s"); install.packages(c("%s"), lib = "%s")'`,
+			g.cranMirror(), strings.Join(packages, `","`), rSiteLibrary)
 		run := root.
-			Run(llb.Shlex(command), llb.WithCustomNamef("[internal] installing R packages: %s", strings.Join(packages, " ")))
+			Run(llb.Shlex(command), g.mountSecrets(), llb.WithCustomNamef("[internal] installing R packages: %s", strings.Join(packages, " ")))
 		root = run.Root()
 
 	}
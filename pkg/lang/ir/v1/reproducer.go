@@ -0,0 +1,129 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+const reproducerVersion = "v1"
+
+// Reproducer is the portable manifest of the environment for the bug
+// reports. It contains the packages pinned to the resolved versions and the
+// base image pinned by the digest, and it's imported by ImportReproducer to
+// rebuild the environment.
+type Reproducer struct {
+	Version string `json:"version"`
+	// Image is the base image pinned by the digest
+	Image string `json:"image"`
+	// Graph is the serialized graph without the host specific settings
+	Graph json.RawMessage `json:"graph"`
+}
+
+// ExportReproducer serializes the graph with the base image and the packages
+// pinned. The image is pinned by the digest, e.g. `ubuntu:22.04@sha256:...`,
+// and the packages are the resolved versions by the package manager, as
+// returned by CompileResolution. The declared packages which are not
+// resolved, e.g. the conda and R packages, are kept as they are.
+func (g *generalGraph) ExportReproducer(image string, packages map[string][]string) ([]byte, error) {
+	name, dgst, found := strings.Cut(image, "@")
+	if !found {
+		return nil, errors.Newf("base image %s is not pinned by the digest", image)
+	}
+	r := *g
+	// the host specific settings are not portable
+	r.PublicKeyPath = ""
+	r.EnvironmentName = ""
+	r.Image = name
+	r.ImageDigest = dgst
+	if resolved, ok := packages["system"]; ok {
+		r.SystemPackages = resolved
+	}
+	if resolved, ok := packages["pypi"]; ok {
+		pinned := make([]string, 0, len(resolved))
+		for _, p := range resolved {
+			// pip writes `name-version`, and the version never has `-`
+			if i := strings.LastIndex(p, "-"); i > 0 {
+				p = p[:i] + "==" + p[i+1:]
+			}
+			pinned = append(pinned, p)
+		}
+		// the requirements file is also resolved
+		r.PyPIPackages = [][]string{pinned}
+		r.RequirementsFile = nil
+		r.RequirementsFiles = nil
+	}
+	if resolved, ok := packages["julia"]; ok {
+		r.JuliaPackages = [][]string{pinnedJuliaPackages(g.juliaPackages(g.platform()), resolved)}
+		// the resolution is specific to the platform of the base image digest
+		r.JuliaPlatformPackages = nil
+	}
+
+	code, err := r.Dump()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dump the graph")
+	}
+	return json.MarshalIndent(Reproducer{
+		Version: reproducerVersion,
+		Image:   image,
+		Graph:   json.RawMessage(code),
+	}, "", "  ")
+}
+
+// pinnedJuliaPackages returns the resolved `name@version` of the packages,
+// except that the packages added by the Git URL are kept as declared since
+// they are not in the registry.
+func pinnedJuliaPackages(declared [][]string, resolved []string) []string {
+	var pinned []string
+	urls := map[string]bool{}
+	for _, packages := range declared {
+		for _, dep := range packages {
+			if p, err := parseJuliaPackage(dep); err == nil && p.URL != "" {
+				urls[p.Name] = true
+				pinned = append(pinned, dep)
+			}
+		}
+	}
+	for _, p := range resolved {
+		if name, _, _ := strings.Cut(p, "@"); !urls[name] {
+			pinned = append(pinned, p)
+		}
+	}
+	return pinned
+}
+
+// ImportReproducer loads the graph exported by ExportReproducer as the
+// default graph.
+func ImportReproducer(data []byte) error {
+	var r Reproducer
+	if err := json.Unmarshal(data, &r); err != nil {
+		return errors.Wrap(err, "failed to parse the reproducer")
+	}
+	if r.Version != reproducerVersion {
+		return errors.Newf("unsupported reproducer version: %s", r.Version)
+	}
+	g := NewGraph().(*generalGraph)
+	if err := g.Load(r.Graph); err != nil {
+		return errors.Wrap(err, "failed to load the graph of the reproducer")
+	}
+	if g.ImageDigest == "" {
+		return errors.New("base image of the reproducer must be pinned by the digest")
+	}
+	DefaultGraph = g
+	return nil
+}
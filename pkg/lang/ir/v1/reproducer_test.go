@@ -0,0 +1,54 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportReproducer(t *testing.T) {
+	resetDefaultGraph(t)
+	requirements := "requirements.txt"
+	g := NewGraph().(*generalGraph)
+	g.Image = "ubuntu:22.04"
+	g.SystemPackages = []string{"curl"}
+	g.PyPIPackages = [][]string{{"numpy>=1.23"}}
+	g.RequirementsFile = &requirements
+	g.JuliaPackages = [][]string{{"Example", "JuliaLang/Tokenize.jl#v0.5.25"}}
+
+	_, err := g.ExportReproducer("ubuntu:22.04", nil)
+	require.Error(t, err)
+
+	image := "ubuntu:22.04@sha256:0bced47fffa3361afa981854fcabcd4577cd43cebbb808cea2b1f33a3dd7f508"
+	data, err := g.ExportReproducer(image, map[string][]string{
+		"system": {"curl=7.81.0-1ubuntu1.15", "libcurl4=7.81.0-1ubuntu1.15"},
+		"pypi":   {"numpy-1.26.4", "scikit-learn-1.2.0"},
+		"julia":  {"Example@0.5.3", "Tokenize@0.5.25"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ImportReproducer(data))
+
+	r := DefaultGraph.(*generalGraph)
+	require.Equal(t, "ubuntu:22.04", r.Image)
+	require.Equal(t, "sha256:0bced47fffa3361afa981854fcabcd4577cd43cebbb808cea2b1f33a3dd7f508", r.ImageDigest)
+	require.Equal(t, []string{"curl=7.81.0-1ubuntu1.15", "libcurl4=7.81.0-1ubuntu1.15"}, r.SystemPackages)
+	require.Equal(t, [][]string{{"numpy==1.26.4", "scikit-learn==1.2.0"}}, r.PyPIPackages)
+	require.Nil(t, r.RequirementsFile)
+	require.Equal(t, [][]string{{"JuliaLang/Tokenize.jl#v0.5.25", "Example@0.5.3"}}, r.JuliaPackages)
+	// the graph exported is not changed
+	require.Equal(t, []string{"curl"}, g.SystemPackages)
+}
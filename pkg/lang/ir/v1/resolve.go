@@ -0,0 +1,249 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/client/llb/imagemetaresolver"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	resolveDir  = "/tmp/envd-resolve"
+	lockDir     = "/tmp/envd-lock"
+	lockBaseDir = "/tmp/envd-lock-base"
+)
+
+// lockSystemPackages lists the installed system packages as `name=version`,
+// sorted for comm.
+const lockSystemPackages = `if command -v dpkg-query > /dev/null; then
+  dpkg-query -W -f '${Package}=${Version}\n'
+elif [ -f /lib/apk/db/installed ]; then
+  awk -F: '/^P:/ {p = $2} /^V:/ {print p "=" $2}' /lib/apk/db/installed
+fi | LC_ALL=C sort`
+
+// CompileResolution resolves the system and language packages on top of the
+// base image and the language installation, which are shared with the image
+// build. The packages are not installed into any image layer, only the
+// resolved versions are exported. The resolution always runs against the
+// upstream indexes instead of the build cache, thus the drift is detected.
+// It returns nil if there is nothing to resolve.
+func (g *generalGraph) CompileResolution(ctx context.Context) (*llb.Definition, error) {
+	if err := g.checkJuliaOffline(); err != nil {
+		return nil, err
+	}
+	base, err := g.compileBaseImage()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the base image")
+	}
+	base = g.compileCACerts(base)
+	lang, err := g.compileLanguage(base)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile language")
+	}
+
+	if len(g.RPackages) > 0 || (g.CondaConfig != nil && len(g.CondaConfig.CondaPackages) > 0) {
+		logrus.Warn("only the system, Julia and PyPI packages are resolved, " +
+			"the conda and R packages are skipped")
+	}
+
+	var outputs []llb.State
+	if len(g.SystemPackages) > 0 {
+		outputs = append(outputs, g.resolveSystemPackages(g.compileUbuntuAPT(base)))
+	}
+	switch g.Language.Name {
+	case "python":
+		if len(g.PyPIPackages) > 0 || g.RequirementsFile != nil {
+			outputs = append(outputs, g.resolvePyPIPackages(g.compilePyPIIndex(lang)))
+		}
+	case "julia":
+		if len(g.juliaPackages(g.platform())) > 0 {
+			outputs = append(outputs, g.resolveJuliaPackages(lang))
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, nil
+	}
+
+	output := llb.Merge(outputs, llb.WithCustomName("[internal] collecting the resolved packages"))
+	def, err := output.Marshal(ctx, llb.Platform(platforms.MustParse(g.platform())), llb.Require(g.WorkerConstraints...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the llb definition")
+	}
+	return g.injectBuildEnv(def)
+}
+
+// CompileLock compiles the LLB which exports the versions of the packages
+// installed in the image of the last Compile. It shares the steps and the
+// build cache with the image, thus the versions are the built ones instead
+// of the upstream ones. It returns nil if there are no packages to lock.
+func (g *generalGraph) CompileLock(ctx context.Context) (*llb.Definition, error) {
+	if g.lock == nil {
+		return nil, nil
+	}
+	def, err := g.lock.Marshal(ctx, llb.Platform(platforms.MustParse(g.platform())), llb.Require(g.WorkerConstraints...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the llb definition")
+	}
+	return g.injectBuildEnv(def)
+}
+
+// compileLock writes the versions of the packages in the image to a scratch
+// mount, a file per package manager: the system packages which are not in the
+// base image, and the PyPI or Julia packages. The steps run on top of the
+// image, thus they are cached along with it.
+func (g generalGraph) compileLock(base, image llb.State) *llb.State {
+	var script []string
+	opts := []llb.RunOption{llb.User("root"), llb.AddEnv("PATH", strings.Join(g.RuntimeEnvPaths, ":")),
+		llb.WithCustomName("[internal] exporting the versions of the installed packages")}
+	if len(g.SystemPackages) > 0 {
+		installed := base.Run(llb.Args([]string{"sh", "-c", fmt.Sprintf("%s > %s/system.txt", lockSystemPackages, lockDir)}),
+			llb.User("root"), llb.WithCustomName("[internal] listing the system packages of the base image")).
+			AddMount(lockDir, llb.Scratch())
+		opts = append(opts, llb.AddMount(lockBaseDir, installed, llb.Readonly))
+		script = append(script, fmt.Sprintf("%s | LC_ALL=C comm -13 %s/system.txt - > %s/system.txt",
+			lockSystemPackages, lockBaseDir, lockDir))
+	}
+	switch g.Language.Name {
+	case "python":
+		if len(g.PyPIPackages) > 0 || g.RequirementsFile != nil || len(g.PythonWheels) > 0 {
+			script = append(script, fmt.Sprintf("python -m pip list --format=freeze > %s/pypi.txt", lockDir))
+		}
+	case "julia":
+		if len(g.juliaPackages(g.platform())) > 0 {
+			script = append(script, fmt.Sprintf("julia --startup-file=no --history-file=no -e 'using Pkg; %s'",
+				juliaDependenciesStatement(lockDir)))
+			opts = append(opts, llb.AddEnv("JULIA_DEPOT_PATH", juliaPkgDir))
+		}
+	}
+	if len(script) == 0 {
+		return nil
+	}
+	run := image.Run(append([]llb.RunOption{
+		llb.Args([]string{"sh", "-c", "set -e\n" + strings.Join(script, "\n")})}, opts...)...)
+	lock := run.AddMount(lockDir, llb.Scratch())
+	return &lock
+}
+
+// juliaDependenciesStatement writes the `name@version` of the dependencies of
+// the active environment to `julia.txt` in the dir.
+func juliaDependenciesStatement(dir string) string {
+	return fmt.Sprintf(`open("%s/julia.txt", "w") do io; `+
+		`for p in sort(collect(values(Pkg.dependencies())); by=p -> p.name); `+
+		`p.version === nothing || println(io, p.name, "@", p.version); end; end`, dir)
+}
+
+// ResolveBaseImage returns the base image pinned by the digest, the digest is
+// resolved from the registry if it's not declared.
+func (g generalGraph) ResolveBaseImage(ctx context.Context) (string, error) {
+	image := g.Image
+	if g.CUDA != nil && !isCUDAImage(image) {
+		image = GetCUDAImage(image, g.CUDA, g.CUDNN, g.Dev)
+	}
+	if g.ImageDigest != "" {
+		return image + "@" + g.ImageDigest, nil
+	}
+	platform := platforms.MustParse(g.platform())
+	dgst, _, err := imagemetaresolver.Default().ResolveImageConfig(ctx, image,
+		llb.ResolveImageConfigOpt{Platform: &platform})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve the base image digest")
+	}
+	return image + "@" + dgst.String(), nil
+}
+
+// resolveSystemPackages simulates the installation by apt-get or apk, and
+// writes the `name=version` of the packages to be installed.
+func (g generalGraph) resolveSystemPackages(root llb.State) llb.State {
+	names := strings.Join(g.SystemPackages, " ")
+	script := fmt.Sprintf(`set -e
+if command -v apt-get > /dev/null; then
+  apt-get update > /dev/null
+  apt-get install -s --no-install-recommends %[1]s > /tmp/envd-apt.log
+  sed -n 's/^Inst \([^ ]*\) (\([^ ]*\) .*/\1=\2/p' /tmp/envd-apt.log > %[2]s/system.txt
+elif command -v apk > /dev/null; then
+  apk add --simulate --no-cache %[1]s > /tmp/envd-apk.log
+  sed -n 's/^([0-9]*\/[0-9]*) Installing \([^ ]*\) (\([^)]*\)).*/\1=\2/p' /tmp/envd-apk.log > %[2]s/system.txt
+else
+  echo 'envd: the base image has neither apt-get nor apk' >&2; exit 1
+fi
+`, names, resolveDir)
+	run := root.Run(llb.Args([]string{"sh", "-c", script}), g.mountSecrets(), llb.IgnoreCache,
+		llb.WithCustomNamef("[internal] resolving system packages: %s", names))
+	return run.AddMount(resolveDir, llb.Scratch())
+}
+
+// resolveJuliaPackages adds the packages to a temporary environment without
+// the precompilation, and writes the `name@version` of the dependencies.
+func (g generalGraph) resolveJuliaPackages(root llb.State) llb.State {
+	var sb strings.Builder
+	sb.WriteString("Pkg.activate(; temp=true)")
+	if g.JuliaOffline {
+		sb.WriteString("; " + g.juliaRegistryStatements())
+		var packages []string
+		for _, p := range g.juliaPackages(g.platform()) {
+			packages = append(packages, p...)
+		}
+		// the registries are added to the depot before the check
+		if check := juliaOfflineCheck(packages); check != nil {
+			sb.WriteString(fmt.Sprintf("; run(`%s`)", strings.Join(check, " ")))
+		}
+	} else {
+		for _, r := range g.JuliaRegistries {
+			sb.WriteString("; " + juliaRegistryAdd(r.URL))
+		}
+	}
+	for _, packages := range g.juliaInstallGroups(g.juliaPackages(g.platform())) {
+		sb.WriteString(fmt.Sprintf("; Pkg.add(%s; preserve=%s)",
+			g.juliaPackageSpecs(packages), g.juliaPreserveLevel()))
+	}
+	sb.WriteString("; " + juliaDependenciesStatement(resolveDir))
+
+	opts := append([]llb.RunOption{llb.Shlex(g.juliaPkgCommand(sb.String())), juliaScriptsMount(),
+		llb.AddEnv("JULIA_PKG_PRECOMPILE_AUTO", "0"), llb.IgnoreCache,
+		llb.WithCustomName("[internal] resolving Julia packages")},
+		append(append(juliaNonInteractiveRunOptions(), g.juliaRegistryRunOptions()...),
+			append(append(g.juliaPkgServerRunOptions(), g.juliaOfflineRunOptions()...), g.mountSecrets())...)...)
+	run := g.waitJuliaPkgServer(root).Run(append(opts, g.juliaFailureHookRunOptions(nil)...)...)
+	return run.AddMount(resolveDir, llb.Scratch())
+}
+
+// resolvePyPIPackages resolves the packages by the dry run of pip, which
+// requires pip 22.2 or later. The output is the `name-version` of the packages.
+func (g generalGraph) resolvePyPIPackages(root llb.State) llb.State {
+	var args []string
+	for _, packages := range g.PyPIPackages {
+		for _, p := range packages {
+			// quoted for the shell since the specifiers may have `<` or `>`
+			args = append(args, fmt.Sprintf("'%s'", p))
+		}
+	}
+	if g.RequirementsFile != nil {
+		args = append(args, "-r", *g.RequirementsFile)
+	}
+	command := fmt.Sprintf(`sh -c "python -m pip install --dry-run --ignore-installed %s > /tmp/envd-pip.log && `+
+		`sed -n 's/^Would install //p' /tmp/envd-pip.log | xargs -n1 > %s/pypi.txt"`,
+		strings.Join(args, " "), resolveDir)
+	run := root.Dir(g.getWorkingDir()).Run(llb.Shlex(command), g.mountSecrets(), llb.IgnoreCache,
+		llb.WithCustomName("[internal] resolving PyPI packages"))
+	run.AddMount(g.getWorkingDir(), dependencyContext(g.RequirementsFiles), llb.Readonly)
+	return run.AddMount(resolveDir, llb.Scratch())
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestResolutionIgnoreCache(t *testing.T) {
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "julia"}
+	g.SystemPackages = []string{"git"}
+	g.JuliaPackages = [][]string{{"Example"}}
+	g.PyPIPackages = [][]string{{"numpy"}}
+
+	base := llb.Image("ubuntu:22.04")
+	for name, state := range map[string]llb.State{
+		"system": g.resolveSystemPackages(base),
+		"julia":  g.resolveJuliaPackages(base),
+		"pypi":   g.resolvePyPIPackages(base),
+	} {
+		def, err := state.Marshal(context.Background())
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+		resolved := false
+		for _, op := range parseOps(t, def) {
+			exec := op.GetExec()
+			if exec == nil || !strings.Contains(strings.Join(exec.Meta.Args, " "), resolveDir) {
+				continue
+			}
+			resolved = true
+			if !def.Metadata[op.Digest].IgnoreCache {
+				t.Errorf("the %s resolution is served from the cache", name)
+			}
+		}
+		if !resolved {
+			t.Errorf("no %s resolution in the LLB", name)
+		}
+	}
+}
+
+func TestLockCached(t *testing.T) {
+	g := NewGraph().(*generalGraph)
+	g.Language = ir.Language{Name: "python"}
+	g.SystemPackages = []string{"git"}
+	g.PyPIPackages = [][]string{{"numpy"}}
+
+	base := llb.Image("ubuntu:22.04")
+	lock := g.compileLock(base, base.Run(llb.Shlex("pip install numpy")).Root())
+	if lock == nil {
+		t.Fatal("no lock of the installed packages")
+	}
+	def, err := lock.Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var script string
+	for _, op := range parseOps(t, def) {
+		if def.Metadata[op.Digest].IgnoreCache {
+			t.Errorf("the lock step is not cached: %v", op.GetExec())
+		}
+		if exec := op.GetExec(); exec != nil && strings.Contains(strings.Join(exec.Meta.Args, " "), lockBaseDir) {
+			script = strings.Join(exec.Meta.Args, " ")
+		}
+	}
+	for _, want := range []string{"pip list --format=freeze > " + lockDir + "/pypi.txt", "comm -13 " + lockBaseDir + "/system.txt"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("the lock step does not run %q: %s", want, script)
+		}
+	}
+
+	g.PyPIPackages = nil
+	g.SystemPackages = nil
+	if g.compileLock(base, base) != nil {
+		t.Error("the lock is compiled without the packages")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	_ "embed"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	rustRootDir   = "/opt/rust"        // Location of the toolchains and the cargo home
+	rustupHomeDir = "/opt/rust/rustup" // RUSTUP_HOME, the toolchains installed by rustup
+	cargoHomeDir  = "/opt/rust/cargo"  // CARGO_HOME, the registry cache and the installed tools
+	cargoBinDir   = "/opt/rust/cargo/bin"
+
+	rustDefaultToolchain = "stable"
+	rustupInitURL        = "https://static.rust-lang.org/rustup/dist/%s-unknown-linux-gnu/rustup-init"
+)
+
+// rustArchs are the target arches of rustup-init by the platform.
+var rustArchs = map[string]string{
+	"linux/amd64": "x86_64",
+	"linux/arm64": "aarch64",
+}
+
+//go:embed rust.sh
+var downloadRustupBashScript string
+
+// rustToolchain returns the toolchain to install by rustup.
+func (g generalGraph) rustToolchain() string {
+	if g.RustConfig == nil || g.RustConfig.Toolchain == "" {
+		return rustDefaultToolchain
+	}
+	return g.RustConfig.Toolchain
+}
+
+// rustEnviron is the environment of rustup and cargo, in order for the
+// stable llb definition.
+var rustEnviron = [][2]string{
+	{"RUSTUP_HOME", rustupHomeDir},
+	{"CARGO_HOME", cargoHomeDir},
+}
+
+// installRust installs the toolchain by rustup-init, verified by its
+// checksum. RUSTUP_HOME and CARGO_HOME are owned by the user, thus rustup and
+// `cargo install` work in the container without sudo.
+func (g *generalGraph) installRust(root llb.State) llb.State {
+	toolchain := g.rustToolchain()
+	builder := g.compileBuilderImage().
+		AddEnv("RUSTUP_URL", fmt.Sprintf(rustupInitURL, rustArchs[g.platform()])).
+		Run(llb.Shlexf("sh -c '%s'", downloadRustupBashScript),
+			llb.WithCustomName("[internal] downloading rustup-init")).Root()
+
+	var path = filepath.Join("/tmp", "rustup-init")
+	command := fmt.Sprintf("%s -y --no-modify-path --profile minimal --default-toolchain %s", path, toolchain)
+	if len(g.RustConfig.Components) > 0 {
+		command += " --component " + strings.Join(g.RustConfig.Components, ",")
+	}
+	opts := []llb.RunOption{llb.Shlexf(`bash -c "%s && rm %s"`, command, path),
+		llb.WithCustomNamef("[internal] installing rust %s toolchain", toolchain)}
+	for _, env := range rustEnviron {
+		opts = append(opts, llb.AddEnv(env[0], env[1]))
+		g.RuntimeEnviron[env[0]] = env[1]
+	}
+	rust := root.
+		File(llb.Copy(builder, path, path),
+			llb.WithCustomName("[internal] copying rustup-init to /tmp")).
+		File(llb.Mkdir(rustRootDir, 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] creating %s folder for rust", rustRootDir)).
+		Run(opts...).Root()
+	g.UserDirectories = append(g.UserDirectories, rustRootDir)
+	return g.updateEnvPath(rust, cargoBinDir)
+}
+
+// compileRustEnviron exposes cargo to the package installation steps, e.g.
+// to build the PyPI wheels with the Rust extensions.
+func (g generalGraph) compileRustEnviron(root llb.State) llb.State {
+	if g.RustConfig == nil {
+		return root
+	}
+	for _, env := range rustEnviron {
+		root = root.AddEnv(env[0], env[1])
+	}
+	return root.AddEnv("PATH", strings.Join(g.RuntimeEnvPaths, ":"))
+}
+
+// installCargoPackages installs the tools by `cargo install`, with the
+// registry of cargo shared across the builds. A C linker is required.
+func (g generalGraph) installCargoPackages(root llb.State) llb.State {
+	if len(g.CargoPackages) == 0 {
+		return root
+	}
+	root = g.compileRustEnviron(root).Run(llb.Shlex(`sh -c "command -v cc > /dev/null || { echo 'envd: cargo install requires a C linker, `+
+		`add install.apt_packages(name=[build-essential])' >&2; exit 1; }"`),
+		llb.WithCustomName("[internal] checking the C linker for cargo install")).Root()
+
+	registryDir := filepath.Join(cargoHomeDir, "registry")
+	cache := llb.Scratch().File(llb.Mkdir("/cache/cargo", 0755, llb.WithParents(true)),
+		llb.WithCustomName("[internal] setting cargo cache mount permissions"))
+	for _, packages := range g.CargoPackages {
+		command := fmt.Sprintf("cargo install --locked %s", strings.Join(packages, " "))
+		logrus.WithField("command", command).Debug("Configure cargo install statements")
+		run := root.Run(llb.Shlex(command), g.mountSecrets(),
+			llb.WithCustomNamef("[internal] cargo install %s", strings.Join(packages, " ")))
+		run.AddMount(registryDir, cache,
+			llb.AsPersistentCacheDir(g.CacheID(registryDir), llb.CacheMountShared), llb.SourcePath("/cache/cargo"))
+		root = run.Root()
+	}
+	return root
+}
@@ -0,0 +1,12 @@
+set -o pipefail && \
+SHA256SUM=$(wget -q "${RUSTUP_URL}.sha256" -O - | cut -d " " -f 1)
+if [ -z "${SHA256SUM}" ]; then
+    echo "failed to get the checksum of ${RUSTUP_URL}"
+    exit 1
+fi
+
+wget "${RUSTUP_URL}" -O /tmp/rustup-init && \
+echo "${SHA256SUM}  /tmp/rustup-init" > /tmp/sha256sum && \
+sha256sum -c -s /tmp/sha256sum || { echo "CHECKSUM FAILED"; exit 1; }
+chmod +x /tmp/rustup-init
+echo "CHECKSUM PASSED"
@@ -0,0 +1,51 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+func TestRust(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := CargoPackage([]string{"ripgrep"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.RustConfig == nil || g.rustToolchain() != rustDefaultToolchain {
+		t.Errorf("the stable rust is not installed by the cargo packages: %+v", g.RustConfig)
+	}
+	if err := Rust("nightly-2024-01-01", []string{"rust-src"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	g.installRust(llb.Image("ubuntu:22.04"))
+	if g.RuntimeEnviron["CARGO_HOME"] != cargoHomeDir || g.RuntimeEnviron["RUSTUP_HOME"] != rustupHomeDir {
+		t.Errorf("unexpected runtime environ: %v", g.RuntimeEnviron)
+	}
+	if g.RuntimeEnvPaths[len(g.RuntimeEnvPaths)-1] != cargoBinDir {
+		t.Errorf("cargo is not in the PATH: %v", g.RuntimeEnvPaths)
+	}
+
+	for _, toolchain := range []string{"latest", "1", "stable;rm"} {
+		if err := Rust(toolchain, nil); err == nil {
+			t.Errorf("expected error for the toolchain %s", toolchain)
+		}
+	}
+	if err := Rust("", []string{"Clippy"}); err == nil {
+		t.Error("expected error for the invalid component")
+	}
+}
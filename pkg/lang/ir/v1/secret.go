@@ -0,0 +1,43 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"github.com/moby/buildkit/client/llb"
+)
+
+// secretRunOptions applies the run options together, thus it can be mixed
+// with the other options of a step.
+type secretRunOptions []llb.RunOption
+
+func (opts secretRunOptions) SetRunOption(ei *llb.ExecInfo) {
+	for _, o := range opts {
+		o.SetRunOption(ei)
+	}
+}
+
+// mountSecrets mounts the secrets declared by `io.mount_secret` in the step.
+// The secrets are tmpfs mounts, thus never persisted in the image layers.
+func (g generalGraph) mountSecrets() llb.RunOption {
+	var opts secretRunOptions
+	for _, s := range g.BuildSecrets {
+		if s.Target == "" {
+			continue
+		}
+		opts = append(opts, llb.AddSecret(s.Target, llb.SecretID(s.ID),
+			llb.SecretFileOpt(g.uid, g.gid, 0400)))
+	}
+	return opts
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+func TestMountSecret(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := JuliaRegistry("https://github.com/org/Registry.git", "GITHUB_TOKEN"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := MountSecret("netrc", "/root/.netrc", "", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := [][4]string{
+		{"netrc", "/root/.pypirc", "", ""},
+		{"pypirc", "/root/.netrc", "", ""},
+		{"pypirc", "root/.pypirc", "", ""},
+		{"pypirc", "/root/.pypirc", "PYPIRC", "pypirc"},
+		{"pypi rc", "/root/.pypirc", "", ""},
+	}
+	for _, c := range cases {
+		if err := MountSecret(c[0], c[1], c[2], c[3]); err == nil {
+			t.Errorf("expected error for the secret %v", c)
+		}
+	}
+
+	// only the secrets with a target are mounted
+	ei := &llb.ExecInfo{}
+	g.mountSecrets().SetRunOption(ei)
+	if len(ei.Secrets) != 1 || ei.Secrets[0].ID != "netrc" || ei.Secrets[0].Target != "/root/.netrc" {
+		t.Errorf("unexpected secret mounts: %+v", ei.Secrets)
+	}
+}
@@ -15,6 +15,11 @@
 package v1
 
 import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"github.com/cockroachdb/errors"
 	"github.com/moby/buildkit/client/llb"
 
@@ -53,6 +58,17 @@ diverged = "<>"
 renamed = "r"
 deleted = "x"
 `
+
+	tmuxConfig = `# generated by envd
+set -g default-shell %s
+set -g default-terminal "screen-256color"
+set -g history-limit 50000
+set -g mouse on
+set -g base-index 1
+setw -g pane-base-index 1
+set -s escape-time 0
+`
+	tmuxSessionScriptPath = "/usr/local/bin/envd-tmux"
 )
 
 func (g *generalGraph) compileShell(root llb.State) (_ llb.State, err error) {
@@ -67,9 +83,108 @@ func (g *generalGraph) compileShell(root llb.State) (_ llb.State, err error) {
 	if g.CondaConfig != nil {
 		root = g.compileCondaShell(root)
 	}
+	if g.EntryScript != nil {
+		root = g.compileEntryScript(root)
+	}
+	if len(g.ShellCompletions) > 0 {
+		root = g.compileShellCompletion(root)
+	}
+	if g.Tmux != nil {
+		root = g.compileTmux(root)
+	}
 	return root, nil
 }
 
+// compileTmux installs tmux and generates the config. The default session
+// has a shell window, and a Julia REPL window for the Julia environment.
+func (g generalGraph) compileTmux(root llb.State) llb.State {
+	shellPath := g.RuntimeEnviron["SHELL"]
+	opts := append([]llb.RunOption{
+		llb.Shlex(`bash -c "apt-get update && apt-get install -y --no-install-recommends tmux"`),
+		llb.User("root"),
+		llb.WithCustomName("[internal] install tmux")}, g.aptListsRunOptions()...)
+	tmux := root.Run(opts...).Root().
+		File(llb.Mkfile(fileutil.EnvdHomeDir(".tmux.conf"), 0644,
+			[]byte(fmt.Sprintf(tmuxConfig, shellPath)), llb.WithUIDGID(g.uid, g.gid)),
+			llb.WithCustomName("[internal] setting tmux config"))
+	if !g.Tmux.DefaultSession {
+		return tmux
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n")
+	sb.WriteString("# attach to the default session, or create it with the default layout\n")
+	sb.WriteString("tmux has-session -t envd 2>/dev/null || {\n")
+	sb.WriteString("\ttmux new-session -d -s envd -n shell\n")
+	if g.Language.Name == "julia" {
+		sb.WriteString("\ttmux new-window -d -t envd -n julia julia\n")
+	}
+	sb.WriteString("}\nexec tmux attach-session -t envd\n")
+	return tmux.File(llb.Mkfile(tmuxSessionScriptPath, 0755, []byte(sb.String())),
+		llb.WithCustomNamef("[internal] generating %s", tmuxSessionScriptPath))
+}
+
+// compileShellCompletion loads the completions of the enabled tools in the
+// rc file of the configured shell. The completion scripts are generated when
+// the shell starts, so that the tools installed later are still covered.
+func (g generalGraph) compileShellCompletion(root llb.State) llb.State {
+	rcPath := fileutil.EnvdHomeDir(".bashrc")
+	if g.Shell == shellZSH {
+		rcPath = fileutil.EnvdHomeDir(".zshrc")
+	}
+	for _, tool := range g.ShellCompletions {
+		cmd := shellCompletionCommands[tool][shellBASH]
+		if g.Shell == shellZSH {
+			cmd = shellCompletionCommands[tool][shellZSH]
+		}
+		root = root.Run(
+			llb.Shlexf(`bash -c 'echo "command -v %s >/dev/null && eval \"\$(%s)\"" >> %s'`, tool, cmd, rcPath),
+			llb.WithCustomNamef("[internal] setting %s completion in %s", tool, rcPath)).Root()
+	}
+	return root
+}
+
+// compileEntryScript generates a script that exports the collected runtime
+// environments and sources it from the shell rc files, so that non-login
+// shells get the same environment as the container entrypoint.
+func (g *generalGraph) compileEntryScript(root llb.State) llb.State {
+	keys := make([]string, 0, len(g.RuntimeEnviron))
+	for k := range g.RuntimeEnviron {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n# generated by envd\n")
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("export %s=%s\n", k, shellQuote(g.RuntimeEnviron[k])))
+	}
+	sb.WriteString(fmt.Sprintf("export PATH=%s\n", shellQuote(strings.Join(g.RuntimeEnvPaths, ":"))))
+	if g.CondaConfig != nil {
+		sb.WriteString(fmt.Sprintf(". %s/activate envd\n", condaBinDir))
+	}
+	for _, c := range g.EntryScriptCommands {
+		sb.WriteString(c + "\n")
+	}
+
+	script := *g.EntryScript
+	root = root.
+		File(llb.Mkdir(filepath.Dir(script), 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] create dir for entry script %s", script)).
+		File(llb.Mkfile(script, 0755, []byte(sb.String())),
+			llb.WithCustomNamef("[internal] generate entry script %s", script))
+
+	rcFiles := []string{fileutil.EnvdHomeDir(".bashrc")}
+	if g.Shell == shellZSH {
+		rcFiles = append(rcFiles, fileutil.EnvdHomeDir(".zshrc"))
+	}
+	for _, rc := range rcFiles {
+		root = root.Run(llb.Shlexf(`bash -c 'echo ". %s" >> %s'`, script, rc),
+			llb.WithCustomNamef("[internal] source entry script in %s", rc)).Root()
+	}
+	return root
+}
+
 func (g *generalGraph) compileCondaShell(root llb.State) llb.State {
 	findDir := fileutil.DefaultHomeDir
 	if g.Dev {
@@ -81,7 +196,12 @@ func (g *generalGraph) compileCondaShell(root llb.State) llb.State {
 	}
 	run := root.
 		Run(llb.Shlexf("bash -c \"%s\"", g.condaInitShell(g.Shell)),
-			llb.WithCustomNamef("[internal] init conda %s env", g.Shell)).
+			llb.WithCustomNamef("[internal] init conda %s env", g.Shell))
+	// the entry script activates the conda environment by itself
+	if g.EntryScript != nil {
+		return run.Root()
+	}
+	run = run.
 		Run(llb.Shlexf(`bash -c 'echo "source %s/activate envd" >> %s'`, condaBinDir, rcPath),
 			llb.WithCustomNamef("[internal] add conda environment to %s", rcPath))
 	return run.Root()
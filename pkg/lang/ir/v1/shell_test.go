@@ -0,0 +1,61 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+func TestRuntimeEntryScript(t *testing.T) {
+	g := resetDefaultGraph(t)
+	RuntimeEntryScript("", []string{"echo welcome"})
+	if g.EntryScript == nil || *g.EntryScript != defaultEntryScriptLocation {
+		t.Fatalf("unexpected entry script location: %v", g.EntryScript)
+	}
+	g.RuntimeEnviron["JULIA_DEPOT_PATH"] = "/opt/julia/user_packages"
+	g.RuntimeEnviron["GREETING"] = "it's me"
+	g.RuntimeEnvPaths = []string{"/usr/bin", "/opt/julia/bin"}
+
+	ops := marshalOps(t, g.compileEntryScript(llb.Image("ubuntu:22.04")))
+	var script string
+	sourced := false
+	for _, op := range ops {
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if mkfile := action.GetMkfile(); mkfile != nil && mkfile.Path == defaultEntryScriptLocation {
+					script = string(mkfile.Data)
+				}
+			}
+		}
+		if exec := op.GetExec(); exec != nil &&
+			strings.Contains(strings.Join(exec.Meta.Args, " "), ". "+defaultEntryScriptLocation) {
+			sourced = true
+		}
+	}
+	expected := "#!/bin/sh\n# generated by envd\n" +
+		"export GREETING='it'\\''s me'\n" +
+		"export JULIA_DEPOT_PATH='/opt/julia/user_packages'\n" +
+		"export PATH='/usr/bin:/opt/julia/bin'\n" +
+		"echo welcome\n"
+	if script != expected {
+		t.Errorf("unexpected entry script:\n%s", script)
+	}
+	if !sourced {
+		t.Error("the entry script is not sourced by the shell rc file")
+	}
+}
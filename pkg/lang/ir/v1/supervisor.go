@@ -26,6 +26,43 @@ import (
 	"github.com/tensorchord/envd/pkg/types"
 )
 
+const (
+	initProcessNone = "none"
+
+	restartAlways    = "always"
+	restartOnFailure = "on-failure"
+	restartNever     = "never"
+
+	preDestroyScriptPath = "/usr/local/bin/envd-pre-destroy"
+	// preDestroyScript waits for the stop signal from horust, and runs the
+	// hooks before it exits.
+	preDestroyScript = `#!/bin/bash
+hook() (
+  set -euo pipefail
+%s
+)
+trap 'hook; exit $?' %s
+sleep infinity &
+wait
+`
+)
+
+// reservedServiceName checks if the name is used by the built-in services.
+func reservedServiceName(name string) bool {
+	switch name {
+	case "sshd", "vscode_settings", "pre_destroy", "jupyter", "rstudio":
+		return true
+	}
+	return strings.HasPrefix(name, "init_") || strings.HasPrefix(name, "daemon_")
+}
+
+// initProcessCommands are the commands prepended to the entrypoint, the
+// binaries are installed by apt.
+var initProcessCommands = map[string][]string{
+	"tini":      {"/usr/bin/tini", "--"},
+	"dumb-init": {"/usr/bin/dumb-init", "--"},
+}
+
 const (
 	horustTemplate = `
 name = "%[1]s"
@@ -42,15 +79,47 @@ working-directory = "${%[3]s}"
 keep-env = true
 
 [restart]
-strategy = "on-failure"
+strategy = "%[8]s"
 backoff = "1s"
 attempts = 2
 
 [termination]
-wait = "5s"
+signal = "%[5]s"
+wait = "%[6]ds"
+%[7]s`
+
+	// The service is considered running by the dependents after the probe
+	// succeeds. Horust only checks the HTTP endpoint or the file, thus the
+	// probe does not depend on the tools in the image.
+	horustHealthinessTemplate = `
+[healthiness]
+%s = "%s"
+max-failed = 3
 `
 )
 
+// httpProbe checks that the HTTP GET of the path succeeds.
+func httpProbe(port int, path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d%s", port, path)
+}
+
+// horustHealthiness returns the healthiness section of the probe, which is
+// the HTTP endpoint or the path of the file created by the service.
+func horustHealthiness(probe string) string {
+	if probe == "" {
+		return ""
+	}
+	key := "file-path"
+	if isHTTPProbe(probe) {
+		key = "http-endpoint"
+	}
+	return fmt.Sprintf(horustHealthinessTemplate, key, probe)
+}
+
+func isHTTPProbe(probe string) bool {
+	return strings.HasPrefix(probe, "http://") || strings.HasPrefix(probe, "https://")
+}
+
 func (g generalGraph) installHorust(root llb.State) llb.State {
 	horust := root.
 		File(llb.Copy(llb.Image(types.HorustImage), "/", "/usr/local/bin"),
@@ -65,7 +134,7 @@ func (g generalGraph) installHorust(root llb.State) llb.State {
 	return horust.Root()
 }
 
-func (g generalGraph) addNewProcess(root llb.State, name, command string, depends []string) llb.State {
+func (g generalGraph) addNewProcess(root llb.State, name, command, probe, restart string, depends []string) llb.State {
 	var sb strings.Builder
 	if len(depends) != 0 {
 		sb.WriteString("start-after = [")
@@ -76,42 +145,121 @@ func (g generalGraph) addNewProcess(root llb.State, name, command string, depend
 		}
 		sb.WriteString("]\n")
 	}
-	template := fmt.Sprintf(horustTemplate, name, command, types.EnvdWorkDir, sb.String())
+	signal, wait := g.stopSignal()
+	template := fmt.Sprintf(horustTemplate, name, command, types.EnvdWorkDir, sb.String(), signal, wait,
+		horustHealthiness(probe), restart)
 
 	filename := filepath.Join(types.HorustServiceDir, fmt.Sprintf("%s.toml", name))
 	supervisor := root.File(llb.Mkfile(filename, 0644, []byte(template), llb.WithUIDGID(g.uid, g.gid)), llb.WithCustomNamef("[internal] create file %s", filename))
 	return supervisor
 }
 
+// daemonName returns the service name of the i-th daemon.
+func (g generalGraph) daemonName(i int) string {
+	if i < len(g.RuntimeDaemonNames) && g.RuntimeDaemonNames[i] != "" {
+		return g.RuntimeDaemonNames[i]
+	}
+	return fmt.Sprintf("daemon_%d", i)
+}
+
+// daemonRestart returns the restart strategy of the i-th daemon.
+func (g generalGraph) daemonRestart(i int) string {
+	if i < len(g.RuntimeDaemonRestart) && g.RuntimeDaemonRestart[i] != "" {
+		return g.RuntimeDaemonRestart[i]
+	}
+	return restartOnFailure
+}
+
+// stopSignal returns the signal without the `SIG` prefix and the grace period
+// to stop the processes.
+func (g generalGraph) stopSignal() (string, int) {
+	if g.StopConfig != nil {
+		return strings.TrimPrefix(g.StopConfig.Signal, "SIG"), g.StopConfig.GracePeriod
+	}
+	return strings.TrimPrefix(defaultStopSignal, "SIG"), defaultStopGracePeriod
+}
+
+// compilePreDestroy adds the process to run the `runtime.pre_destroy` hooks
+// when it's stopped by horust.
+func (g generalGraph) compilePreDestroy(root llb.State) llb.State {
+	if len(g.RuntimePreDestroy) == 0 {
+		return root
+	}
+	var commands []string
+	for _, c := range g.RuntimePreDestroy {
+		commands = append(commands, c...)
+	}
+	signal, _ := g.stopSignal()
+	script := fmt.Sprintf(preDestroyScript, strings.Join(commands, "\n"), signal)
+	hook := root.File(llb.Mkfile(preDestroyScriptPath, 0755, []byte(script)),
+		llb.WithCustomNamef("[internal] generating %s", preDestroyScriptPath))
+	return g.addNewProcess(hook, "pre_destroy", preDestroyScriptPath, "", restartNever, nil)
+}
+
 func (g generalGraph) compileEntrypoint(root llb.State) (llb.State, error) {
 	if len(g.Entrypoint) > 0 {
 		return root, errors.New("`config.entrypoint` is only for custom image, maybe you need `runtime.init`")
 	}
 	cmd := fmt.Sprintf("/var/envd/bin/envd-sshd --port %d --shell %s", config.SSHPortInContainer, g.Shell)
-	entrypoint := g.addNewProcess(root, "sshd", cmd, nil)
+	entrypoint := g.addNewProcess(root, "sshd", cmd, "", restartOnFailure, nil)
+	if g.hasVSCodeSettings() {
+		entrypoint = g.addNewProcess(entrypoint, "vscode_settings", vscodeSettingsScriptPath, "", restartOnFailure, nil)
+	}
 	var deps []string
 	if g.RuntimeInitScript != nil {
 		for i, command := range g.RuntimeInitScript {
-			entrypoint = g.addNewProcess(entrypoint, fmt.Sprintf("init_%d", i), fmt.Sprintf("/bin/bash -c 'set -euo pipefail\n%s'", strings.Join(command, "\n")), nil)
+			entrypoint = g.addNewProcess(entrypoint, fmt.Sprintf("init_%d", i), fmt.Sprintf("/bin/bash -c 'set -euo pipefail\n%s'", strings.Join(command, "\n")), "", restartOnFailure, nil)
 			deps = append(deps, fmt.Sprintf("init_%d", i))
 		}
 	}
 
 	if g.RuntimeDaemon != nil {
 		for i, command := range g.RuntimeDaemon {
-			entrypoint = g.addNewProcess(entrypoint, fmt.Sprintf("daemon_%d", i), strings.Join(command, " "), deps)
+			entrypoint = g.addNewProcess(entrypoint, g.daemonName(i), strings.Join(command, " "),
+				g.RuntimeDaemonProbes[i], g.daemonRestart(i), deps)
 		}
 	}
 
+	entrypoint = g.compilePreDestroy(entrypoint)
+
 	if g.JupyterConfig != nil {
 		jupyterCmd := g.generateJupyterCommand("")
-		entrypoint = g.addNewProcess(entrypoint, "jupyter", strings.Join(jupyterCmd, " "), deps)
+		entrypoint = g.addNewProcess(entrypoint, "jupyter", strings.Join(jupyterCmd, " "),
+			httpProbe(config.JupyterPortInContainer, "/api"), restartOnFailure, deps)
 	}
 
 	if g.RStudioServerConfig != nil {
 		rstudioCmd := g.generateRStudioCommand("")
-		entrypoint = g.addNewProcess(entrypoint, "rstudio", strings.Join(rstudioCmd, " "), deps)
+		entrypoint = g.addNewProcess(entrypoint, "rstudio", strings.Join(rstudioCmd, " "),
+			httpProbe(config.RStudioServerPortInContainer, "/"), restartOnFailure, deps)
 	}
 
 	return entrypoint, nil
 }
+
+// initProcess returns the init process run as PID 1. By default it's only
+// enabled if there are daemons, whose children may be orphaned.
+func (g generalGraph) initProcess() string {
+	if g.InitProcess != nil {
+		if *g.InitProcess == initProcessNone {
+			return ""
+		}
+		return *g.InitProcess
+	}
+	if len(g.RuntimeDaemon) > 0 {
+		return "tini"
+	}
+	return ""
+}
+
+func (g generalGraph) compileInitProcess(root llb.State) llb.State {
+	name := g.initProcess()
+	if name == "" {
+		return root
+	}
+	opts := append([]llb.RunOption{
+		llb.Shlexf(`bash -c "apt-get update && apt-get install -y --no-install-recommends %s"`, name),
+		llb.User("root"),
+		llb.WithCustomNamef("[internal] install init process %s", name)}, g.aptListsRunOptions()...)
+	return root.Run(opts...).Root()
+}
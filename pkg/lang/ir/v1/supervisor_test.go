@@ -0,0 +1,126 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestRuntimeDaemon(t *testing.T) {
+	g := resetDefaultGraph(t)
+	if err := RuntimeDaemon([][]string{{"serve"}, {"worker"}}, nil, []string{"serving", ""}, "always"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RuntimeDaemon([][]string{{"monitor"}}, nil, nil, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		names   []string
+		restart string
+	}{
+		{[]string{"serving"}, ""},
+		{[]string{"jupyter"}, ""},
+		{[]string{"daemon_3"}, ""},
+		{[]string{"my service"}, ""},
+		{[]string{"a", "b"}, ""},
+		{nil, "unless-stopped"},
+	}
+	for _, c := range cases {
+		if err := RuntimeDaemon([][]string{{"sleep"}}, nil, c.names, c.restart); err == nil {
+			t.Errorf("expected error for the names %v and restart %s", c.names, c.restart)
+		}
+	}
+	if err := RuntimeDaemon([][]string{{"sleep"}, {"sleep"}}, nil, []string{"dup", "dup"}, ""); err == nil {
+		t.Error("expected error for the duplicate names in the same call")
+	}
+	if err := RuntimeDaemon([][]string{{"sleep"}}, []string{"curl -f http://127.0.0.1:8080"}, nil, ""); err == nil {
+		t.Error("expected error for the probe command")
+	}
+
+	expected := [][2]string{
+		{"serving", restartAlways},
+		{"daemon_1", restartAlways},
+		{"daemon_2", restartOnFailure},
+	}
+	if len(g.RuntimeDaemon) != len(expected) {
+		t.Fatalf("expected %d daemons, got %d", len(expected), len(g.RuntimeDaemon))
+	}
+	for i, e := range expected {
+		if name, restart := g.daemonName(i), g.daemonRestart(i); name != e[0] || restart != e[1] {
+			t.Errorf("daemon %d: expected %v, got [%s %s]", i, e, name, restart)
+		}
+	}
+}
+
+func TestHorustHealthiness(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"http://127.0.0.1:8888/api": "http-endpoint = \"http://127.0.0.1:8888/api\"",
+		"/tmp/serving.ready":        "file-path = \"/tmp/serving.ready\"",
+	}
+	for probe, expected := range cases {
+		actual := horustHealthiness(probe)
+		if expected == "" {
+			if actual != "" {
+				t.Errorf("expected no healthiness for the empty probe, got %s", actual)
+			}
+			continue
+		}
+		if !strings.Contains(actual, "[healthiness]\n"+expected+"\n") {
+			t.Errorf("expected %s in the healthiness of %s, got %s", expected, probe, actual)
+		}
+	}
+}
+
+func TestCompilePreDestroy(t *testing.T) {
+	root := llb.Image("ubuntu:22.04")
+	g := generalGraph{}
+	if g.compilePreDestroy(root).Output() != root.Output() {
+		t.Error("expected no change without the pre-destroy hooks")
+	}
+
+	g.RuntimePreDestroy = [][]string{{"echo saving", "sync"}, {"echo bye"}}
+	g.StopConfig = &ir.StopConfig{Signal: "SIGINT", GracePeriod: 30}
+	ops := marshalOps(t, g.compilePreDestroy(root))
+	files := map[string]string{}
+	for _, op := range ops {
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if mkfile := action.GetMkfile(); mkfile != nil {
+					files[mkfile.Path] = string(mkfile.Data)
+				}
+			}
+		}
+	}
+
+	script := files[preDestroyScriptPath]
+	if !strings.Contains(script, "echo saving\nsync\necho bye\n") {
+		t.Errorf("the hooks are not in the script:\n%s", script)
+	}
+	if !strings.Contains(script, "trap 'hook; exit $?' INT") {
+		t.Errorf("the hooks are not trapped on the stop signal:\n%s", script)
+	}
+	service := files["/etc/horust/services/pre_destroy.toml"]
+	if !strings.Contains(service, "command = \"\"\"\n"+preDestroyScriptPath+"\n") ||
+		!strings.Contains(service, `strategy = "never"`) || !strings.Contains(service, `wait = "30s"`) {
+		t.Errorf("unexpected pre-destroy service:\n%s", service)
+	}
+}
@@ -20,11 +20,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/cockroachdb/errors"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/client/llb/imagemetaresolver"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 
 	"github.com/tensorchord/envd/pkg/config"
@@ -65,7 +67,7 @@ func (g generalGraph) copyAPTSignature(root llb.State, name string, url string)
 	// The value of path should be /etc/apt/keyrings/*.asc
 	var path = filepath.Join(signFolder, fileName)
 
-	base := llb.Image(builderImage)
+	base := g.compileBuilderImage()
 	builder := base.
 		Run(llb.Shlexf("sh -c \"curl %s >> %s\"", url, fileName),
 			llb.WithCustomName("[internal] downloading apt-source signature in base image")).Root()
@@ -156,7 +158,12 @@ func (g generalGraph) compileRun(root llb.State) llb.State {
 		// TODO(gaocegege): Maybe we should make it readonly,
 		// but these cases then cannot be supported:
 		// run(commands=["git clone xx.git"])
-		run := root.Dir(workingDir).Run(llb.Shlex(cmdStr))
+		opts := append([]llb.RunOption{llb.Shlex(cmdStr), g.mountSecrets()}, g.aptListsRunOptions()...)
+		if execGroup.User != "" {
+			root = g.checkUserExists(root, execGroup.User)
+			opts = append(opts, llb.User(execGroup.User))
+		}
+		run := root.Dir(workingDir).Run(opts...)
 		if execGroup.MountHost {
 			run.AddMount(workingDir, llb.Local(flag.FlagBuildContext))
 		}
@@ -165,6 +172,18 @@ func (g generalGraph) compileRun(root llb.State) llb.State {
 	return root
 }
 
+// checkUserExists fails the build with a clear message if the named user is
+// not created at this point of the build. The uids are always valid.
+func (g generalGraph) checkUserExists(root llb.State, user string) llb.State {
+	if user == "root" || (user == "envd" && g.Dev) || g.hasUser(user) || (user[0] >= '0' && user[0] <= '9') {
+		return root
+	}
+	return root.Run(llb.Shlexf(`sh -c "getent passwd %[1]s > /dev/null || `+
+		`{ echo 'envd: user %[1]s does not exist at this point of the build' >&2; exit 1; }"`, user),
+		llb.User("root"),
+		llb.WithCustomNamef("[internal] checking user %s", user)).Root()
+}
+
 func (g generalGraph) compileCopy(root llb.State) llb.State {
 	if len(g.Copy) == 0 {
 		return root
@@ -180,24 +199,43 @@ func (g generalGraph) compileCopy(root llb.State) llb.State {
 	return result
 }
 
+// aptListsRunOptions returns the extra run options for the steps that call
+// apt-get. When the lists cleanup is enabled, /var/lib/apt/lists is a shared
+// cache mount: the lists fetched by one step are reused by the next one, and
+// none of them ends up in the image.
+func (g generalGraph) aptListsRunOptions() []llb.RunOption {
+	if !g.isAptListsCleanupEnabled() {
+		return nil
+	}
+	listsDir := "/var/lib/apt/lists"
+	return []llb.RunOption{
+		llb.AddMount(listsDir, llb.Scratch(),
+			llb.AsPersistentCacheDir(g.CacheID(listsDir), llb.CacheMountShared)),
+	}
+}
+
+// systemPackagesCommand composes the command to install the packages by the
+// package manager of the base image, which is apt-get on Debian and Ubuntu, or
+// apk on Alpine. It fails with the hint if there is neither of them.
+func systemPackagesCommand(packages []string) string {
+	names := strings.Join(packages, " ")
+	return fmt.Sprintf(`sh -c "if command -v apt-get > /dev/null; then `+
+		`apt-get update && apt-get install -y --no-install-recommends %[1]s; `+
+		`elif command -v apk > /dev/null; then apk add --no-cache %[1]s; `+
+		`else echo 'envd: the base image has neither apt-get nor apk, install the system packages %[1]s in the base image instead' >&2; exit 1; fi"`,
+		names)
+}
+
 func (g generalGraph) compileSystemPackages(root llb.State) llb.State {
 	if len(g.SystemPackages) == 0 {
 		logrus.Debug("skip the apt since system package is not specified")
 		return root
 	}
 
-	// Compose the package install command.
-	var sb strings.Builder
-	sb.WriteString("apt-get update && apt-get install -y --no-install-recommends")
-
-	for _, pkg := range g.SystemPackages {
-		sb.WriteString(fmt.Sprintf(" %s", pkg))
-	}
-
 	cacheDir := "/var/cache/apt"
 	cacheLibDir := "/var/lib/apt"
 
-	run := root.Run(llb.Shlexf(`bash -c "%s"`, sb.String()),
+	run := root.Run(llb.Shlex(systemPackagesCommand(g.SystemPackages)),
 		llb.WithCustomNamef("apt-get install %s",
 			strings.Join(g.SystemPackages, " ")))
 	run.AddMount(cacheDir, llb.Scratch(),
@@ -238,18 +276,20 @@ func (g *generalGraph) compileLanguage(root llb.State) (llb.State, error) {
 		rSrc := g.compileRLang(root)
 		lang = g.installRLang(rSrc)
 	case "julia":
-		lang = g.installJulia(root)
+		lang, err = g.installJulia(root)
 	}
 
 	return lang, err
 }
 
 func (g *generalGraph) compileLanguagePackages(root llb.State) llb.State {
+	root = g.compileRustEnviron(g.compileTrustedCerts(g.updateCACerts(root)))
 	pack := root
 	switch g.Language.Name {
 	case "python":
 		index := g.compilePyPIIndex(root)
-		pypi := g.compilePyPIPackages(index)
+		toolchain := g.compilePipToolchain(index)
+		pypi := g.compilePyPIPackages(toolchain)
 		if g.CondaConfig == nil {
 			pack = pypi
 		} else {
@@ -266,7 +306,24 @@ func (g *generalGraph) compileLanguagePackages(root llb.State) llb.State {
 	case "julia":
 		pack = g.installJuliaPackages(root)
 	}
-	return pack
+
+	// The npm and cargo packages don't depend on the language packages, thus
+	// they are installed in parallel and merged.
+	layers := []llb.State{pack}
+	if len(g.NPMPackages) > 0 {
+		layers = append(layers, llb.Diff(root, g.installNPMPackages(root),
+			llb.WithCustomName("[internal] npm packages")))
+	}
+	if len(g.CargoPackages) > 0 {
+		layers = append(layers, llb.Diff(root, g.installCargoPackages(root),
+			llb.WithCustomName("[internal] cargo packages")))
+	}
+	if len(layers) == 1 {
+		return pack
+	}
+	merge := llb.Merge(layers, llb.WithCustomName("[internal] language, npm and cargo packages"))
+	// keep the env of the language packages, the merged state has none
+	return pack.WithOutput(merge.Output())
 }
 
 func (g *generalGraph) compileDevPackages(root llb.State) llb.State {
@@ -276,15 +333,20 @@ func (g *generalGraph) compileDevPackages(root llb.State) llb.State {
 
 	// apt packages
 	var sb strings.Builder
+	// the built-in packages are only known to apt, fail early on the others
+	sb.WriteString("command -v apt-get > /dev/null || { echo 'envd: the built-in packages of the dev environment " +
+		"require apt-get, use a Debian or Ubuntu based image instead' >&2; exit 1; } && ")
 	sb.WriteString("apt-get update && apt-get install -y apt-utils && ")
 	sb.WriteString("apt-get install -y --no-install-recommends --no-install-suggests --fix-missing ")
 	sb.WriteString(strings.Join(types.BaseAptPackage, " "))
-	sb.WriteString("&& rm -rf /var/lib/apt/lists/* ")
+	sb.WriteString(" && rm -rf /var/lib/apt/lists/*")
 	// shell prompt
-	sb.WriteString("&& locale-gen en_US.UTF-8")
+	sb.WriteString(" && locale-gen en_US.UTF-8")
 
-	run := root.Run(llb.Shlexf(`bash -c "%s"`, sb.String()),
-		llb.WithCustomName("[internal] install built-in packages"))
+	opts := append([]llb.RunOption{llb.Shlexf(`bash -c "%s"`, sb.String()),
+		llb.WithCustomName("[internal] install built-in packages")},
+		g.aptListsRunOptions()...)
+	run := root.Run(opts...)
 
 	return run.Root()
 }
@@ -307,9 +369,14 @@ func (g generalGraph) compileSSHD(root llb.State) llb.State {
 
 func (g *generalGraph) compileBaseImage() (llb.State, error) {
 	// TODO: find another way to install CUDA
-	if g.CUDA != nil {
+	if g.CUDA != nil && !isCUDAImage(g.Image) {
 		g.Image = GetCUDAImage(g.Image, g.CUDA, g.CUDNN, g.Dev)
 	}
+	image := g.Image
+	if g.ImageDigest != "" {
+		image = g.Image + "@" + g.ImageDigest
+		g.baseImageDigest = digest.Digest(g.ImageDigest)
+	}
 
 	logger := logrus.WithFields(logrus.Fields{
 		"image":    g.Image,
@@ -322,7 +389,7 @@ func (g *generalGraph) compileBaseImage() (llb.State, error) {
 
 	// Fix https://github.com/tensorchord/envd/issues/1147.
 	// Fetch the image metadata from base image.
-	base := llb.Image(g.Image, llb.WithMetaResolver(imagemetaresolver.Default()))
+	base := llb.Image(image, llb.WithMetaResolver(imagemetaresolver.Default()))
 	envs, err := base.Env(context.Background())
 	if err != nil {
 		return llb.State{}, errors.Wrap(err, "failed to get the image metadata")
@@ -334,6 +401,15 @@ func (g *generalGraph) compileBaseImage() (llb.State, error) {
 		kv := strings.SplitN(e, "=", 2)
 		g.RuntimeEnviron[kv[0]] = kv[1]
 	}
+	if g.AttestationConfig != nil && g.baseImageDigest == "" {
+		dgst, _, err := imagemetaresolver.Default().ResolveImageConfig(
+			context.Background(), g.Image, llb.ResolveImageConfigOpt{})
+		if err != nil {
+			return llb.State{}, errors.Wrap(err, "failed to resolve the base image digest")
+		}
+		g.baseImageDigest = dgst
+	}
+
 	// TODO: inherit the USER from base
 	g.User = ""
 	return base, nil
@@ -371,10 +447,97 @@ func (g generalGraph) compileMountDir(root llb.State) llb.State {
 			llb.WithCustomNamef("[internal] create dir for runtime.mount %s", m.Destination),
 		)
 	}
+	for _, s := range g.RuntimeSecrets {
+		dir := filepath.Dir(s.Path)
+		mount = mount.File(llb.Mkdir(dir, 0755, llb.WithParents(true)),
+			llb.WithCustomNamef("[internal] create dir for runtime.secret %s", dir))
+	}
 	return mount
 }
 
+// compileDependsOn generates the script to wait for the dependencies,
+// which wraps the entrypoint.
+func (g generalGraph) compileDependsOn(root llb.State) llb.State {
+	if len(g.RuntimeDependsOn) == 0 {
+		return root
+	}
+
+	var sb strings.Builder
+	for _, d := range g.RuntimeDependsOn {
+		sb.WriteString(fmt.Sprintf("wait_for %s %s %d %d\n", shellQuote(d.Name), d.Host, d.Port, d.Timeout))
+	}
+	script := "#!/bin/bash\n" + fmt.Sprintf(waitDepsTemplate, sb.String())
+	return root.File(llb.Mkfile(waitDepsScriptPath, 0755, []byte(script)),
+		llb.WithCustomNamef("[internal] generating %s", waitDepsScriptPath))
+}
+
+// compileArtifacts copies the content of the artifacts to the destinations.
+// The artifacts must be packaged as images, since they are pulled by the
+// image source of buildkit. Every artifact is a separate layer merged into the
+// image, thus the layer is reused for the same digest. The files are owned by
+// root, so that they are read-only to the envd user.
+func (g generalGraph) compileArtifacts(root llb.State) llb.State {
+	if len(g.Artifacts) == 0 {
+		return root
+	}
+
+	states := []llb.State{root}
+	for _, a := range g.Artifacts {
+		artifact := llb.Scratch().File(llb.Copy(
+			llb.Image(a.Reference), "/", a.Destination,
+			&llb.CopyInfo{CopyDirContentsOnly: true, CreateDestPath: true}),
+			llb.WithCustomNamef("[internal] copying artifact %s to %s", a.Reference, a.Destination))
+		states = append(states, artifact)
+	}
+	return llb.Merge(states, llb.WithCustomName("[internal] merge artifacts"))
+}
+
 func (g *generalGraph) updateEnvPath(root llb.State, path string) llb.State {
 	g.RuntimeEnvPaths = append(g.RuntimeEnvPaths, path)
 	return root.AddEnv("PATH", strings.Join(g.RuntimeEnvPaths, ":"))
 }
+
+// compileSlimRuntime composes the runtime stage from the base image, the
+// system packages and the dirs crossing the stage boundary from the builder.
+// The Julia binary and depots are kept, while the build-time deps are not.
+func (g generalGraph) compileSlimRuntime(base, systemPackages, builder llb.State) (llb.State, error) {
+	if g.Dev {
+		return llb.State{}, errors.New("slim runtime is not supported for the dev environment")
+	}
+	if g.Language.Name != "julia" {
+		return llb.State{}, errors.Newf("slim runtime is not supported for %s", g.Language.Name)
+	}
+
+	paths := []string{juliaRootDir}
+	if len(g.TrustedCerts) > 0 {
+		paths = append(paths, trustedCertDir)
+	}
+	if g.isJuliaDepotLocked() {
+		paths = append(paths, juliaUnlockScriptPath)
+	}
+	if g.hasJuliaWrapper() {
+		paths = append(paths, juliaWrapperPath)
+	}
+	runtime := llb.Scratch()
+	for _, p := range paths {
+		runtime = runtime.File(llb.Copy(builder, p, p,
+			&llb.CopyInfo{CopyDirContentsOnly: true, CreateDestPath: true}),
+			llb.WithCustomNamef("[internal] copy %s to the runtime stage", p))
+	}
+	slim := llb.Merge([]llb.State{
+		base,
+		llb.Diff(base, systemPackages, llb.WithCustomName("[internal] system packages for runtime")),
+		runtime,
+	}, llb.WithCustomName("[internal] slim runtime"))
+
+	// the environments of the builder stage are lost in the merge
+	keys := make([]string, 0, len(g.RuntimeEnviron))
+	for k := range g.RuntimeEnviron {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		slim = slim.AddEnv(k, g.RuntimeEnviron[k])
+	}
+	return slim.AddEnv("PATH", strings.Join(g.RuntimeEnvPaths, ":")), nil
+}
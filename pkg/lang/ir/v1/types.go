@@ -15,6 +15,9 @@
 package v1
 
 import (
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+
 	"github.com/tensorchord/envd/pkg/editor/vscode"
 	"github.com/tensorchord/envd/pkg/lang/ir"
 	"github.com/tensorchord/envd/pkg/progress/compileui"
@@ -31,45 +34,181 @@ type generalGraph struct {
 	ir.Language
 	EnvdSyntaxVersion string
 	Image             string
+	ImageDigest       string
 	User              string
 
-	Shell   string
-	Dev     bool
-	CUDA    *string
-	CUDNN   string
-	NumGPUs int
+	Shell            string
+	ShellCompletions []string
+	Tmux             *ir.TmuxConfig
+	Dev              bool
+	CUDA             *string
+	CUDNN            string
+	NumGPUs          int
+
+	// CUDAComputeCapabilities are the targets of all the ahead-of-time CUDA
+	// builds in the image, CUDA.jl compiles the kernels at runtime instead
+	CUDAComputeCapabilities []string
 
 	UbuntuAPTSource    *string
 	CRANMirrorURL      *string
 	JuliaPackageServer *string
+	JuliaRegistries    []ir.JuliaRegistry
+	JuliaDebugBuild    *ir.JuliaDebugBuild
+
+	// JuliaVersion is the Julia release to install, the default one if empty
+	JuliaVersion string
+	// JuliaBuildLogMaxSize caps the package build logs, the default one if nil
+	JuliaBuildLogMaxSize *int64
+	// JuliaOffline fetches everything of Julia from the mirrors in the build
+	JuliaOffline bool
+	// JuliaReleaseMirror replaces the host of the Julia releases, if not empty
+	JuliaReleaseMirror string
+	// JuliaNumThreads is `JULIA_NUM_THREADS` at runtime, the Julia default if empty
+	JuliaNumThreads string
+	// JuliaCPUTarget is `JULIA_CPU_TARGET` of the build and runtime, the
+	// Julia default if empty
+	JuliaCPUTarget string
+
+	JuliaRuntimeConfig *ir.JuliaRuntimeConfig
+	JuliaFailureHook   *string
+	JuliaREPLConfig    *ir.JuliaREPLConfig
 	PyPIIndexURL       *string
 	PyPIExtraIndexURL  *string
 	PyPITrust          bool
+	PipToolchain       *ir.PipToolchain
+	TrustedCerts       []ir.TrustedCert
+	// CACerts are the PEM encoded CAs installed into the system trust store
+	CACerts []string
+	// ProxyConfig is the proxy of the build steps, none if nil
+	ProxyConfig *ir.ProxyConfig
+	// BuildLimits is the resource limits of the build steps, none if nil
+	BuildLimits *ir.BuildLimitsConfig
 
 	PublicKeyPath string
 
+	// JuliaResolveStrategy is the preserve level of `Pkg.add`, e.g. `all`
+	JuliaResolveStrategy string
+	// JuliaPrecompileSeed is the RNG seed of the precompilation, unset if nil
+	JuliaPrecompileSeed *uint64
+	// JuliaPreferences is the TOML of the preferences in the default environment
+	JuliaPreferences string
+	// JuliaArtifactOverrides is the TOML of the artifact overrides in the depot,
+	// which point to the artifacts baked from JuliaArtifactsDir
+	JuliaArtifactOverrides string
+	JuliaArtifactsDir      string
+	// JuliaDebuggers are added with the Julia packages in the dev environment
+	JuliaDebuggers []string
+	// JuliaCUDA adds CUDA.jl and the GPU runtime environment, none if nil
+	JuliaCUDA *ir.JuliaCUDAConfig
+	// JuliaPrecompilePackages are precompiled after the Pkg operations even if
+	// the auto precompilation is disabled
+	JuliaPrecompilePackages []string
+	// JuliaSysimage is the custom sysimage used by default, none if nil
+	JuliaSysimage *ir.JuliaSysimageConfig
+	// JuliaParallelInstantiate instantiates the Julia projects concurrently
+	JuliaParallelInstantiate bool
+
+	// NodeVersion is the Node.js release to install, none if nil and the
+	// default one if empty
+	NodeVersion *string
+	// NPMPackages are installed globally by npm
+	NPMPackages [][]string
+	// RustConfig is the Rust toolchain, none if nil
+	RustConfig *ir.RustConfig
+	// CargoPackages are the tools installed by `cargo install`
+	CargoPackages [][]string
+
+	// LanguageCacheDir is the common parent of the language package caches
+	LanguageCacheDir *string
+
 	PyPIPackages     [][]string
 	RequirementsFile *string
-	PythonWheels     []string
-	RPackages        [][]string
-	JuliaPackages    [][]string
-	SystemPackages   []string
+	// RequirementsFiles are the files in the build context required by the
+	// requirements file, the whole build context is used if empty
+	RequirementsFiles []string
+	// CondaEnvFiles are the same as RequirementsFiles for the conda env file
+	CondaEnvFiles      []string
+	PythonWheels       []string
+	RPackages          [][]string
+	JuliaPackages      [][]string
+	JuliaCachePackages [][]string
+	JuliaDevPackages   []string
+	JuliaProjects      []string
+	// JuliaActiveProject is the project in JuliaProjects activated at runtime
+	JuliaActiveProject string
+	SystemPackages     []string
+
+	// JuliaPlatformPackages override JuliaPackages for the platforms
+	JuliaPlatformPackages []ir.PlatformPackages
+	// JuliaPackageUUIDs disambiguate the Julia packages with the same name
+	JuliaPackageUUIDs map[string]string
 
 	VSCodePlugins   []vscode.Plugin
 	UserDirectories []string
 
+	// VSCodeSettings are the team defaults merged into the machine settings
+	VSCodeSettings map[string]interface{}
+
+	// Users and Groups are the additional accounts besides envd
+	Users  []ir.UserAccount
+	Groups []ir.GroupAccount
+
 	Exec       []ir.RunBuildCommand
 	Copy       []ir.CopyInfo
 	Mount      []ir.MountInfo
+	Artifacts  []ir.ArtifactInfo
 	HTTP       []ir.HTTPInfo
 	Entrypoint []string
 
-	Repo types.RepoInfo
+	EntryScript         *string
+	EntryScriptCommands []string
+	// RuntimeDaemonProbes are the readiness probes of RuntimeDaemon, by index
+	RuntimeDaemonProbes []string
+	// RuntimeDaemonNames are the service names of RuntimeDaemon, by index,
+	// `daemon_<index>` is used if empty
+	RuntimeDaemonNames []string
+	// RuntimeDaemonRestart are the restart strategies of RuntimeDaemon, by index
+	RuntimeDaemonRestart []string
+
+	// InitProcess is the init run as PID 1, it's decided by the daemons if nil
+	InitProcess *string
+
+	// Features are the switches consulted by the installers, see knownFeatures
+	Features map[string]bool
+
+	Repo     types.RepoInfo
+	Metadata ir.ImageMetadata
+
+	// SlimRuntime only keeps the runtime needed bits from the builder stage
+	SlimRuntime bool
+
+	// WorkerConstraints are the BuildKit worker filters for the whole build
+	WorkerConstraints []string
+	// GPUWorkerConstraints are the BuildKit worker filters for the CUDA related stages
+	GPUWorkerConstraints []string
+	// Platform is the normalized platform the image is built for, the default one if empty
+	Platform string
+
+	BuildSecrets []ir.BuildSecret
+
+	AttestationConfig *ir.AttestationConfig
+	CompressionConfig *ir.CompressionConfig
+	OutputConfig      *ir.OutputConfig
+	// baseImageDigest is resolved only if the attestation is enabled
+	baseImageDigest digest.Digest
+	// hooks are the custom LLB operations registered by the Go API users
+	hooks map[HookPoint][]LLBHook
+	// checks are the uncached steps run only for the failures, e.g. the
+	// reachability of the servers, they are not in the image
+	checks []llb.State
+	// lock exports the versions of the installed packages, nil if none
+	lock *llb.State
 
 	*ir.JupyterConfig
 	*ir.GitConfig
 	*ir.CondaConfig
 	*ir.RStudioServerConfig
+	*ir.StopConfig
 
 	Writer compileui.Writer `json:"-"`
 	// EnvironmentName is the base name of the environment.
@@ -15,15 +15,189 @@
 package v1
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/cockroachdb/errors"
 	"github.com/moby/buildkit/client/llb"
 
+	"github.com/tensorchord/envd/pkg/lang/ir"
 	"github.com/tensorchord/envd/pkg/types"
+	"github.com/tensorchord/envd/pkg/util/fileutil"
 )
 
+// reservedAccountNames are the accounts managed by envd
+var reservedAccountNames = map[string]bool{
+	"root": true,
+	"envd": true,
+}
+
+// primaryGroup returns the primary group of the additional user.
+func primaryGroup(user ir.UserAccount) string {
+	if user.Group == "" {
+		return user.Name
+	}
+	return user.Group
+}
+
+// accountGroups returns the declared groups and the private groups of the
+// users without a primary group.
+func accountGroups(users []ir.UserAccount, groups []ir.GroupAccount) []ir.GroupAccount {
+	res := append([]ir.GroupAccount{}, groups...)
+	for _, user := range users {
+		if user.Group == "" {
+			res = append(res, ir.GroupAccount{Name: user.Name, GID: user.UID})
+		}
+	}
+	return res
+}
+
+// validateAccounts checks the names, uids and gids of the additional accounts
+// do not collide with each other.
+func validateAccounts(users []ir.UserAccount, groups []ir.GroupAccount) error {
+	userNames := make(map[string]bool)
+	uids := make(map[int]string)
+	for _, user := range users {
+		if userNames[user.Name] {
+			return errors.Newf("user %s is declared more than once", user.Name)
+		}
+		userNames[user.Name] = true
+		if name, ok := uids[user.UID]; ok {
+			return errors.Newf("uid %d of user %s collides with user %s", user.UID, user.Name, name)
+		}
+		uids[user.UID] = user.Name
+	}
+	groupNames := make(map[string]bool)
+	gids := make(map[int]string)
+	for _, group := range accountGroups(users, groups) {
+		if groupNames[group.Name] {
+			return errors.Newf("group %s is declared more than once", group.Name)
+		}
+		groupNames[group.Name] = true
+		if name, ok := gids[group.GID]; ok {
+			return errors.Newf("gid %d of group %s collides with group %s", group.GID, group.Name, name)
+		}
+		gids[group.GID] = group.Name
+	}
+	return nil
+}
+
+// hasUser checks if the user is one of the additional users.
+func (g generalGraph) hasUser(name string) bool {
+	for _, user := range g.Users {
+		if user.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compileAccounts creates the additional groups and users, it runs before the
+// packages are installed thus they can be used by `run(user=)`.
+func (g generalGraph) compileAccounts(root llb.State) (llb.State, error) {
+	if len(g.Users) == 0 && len(g.Groups) == 0 {
+		return root, nil
+	}
+	declared := make(map[string]bool)
+	for _, group := range g.Groups {
+		declared[group.Name] = true
+	}
+	groups := accountGroups(g.Users, g.Groups)
+	for _, user := range g.Users {
+		if user.Group != "" && !declared[user.Group] {
+			return llb.State{}, errors.Newf("primary group %s of user %s is not declared", user.Group, user.Name)
+		}
+		// envd takes the host uid and gid in the dev env, except the root context
+		if g.Dev && g.uid != 0 && user.UID == g.uid {
+			return llb.State{}, errors.Newf("uid %d of user %s collides with user envd", user.UID, user.Name)
+		}
+	}
+	for _, group := range groups {
+		if g.Dev && g.uid != 0 && group.GID == g.gid {
+			return llb.State{}, errors.Newf("gid %d of group %s collides with group envd", group.GID, group.Name)
+		}
+	}
+
+	for _, group := range groups {
+		root = root.Run(llb.Shlexf("groupadd -g %d %s", group.GID, group.Name),
+			llb.User("root"),
+			llb.WithCustomNamef("[internal] create group %s(g:%d)", group.Name, group.GID)).Root()
+	}
+	for _, user := range g.Users {
+		cmd := []string{"useradd", "-m", "-u", fmt.Sprint(user.UID), "-g", primaryGroup(user), "-s", "/bin/sh"}
+		if len(user.Groups) > 0 {
+			cmd = append(cmd, "-G", strings.Join(user.Groups, ","))
+		}
+		cmd = append(cmd, user.Name)
+		root = root.Run(llb.Shlex(strings.Join(cmd, " ")),
+			llb.User("root"),
+			llb.WithCustomNamef("[internal] create user %s(u:%d)", user.Name, user.UID)).Root()
+		if len(user.Directories) > 0 {
+			root = root.Run(llb.Shlexf("install -d -o %s -g %s %s",
+				user.Name, primaryGroup(user), strings.Join(user.Directories, " ")),
+				llb.User("root"),
+				llb.WithCustomNamef("[internal] create directories of user %s", user.Name)).Root()
+		}
+	}
+	return root, nil
+}
+
+// compileAccountOwn chowns the directories of the additional users, since the
+// files may be added to them after the users are created.
+func (g generalGraph) compileAccountOwn(root llb.State) llb.State {
+	for _, user := range g.Users {
+		for _, dir := range user.Directories {
+			root = root.Run(llb.Shlexf("chown -R %s:%s %s", user.Name, primaryGroup(user), dir),
+				llb.User("root"),
+				llb.WithCustomNamef("[internal] configure user permissions of %s for %s", user.Name, dir)).Root()
+		}
+	}
+	return root
+}
+
+// isRootlessBuild returns true if the install steps writing the dirs of the
+// user run as the user in the dev environment.
+func (g generalGraph) isRootlessBuild() bool {
+	return g.isRootlessBuildEnabled() && g.Dev && g.uid > 0
+}
+
+// userRunOptions runs the step as the user in the rootless build, thus the
+// files written by the step are owned by the user.
+func (g generalGraph) userRunOptions() []llb.RunOption {
+	if !g.isRootlessBuild() {
+		return nil
+	}
+	return []llb.RunOption{
+		llb.User(fmt.Sprintf("%d:%d", g.uid, g.gid)),
+		llb.AddEnv("HOME", fileutil.EnvdHomeDir()),
+	}
+}
+
+// userMkdirOptions creates the dir with the parents, it's owned by the user
+// in the rootless build.
+func (g generalGraph) userMkdirOptions() []llb.MkdirOption {
+	opts := []llb.MkdirOption{llb.WithParents(true)}
+	if g.isRootlessBuild() {
+		opts = append(opts, llb.WithUIDGID(g.uid, g.gid))
+	}
+	return opts
+}
+
+// userCacheMount mounts the persistent cache, it's owned by the user in the
+// rootless build. The caches of the rootless builds are keyed by the UID, since
+// the files cached by the others are not writable.
+func (g generalGraph) userCacheMount(target, id string, sharing llb.CacheMountSharingMode) llb.RunOption {
+	if !g.isRootlessBuild() {
+		return llb.AddMount(target, llb.Scratch(), llb.AsPersistentCacheDir(id, sharing))
+	}
+	dir := llb.Scratch().File(llb.Mkdir("/cache", 0755, llb.WithUIDGID(g.uid, g.gid)))
+	return llb.AddMount(target, dir, llb.SourcePath("/cache"),
+		llb.AsPersistentCacheDir(fmt.Sprintf("%s-%d", id, g.uid), sharing))
+}
+
 // compileUserOwn chown related directories
 func (g *generalGraph) compileUserOwn(root llb.State) llb.State {
+	root = g.compileAccountOwn(root)
 	if g.uid == 0 {
 		g.RuntimeEnviron["USER"] = "root"
 		return root
@@ -0,0 +1,59 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/tensorchord/envd/pkg/lang/ir"
+)
+
+func TestValidateAccounts(t *testing.T) {
+	testcases := []struct {
+		users  []ir.UserAccount
+		groups []ir.GroupAccount
+		valid  bool
+	}{
+		{
+			users:  []ir.UserAccount{{Name: "worker", UID: 2001, Group: "data"}, {Name: "web", UID: 2002}},
+			groups: []ir.GroupAccount{{Name: "data", GID: 2001}},
+			valid:  true,
+		},
+		{
+			users: []ir.UserAccount{{Name: "worker", UID: 2001}, {Name: "web", UID: 2001}},
+		},
+		{
+			users: []ir.UserAccount{{Name: "worker", UID: 2001}, {Name: "worker", UID: 2002}},
+		},
+		{
+			// the private group of worker takes the gid 2001
+			users:  []ir.UserAccount{{Name: "worker", UID: 2001}},
+			groups: []ir.GroupAccount{{Name: "data", GID: 2001}},
+		},
+		{
+			users:  []ir.UserAccount{{Name: "worker", UID: 2001}},
+			groups: []ir.GroupAccount{{Name: "worker", GID: 2002}},
+		},
+	}
+	for i, tc := range testcases {
+		err := validateAccounts(tc.users, tc.groups)
+		if tc.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("case %d: expected a collision error", i)
+		}
+	}
+}
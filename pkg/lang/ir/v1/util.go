@@ -28,6 +28,7 @@ import (
 	"strings"
 
 	"github.com/cockroachdb/errors"
+	"github.com/moby/buildkit/client/llb"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
@@ -40,6 +41,11 @@ func (g generalGraph) getWorkingDir() string {
 	return fileutil.EnvdHomeDir(g.EnvironmentName)
 }
 
+// shellQuote quotes the string with single quotes for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func (g generalGraph) getExtraSourceDir() string {
 	return fileutil.EnvdHomeDir("extra_source")
 }
@@ -143,7 +149,12 @@ func GetCUDAImage(image string, cuda *string, cudnn string, dev bool) string {
 	}
 	imageTag := strings.Replace(image, ":", "", 1)
 
-	return fmt.Sprintf("docker.io/nvidia/cuda:%s-cudnn%s-%s-%s", *cuda, cudnn, target, imageTag)
+	return fmt.Sprintf("docker.io/nvidia/cuda:%s-%s-%s-%s", *cuda, cudnnTag(*cuda, cudnn), target, imageTag)
+}
+
+// isCUDAImage returns true if the image is already derived by GetCUDAImage.
+func isCUDAImage(image string) bool {
+	return strings.HasPrefix(image, "docker.io/nvidia/cuda:")
 }
 
 func (g *generalGraph) Dump() (string, error) {
@@ -171,3 +182,51 @@ func (g generalGraph) GeneralGraphFromLabel(label []byte) (ir.Graph, error) {
 	}
 	return &newg, nil
 }
+
+// gpuStageConstraint returns the worker constraints for the stages which may
+// require a GPU, such as installing the language packages with CUDA.
+func (g generalGraph) gpuStageConstraint() llb.ConstraintsOpt {
+	if g.CUDA == nil {
+		return llb.Require()
+	}
+	return llb.Require(g.GPUWorkerConstraints...)
+}
+
+var spdxIDRegex = regexp.MustCompile(`^(LicenseRef-|DocumentRef-[A-Za-z0-9.\-]+:LicenseRef-)?[A-Za-z0-9.\-]+\+?$`)
+
+// isSPDXExpression checks the syntax of the SPDX license expression,
+// e.g. `MIT`, `Apache-2.0 OR MIT`, `(GPL-2.0-only WITH Classpath-exception-2.0)`.
+func isSPDXExpression(expr string) bool {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr))
+	depth := 0
+	// expectID is true when an identifier or "(" is expected
+	expectID := true
+	afterWith := false
+	for _, t := range tokens {
+		switch {
+		case t == "(":
+			if !expectID || afterWith {
+				return false
+			}
+			depth++
+		case t == ")":
+			if expectID || depth == 0 {
+				return false
+			}
+			depth--
+		case t == "AND" || t == "OR" || t == "WITH":
+			if expectID {
+				return false
+			}
+			expectID = true
+			afterWith = t == "WITH"
+		default:
+			if !expectID || !spdxIDRegex.MatchString(t) {
+				return false
+			}
+			expectID = false
+			afterWith = false
+		}
+	}
+	return len(tokens) > 0 && !expectID && depth == 0
+}
@@ -14,7 +14,54 @@
 
 package v1
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+)
+
+// resetDefaultGraph replaces DefaultGraph by a new graph, which is reset
+// again after the test.
+func resetDefaultGraph(t *testing.T) *generalGraph {
+	t.Helper()
+	DefaultGraph = NewGraph()
+	t.Cleanup(func() { DefaultGraph = NewGraph() })
+	return DefaultGraph.(*generalGraph)
+}
+
+// parsedOp is the op of the definition with its digest, which is the key of
+// the metadata of the op.
+type parsedOp struct {
+	pb.Op
+	Digest digest.Digest
+}
+
+// marshalOps marshals the state and parses the ops of the definition.
+func marshalOps(t *testing.T, state llb.State) []parsedOp {
+	t.Helper()
+	def, err := state.Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	return parseOps(t, def)
+}
+
+// parseOps parses the ops of the definition, in the order of the definition.
+func parseOps(t *testing.T, def *llb.Definition) []parsedOp {
+	t.Helper()
+	ops := make([]parsedOp, 0, len(def.Def))
+	for _, dt := range def.Def {
+		op := parsedOp{Digest: digest.FromBytes(dt)}
+		if err := op.Unmarshal(dt); err != nil {
+			t.Fatalf("failed to parse op: %v", err)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
 
 func TestParseLanguage(t *testing.T) {
 	tcs := []struct {
@@ -76,3 +123,32 @@ func TestParseLanguage(t *testing.T) {
 
 	}
 }
+
+func TestIsSPDXExpression(t *testing.T) {
+	tcs := []struct {
+		expr     string
+		expected bool
+	}{
+		{expr: "MIT", expected: true},
+		{expr: "Apache-2.0", expected: true},
+		{expr: "GPL-2.0+", expected: true},
+		{expr: "MIT OR Apache-2.0", expected: true},
+		{expr: "(MIT AND BSD-3-Clause) OR Apache-2.0", expected: true},
+		{expr: "GPL-2.0-only WITH Classpath-exception-2.0", expected: true},
+		{expr: "LicenseRef-internal", expected: true},
+		{expr: "", expected: false},
+		{expr: "MIT OR", expected: false},
+		{expr: "MIT Apache-2.0", expected: false},
+		{expr: "(MIT", expected: false},
+		{expr: "MIT)", expected: false},
+		{expr: "MIT WITH (Apache-2.0)", expected: false},
+		{expr: "MIT License", expected: false},
+		{expr: "GPL/2", expected: false},
+	}
+
+	for _, tc := range tcs {
+		if actual := isSPDXExpression(tc.expr); actual != tc.expected {
+			t.Errorf("isSPDXExpression(%q) returned %t, expected %t", tc.expr, actual, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progresswriter
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	EventStatusStarted   = "started"
+	EventStatusCompleted = "completed"
+	EventStatusCached    = "cached"
+	EventStatusError     = "error"
+)
+
+// Event is the progress of the step printed by the json mode, one per line.
+// Every executed step has a started event, and at last an event of completed
+// or error with the duration. The cached steps arrive already completed, thus
+// they only have the cached event.
+type Event struct {
+	Time   time.Time     `json:"time"`
+	Digest digest.Digest `json:"digest"`
+	Name   string        `json:"name"`
+	Status string        `json:"status"`
+	Cached bool          `json:"cached"`
+	// Duration is the seconds from the start of the step to its completion
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// displayJSON writes the events of the status updates of the steps. The
+// updates are drained until the channel is closed even if the write fails,
+// thus the build is never blocked.
+func displayJSON(w io.Writer, ch chan *client.SolveStatus) error {
+	enc := json.NewEncoder(w)
+	printed := map[digest.Digest]string{}
+	var err error
+	for s := range ch {
+		for _, v := range s.Vertexes {
+			e, ok := vertexEvent(v, printed[v.Digest])
+			if !ok {
+				continue
+			}
+			printed[v.Digest] = e.Status
+			if err == nil {
+				err = enc.Encode(e)
+			}
+		}
+	}
+	return err
+}
+
+// vertexEvent returns the event of the vertex if its status is changed from
+// the last printed one.
+func vertexEvent(v *client.Vertex, last string) (Event, bool) {
+	e := Event{
+		Digest: v.Digest,
+		Name:   v.Name,
+		Cached: v.Cached,
+	}
+	switch {
+	case v.Completed != nil:
+		if last != "" && last != EventStatusStarted {
+			return Event{}, false
+		}
+		e.Time = *v.Completed
+		e.Status = EventStatusCompleted
+		if v.Cached {
+			e.Status = EventStatusCached
+		}
+		if v.Error != "" {
+			e.Status = EventStatusError
+			e.Error = v.Error
+		}
+		if v.Started != nil {
+			e.Duration = v.Completed.Sub(*v.Started).Seconds()
+		}
+	case v.Started != nil:
+		if last != "" {
+			return Event{}, false
+		}
+		e.Time = *v.Started
+		e.Status = EventStatusStarted
+	default:
+		return Event{}, false
+	}
+	return e, true
+}
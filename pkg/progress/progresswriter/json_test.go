@@ -0,0 +1,64 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progresswriter
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisplayJSON(t *testing.T) {
+	start := time.Unix(100, 0)
+	stop := start.Add(1500 * time.Millisecond)
+	ch := make(chan *client.SolveStatus, 4)
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: "sha256:a", Name: "[internal] downloading julia binary", Started: &start},
+		{Digest: "sha256:b", Name: "apt-get update", Started: &start, Completed: &start, Cached: true},
+	}}
+	// the repeated updates are not printed
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: "sha256:a", Name: "[internal] downloading julia binary", Started: &start},
+		{Digest: "sha256:b", Name: "apt-get update", Started: &start, Completed: &start, Cached: true},
+	}}
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: "sha256:a", Name: "[internal] downloading julia binary", Started: &start, Completed: &stop},
+		{Digest: "sha256:c", Name: "pip install", Started: &start, Completed: &stop, Error: "exit code: 1"},
+	}}
+	close(ch)
+
+	var buf bytes.Buffer
+	require.NoError(t, displayJSON(&buf, ch))
+	var events []Event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e Event
+		require.NoError(t, dec.Decode(&e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 4)
+	require.Equal(t, EventStatusStarted, events[0].Status)
+	require.Equal(t, EventStatusCached, events[1].Status)
+	require.True(t, events[1].Cached)
+	require.Equal(t, "[internal] downloading julia binary", events[2].Name)
+	require.Equal(t, EventStatusCompleted, events[2].Status)
+	require.Equal(t, 1.5, events[2].Duration)
+	require.Equal(t, EventStatusError, events[3].Status)
+	require.Equal(t, "exit code: 1", events[3].Error)
+}
@@ -73,6 +73,9 @@ func Tee(w Writer, ch chan *client.SolveStatus) Writer {
 	return t
 }
 
+// NewPrinter prints the progress to out in the mode. The json events always
+// go to stderr, thus the consumers read them from the same stream, whichever
+// command runs the build, and stdout only has the output of the command.
 func NewPrinter(ctx context.Context, out console.File, mode string) (Writer, error) {
 	statusCh := make(chan *client.SolveStatus)
 	doneCh := make(chan struct{})
@@ -97,8 +100,14 @@ func NewPrinter(ctx context.Context, out console.File, mode string) (Writer, err
 			}
 		}
 	case "plain":
+	case "json":
+		go func() {
+			pw.err = displayJSON(os.Stderr, statusCh)
+			close(doneCh)
+		}()
+		return pw, nil
 	default:
-		return nil, errors.Errorf("invalid progress mode %s", mode)
+		return nil, errors.Errorf("invalid progress mode %s, must be auto, tty, plain or json", mode)
 	}
 
 	go func() {
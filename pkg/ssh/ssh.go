@@ -39,6 +39,7 @@ import (
 type Client interface {
 	Attach() error
 	ExecWithOutput(cmd string) ([]byte, error)
+	ExecWithWriter(cmd string, stdout, stderr io.Writer) error
 	LocalForward(localAddress, targetAddress string) error
 	Close() error
 }
@@ -178,6 +179,22 @@ func (c generalClient) ExecWithOutput(cmd string) ([]byte, error) {
 	return session.CombinedOutput(cmd)
 }
 
+// ExecWithWriter streams the output of the command to the writers, e.g. for
+// the commands that never exit like `tail -f`.
+func (c generalClient) ExecWithWriter(cmd string, stdout, stderr io.Writer) error {
+	defer c.cli.Close()
+
+	session, err := c.cli.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "creating session failed")
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(cmd)
+}
+
 func (c generalClient) Attach() error {
 	// open session
 	session, err := c.cli.NewSession()
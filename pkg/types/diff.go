@@ -0,0 +1,159 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	PackageTypeSystem = "System"
+	PackageTypePyPI   = "Python"
+	PackageTypeJulia  = "Julia"
+	PackageTypeImage  = "Image"
+)
+
+// PackageChange is the change of a package between two builds. From and To
+// are the resolved versions, e.g. `1.23.0` of numpy.
+type PackageChange struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// DependencyDiff is the difference of the dependencies between two builds.
+type DependencyDiff struct {
+	Added   []PackageChange `json:"added"`
+	Removed []PackageChange `json:"removed"`
+	Changed []PackageChange `json:"changed"`
+}
+
+// Empty returns true if the dependencies are the same.
+func (d DependencyDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffResolvedPackages compares the packages resolved in the two builds,
+// which are grouped by the package manager as in the lockfile. The packages
+// are matched by name, thus the version bump is reported as a change instead
+// of a pair of removal and addition.
+func DiffResolvedPackages(from, to map[string][]string) DependencyDiff {
+	diff := DependencyDiff{
+		Added:   []PackageChange{},
+		Removed: []PackageChange{},
+		Changed: []PackageChange{},
+	}
+	managers := map[string]bool{}
+	for m := range from {
+		managers[m] = true
+	}
+	for m := range to {
+		managers[m] = true
+	}
+	names := make([]string, 0, len(managers))
+	for m := range managers {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	for _, m := range names {
+		diffPackages(&diff, m, from[m], to[m])
+	}
+	return diff
+}
+
+func diffPackages(diff *DependencyDiff, manager string, from, to []string) {
+	pkgType := packageType(manager)
+	fromVersions := packageVersions(manager, from)
+	toVersions := packageVersions(manager, to)
+	for _, name := range sortedKeys(toVersions) {
+		version, ok := fromVersions[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, PackageChange{Type: pkgType, Name: name, To: toVersions[name]})
+		case version != toVersions[name]:
+			diff.Changed = append(diff.Changed, PackageChange{Type: pkgType, Name: name, From: version, To: toVersions[name]})
+		}
+	}
+	for _, name := range sortedKeys(fromVersions) {
+		if _, ok := toVersions[name]; !ok {
+			diff.Removed = append(diff.Removed, PackageChange{Type: pkgType, Name: name, From: fromVersions[name]})
+		}
+	}
+}
+
+func packageType(manager string) string {
+	switch manager {
+	case "system":
+		return PackageTypeSystem
+	case "pypi":
+		return PackageTypePyPI
+	case "julia":
+		return PackageTypeJulia
+	default:
+		return manager
+	}
+}
+
+// packageVersions maps the package names to the resolved versions.
+func packageVersions(manager string, packages []string) map[string]string {
+	m := make(map[string]string, len(packages))
+	for _, p := range packages {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		name, version := splitResolvedPackage(manager, p)
+		m[name] = version
+	}
+	return m
+}
+
+// splitResolvedPackage returns the name and the version of the resolved
+// package, e.g. `curl=7.81.0-1ubuntu1.15` of the system packages,
+// `Example@0.5.3` of Julia and `scikit-learn-1.2.0` of PyPI.
+func splitResolvedPackage(manager, p string) (string, string) {
+	var i int
+	switch manager {
+	case "system":
+		i = strings.Index(p, "=")
+	case "julia":
+		i = strings.Index(p, "@")
+	case "pypi":
+		// the version of the wheel never has `-`
+		i = strings.LastIndex(p, "-")
+	default:
+		i = strings.IndexAny(p, "=@")
+	}
+	if i <= 0 {
+		return p, ""
+	}
+	name, version := p[:i], p[i+1:]
+	if manager == "pypi" {
+		// PEP 503 normalized name
+		name = strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(name))
+	}
+	return name, version
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
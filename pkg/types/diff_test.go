@@ -0,0 +1,52 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	g "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = g.Describe("dependency diff", func() {
+	g.It("should report the added, removed and changed packages", func() {
+		from := map[string][]string{
+			"system": {"curl=7.81.0-1ubuntu1.15", "git=1:2.34.1-1ubuntu1.9"},
+			"pypi":   {"numpy-1.23.0", "Scikit_Learn-1.2.0"},
+			"julia":  {"Example@0.5.3"},
+		}
+		to := map[string][]string{
+			"system": {"git=1:2.34.1-1ubuntu1.10"},
+			"pypi":   {"numpy-1.24.0", "scikit-learn-1.2.0", "torch-2.0.1"},
+			"julia":  {"Example@0.5.3", "Flux@0.13.17"},
+		}
+		diff := DiffResolvedPackages(from, to)
+		Expect(diff.Added).To(Equal([]PackageChange{
+			{Type: PackageTypeJulia, Name: "Flux", To: "0.13.17"},
+			{Type: PackageTypePyPI, Name: "torch", To: "2.0.1"},
+		}))
+		Expect(diff.Removed).To(Equal([]PackageChange{
+			{Type: PackageTypeSystem, Name: "curl", From: "7.81.0-1ubuntu1.15"},
+		}))
+		Expect(diff.Changed).To(Equal([]PackageChange{
+			{Type: PackageTypePyPI, Name: "numpy", From: "1.23.0", To: "1.24.0"},
+			{Type: PackageTypeSystem, Name: "git", From: "1:2.34.1-1ubuntu1.9", To: "1:2.34.1-1ubuntu1.10"},
+		}))
+	})
+
+	g.It("should be empty for the same packages", func() {
+		packages := map[string][]string{"pypi": {"numpy-1.24.0"}}
+		Expect(DiffResolvedPackages(packages, packages).Empty()).To(BeTrue())
+	})
+})
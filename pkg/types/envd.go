@@ -17,6 +17,7 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 
 	"github.com/cockroachdb/errors"
 	"github.com/docker/docker/api/types"
@@ -43,6 +44,9 @@ const (
 	EnvdWorkDir = "ENVD_WORKDIR"
 )
 
+// ServiceNameRegex matches the names of the horust services, i.e. the daemons.
+var ServiceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
 var EnvdSshdImage = fmt.Sprintf(
 	"tensorchord/envd-sshd-from-scratch:%s",
 	version.GetVersionForImageTag())
@@ -115,8 +119,11 @@ type Context struct {
 	Name           string      `json:"name,omitempty"`
 	Builder        BuilderType `json:"builder,omitempty"`
 	BuilderAddress string      `json:"builder_address,omitempty"`
-	Runner         RunnerType  `json:"runner,omitempty"`
-	RunnerAddress  *string     `json:"runner_address,omitempty"`
+	// BuilderRootless starts the rootless buildkitd for the builder
+	// docker-container or nerdctl-container
+	BuilderRootless bool       `json:"builder_rootless,omitempty"`
+	Runner          RunnerType `json:"runner,omitempty"`
+	RunnerAddress   *string    `json:"runner_address,omitempty"`
 }
 
 type BuilderType string
@@ -135,11 +142,13 @@ type RunnerType string
 const (
 	RunnerTypeDocker     RunnerType = "docker"
 	RunnerTypeEnvdServer RunnerType = "envd-server"
+	RunnerTypeKubernetes RunnerType = "k8s"
 )
 
 type Dependency struct {
-	APTPackages  []string `json:"apt_packages,omitempty"`
-	PyPIPackages []string `json:"pypi_packages,omitempty"`
+	APTPackages   []string `json:"apt_packages,omitempty"`
+	PyPIPackages  []string `json:"pypi_packages,omitempty"`
+	JuliaPackages []string `json:"julia_packages,omitempty"`
 }
 
 type RepoInfo struct {
@@ -317,6 +326,13 @@ func NewDependencyFromLabels(label map[string]string) (*Dependency, error) {
 		}
 		dep.PyPIPackages = pkgs
 	}
+	if juliaPackages, ok := label[ImageLabelJulia]; ok {
+		var pkgs []string
+		if err := json.Unmarshal([]byte(juliaPackages), &pkgs); err != nil {
+			return nil, errors.Wrap(err, "failed to parse julia packages")
+		}
+		dep.JuliaPackages = pkgs
+	}
 	return &dep, nil
 }
 
@@ -333,7 +349,9 @@ func parsePyPICommands(lst string) ([]string, error) {
 }
 
 func (c Context) GetSSHHostname(sshdHost string) (string, error) {
-	if c.RunnerAddress == nil {
+	// The runner address of k8s is the namespace, the SSH port of the pod is
+	// forwarded to the sshd host.
+	if c.RunnerAddress == nil || c.Runner == RunnerTypeKubernetes {
 		return sshdHost, nil
 	}
 
@@ -28,6 +28,7 @@ const (
 	ImageLabelAPT           = "ai.tensorchord.envd.apt.packages"
 	ImageLabelPyPI          = "ai.tensorchord.envd.pypi.commands"
 	ImageLabelR             = "ai.tensorchord.envd.r.packages"
+	ImageLabelJulia         = "ai.tensorchord.envd.julia.packages"
 	ImageLabelCUDA          = "ai.tensorchord.envd.gpu.cuda"
 	ImageLabelCUDNN         = "ai.tensorchord.envd.gpu.cudnn"
 	ImageLabelContext       = "ai.tensorchord.envd.build.context"
@@ -36,5 +37,10 @@ const (
 	RuntimeGraphCode        = "ai.tensorchord.envd.graph.runtime"
 	GeneralGraphCode        = "ai.tensorchord.envd.graph.general"
 
+	// well-known keys of the image metadata
+	ImageLabelAuthors    = "org.opencontainers.image.authors"
+	ImageLabelLicenses   = "org.opencontainers.image.licenses"
+	ImageLabelMaintainer = "maintainer"
+
 	ImageVendorEnvd = "envd"
 )
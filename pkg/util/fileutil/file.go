@@ -18,11 +18,13 @@ package fileutil
 
 import (
 	"os"
+	"os/user"
 	"path/filepath"
 	"strings"
 
 	"github.com/cockroachdb/errors"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -185,11 +187,85 @@ func DownloadOrUpdateGitRepo(url string) (path string, err error) {
 	return path, nil
 }
 
+// DownloadGitRepoRef downloads the git repo and checks out the ref, which is a
+// branch, a tag or a commit. The repo is fetched again unless the ref is a
+// commit, and the cached one is used if it can not be fetched. The latest
+// default branch is used if ref is empty.
+func DownloadGitRepoRef(url, ref string) (path string, err error) {
+	if ref == "" {
+		return DownloadOrUpdateGitRepo(url)
+	}
+	logger := logrus.WithFields(logrus.Fields{"git": url, "ref": ref})
+	path = filepath.Join(DefaultEnvdLibDir,
+		strings.ReplaceAll(url, "/", "_")+"@"+strings.ReplaceAll(ref, "/", "_"))
+	exist, err := DirExists(path)
+	if err != nil {
+		return "", err
+	}
+	var repo *git.Repository
+	if !exist {
+		logger.Debugf("clone repo to %s", path)
+		repo, err = git.PlainClone(path, false, &git.CloneOptions{URL: url})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to clone %s", url)
+		}
+	} else {
+		repo, err = git.PlainOpen(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to open the repo in %s", path)
+		}
+		if !plumbing.IsHash(ref) {
+			logger.Debug("try to fetch latest")
+			err = repo.Fetch(&git.FetchOptions{Tags: git.AllTags, Force: true})
+			if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+				logger.Warnf("failed to fetch %s, the cached one is used: %s", url, err)
+			}
+		}
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision("refs/remotes/origin/" + ref))
+	if err != nil {
+		hash, err = repo.ResolveRevision(plumbing.Revision(ref))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to find %s in %s", ref, url)
+		}
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	if err = wt.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+		return "", errors.Wrapf(err, "failed to checkout %s of %s", ref, url)
+	}
+	return path, nil
+}
+
 // EnvdHomeDir returns the envd user path inside the environment
 func EnvdHomeDir(path ...string) string {
 	return filepath.Join(append([]string{"/", "home", "envd"}, path...)...)
 }
 
+// ExpandHostHome expands the leading `~` of the path to the home of the host user
+func ExpandHostHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(usr.HomeDir, strings.TrimPrefix(path, "~"))
+}
+
+// ExpandEnvdHome expands the leading `~` of the path to the envd user path
+// inside the environment
+func ExpandEnvdHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	return EnvdHomeDir(strings.TrimPrefix(path, "~"))
+}
+
 // DefaultHomeDir returns the default user path inside the environment
 func DefaultHomeDir(path ...string) string {
 	return filepath.Join(append([]string{"~"}, path...)...)
@@ -16,9 +16,14 @@ package fileutil
 
 import (
 	"os"
+	"os/user"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/require"
 )
 
@@ -80,3 +85,56 @@ func TestFindFileAbsPath(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandHome(t *testing.T) {
+	usr, err := user.Current()
+	require.Nil(t, err, "cannot get the current user")
+
+	require.Equal(t, filepath.Join(usr.HomeDir, ".cache"), ExpandHostHome("~/.cache"))
+	require.Equal(t, "/data/~", ExpandHostHome("/data/~"))
+	require.Equal(t, "/home/envd", ExpandEnvdHome("~"))
+	require.Equal(t, "/home/envd/.cache/huggingface", ExpandEnvdHome("~/.cache/huggingface"))
+	require.Equal(t, "~user/data", ExpandEnvdHome("~user/data"))
+}
+
+func TestDownloadGitRepoRef(t *testing.T) {
+	libDir := DefaultEnvdLibDir
+	defer func() { DefaultEnvdLibDir = libDir }()
+	DefaultEnvdLibDir = t.TempDir()
+
+	src := t.TempDir()
+	repo, err := git.PlainInit(src, false)
+	require.Nil(t, err)
+	wt, err := repo.Worktree()
+	require.Nil(t, err)
+	commit := func(content string) plumbing.Hash {
+		require.Nil(t, os.WriteFile(filepath.Join(src, "base.envd"), []byte(content), 0644))
+		_, err := wt.Add("base.envd")
+		require.Nil(t, err)
+		hash, err := wt.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "envd", Email: "envd@tensorchord.ai", When: time.Now()},
+		})
+		require.Nil(t, err)
+		return hash
+	}
+	first := commit("v1")
+	_, err = repo.CreateTag("v1", first, nil)
+	require.Nil(t, err)
+	commit("v2")
+	head, err := repo.Head()
+	require.Nil(t, err)
+
+	for ref, expected := range map[string]string{
+		"v1":                "v1",
+		first.String():      "v1",
+		head.Name().Short(): "v2",
+	} {
+		path, err := DownloadGitRepoRef(src, ref)
+		require.Nil(t, err)
+		content, err := os.ReadFile(filepath.Join(path, "base.envd"))
+		require.Nil(t, err)
+		require.Equal(t, expected, string(content), "unexpected content of %s", ref)
+	}
+	_, err = DownloadGitRepoRef(src, "v3")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package starlarkutil
+
+import (
+	"github.com/cockroachdb/errors"
+
+	"go.starlark.net/starlark"
+)
+
+// ToGoValue converts the starlark value to the Go value of the JSON types,
+// e.g. dict to map[string]interface{} and list to []interface{}.
+func ToGoValue(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, errors.Newf("Conversion failed, %s overflows int64", v)
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return v.GoString(), nil
+	case *starlark.List, starlark.Tuple:
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		s := []interface{}{}
+		var item starlark.Value
+		for iter.Next(&item) {
+			value, err := ToGoValue(item)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+		}
+		return s, nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, errors.Newf("Conversion failed, expect string key, but got %s as %s", item[0], item[0].Type())
+			}
+			value, err := ToGoValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	default:
+		return nil, errors.Newf("Conversion failed, unsupported type %s", v.Type())
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 The envd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package starlarkutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.starlark.net/starlark"
+)
+
+func TestToGoValueDict(t *testing.T) {
+	dict := starlark.NewDict(2)
+	assert.Nil(t, dict.SetKey(starlark.String("editor.formatOnSave"), starlark.True))
+	assert.Nil(t, dict.SetKey(starlark.String("editor.rulers"),
+		starlark.NewList([]starlark.Value{starlark.MakeInt(92), starlark.None})))
+	value, err := ToGoValue(dict)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"editor.formatOnSave": true,
+		"editor.rulers":       []interface{}{int64(92), nil},
+	}, value)
+}
+
+func TestToGoValueInvalidKey(t *testing.T) {
+	dict := starlark.NewDict(1)
+	assert.Nil(t, dict.SetKey(starlark.MakeInt(1), starlark.True))
+	_, err := ToGoValue(dict)
+	assert.NotNil(t, err)
+}